@@ -3,51 +3,48 @@ package main
 
 import (
 	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
 	"net/http"
 	_ "net/http/pprof"
+	"net/url"
 	"os"
 	"os/signal"
 	"runtime"
+	"strings"
 	"syscall"
 
-	"github.com/caarlos0/env/v6"
 	"github.com/labstack/echo-contrib/pprof"
 	"github.com/labstack/echo/v4"
+	elog "github.com/labstack/gommon/log"
 	_ "github.com/lib/pq"
-	"golang.org/x/crypto/acme/autocert"
 	"golang.org/x/sync/errgroup"
 
+	"github.com/ivanmyagkov/shortener.git/internal/accounts"
+	"github.com/ivanmyagkov/shortener.git/internal/canonical"
+	"github.com/ivanmyagkov/shortener.git/internal/changes"
 	"github.com/ivanmyagkov/shortener.git/internal/config"
+	"github.com/ivanmyagkov/shortener.git/internal/geoip"
 	"github.com/ivanmyagkov/shortener.git/internal/handlers"
 	"github.com/ivanmyagkov/shortener.git/internal/interfaces"
+	"github.com/ivanmyagkov/shortener.git/internal/keys"
+	"github.com/ivanmyagkov/shortener.git/internal/linkcheck"
 	"github.com/ivanmyagkov/shortener.git/internal/middleware"
+	"github.com/ivanmyagkov/shortener.git/internal/pagemeta"
+	"github.com/ivanmyagkov/shortener.git/internal/ratelimit"
+	"github.com/ivanmyagkov/shortener.git/internal/reload"
+	"github.com/ivanmyagkov/shortener.git/internal/reputation"
+	"github.com/ivanmyagkov/shortener.git/internal/routing"
 	"github.com/ivanmyagkov/shortener.git/internal/storage"
+	"github.com/ivanmyagkov/shortener.git/internal/tlsconfig"
+	"github.com/ivanmyagkov/shortener.git/internal/urlpolicy"
+	"github.com/ivanmyagkov/shortener.git/internal/webhooks"
 	"github.com/ivanmyagkov/shortener.git/internal/workerpool"
+	"github.com/ivanmyagkov/shortener.git/internal/workspaces"
 )
 
-//	Structure of flags.
-var flags struct {
-	A string `json:"server_address"`
-	B string `json:"base_url"`
-	F string `json:"file_storage_path"`
-	D string `json:"database_dns"`
-	S bool   `json:"enable_https"`
-	C string `json:"-"`
-}
-
-//	envVar structure is struct of env variables.
-var envVar struct {
-	ServerAddress   string `env:"SERVER_ADDRESS" envDefault:":8080"`
-	BaseURL         string `env:"BASE_URL" envDefault:"http://localhost:8080" json:"base_url"`
-	FileStoragePath string `env:"FILE_STORAGE_PATH" json:"file_storage_path"`
-	Database        string `env:"DATABASE_DSN" json:"database_dns"`
-	EnableHTTPS     bool   `env:"ENABLE_HTTPS" json:"enable_https"`
-	Config          string `env:"CONFIG" json:"-"`
-}
-
 //build and compile flags
 var (
 	buildVersion string
@@ -77,33 +74,238 @@ func buildParams() {
 	}
 }
 
-//	init Initializing startup parameters.
-func init() {
-	buildParams()
-	err := env.Parse(&envVar)
+//	printConfig Printing the effective settings: shortener config print [-format json|yaml|toml] [flags].
+func printConfig(args []string) error {
+	fs := flag.NewFlagSet("config print", flag.ContinueOnError)
+	format := fs.String("format", "yaml", "output format: json, yaml or toml")
+	cfg, err := config.Load(fs, args)
+	if errors.Is(err, flag.ErrHelp) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	return cfg.Print(os.Stdout, *format)
+}
+
+//	generateKey Printing a new session key: shortener keys generate [-id id].
+func generateKey(args []string) error {
+	fs := flag.NewFlagSet("keys generate", flag.ContinueOnError)
+	id := fs.String("id", "", "key id, the current time by default")
+	if err := fs.Parse(args); errors.Is(err, flag.ErrHelp) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	key, err := keys.Generate()
 	if err != nil {
-		log.Fatal(err)
+		return err
+	}
+	if *id != "" {
+		key.ID = *id
+	}
+	fmt.Println(key)
+	return nil
+}
+
+//	sessionKeys Loading session keys, without configured keys a temporary one is generated.
+func sessionKeys(cfg *config.Config) (*keys.Ring, error) {
+	settings := keys.Settings{
+		Keys:    cfg.SessionKeys,
+		File:    cfg.SessionKeysFile,
+		Dir:     cfg.SessionKeysDir,
+		Primary: cfg.SessionPrimaryKey,
+		Legacy:  cfg.SessionLegacyKey,
+	}
+	if len(settings.Keys) == 0 && settings.File == "" && settings.Dir == "" {
+		key, err := keys.Generate()
+		if err != nil {
+			return nil, err
+		}
+		log.Println("no session keys are set, sessions will not survive a restart; create one with: shortener keys generate")
+		settings.Keys = []string{key.String()}
+	}
+	return keys.Load(settings)
+}
+
+//	newURLChecker Creating the reputation checker from settings, nil if none is configured.
+func newURLChecker(cfg *config.Config) (interfaces.URLChecker, error) {
+	var chain reputation.Chain
+	if cfg.ReputationHashList != "" {
+		list, err := reputation.LoadHashPrefixList(cfg.ReputationHashList)
+		if err != nil {
+			return nil, err
+		}
+		chain = append(chain, list)
+	}
+	if cfg.ReputationURL != "" {
+		headers := make(map[string]string)
+		if header := strings.SplitN(cfg.ReputationHeader, ":", 2); len(header) == 2 {
+			headers[strings.TrimSpace(header[0])] = strings.TrimSpace(header[1])
+		}
+		lookup := reputation.NewHTTPChecker(cfg.ReputationURL, headers, cfg.ReputationTimeout)
+		chain = append(chain, reputation.NewCache(lookup, cfg.ReputationCacheTTL, 10000))
+	}
+	if len(chain) == 0 {
+		return nil, nil
+	}
+	return chain, nil
+}
+
+//	rateLimits Parsing rate limits of route classes from settings.
+func rateLimits(cfg *config.Config) (map[string]interfaces.RateLimit, error) {
+	limits := make(map[string]interfaces.RateLimit)
+	for class, value := range map[string]string{
+		ratelimit.ClassCreate:   cfg.RateLimitCreate,
+		ratelimit.ClassRedirect: cfg.RateLimitRedirect,
+		ratelimit.ClassDelete:   cfg.RateLimitDelete,
+		ratelimit.ClassUnlock:   cfg.RateLimitUnlock,
+		ratelimit.ClassAccount:  cfg.RateLimitAccount,
+		ratelimit.ClassLogin:    cfg.RateLimitLogin,
+	} {
+		limit, err := ratelimit.ParseLimit(value)
+		if err != nil {
+			return nil, err
+		}
+		limits[class] = limit
+	}
+	return limits, nil
+}
+
+//	newTLS Building TLS settings, Let's Encrypt certificates are requested for hosts of all domains by default.
+func newTLS(cfg *config.Config) (*tlsconfig.Server, error) {
+	hosts := cfg.AutocertHosts
+	if len(hosts) == 0 {
+		for _, baseURL := range cfg.BaseURLs() {
+			u, err := url.Parse(baseURL)
+			if err != nil {
+				return nil, err
+			}
+			hosts = append(hosts, u.Hostname())
+		}
+	}
+	return tlsconfig.New(tlsconfig.Settings{
+		CertFile:         cfg.TLSCertFile,
+		KeyFile:          cfg.TLSKeyFile,
+		SelfSigned:       cfg.TLSSelfSigned,
+		AutocertHosts:    hosts,
+		AutocertCacheDir: cfg.AutocertCacheDir,
+		MinVersion:       cfg.TLSMinVersion,
+		CipherSuites:     cfg.TLSCipherSuites,
+	}, cfg.SrvAddr())
+}
+
+//	parts is the part of the server built from reloadable settings.
+type parts struct {
+	canonicalizer *canonical.Canonicalizer
+	policy        *urlpolicy.Policy
+	limits        map[string]interfaces.RateLimit
+	level         elog.Lvl
+}
+
+var logLevels = map[string]elog.Lvl{
+	"debug": elog.DEBUG,
+	"info":  elog.INFO,
+	"warn":  elog.WARN,
+	"error": elog.ERROR,
+	"off":   elog.OFF,
+}
+
+//	newParts Building the parts of the server from settings.
+func newParts(cfg *config.Config) (*parts, error) {
+	canonicalizer, err := canonical.New(cfg.CanonicalSteps, cfg.TrackingParams)
+	if err != nil {
+		return nil, err
+	}
+	policy, err := urlpolicy.New(urlpolicy.Settings{
+		Schemes:       cfg.AllowedSchemes,
+		DenyPrivate:   cfg.DenyPrivateHosts,
+		ResolveHosts:  cfg.ResolveHosts,
+		MaxLength:     cfg.MaxURLLength,
+		BlocklistFile: cfg.BlocklistFile,
+		AllowlistFile: cfg.AllowlistFile,
+		SelfURLs:      cfg.BaseURLs(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	limits, err := rateLimits(cfg)
+	if err != nil {
+		return nil, err
+	}
+	level, ok := logLevels[cfg.LogLevel]
+	if !ok {
+		return nil, fmt.Errorf("unknown log level %q", cfg.LogLevel)
+	}
+	return &parts{canonicalizer: canonicalizer, policy: policy, limits: limits, level: level}, nil
+}
+
+//	reloadable is the part of the running server replaced on SIGHUP.
+type reloadable struct {
+	cfg           *config.Holder
+	canonicalizer *reload.Canonicalizer
+	validator     *reload.Validator
+	limiter       *ratelimit.Limiter
+	logger        echo.Logger
+}
+
+//	reload Reading settings again and applying the reloadable ones.
+//	Invalid settings are rejected and the server keeps running with the current ones.
+func (r *reloadable) reload() {
+	next, err := config.Load(flag.NewFlagSet(os.Args[0], flag.ContinueOnError), os.Args[1:])
+	if err != nil {
+		log.Printf("config reload rejected: %v", err)
+		return
+	}
+	current := r.cfg.Get()
+	next, restart := config.Reloadable(current, next)
+	p, err := newParts(next)
+	if err != nil {
+		log.Printf("config reload rejected: %v", err)
+		return
+	}
+	r.canonicalizer.Store(p.canonicalizer)
+	r.validator.Store(p.policy)
+	r.limiter.SetLimits(p.limits)
+	r.logger.SetLevel(p.level)
+	r.cfg.Set(next)
+
+	diff := config.Diff(current, next)
+	if len(diff) == 0 {
+		log.Println("config reloaded, nothing changed")
+	}
+	for _, change := range diff {
+		log.Printf("config reloaded, %s", change)
+	}
+	if len(restart) > 0 {
+		log.Printf("config reload ignored changes of %s, they need a restart", strings.Join(restart, ", "))
 	}
-	flag.StringVar(&flags.A, "a", envVar.ServerAddress, "server address")
-	flag.StringVar(&flags.B, "b", envVar.BaseURL, "base url")
-	flag.StringVar(&flags.F, "f", envVar.FileStoragePath, "file storage path")
-	flag.StringVar(&flags.D, "d", envVar.Database, "database path")
-	flag.BoolVar(&flags.S, "s", envVar.EnableHTTPS, "enable ssl")
-	flag.StringVar(&flags.C, "config", envVar.Config, "config file")
-	flag.StringVar(&flags.C, "c", envVar.Config, "config file")
-	flag.Parse()
-	config.ParseConfig(flags.C, &flags)
 }
 
 //	main is entry point
 func main() {
+	if len(os.Args) > 2 && os.Args[1] == "config" && os.Args[2] == "print" {
+		if err := printConfig(os.Args[3:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if len(os.Args) > 2 && os.Args[1] == "keys" && os.Args[2] == "generate" {
+		if err := generateKey(os.Args[3:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	cfg, err := config.Load(flag.CommandLine, os.Args[1:])
+	if err != nil {
+		log.Fatal(err)
+	}
+	buildParams()
+
 	ctx, cancel := context.WithCancel(context.Background())
 	signalChan := make(chan os.Signal, 1)
 	signal.Notify(signalChan, syscall.SIGINT)
 	var db interfaces.Storage
 
-	cfg := config.NewConfig(flags.A, flags.B, flags.F, flags.D, flags.S)
-	var err error
 	if cfg.FilePath() != "" {
 		if db, err = storage.NewInFile(cfg.FilePath()); err != nil {
 			log.Fatal(err)
@@ -117,12 +319,42 @@ func main() {
 		db = storage.NewDBConn()
 	}
 	defer db.Close()
+	// settings reloaded on SIGHUP are read from the holder
+	holder := config.NewHolder(cfg)
 
 	//	Init Workers
 	g, _ := errgroup.WithContext(ctx)
 	recordCh := make(chan interfaces.Task, 50)
 	doneCh := make(chan struct{})
 
+	//	Init publishing of the change log of links
+	relays := changes.Settings{Interval: cfg.EventsInterval, Config: holder}
+	if cfg.EventsFile != "" {
+		g.Go(changes.New(ctx, db, "file", changes.NewFileSink(cfg.EventsFile), relays).Loop)
+	}
+	if cfg.EventsURL != "" {
+		g.Go(changes.New(ctx, db, "http", changes.NewHTTPSink(cfg.EventsURL, cfg.EventsTimeout), relays).Loop)
+	}
+
+	//	Init webhook deliveries of events of links
+	var hooks *webhooks.Service
+	if cfg.WebhookWorkers > 0 {
+		hooks = webhooks.New(ctx, db, webhooks.Settings{
+			Workers:     cfg.WebhookWorkers,
+			Timeout:     cfg.WebhookTimeout,
+			MaxAttempts: cfg.WebhookMaxAttempts,
+			Backoff:     cfg.WebhookBackoff,
+			MaxBackoff:  cfg.WebhookMaxBackoff,
+			Retention:   cfg.WebhookRetention,
+			DenyPrivate: cfg.DenyPrivateHosts,
+			Config:      holder,
+		})
+		g.Go(hooks.Loop)
+		g.Go(hooks.CountLoop)
+		// created and deleted links come from the change log
+		g.Go(changes.New(ctx, db, "webhooks", hooks, relays).Loop)
+	}
+
 	inWorker := workerpool.NewInputWorker(recordCh, doneCh, ctx)
 	for i := 1; i <= runtime.NumCPU(); i++ {
 		outWorker := workerpool.NewOutputWorker(i, recordCh, doneCh, ctx, db)
@@ -131,31 +363,154 @@ func main() {
 
 	g.Go(inWorker.Loop)
 
-	usr := storage.New()
-	mw := middleware.New(usr)
-	srv := handlers.New(db, cfg, usr, inWorker)
+	//	Init availability checks of original URLs
+	if cfg.HealthCheckInterval > 0 {
+		checker := linkcheck.New(ctx, db, linkcheck.Settings{
+			Interval:     cfg.HealthCheckInterval,
+			Workers:      cfg.HealthCheckWorkers,
+			Timeout:      cfg.HealthCheckTimeout,
+			HostInterval: cfg.HealthCheckHostInterval,
+			DenyPrivate:  cfg.DenyPrivateHosts,
+		})
+		g.Go(checker.Loop)
+	}
+
+	//	Init metadata fetches of pages of new original URLs
+	var fetcher *pagemeta.Fetcher
+	if cfg.MetaWorkers > 0 {
+		fetcher = pagemeta.New(ctx, db, pagemeta.Settings{
+			Workers:     cfg.MetaWorkers,
+			Timeout:     cfg.MetaTimeout,
+			MaxBytes:    int64(cfg.MetaMaxBytes),
+			DenyPrivate: cfg.DenyPrivateHosts,
+		})
+		g.Go(fetcher.Loop)
+	}
+
+	initial, err := newParts(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+	live := &reloadable{
+		cfg:           holder,
+		canonicalizer: reload.NewCanonicalizer(initial.canonicalizer),
+		validator:     reload.NewValidator(initial.policy),
+	}
+
+	opts := []handlers.Option{
+		handlers.WithCanonicalizer(live.canonicalizer),
+		handlers.WithValidator(live.validator),
+	}
+	if checker, err := newURLChecker(cfg); err != nil {
+		log.Fatal(err)
+	} else if checker != nil {
+		opts = append(opts, handlers.WithURLChecker(checker, cfg.ReputationFailOpen))
+	}
+
+	ring, err := sessionKeys(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+	usr := storage.New(ring)
+	accountService := accounts.New(db)
+	if fetcher != nil {
+		opts = append(opts, handlers.WithMetaFetcher(fetcher))
+	}
+	if hooks != nil {
+		opts = append(opts, handlers.WithWebhooks(hooks))
+	}
+	opts = append(opts, handlers.WithAccounts(accountService), handlers.WithWorkspaces(workspaces.New(db)), handlers.WithRouting(routing.New(db)))
+	if cfg.GeoIPFile != "" {
+		geo, err := geoip.Load(cfg.GeoIPFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		opts = append(opts, handlers.WithGeoResolver(geo))
+	}
+	mw := middleware.New(usr, accountService)
+	srv := handlers.New(db, holder, usr, inWorker, opts...)
+
+	var rateStore interfaces.RateStore = ratelimit.NewMemoryStore()
+	if cfg.RateLimitStore == "postgres" {
+		pg, ok := db.(*storage.Storage)
+		if !ok {
+			log.Fatal("postgres rate limit store requires DATABASE_DSN")
+		}
+		rateStore = pg
+	}
+	live.limiter = ratelimit.New(rateStore, usr, initial.limits)
 
 	e := echo.New()
+	e.Logger.SetLevel(initial.level)
+	live.logger = e.Logger
+	go reload.OnHangup(ctx, live.reload)
+	if cfg.TrustProxy {
+		e.IPExtractor = echo.ExtractIPFromXFFHeader()
+	} else {
+		e.IPExtractor = echo.ExtractIPDirect()
+	}
 	pprof.Register(e)
 	e.Use(middleware.CompressHandle)
 	e.Use(middleware.Decompress)
 	e.Use(mw.SessionWithCookies)
-	e.GET("/:id", srv.GetURL)
+	e.GET("/:id", srv.GetURL, live.limiter.Limit(ratelimit.ClassRedirect, nil))
+	e.POST("/:id", srv.PostUnlock, live.limiter.LimitLink(ratelimit.ClassUnlock))
+	e.GET("/api/links/:id", srv.GetLinkPreview, live.limiter.Limit(ratelimit.ClassRedirect, nil))
+	e.GET("/:id/qr", srv.GetQR, live.limiter.Limit(ratelimit.ClassRedirect, nil))
 	e.GET("/api/user/urls", srv.GetURLsByUserID)
+	e.GET("/api/user/urls/search", srv.GetSearch)
+	e.GET("/api/events", srv.GetEvents)
 	e.GET("/ping", srv.GetPing)
-	e.POST("/", srv.PostURL)
-	e.POST("/api/shorten", srv.PostJSON)
-	e.POST("/api/shorten/batch", srv.PostBatch)
-	e.DELETE("/api/user/urls", srv.DelURLsBATCH)
-	m := &autocert.Manager{
-		Prompt:     autocert.AcceptTOS,
-		Cache:      autocert.DirCache("cache-dir"),
-		HostPolicy: autocert.HostWhitelist("mysite.ru"),
-	}
+	e.POST("/", srv.PostURL, live.limiter.Limit(ratelimit.ClassCreate, nil))
+	e.POST("/api/shorten", srv.PostJSON, live.limiter.Limit(ratelimit.ClassCreate, nil))
+	e.POST("/api/shorten/batch", srv.PostBatch, live.limiter.Limit(ratelimit.ClassCreate, ratelimit.BatchCost))
+	e.DELETE("/api/user/urls", srv.DelURLsBATCH, live.limiter.Limit(ratelimit.ClassDelete, ratelimit.BatchCost))
+	e.PATCH("/api/user/urls/:id", srv.PatchURL, live.limiter.Limit(ratelimit.ClassCreate, nil))
+	e.GET("/api/user/urls/:id/history", srv.GetURLHistory)
+	e.GET("/api/user/urls/:id/rules", srv.GetRules)
+	e.POST("/api/user/urls/:id/rules", srv.PostRule, live.limiter.Limit(ratelimit.ClassCreate, nil))
+	e.PUT("/api/user/urls/:id/rules/:rule_id", srv.PutRule, live.limiter.Limit(ratelimit.ClassCreate, nil))
+	e.DELETE("/api/user/urls/:id/rules/:rule_id", srv.DelRule)
+	e.GET("/api/user/urls/:id/destinations", srv.GetDestinations)
+	e.PUT("/api/user/urls/:id/destinations", srv.PutDestinations, live.limiter.Limit(ratelimit.ClassCreate, nil))
+	e.PUT("/api/user/urls/:id/annotation", srv.PutAnnotation, live.limiter.Limit(ratelimit.ClassCreate, nil))
+	e.POST("/api/user/register", srv.PostRegister, live.limiter.Limit(ratelimit.ClassAccount, nil))
+	e.POST("/api/user/login", srv.PostLogin, live.limiter.Limit(ratelimit.ClassAccount, nil), live.limiter.LimitLogin(ratelimit.ClassLogin))
+	e.GET("/api/user/keys", srv.GetAPIKeys)
+	e.POST("/api/user/keys", srv.PostAPIKey, live.limiter.Limit(ratelimit.ClassAccount, nil))
+	e.DELETE("/api/user/keys/:id", srv.DelAPIKey, live.limiter.Limit(ratelimit.ClassAccount, nil))
+	e.POST("/api/workspaces", srv.PostWorkspace)
+	e.GET("/api/workspaces", srv.GetWorkspaces)
+	e.GET("/api/workspaces/:id/members", srv.GetMembers)
+	e.PUT("/api/workspaces/:id/members", srv.PutMember)
+	e.DELETE("/api/workspaces/:id/members/:user_id", srv.DelMember)
+	e.GET("/api/user/webhooks", srv.GetWebhooks)
+	e.POST("/api/user/webhooks", srv.PostWebhook, live.limiter.Limit(ratelimit.ClassCreate, nil))
+	e.DELETE("/api/user/webhooks/:id", srv.DelWebhook)
+	e.GET("/api/user/webhooks/:id/deliveries", srv.GetWebhookDeliveries)
+
 	s := http.Server{
-		Addr:      cfg.SrvAddr(),
-		Handler:   e, // set Echo as handler
-		TLSConfig: m.TLSConfig(),
+		Addr:    cfg.SrvAddr(),
+		Handler: e, // set Echo as handler
+	}
+	var redirect *http.Server
+	if cfg.EnableHTTPS {
+		tlsSrv, err := newTLS(cfg)
+		if err != nil {
+			log.Fatal(err)
+		}
+		s.TLSConfig = tlsSrv.Config
+		if cfg.HTTPRedirectAddress != "" {
+			redirect = &http.Server{
+				Addr:    cfg.HTTPRedirectAddress,
+				Handler: tlsSrv.Redirect,
+			}
+			go func() {
+				if err := redirect.ListenAndServe(); err != http.ErrServerClosed {
+					log.Println(err)
+				}
+			}()
+		}
 	}
 	signal.Notify(signalChan, syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT)
 	go func() {
@@ -165,8 +520,13 @@ func main() {
 		log.Println("Shutting down...")
 
 		if cfg.EnableHTTPS {
+			if redirect != nil {
+				if err = redirect.Shutdown(ctx); err != nil && err != ctx.Err() {
+					log.Println(err)
+				}
+			}
 			if err = s.Shutdown(ctx); err != nil && err != ctx.Err() {
-				s.ErrorLog.Fatal(ctx)
+				log.Fatal(err)
 			}
 		} else {
 			if err = e.Shutdown(ctx); err != nil && err != ctx.Err() {
@@ -194,7 +554,7 @@ func main() {
 		}
 	} else {
 		if err = s.ListenAndServeTLS("", ""); err != http.ErrServerClosed {
-			s.ErrorLog.Fatal(err)
+			log.Fatal(err)
 		}
 	}
 
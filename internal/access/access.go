@@ -0,0 +1,58 @@
+//	Package access for short URLs asking for a password or gone after a number of visits.
+package access
+
+import (
+	"fmt"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/ivanmyagkov/shortener.git/internal/interfaces"
+)
+
+//	Codes of rejected access limits.
+const (
+	CodeInvalidPassword  = "invalid_password"
+	CodeInvalidMaxVisits = "invalid_max_visits"
+)
+
+//	Limits of access settings.
+const (
+	MinPassword = 4
+	// bcrypt uses only the first 72 bytes
+	MaxPassword  = 72
+	MaxMaxVisits = 1000000
+)
+
+//	SetPassword Returning the access with the password, an empty password removes it.
+func SetPassword(a interfaces.Access, password string) (interfaces.Access, error) {
+	if password == "" {
+		a.PasswordHash = nil
+		return a, nil
+	}
+	if len(password) < MinPassword || len(password) > MaxPassword {
+		return a, &interfaces.ValidationError{Code: CodeInvalidPassword, Message: fmt.Sprintf("password must have %d to %d bytes", MinPassword, MaxPassword)}
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return a, err
+	}
+	a.PasswordHash = hash
+	return a, nil
+}
+
+//	SetMaxVisits Returning the access with the limit of visits, 0 is unlimited and 1 makes a one-time link.
+func SetMaxVisits(a interfaces.Access, maxVisits int64) (interfaces.Access, error) {
+	if maxVisits < 0 || maxVisits > MaxMaxVisits {
+		return a, &interfaces.ValidationError{Code: CodeInvalidMaxVisits, Message: fmt.Sprintf("max visits must be 0 to %d", MaxMaxVisits)}
+	}
+	a.MaxVisits = maxVisits
+	return a, nil
+}
+
+//	Unlock Checking the password opens the short URL, any password opens one without a password.
+func Unlock(a interfaces.Access, password string) bool {
+	if len(a.PasswordHash) == 0 {
+		return true
+	}
+	return bcrypt.CompareHashAndPassword(a.PasswordHash, []byte(password)) == nil
+}
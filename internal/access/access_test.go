@@ -0,0 +1,40 @@
+package access
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ivanmyagkov/shortener.git/internal/interfaces"
+)
+
+func TestSetPassword(t *testing.T) {
+	_, err := SetPassword(interfaces.Access{}, "abc")
+	assert.Error(t, err)
+	_, err = SetPassword(interfaces.Access{}, strings.Repeat("a", MaxPassword+1))
+	assert.Error(t, err)
+
+	a, err := SetPassword(interfaces.Access{MaxVisits: 3}, "secret")
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), a.MaxVisits)
+	assert.True(t, Unlock(a, "secret"))
+	assert.False(t, Unlock(a, "Secret"))
+	assert.False(t, Unlock(a, ""))
+
+	a, err = SetPassword(a, "")
+	require.NoError(t, err)
+	assert.True(t, Unlock(a, ""))
+	assert.Equal(t, interfaces.Access{MaxVisits: 3}, a)
+}
+
+func TestSetMaxVisits(t *testing.T) {
+	_, err := SetMaxVisits(interfaces.Access{}, -1)
+	assert.Error(t, err)
+	_, err = SetMaxVisits(interfaces.Access{}, MaxMaxVisits+1)
+	assert.Error(t, err)
+	a, err := SetMaxVisits(interfaces.Access{}, 1)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), a.MaxVisits)
+}
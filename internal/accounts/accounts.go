@@ -0,0 +1,171 @@
+//	Package accounts for registered users and their API keys.
+//
+//	An account is created from an anonymous session and keeps its user ID,
+//	so the links of the session become the links of the account.
+package accounts
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/ivanmyagkov/shortener.git/internal/interfaces"
+)
+
+//	Codes of rejected registrations.
+const (
+	CodeInvalidLogin = "invalid_login"
+	CodeWeakPassword = "weak_password"
+)
+
+const (
+	minPassword = 8
+	// bcrypt uses only the first 72 bytes
+	maxPassword = 72
+	maxLogin    = 64
+	// keyPrefix marks API keys, so leaked keys are easy to find
+	keyPrefix = "sk_"
+)
+
+type Service struct {
+	storage interfaces.Storage
+	// dummyHash is compared for unknown logins, so they take as long as wrong passwords
+	dummyHash []byte
+}
+
+//	New is function to create the accounts service.
+func New(storage interfaces.Storage) *Service {
+	dummy, _ := bcrypt.GenerateFromPassword([]byte("dummy password"), bcrypt.DefaultCost)
+	return &Service{
+		storage:   storage,
+		dummyHash: dummy,
+	}
+}
+
+//	Register Creating an account for the session user.
+//	Returns interfaces.ErrAlreadyExists if the login is taken or the user has an account.
+func (s *Service) Register(userID, login, password string) (interfaces.Account, error) {
+	login = strings.TrimSpace(login)
+	if login == "" || len(login) > maxLogin || strings.ContainsAny(login, " \t\r\n") {
+		return interfaces.Account{}, &interfaces.ValidationError{Code: CodeInvalidLogin, Message: fmt.Sprintf("login must have 1 to %d characters without spaces", maxLogin)}
+	}
+	if len(password) < minPassword || len(password) > maxPassword {
+		return interfaces.Account{}, &interfaces.ValidationError{Code: CodeWeakPassword, Message: fmt.Sprintf("password must have %d to %d characters", minPassword, maxPassword)}
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return interfaces.Account{}, err
+	}
+	account := interfaces.Account{
+		UserID:       userID,
+		Login:        login,
+		PasswordHash: hash,
+		CreatedAt:    time.Now().UTC(),
+	}
+	if err = s.storage.CreateAccount(account); err != nil {
+		return interfaces.Account{}, err
+	}
+	return account, nil
+}
+
+//	Login Checking the password of the account.
+//	Links of the anonymous session user are moved to the account.
+func (s *Service) Login(login, password, sessionUserID string) (interfaces.Account, error) {
+	account, err := s.storage.GetAccount(strings.TrimSpace(login))
+	if errors.Is(err, interfaces.ErrNotFound) {
+		_ = bcrypt.CompareHashAndPassword(s.dummyHash, []byte(password))
+		return interfaces.Account{}, interfaces.ErrUnauthorized
+	} else if err != nil {
+		return interfaces.Account{}, err
+	}
+	if err = bcrypt.CompareHashAndPassword(account.PasswordHash, []byte(password)); err != nil {
+		return interfaces.Account{}, interfaces.ErrUnauthorized
+	}
+	if sessionUserID != "" && sessionUserID != account.UserID {
+		// links of another account are never claimed
+		if _, err = s.storage.GetAccountByUserID(sessionUserID); errors.Is(err, interfaces.ErrNotFound) {
+			if err = s.storage.ClaimURLs(sessionUserID, account.UserID); err != nil {
+				return interfaces.Account{}, err
+			}
+		} else if err != nil {
+			return interfaces.Account{}, err
+		}
+	}
+	return account, nil
+}
+
+//	IssueKey Creating an API key of the account, the key itself is returned only here.
+//	Returns interfaces.ErrNotFound if the user has no account.
+func (s *Service) IssueKey(userID, name string) (interfaces.APIKey, string, error) {
+	if _, err := s.storage.GetAccountByUserID(userID); err != nil {
+		return interfaces.APIKey{}, "", err
+	}
+	id, err := random(8)
+	if err != nil {
+		return interfaces.APIKey{}, "", err
+	}
+	secret, err := random(24)
+	if err != nil {
+		return interfaces.APIKey{}, "", err
+	}
+	raw := keyPrefix + secret
+	key := interfaces.APIKey{
+		ID:        id,
+		UserID:    userID,
+		Name:      strings.TrimSpace(name),
+		Hash:      hash(raw),
+		CreatedAt: time.Now().UTC(),
+	}
+	if err = s.storage.SetAPIKey(key); err != nil {
+		return interfaces.APIKey{}, "", err
+	}
+	return key, raw, nil
+}
+
+//	Keys Listing API keys of the account.
+//	Returns interfaces.ErrNotFound if the user has no account.
+func (s *Service) Keys(userID string) ([]interfaces.APIKey, error) {
+	if _, err := s.storage.GetAccountByUserID(userID); err != nil {
+		return nil, err
+	}
+	return s.storage.GetAPIKeys(userID)
+}
+
+//	Revoke Deleting an API key of the account.
+func (s *Service) Revoke(userID, id string) error {
+	return s.storage.DelAPIKey(userID, id)
+}
+
+//	Authenticate Returning the user of the API key.
+func (s *Service) Authenticate(apiKey string) (string, error) {
+	if !strings.HasPrefix(apiKey, keyPrefix) {
+		return "", interfaces.ErrUnauthorized
+	}
+	key, err := s.storage.GetAPIKeyByHash(hash(apiKey))
+	if errors.Is(err, interfaces.ErrNotFound) {
+		return "", interfaces.ErrUnauthorized
+	} else if err != nil {
+		return "", err
+	}
+	return key.UserID, nil
+}
+
+//	hash Hashing an API key, keys are random so a plain hash is enough.
+func hash(apiKey string) string {
+	sum := sha256.Sum256([]byte(apiKey))
+	return hex.EncodeToString(sum[:])
+}
+
+func random(size int) (string, error) {
+	b := make([]byte, size)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,99 @@
+package accounts
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ivanmyagkov/shortener.git/internal/interfaces"
+	"github.com/ivanmyagkov/shortener.git/internal/storage"
+)
+
+func TestRegister(t *testing.T) {
+	tests := []struct {
+		name     string
+		userID   string
+		login    string
+		password string
+		code     string
+		err      error
+	}{
+		{name: "ok", userID: "u1", login: "alice", password: "password1"},
+		{name: "login taken", userID: "u2", login: "alice", password: "password1", err: interfaces.ErrAlreadyExists},
+		{name: "user has account", userID: "u1", login: "bob", password: "password1", err: interfaces.ErrAlreadyExists},
+		{name: "empty login", userID: "u3", login: " ", password: "password1", code: CodeInvalidLogin},
+		{name: "login with space", userID: "u3", login: "a b", password: "password1", code: CodeInvalidLogin},
+		{name: "short password", userID: "u3", login: "carol", password: "short", code: CodeWeakPassword},
+	}
+	s := New(storage.NewDBConn())
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			account, err := s.Register(tt.userID, tt.login, tt.password)
+			var validationErr *interfaces.ValidationError
+			switch {
+			case tt.code != "":
+				require.True(t, errors.As(err, &validationErr))
+				assert.Equal(t, tt.code, validationErr.Code)
+			case tt.err != nil:
+				assert.ErrorIs(t, err, tt.err)
+			default:
+				require.NoError(t, err)
+				assert.Equal(t, tt.userID, account.UserID)
+				assert.NotEqual(t, tt.password, string(account.PasswordHash))
+			}
+		})
+	}
+}
+
+func TestLogin(t *testing.T) {
+	db := storage.NewDBConn()
+	s := New(db)
+	_, err := s.Register("owner", "alice", "password1")
+	require.NoError(t, err)
+	require.NoError(t, db.SetShortURL("owner", "a", "http://a.example"))
+	require.NoError(t, db.SetShortURL("anonymous", "b", "http://b.example"))
+
+	_, err = s.Login("alice", "wrong password", "")
+	assert.ErrorIs(t, err, interfaces.ErrUnauthorized)
+	_, err = s.Login("nobody", "password1", "")
+	assert.ErrorIs(t, err, interfaces.ErrUnauthorized)
+
+	account, err := s.Login("alice", "password1", "anonymous")
+	require.NoError(t, err)
+	assert.Equal(t, "owner", account.UserID)
+	urls, err := db.GetAllURLsByUserID("owner")
+	require.NoError(t, err)
+	assert.Len(t, urls, 2)
+	_, err = db.GetAllURLsByUserID("anonymous")
+	assert.Error(t, err)
+}
+
+func TestAPIKeys(t *testing.T) {
+	s := New(storage.NewDBConn())
+	_, _, err := s.IssueKey("anonymous", "ci")
+	assert.ErrorIs(t, err, interfaces.ErrNotFound)
+
+	_, err = s.Register("owner", "alice", "password1")
+	require.NoError(t, err)
+	key, raw, err := s.IssueKey("owner", "ci")
+	require.NoError(t, err)
+	assert.NotContains(t, key.Hash, raw)
+
+	userID, err := s.Authenticate(raw)
+	require.NoError(t, err)
+	assert.Equal(t, "owner", userID)
+	_, err = s.Authenticate("sk_unknown")
+	assert.ErrorIs(t, err, interfaces.ErrUnauthorized)
+
+	list, err := s.Keys("owner")
+	require.NoError(t, err)
+	require.Len(t, list, 1)
+	assert.Equal(t, "ci", list[0].Name)
+
+	assert.ErrorIs(t, s.Revoke("someone", key.ID), interfaces.ErrNotFound)
+	require.NoError(t, s.Revoke("owner", key.ID))
+	_, err = s.Authenticate(raw)
+	assert.ErrorIs(t, err, interfaces.ErrUnauthorized)
+}
@@ -0,0 +1,96 @@
+//	Package annotation for titles, notes and tags owners give their short URLs.
+package annotation
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/ivanmyagkov/shortener.git/internal/interfaces"
+)
+
+//	Codes of rejected annotations and filters.
+const (
+	CodeInvalidTitle = "invalid_title"
+	CodeInvalidNotes = "invalid_notes"
+	CodeInvalidTags  = "invalid_tags"
+	CodeInvalidQuery = "invalid_query"
+)
+
+//	Limits of annotations in characters.
+const (
+	MaxTitle = 200
+	MaxNotes = 2000
+	MaxTag   = 32
+	MaxTags  = 20
+	MaxQuery = 200
+)
+
+//	Normalize Returning the annotation with trimmed text and tags in lower case, sorted and without duplicates.
+func Normalize(a interfaces.Annotation) (interfaces.Annotation, error) {
+	a.Title = strings.TrimSpace(a.Title)
+	if utf8.RuneCountInString(a.Title) > MaxTitle || strings.ContainsAny(a.Title, "\r\n") {
+		return a, &interfaces.ValidationError{Code: CodeInvalidTitle, Message: fmt.Sprintf("title must be one line of at most %d characters", MaxTitle)}
+	}
+	a.Notes = strings.TrimSpace(a.Notes)
+	if utf8.RuneCountInString(a.Notes) > MaxNotes {
+		return a, &interfaces.ValidationError{Code: CodeInvalidNotes, Message: fmt.Sprintf("notes must have at most %d characters", MaxNotes)}
+	}
+	tags, err := Tags(a.Tags)
+	if err != nil {
+		return a, err
+	}
+	if len(tags) > MaxTags {
+		return a, &interfaces.ValidationError{Code: CodeInvalidTags, Message: fmt.Sprintf("a link may have at most %d tags", MaxTags)}
+	}
+	a.Tags = tags
+	return a, nil
+}
+
+//	Tags Returning the tags in lower case, sorted and without duplicates, nil for no tags.
+//	A tag is made of letters, digits, "-", "_" and ".".
+func Tags(tags []string) ([]string, error) {
+	seen := make(map[string]struct{}, len(tags))
+	var normalized []string
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if !validTag(tag) {
+			return nil, &interfaces.ValidationError{Code: CodeInvalidTags, Message: fmt.Sprintf("tag %q must have 1 to %d letters, digits, \"-\", \"_\" or \".\"", tag, MaxTag)}
+		}
+		if _, ok := seen[tag]; ok {
+			continue
+		}
+		seen[tag] = struct{}{}
+		normalized = append(normalized, tag)
+	}
+	sort.Strings(normalized)
+	return normalized, nil
+}
+
+func validTag(tag string) bool {
+	if tag == "" || utf8.RuneCountInString(tag) > MaxTag {
+		return false
+	}
+	for _, r := range tag {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '-' && r != '_' && r != '.' {
+			return false
+		}
+	}
+	return true
+}
+
+//	Filter Returning the filter of the tags and the text to search for.
+func Filter(tags []string, query string) (interfaces.URLFilter, error) {
+	var filter interfaces.URLFilter
+	var err error
+	if filter.Tags, err = Tags(tags); err != nil {
+		return filter, err
+	}
+	filter.Query = strings.TrimSpace(query)
+	if utf8.RuneCountInString(filter.Query) > MaxQuery {
+		return filter, &interfaces.ValidationError{Code: CodeInvalidQuery, Message: fmt.Sprintf("query must have at most %d characters", MaxQuery)}
+	}
+	return filter, nil
+}
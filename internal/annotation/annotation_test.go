@@ -0,0 +1,68 @@
+package annotation
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ivanmyagkov/shortener.git/internal/interfaces"
+)
+
+func TestNormalize(t *testing.T) {
+	a, err := Normalize(interfaces.Annotation{Title: "  Launch post ", Notes: "\nfor the blog\n", Tags: []string{"Blog", "2024", "blog", " q3.launch "}})
+	require.NoError(t, err)
+	assert.Equal(t, interfaces.Annotation{Title: "Launch post", Notes: "for the blog", Tags: []string{"2024", "blog", "q3.launch"}}, a)
+
+	a, err = Normalize(interfaces.Annotation{Tags: []string{}})
+	require.NoError(t, err)
+	assert.True(t, a.IsZero())
+
+	tooMany := make([]string, MaxTags+1)
+	for i := range tooMany {
+		tooMany[i] = strings.Repeat("t", i+1)
+	}
+	tests := []struct {
+		name       string
+		annotation interfaces.Annotation
+		code       string
+	}{
+		{"long title", interfaces.Annotation{Title: strings.Repeat("й", MaxTitle+1)}, CodeInvalidTitle},
+		{"title lines", interfaces.Annotation{Title: "one\ntwo"}, CodeInvalidTitle},
+		{"long notes", interfaces.Annotation{Notes: strings.Repeat("n", MaxNotes+1)}, CodeInvalidNotes},
+		{"empty tag", interfaces.Annotation{Tags: []string{" "}}, CodeInvalidTags},
+		{"tag with space", interfaces.Annotation{Tags: []string{"two words"}}, CodeInvalidTags},
+		{"long tag", interfaces.Annotation{Tags: []string{strings.Repeat("t", MaxTag+1)}}, CodeInvalidTags},
+		{"too many tags", interfaces.Annotation{Tags: tooMany}, CodeInvalidTags},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Normalize(tt.annotation)
+			var validationErr *interfaces.ValidationError
+			require.ErrorAs(t, err, &validationErr)
+			assert.Equal(t, tt.code, validationErr.Code)
+		})
+	}
+	// long titles are counted in characters, not bytes
+	_, err = Normalize(interfaces.Annotation{Title: strings.Repeat("й", MaxTitle)})
+	assert.NoError(t, err)
+}
+
+func TestFilter(t *testing.T) {
+	filter, err := Filter([]string{"Blog", "news"}, " Launch ")
+	require.NoError(t, err)
+	assert.Equal(t, interfaces.URLFilter{Tags: []string{"blog", "news"}, Query: "Launch"}, filter)
+
+	model := interfaces.ModelURL{BaseURL: "https://example.com/posts", Annotation: interfaces.Annotation{Title: "Launch post", Tags: []string{"blog", "news", "q3"}}}
+	assert.True(t, filter.Match(model))
+	assert.True(t, interfaces.URLFilter{Query: "EXAMPLE.com"}.Match(model))
+	assert.False(t, interfaces.URLFilter{Tags: []string{"blog", "video"}}.Match(model))
+	assert.False(t, interfaces.URLFilter{Query: "release"}.Match(model))
+	assert.True(t, interfaces.URLFilter{}.Match(model))
+
+	_, err = Filter([]string{"a b"}, "")
+	assert.Error(t, err)
+	_, err = Filter(nil, strings.Repeat("q", MaxQuery+1))
+	assert.Error(t, err)
+}
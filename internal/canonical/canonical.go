@@ -0,0 +1,200 @@
+//	Package canonical for bringing URLs to a canonical form before shortening,
+//	so that equivalent URLs get the same short URL.
+package canonical
+
+import (
+	"net"
+	"net/url"
+	"sort"
+	"strings"
+
+	"golang.org/x/net/idna"
+)
+
+//	Names of canonicalization steps.
+const (
+	StepCase          = "case"
+	StepDefaultPort   = "port"
+	StepTrailingSlash = "slash"
+	StepSortQuery     = "query"
+	StepTracking      = "tracking"
+	StepIDN           = "idn"
+)
+
+//	DefaultSteps are the steps applied when nothing is configured.
+var DefaultSteps = []string{StepCase, StepDefaultPort, StepTrailingSlash, StepSortQuery, StepTracking, StepIDN}
+
+//	DefaultTrackingParams are query parameters stripped by the tracking step.
+//	A trailing "*" matches any parameter with this prefix.
+var DefaultTrackingParams = []string{"utm_*", "fbclid", "gclid", "yclid"}
+
+var defaultPorts = map[string]string{
+	"http":  "80",
+	"https": "443",
+}
+
+type step func(u *url.URL) error
+
+type Canonicalizer struct {
+	steps    []step
+	tracking []string
+}
+
+//	New is function to create a canonicalizer from step names in the order of application.
+func New(steps []string, trackingParams []string) (*Canonicalizer, error) {
+	c := &Canonicalizer{tracking: trackingParams}
+	for _, name := range steps {
+		switch strings.TrimSpace(name) {
+		case StepCase:
+			c.steps = append(c.steps, lowerCase)
+		case StepDefaultPort:
+			c.steps = append(c.steps, dropDefaultPort)
+		case StepTrailingSlash:
+			c.steps = append(c.steps, trimTrailingSlash)
+		case StepSortQuery:
+			c.steps = append(c.steps, sortQuery)
+		case StepTracking:
+			c.steps = append(c.steps, c.stripTracking)
+		case StepIDN:
+			c.steps = append(c.steps, punycode)
+		case "":
+		default:
+			return nil, &UnknownStepError{Name: name}
+		}
+	}
+	return c, nil
+}
+
+//	UnknownStepError is returned by New for a step name it doesn't know.
+type UnknownStepError struct {
+	Name string
+}
+
+func (e *UnknownStepError) Error() string {
+	return "unknown canonicalization step: " + e.Name
+}
+
+//	Canonicalize Bringing the URL to the canonical form.
+func (c *Canonicalizer) Canonicalize(rawURL string) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", err
+	}
+	for _, s := range c.steps {
+		if err = s(u); err != nil {
+			return "", err
+		}
+	}
+	return u.String(), nil
+}
+
+//	lowerCase Scheme and host are case-insensitive.
+func lowerCase(u *url.URL) error {
+	u.Scheme = strings.ToLower(u.Scheme)
+	u.Host = strings.ToLower(u.Host)
+	return nil
+}
+
+func dropDefaultPort(u *url.URL) error {
+	host, port, err := net.SplitHostPort(u.Host)
+	if err != nil {
+		return nil
+	}
+	if defaultPorts[strings.ToLower(u.Scheme)] == port {
+		if strings.Contains(host, ":") {
+			host = "[" + host + "]"
+		}
+		u.Host = host
+	}
+	return nil
+}
+
+func trimTrailingSlash(u *url.URL) error {
+	u.Path = strings.TrimRight(u.Path, "/")
+	if u.RawPath != "" {
+		u.RawPath = strings.TrimRight(u.RawPath, "/")
+	}
+	return nil
+}
+
+//	sortQuery Query parameters are sorted by name, values of the same parameter keep their order.
+func sortQuery(u *url.URL) error {
+	if u.RawQuery == "" {
+		return nil
+	}
+	params := strings.Split(u.RawQuery, "&")
+	sort.SliceStable(params, func(i, j int) bool {
+		return paramName(params[i]) < paramName(params[j])
+	})
+	u.RawQuery = strings.Join(params, "&")
+	return nil
+}
+
+func (c *Canonicalizer) stripTracking(u *url.URL) error {
+	if u.RawQuery == "" {
+		return nil
+	}
+	params := strings.Split(u.RawQuery, "&")
+	kept := params[:0]
+	for _, param := range params {
+		if param == "" || c.isTracking(paramName(param)) {
+			continue
+		}
+		kept = append(kept, param)
+	}
+	u.RawQuery = strings.Join(kept, "&")
+	u.ForceQuery = false
+	return nil
+}
+
+func (c *Canonicalizer) isTracking(name string) bool {
+	name = strings.ToLower(name)
+	for _, pattern := range c.tracking {
+		pattern = strings.ToLower(pattern)
+		if strings.HasSuffix(pattern, "*") {
+			if strings.HasPrefix(name, strings.TrimSuffix(pattern, "*")) {
+				return true
+			}
+		} else if name == pattern {
+			return true
+		}
+	}
+	return false
+}
+
+//	punycode Converting internationalized host names to ASCII.
+func punycode(u *url.URL) error {
+	host, port := u.Hostname(), u.Port()
+	if isASCII(host) {
+		return nil
+	}
+	ascii, err := idna.Lookup.ToASCII(host)
+	if err != nil {
+		return err
+	}
+	if port != "" {
+		ascii = net.JoinHostPort(ascii, port)
+	}
+	u.Host = ascii
+	return nil
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= 0x80 {
+			return false
+		}
+	}
+	return true
+}
+
+func paramName(param string) string {
+	name := param
+	if i := strings.IndexByte(param, '='); i >= 0 {
+		name = param[:i]
+	}
+	if unescaped, err := url.QueryUnescape(name); err == nil {
+		return unescaped
+	}
+	return name
+}
@@ -0,0 +1,86 @@
+package canonical
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCanonicalizer_Canonicalize(t *testing.T) {
+	tests := []struct {
+		name  string
+		steps []string
+		value string
+		want  string
+	}{
+		{
+			name:  "scheme and host case",
+			steps: DefaultSteps,
+			value: "HTTP://Example.COM/Path",
+			want:  "http://example.com/Path",
+		},
+		{
+			name:  "root trailing slash",
+			steps: DefaultSteps,
+			value: "HTTP://Example.com/",
+			want:  "http://example.com",
+		},
+		{
+			name:  "default port",
+			steps: DefaultSteps,
+			value: "https://example.com:443/a/",
+			want:  "https://example.com/a",
+		},
+		{
+			name:  "not default port",
+			steps: DefaultSteps,
+			value: "https://example.com:8443/a",
+			want:  "https://example.com:8443/a",
+		},
+		{
+			name:  "query order and tracking",
+			steps: DefaultSteps,
+			value: "http://example.com/?b=2&utm_source=x&a=1&a=0&UTM_Medium=y&gclid=1",
+			want:  "http://example.com?a=1&a=0&b=2",
+		},
+		{
+			name:  "only tracking",
+			steps: DefaultSteps,
+			value: "http://example.com/p?utm_campaign=x",
+			want:  "http://example.com/p",
+		},
+		{
+			name:  "idn",
+			steps: DefaultSteps,
+			value: "http://Пример.рф/",
+			want:  "http://xn--e1afmkfd.xn--p1ai",
+		},
+		{
+			name:  "no steps",
+			steps: nil,
+			value: "HTTP://Example.com/?b=2&a=1",
+			want:  "http://Example.com/?b=2&a=1",
+		},
+		{
+			name:  "only query",
+			steps: []string{StepSortQuery},
+			value: "http://Example.com/?b=2&a=1",
+			want:  "http://Example.com/?a=1&b=2",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := New(tt.steps, DefaultTrackingParams)
+			require.NoError(t, err)
+			got, err := c.Canonicalize(tt.value)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestNew_UnknownStep(t *testing.T) {
+	_, err := New([]string{StepCase, "fragment"}, nil)
+	assert.Error(t, err)
+}
@@ -0,0 +1,197 @@
+//	Package changes for publishing the change log of links to downstream systems.
+//
+//	The storage writes a change with the change of a link itself, a relay reads the log
+//	past the position saved for its consumer, hands the changes to a publisher and saves
+//	the new position. A batch whose position wasn't saved is published again, so
+//	consumers may get a change more than once and should skip offsets they have seen.
+package changes
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/ivanmyagkov/shortener.git/internal/domains"
+	"github.com/ivanmyagkov/shortener.git/internal/interfaces"
+)
+
+const (
+	// changes published at once
+	defaultBatch = 100
+	// longest pause after failed publishing, the pause doubles from the interval
+	maxRetryInterval = time.Minute
+	// how much of a response is read before the connection is dropped
+	maxResponse = 64 << 10
+)
+
+//	Settings of a relay.
+type Settings struct {
+	// how often the log is checked for new changes
+	Interval time.Duration
+	// changes published at once
+	Batch int
+	// base URLs of domains, short URLs are published in full, they are read for every batch so reloads apply
+	Config interfaces.Config
+}
+
+//	Relay publishes the change log to one consumer.
+type Relay struct {
+	storage   interfaces.Storage
+	consumer  string
+	publisher interfaces.ChangePublisher
+	settings  Settings
+	ctx       context.Context
+}
+
+//	New is function to create a relay publishing changes to the publisher, its position is saved as the consumer.
+func New(ctx context.Context, storage interfaces.Storage, consumer string, publisher interfaces.ChangePublisher, settings Settings) *Relay {
+	if settings.Interval <= 0 {
+		settings.Interval = time.Second
+	}
+	if settings.Batch < 1 {
+		settings.Batch = defaultBatch
+	}
+	return &Relay{
+		storage:   storage,
+		consumer:  consumer,
+		publisher: publisher,
+		settings:  settings,
+		ctx:       ctx,
+	}
+}
+
+//	Loop Publishing new changes until the context is done.
+//	Failed batches are retried with a growing pause, later changes wait for them.
+func (r *Relay) Loop() error {
+	pause := r.settings.Interval
+	for {
+		n, err := r.Publish()
+		if err != nil {
+			log.Printf("publishing changes to %s: %v", r.consumer, err)
+			if pause *= 2; pause > maxRetryInterval {
+				pause = maxRetryInterval
+			}
+		} else {
+			pause = r.settings.Interval
+		}
+		if err == nil && n == r.settings.Batch {
+			// there may be more
+			continue
+		}
+		select {
+		case <-r.ctx.Done():
+			return nil
+		case <-time.After(pause):
+		}
+	}
+}
+
+//	Publish Publishing a batch of changes past the saved position and saving the new one,
+//	returning how many changes were published.
+func (r *Relay) Publish() (int, error) {
+	after, err := r.storage.GetChangeCursor(r.consumer)
+	if err != nil {
+		return 0, err
+	}
+	changes, err := r.storage.GetChanges("", "", after, r.settings.Batch)
+	if err != nil || len(changes) == 0 {
+		return 0, err
+	}
+	served := domains.New(r.settings.Config.BaseURLs())
+	for i := range changes {
+		changes[i].ShortURL = served.URL(changes[i].ShortURL)
+	}
+	if err = r.publisher.Publish(r.ctx, changes); err != nil {
+		return 0, err
+	}
+	return len(changes), r.storage.SetChangeCursor(r.consumer, changes[len(changes)-1].Offset)
+}
+
+//	FileSink appends changes to a file as newline delimited JSON.
+type FileSink struct {
+	mu   sync.Mutex
+	path string
+}
+
+//	NewFileSink is function to create a sink appending to the file at the path, it is created if it doesn't exist.
+func NewFileSink(path string) *FileSink {
+	return &FileSink{path: path}
+}
+
+//	Publish Appending the changes to the file, one JSON object per line, and syncing it to the disk.
+func (f *FileSink) Publish(_ context.Context, changes []interfaces.Change) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	body, err := encode(changes)
+	if err != nil {
+		return err
+	}
+	file, err := os.OpenFile(f.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err = file.Write(body); err != nil {
+		file.Close()
+		return err
+	}
+	if err = file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+//	HTTPSink posts batches of changes to a URL as newline delimited JSON.
+type HTTPSink struct {
+	url    string
+	client *http.Client
+}
+
+//	NewHTTPSink is function to create a sink posting to the URL, a batch fails without a 2xx response in time.
+func NewHTTPSink(url string, timeout time.Duration) *HTTPSink {
+	return &HTTPSink{url: url, client: &http.Client{Timeout: timeout}}
+}
+
+//	Publish Posting the changes in one request.
+func (h *HTTPSink) Publish(ctx context.Context, changes []interfaces.Change) error {
+	body, err := encode(changes)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	req.Header.Set("User-Agent", "shortener-changes")
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return err
+	}
+	// reading the rest lets the connection be reused
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, maxResponse))
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+//	encode Returning the changes as newline delimited JSON.
+func encode(changes []interfaces.Change) ([]byte, error) {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	for _, change := range changes {
+		if err := encoder.Encode(change); err != nil {
+			return nil, err
+		}
+	}
+	return buf.Bytes(), nil
+}
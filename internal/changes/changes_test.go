@@ -0,0 +1,163 @@
+package changes
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ivanmyagkov/shortener.git/internal/config"
+	"github.com/ivanmyagkov/shortener.git/internal/interfaces"
+	"github.com/ivanmyagkov/shortener.git/internal/storage"
+)
+
+var testConfig = config.NewConfig(":8080", "http://localhost:8080", "", "", false)
+
+func readLines(t *testing.T, path string) []interfaces.Change {
+	t.Helper()
+	file, err := os.Open(path)
+	require.NoError(t, err)
+	defer file.Close()
+	var changes []interfaces.Change
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var change interfaces.Change
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &change))
+		changes = append(changes, change)
+	}
+	require.NoError(t, scanner.Err())
+	return changes
+}
+
+func TestFileSink(t *testing.T) {
+	db := storage.NewDBConn()
+	require.NoError(t, db.SetShortURL("user", "a", "https://a.example"))
+	require.NoError(t, db.SetWorkspaceURL("ws", "user", "b", "https://b.example"))
+	require.NoError(t, db.SetShortURL("user", "c", "https://c.example"))
+	path := filepath.Join(t.TempDir(), "changes.ndjson")
+	relay := New(context.Background(), db, "file", NewFileSink(path), Settings{Batch: 2, Config: testConfig})
+
+	n, err := relay.Publish()
+	require.NoError(t, err)
+	assert.Equal(t, 2, n)
+	n, err = relay.Publish()
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+	n, err = relay.Publish()
+	require.NoError(t, err)
+	assert.Zero(t, n)
+
+	require.NoError(t, db.DelBatchShortURLs([]interfaces.Task{{ID: "user", ShortURL: "a"}}))
+	_, err = relay.Publish()
+	require.NoError(t, err)
+	changes := readLines(t, path)
+	require.Len(t, changes, 4)
+	for i, change := range changes {
+		assert.Equal(t, int64(i+1), change.Offset)
+	}
+	assert.Equal(t, "http://localhost:8080/a", changes[0].ShortURL)
+	assert.Equal(t, "ws", changes[1].WorkspaceID)
+	assert.Equal(t, interfaces.EventLinkDeleted, changes[3].Type)
+	assert.Equal(t, "https://a.example", changes[3].BaseURL)
+	cursor, err := db.GetChangeCursor("file")
+	require.NoError(t, err)
+	assert.Equal(t, int64(4), cursor)
+}
+
+func TestHTTPSink(t *testing.T) {
+	var mu sync.Mutex
+	var bodies []string
+	fail := true
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "application/x-ndjson", r.Header.Get("Content-Type"))
+		var buf strings.Builder
+		scanner := bufio.NewScanner(r.Body)
+		for scanner.Scan() {
+			buf.WriteString(scanner.Text() + "\n")
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		bodies = append(bodies, buf.String())
+		if fail {
+			fail = false
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer server.Close()
+
+	db := storage.NewDBConn()
+	require.NoError(t, db.SetShortURL("user", "a", "https://a.example"))
+	require.NoError(t, db.SetShortURL("user", "b", "https://b.example"))
+	relay := New(context.Background(), db, "http", NewHTTPSink(server.URL, 0), Settings{Config: testConfig})
+
+	// a failed batch is not skipped
+	_, err := relay.Publish()
+	assert.EqualError(t, err, "status 503")
+	cursor, err := db.GetChangeCursor("http")
+	require.NoError(t, err)
+	assert.Zero(t, cursor)
+
+	n, err := relay.Publish()
+	require.NoError(t, err)
+	assert.Equal(t, 2, n)
+	require.Len(t, bodies, 2)
+	assert.Equal(t, bodies[0], bodies[1])
+	lines := strings.Split(strings.TrimSuffix(bodies[1], "\n"), "\n")
+	require.Len(t, lines, 2)
+	var change interfaces.Change
+	require.NoError(t, json.Unmarshal([]byte(lines[1]), &change))
+	assert.Equal(t, int64(2), change.Offset)
+	assert.Equal(t, "http://localhost:8080/b", change.ShortURL)
+	cursor, err = db.GetChangeCursor("http")
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), cursor)
+}
+
+func TestLoop(t *testing.T) {
+	db := storage.NewDBConn()
+	require.NoError(t, db.SetShortURL("user", "a", "https://a.example"))
+	path := filepath.Join(t.TempDir(), "changes.ndjson")
+	ctx, cancel := context.WithCancel(context.Background())
+	relay := New(ctx, db, "file", NewFileSink(path), Settings{Config: testConfig})
+	done := make(chan error)
+	go func() { done <- relay.Loop() }()
+	require.Eventually(t, func() bool {
+		cursor, err := db.GetChangeCursor("file")
+		return err == nil && cursor == 1
+	}, 5*time.Second, 10*time.Millisecond)
+	cancel()
+	require.NoError(t, <-done)
+	assert.Len(t, readLines(t, path), 1)
+}
+
+func TestPublish_ReloadedBaseURLs(t *testing.T) {
+	db := storage.NewDBConn()
+	require.NoError(t, db.SetShortURL("user", "a", "https://a.example"))
+	holder := config.NewHolder(testConfig)
+	path := filepath.Join(t.TempDir(), "changes.ndjson")
+	relay := New(context.Background(), db, "file", NewFileSink(path), Settings{Config: holder})
+	_, err := relay.Publish()
+	require.NoError(t, err)
+
+	// short URLs published after a reload use the new base URL
+	holder.Set(config.NewConfig(":8080", "https://sho.rt", "", "", false))
+	require.NoError(t, db.SetShortURL("user", "b", "https://b.example"))
+	_, err = relay.Publish()
+	require.NoError(t, err)
+	changes := readLines(t, path)
+	require.Len(t, changes, 2)
+	assert.Equal(t, "http://localhost:8080/a", changes[0].ShortURL)
+	assert.Equal(t, "https://sho.rt/b", changes[1].ShortURL)
+}
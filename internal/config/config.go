@@ -1,31 +1,144 @@
 //	Package config - defining and getting application launch settings
+//
+//	Every setting has a default value and may be set in a config file, by an
+//	environment variable or by a command line flag. Later sources take precedence:
+//	defaults < file < environment < flags.
+//
+//	Tags of Config fields describe the sources: json is the key in config files
+//	of any format, env is the environment variable, flag is the comma separated
+//	list of flag names, default is the default value and usage is the flag help,
+//	alias is an old file key and secret marks values masked when printed.
+//	Settings tagged with reload are applied on SIGHUP, others need a restart.
 package config
 
 import (
-	"encoding/json"
-	"log"
-	"os"
+	"time"
 )
 
 //	Config struct  - Structure of application settings fields
 type Config struct {
 	// server address
-	ServerAddress string
+	ServerAddress string `json:"server_address" env:"SERVER_ADDRESS" flag:"a" default:":8080" usage:"server address"`
 	// server base URL
-	BaseURL string
+	BaseURL string `json:"base_url" env:"BASE_URL" flag:"b" default:"http://localhost:8080" usage:"base url" reload:"true"`
+	// base URLs of other domains short URLs are served under, like "https://go.example.com"
+	Domains []string `json:"domains" env:"DOMAINS" flag:"domains" usage:"comma separated base URLs of other domains short URLs are served under" reload:"true"`
 	// file storage path
-	FileStoragePath string
+	FileStoragePath string `json:"file_storage_path" env:"FILE_STORAGE_PATH" flag:"f" usage:"file storage path"`
 	// database path
-	DatabasePath string
+	DatabasePath string `json:"database_dsn" alias:"database_dns" env:"DATABASE_DSN" flag:"d" usage:"database path" secret:"dsn"`
 	// use ssl
-	EnableHTTPS bool
+	EnableHTTPS bool `json:"enable_https" env:"ENABLE_HTTPS" flag:"s" usage:"enable ssl"`
 	// config file
-	Config string
+	Config string `json:"-" env:"CONFIG" flag:"config,c" usage:"config file: .json, .yaml, .yml or .toml"`
+	// pause between availability checks of original URLs, 0 disables checks
+	HealthCheckInterval time.Duration `json:"health_check_interval" env:"HEALTH_CHECK_INTERVAL" flag:"health-interval" default:"0s" usage:"pause between availability checks of original URLs, 0 disables checks"`
+	// number of concurrent availability checks
+	HealthCheckWorkers int `json:"health_check_workers" env:"HEALTH_CHECK_WORKERS" flag:"health-workers" default:"4" usage:"number of concurrent availability checks"`
+	// timeout of a single availability check
+	HealthCheckTimeout time.Duration `json:"health_check_timeout" env:"HEALTH_CHECK_TIMEOUT" flag:"health-timeout" default:"10s" usage:"timeout of a single availability check"`
+	// minimal pause between availability checks of the same host
+	HealthCheckHostInterval time.Duration `json:"health_check_host_interval" env:"HEALTH_CHECK_HOST_INTERVAL" flag:"health-host-interval" default:"1s" usage:"minimal pause between availability checks of the same host"`
+	// number of concurrent metadata fetches of pages of new original URLs, 0 disables fetching
+	MetaWorkers int `json:"meta_workers" env:"META_WORKERS" flag:"meta-workers" default:"2" usage:"number of concurrent fetches of titles and icons of pages of new original URLs, 0 disables fetching"`
+	// timeout of a single metadata fetch
+	MetaTimeout time.Duration `json:"meta_timeout" env:"META_TIMEOUT" flag:"meta-timeout" default:"5s" usage:"timeout of a single fetch of page metadata"`
+	// how much of a page is read for its metadata
+	MetaMaxBytes int `json:"meta_max_bytes" env:"META_MAX_BYTES" flag:"meta-max-bytes" default:"262144" usage:"how many bytes of a page are read for its metadata"`
+	// number of concurrent webhook deliveries, 0 disables webhooks
+	WebhookWorkers int `json:"webhook_workers" env:"WEBHOOK_WORKERS" flag:"webhook-workers" default:"2" usage:"number of concurrent webhook deliveries, 0 disables webhooks"`
+	// timeout of a single webhook delivery
+	WebhookTimeout time.Duration `json:"webhook_timeout" env:"WEBHOOK_TIMEOUT" flag:"webhook-timeout" default:"10s" usage:"timeout of a single webhook delivery"`
+	// attempts of a webhook delivery before it fails
+	WebhookMaxAttempts int `json:"webhook_max_attempts" env:"WEBHOOK_MAX_ATTEMPTS" flag:"webhook-max-attempts" default:"8" usage:"attempts of a webhook delivery before it fails"`
+	// pause before the first retry of a webhook delivery, doubled after every failed attempt
+	WebhookBackoff time.Duration `json:"webhook_backoff" env:"WEBHOOK_BACKOFF" flag:"webhook-backoff" default:"30s" usage:"pause before the first retry of a webhook delivery, doubled after every failed attempt"`
+	// longest pause between attempts of a webhook delivery
+	WebhookMaxBackoff time.Duration `json:"webhook_max_backoff" env:"WEBHOOK_MAX_BACKOFF" flag:"webhook-max-backoff" default:"1h" usage:"longest pause between attempts of a webhook delivery"`
+	// how long finished webhook deliveries are kept in the delivery log
+	WebhookRetention time.Duration `json:"webhook_retention" env:"WEBHOOK_RETENTION" flag:"webhook-retention" default:"168h" usage:"how long finished webhook deliveries are kept in the delivery log"`
+	// where the change log of links is published, as newline delimited JSON
+	EventsFile string `json:"events_file" env:"EVENTS_FILE" flag:"events-file" usage:"file the change log of links is appended to as newline delimited JSON"`
+	EventsURL  string `json:"events_url" env:"EVENTS_URL" flag:"events-url" usage:"URL batches of the change log of links are posted to as newline delimited JSON"`
+	// how often the change log is checked for changes to publish
+	EventsInterval time.Duration `json:"events_interval" env:"EVENTS_INTERVAL" flag:"events-interval" default:"1s" usage:"how often the change log of links is checked for changes to publish"`
+	// timeout of a single post of changes to events_url
+	EventsTimeout time.Duration `json:"events_timeout" env:"EVENTS_TIMEOUT" flag:"events-timeout" default:"10s" usage:"timeout of a single post of changes to events_url"`
+	// URL canonicalization steps in the order of application
+	CanonicalSteps []string `json:"url_canonical_steps" env:"URL_CANONICAL_STEPS" flag:"canonical" default:"case,port,slash,query,tracking,idn" usage:"comma separated URL canonicalization steps: case, port, slash, query, tracking, idn" reload:"true"`
+	// query parameters stripped during canonicalization, "*" at the end matches a prefix
+	TrackingParams []string `json:"url_tracking_params" env:"URL_TRACKING_PARAMS" flag:"tracking-params" default:"utm_*,fbclid,gclid,yclid" usage:"comma separated query parameters stripped from URLs, \"*\" at the end matches a prefix" reload:"true"`
+	// allowed URL schemes
+	AllowedSchemes []string `json:"url_allowed_schemes" env:"URL_ALLOWED_SCHEMES" flag:"schemes" default:"http,https" usage:"comma separated allowed URL schemes" reload:"true"`
+	// deny URLs pointing to private, loopback and link-local addresses
+	DenyPrivateHosts bool `json:"url_deny_private" env:"URL_DENY_PRIVATE" flag:"deny-private" default:"true" usage:"deny URLs pointing to private, loopback and link-local addresses" reload:"true"`
+	// resolve host names when checking for private addresses
+	ResolveHosts bool `json:"url_resolve_hosts" env:"URL_RESOLVE_HOSTS" flag:"resolve-hosts" usage:"resolve host names when checking for private addresses" reload:"true"`
+	// maximal URL length, 0 is unlimited
+	MaxURLLength int `json:"url_max_length" env:"URL_MAX_LENGTH" flag:"max-url-length" default:"2048" usage:"maximal URL length, 0 is unlimited" reload:"true"`
+	// file of denied domains
+	BlocklistFile string `json:"url_blocklist_file" env:"URL_BLOCKLIST_FILE" flag:"blocklist" usage:"file of denied domains" reload:"true"`
+	// file of allowed domains
+	AllowlistFile string `json:"url_allowlist_file" env:"URL_ALLOWLIST_FILE" flag:"allowlist" usage:"file of allowed domains" reload:"true"`
+	// lookup API of the reputation service
+	ReputationURL string `json:"reputation_url" env:"REPUTATION_URL" flag:"reputation-url" usage:"lookup API of the URL reputation service"`
+	// header added to requests to the lookup API, "Name: value"
+	ReputationHeader string `json:"reputation_header" env:"REPUTATION_HEADER" flag:"reputation-header" usage:"header added to requests to the lookup API, \"Name: value\"" secret:"header"`
+	// timeout of a request to the lookup API
+	ReputationTimeout time.Duration `json:"reputation_timeout" env:"REPUTATION_TIMEOUT" flag:"reputation-timeout" default:"2s" usage:"timeout of a request to the lookup API"`
+	// file of hash prefixes of flagged URLs
+	ReputationHashList string `json:"reputation_hash_list" env:"REPUTATION_HASH_LIST" flag:"reputation-hash-list" usage:"file of hash prefixes of flagged URLs"`
+	// time to keep reputation verdicts
+	ReputationCacheTTL time.Duration `json:"reputation_cache_ttl" env:"REPUTATION_CACHE_TTL" flag:"reputation-cache-ttl" default:"10m" usage:"time to keep reputation verdicts"`
+	// accept URLs when the reputation service is unavailable
+	ReputationFailOpen bool `json:"reputation_fail_open" env:"REPUTATION_FAIL_OPEN" flag:"reputation-fail-open" default:"true" usage:"accept URLs when the reputation service is unavailable"`
+	// CSV file of IP ranges and their countries for routing rules by country
+	GeoIPFile string `json:"geoip_file" env:"GEOIP_FILE" flag:"geoip-file" usage:"CSV file of IP ranges and their countries like \"1.0.0.0,1.0.0.255,AU\" for routing rules by country"`
+	// where token buckets are kept: memory or postgres
+	RateLimitStore string `json:"rate_limit_store" env:"RATE_LIMIT_STORE" flag:"rate-limit-store" default:"memory" usage:"where rate limit buckets are kept: memory or postgres"`
+	// rate limit of link creation, like "60/m:120"
+	RateLimitCreate string `json:"rate_limit_create" env:"RATE_LIMIT_CREATE" flag:"rate-limit-create" default:"60/m:120" usage:"rate limit of link creation, like \"60/m:120\", empty disables" reload:"true"`
+	// rate limit of redirects
+	RateLimitRedirect string `json:"rate_limit_redirect" env:"RATE_LIMIT_REDIRECT" flag:"rate-limit-redirect" default:"600/m:1200" usage:"rate limit of redirects, empty disables" reload:"true"`
+	// rate limit of password attempts, per client IP and per link
+	RateLimitUnlock string `json:"rate_limit_unlock" env:"RATE_LIMIT_UNLOCK" flag:"rate-limit-unlock" default:"5/m:10" usage:"rate limit of password attempts of protected links, per client IP and per link, empty disables" reload:"true"`
+	// rate limit of registration, logins and API key changes, per client IP and per user
+	RateLimitAccount string `json:"rate_limit_account" env:"RATE_LIMIT_ACCOUNT" flag:"rate-limit-account" default:"10/m:20" usage:"rate limit of registration, logins and API key changes, per client IP and per user, empty disables" reload:"true"`
+	// rate limit of failed logins, per account
+	RateLimitLogin string `json:"rate_limit_login" env:"RATE_LIMIT_LOGIN" flag:"rate-limit-login" default:"5/m:10" usage:"rate limit of failed logins, per account, empty disables" reload:"true"`
+	// rate limit of deletion
+	RateLimitDelete string `json:"rate_limit_delete" env:"RATE_LIMIT_DELETE" flag:"rate-limit-delete" default:"60/m:1000" usage:"rate limit of deletion, empty disables" reload:"true"`
+	// take the client IP from X-Forwarded-For set by a trusted proxy
+	TrustProxy bool `json:"trust_proxy" env:"TRUST_PROXY" flag:"trust-proxy" usage:"take the client IP from X-Forwarded-For set by a trusted proxy"`
+	// TLS certificate and key files
+	TLSCertFile string `json:"tls_cert_file" env:"TLS_CERT_FILE" flag:"tls-cert" usage:"TLS certificate file"`
+	TLSKeyFile  string `json:"tls_key_file" env:"TLS_KEY_FILE" flag:"tls-key" usage:"TLS key file"`
+	// use a self-signed certificate, for development
+	TLSSelfSigned bool `json:"tls_self_signed" env:"TLS_SELF_SIGNED" flag:"tls-self-signed" usage:"use a self-signed certificate, for development"`
+	// host names to get Let's Encrypt certificates for
+	AutocertHosts []string `json:"autocert_hosts" env:"AUTOCERT_HOSTS" flag:"autocert-hosts" usage:"comma separated host names to get Let's Encrypt certificates for, hosts of the base URL and the domains by default"`
+	// directory to keep Let's Encrypt certificates
+	AutocertCacheDir string `json:"autocert_cache_dir" env:"AUTOCERT_CACHE_DIR" flag:"autocert-cache-dir" default:"cache-dir" usage:"directory to keep Let's Encrypt certificates"`
+	// minimal TLS version
+	TLSMinVersion string `json:"tls_min_version" env:"TLS_MIN_VERSION" flag:"tls-min-version" default:"1.2" usage:"minimal TLS version: 1.0, 1.1, 1.2 or 1.3"`
+	// allowed TLS cipher suites
+	TLSCipherSuites []string `json:"tls_cipher_suites" env:"TLS_CIPHER_SUITES" flag:"tls-cipher-suites" usage:"comma separated TLS cipher suites, Go defaults if empty"`
+	// address of the plain HTTP listener redirecting to HTTPS, empty disables it
+	HTTPRedirectAddress string `json:"http_redirect_address" env:"HTTP_REDIRECT_ADDRESS" flag:"http-redirect-address" usage:"address of the plain HTTP listener redirecting to HTTPS, empty disables it"`
+	// session keys like "id:hex", the first one is primary
+	SessionKeys []string `json:"session_keys" env:"SESSION_KEYS" flag:"session-keys" usage:"comma separated session keys like \"id:hex\", see \"shortener keys generate\"" secret:"key"`
+	// file with a session key per line
+	SessionKeysFile string `json:"session_keys_file" env:"SESSION_KEYS_FILE" flag:"session-keys-file" usage:"file with a session key like \"id:hex\" per line"`
+	// directory with a session key per file, the file name is the key id
+	SessionKeysDir string `json:"session_keys_dir" env:"SESSION_KEYS_DIR" flag:"session-keys-dir" usage:"directory with a hex session key per file, the file name is the key id"`
+	// id of the key new sessions are encrypted with, the first key by default
+	SessionPrimaryKey string `json:"session_primary_key" env:"SESSION_PRIMARY_KEY" flag:"session-primary-key" usage:"id of the key new sessions are encrypted with, the first key by default"`
+	// key of sessions created before session keys were introduced
+	SessionLegacyKey string `json:"session_legacy_key" env:"SESSION_LEGACY_KEY" flag:"session-legacy-key" usage:"key of old unsigned sessions, they are accepted and encrypted again with the primary key" secret:"true"`
+	// level of the server log: debug, info, warn, error or off
+	LogLevel string `json:"log_level" env:"LOG_LEVEL" flag:"log-level" default:"info" usage:"level of the server log: debug, info, warn, error or off" reload:"true"`
 }
 
-//	The secret word for creating a session id
-const Secret = "vfktymrjqtkjxrt[jkjlyjpb"
-
 //	Getters
 //	SrvAddr is function to get server address.
 func (c Config) SrvAddr() string {
@@ -37,6 +150,11 @@ func (c Config) HostName() string {
 	return c.BaseURL
 }
 
+//	BaseURLs is function to get base URLs of all served domains, the base URL first.
+func (c Config) BaseURLs() []string {
+	return append([]string{c.BaseURL}, c.Domains...)
+}
+
 //	FilePath function to get file path.
 func (c Config) FilePath() string {
 	return c.FileStoragePath
@@ -59,17 +177,3 @@ func NewConfig(srvAddr, hostName string, filePath string, database string, ssl b
 		EnableHTTPS:     ssl,
 	}
 }
-
-func ParseConfig(path string, flags interface{}) {
-	if path != "" {
-		data, err := os.ReadFile(path)
-		if err != nil {
-			log.Println(err)
-		}
-
-		err = json.Unmarshal(data, &flags)
-		if err != nil {
-			log.Println(err)
-		}
-	}
-}
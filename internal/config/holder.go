@@ -0,0 +1,75 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"sync/atomic"
+)
+
+//	Holder keeps the current settings, on reload they are replaced as a whole
+//	so a request never sees a mix of old and new values.
+type Holder struct {
+	v atomic.Value
+}
+
+//	NewHolder is function to create a holder of the settings.
+func NewHolder(cfg *Config) *Holder {
+	h := &Holder{}
+	h.Set(cfg)
+	return h
+}
+
+//	Get Returning the current settings, they must not be modified.
+func (h *Holder) Get() *Config {
+	return h.v.Load().(*Config)
+}
+
+//	Set Replacing the current settings.
+func (h *Holder) Set(cfg *Config) {
+	h.v.Store(cfg)
+}
+
+//	SrvAddr is function to get server address.
+func (h *Holder) SrvAddr() string {
+	return h.Get().SrvAddr()
+}
+
+//	HostName is function to get server hostname.
+func (h *Holder) HostName() string {
+	return h.Get().HostName()
+}
+
+//	BaseURLs is function to get base URLs of all served domains.
+func (h *Holder) BaseURLs() []string {
+	return h.Get().BaseURLs()
+}
+
+//	Reloadable Returning a copy of old with reloadable settings taken from next,
+//	and the keys of other settings which differ and need a restart.
+func Reloadable(old, next *Config) (*Config, []string) {
+	merged := *old
+	v := reflect.ValueOf(&merged).Elem()
+	n := reflect.ValueOf(next).Elem()
+	var restart []string
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+		if _, ok := field.Tag.Lookup("reload"); ok {
+			v.Field(i).Set(n.Field(i))
+		} else if key := fileKey(field); key != "" && !reflect.DeepEqual(v.Field(i).Interface(), n.Field(i).Interface()) {
+			restart = append(restart, key)
+		}
+	}
+	return &merged, restart
+}
+
+//	Diff Listing changed settings like "key: old -> new", secrets are masked.
+func Diff(old, next *Config) []string {
+	before, after := old.settings(), next.settings()
+	var diff []string
+	for i := range before {
+		if !reflect.DeepEqual(before[i].value, after[i].value) {
+			diff = append(diff, fmt.Sprintf("%s: %v -> %v", before[i].key, before[i].value, after[i].value))
+		}
+	}
+	return diff
+}
@@ -0,0 +1,274 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+//	Default is function to get settings with default values.
+func Default() *Config {
+	cfg := &Config{}
+	v := reflect.ValueOf(cfg).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		if def, ok := v.Type().Field(i).Tag.Lookup("default"); ok {
+			if err := setField(v.Field(i), def); err != nil {
+				panic(fmt.Sprintf("config: default of %s: %v", v.Type().Field(i).Name, err))
+			}
+		}
+	}
+	return cfg
+}
+
+//	Load is function to get settings from all sources and validate them.
+//	Flags of settings are registered in fs and parsed from args,
+//	the config file is taken from the -config flag or the CONFIG variable.
+//	Precedence: defaults < config file < environment < flags.
+func Load(fs *flag.FlagSet, args []string) (*Config, error) {
+	// flags are parsed first to find the config file, they are applied last
+	flagged := Default()
+	register(fs, flagged)
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	cfg := Default()
+	cfg.Config = os.Getenv("CONFIG")
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == "config" || f.Name == "c" {
+			cfg.Config = flagged.Config
+		}
+	})
+	if cfg.Config != "" {
+		if err := cfg.loadFile(cfg.Config); err != nil {
+			return nil, err
+		}
+	}
+	if err := cfg.loadEnv(); err != nil {
+		return nil, err
+	}
+	fs.Visit(func(f *flag.Flag) {
+		if value, ok := f.Value.(*fieldValue); ok && value.cfg == flagged {
+			field := value.index
+			reflect.ValueOf(cfg).Elem().Field(field).Set(reflect.ValueOf(flagged).Elem().Field(field))
+		}
+	})
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+//	loadFile Reading settings from a JSON, YAML or TOML file, the format is chosen by the extension.
+func (c *Config) loadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("config file: %w", err)
+	}
+	values := make(map[string]interface{})
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".json":
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.UseNumber()
+		err = dec.Decode(&values)
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &values)
+	case ".toml":
+		err = toml.Unmarshal(data, &values)
+	default:
+		return fmt.Errorf("config file %s: unknown format %q, use .json, .yaml, .yml or .toml", path, ext)
+	}
+	if err != nil {
+		return fmt.Errorf("config file %s: %w", path, err)
+	}
+
+	v := reflect.ValueOf(c).Elem()
+	keys := make(map[string]int)
+	for i := 0; i < v.NumField(); i++ {
+		tag := v.Type().Field(i)
+		if key := fileKey(tag); key != "" {
+			keys[key] = i
+		}
+		if alias, ok := tag.Tag.Lookup("alias"); ok {
+			keys[alias] = i
+		}
+	}
+	var errs []string
+	for key, value := range values {
+		i, ok := keys[key]
+		if !ok {
+			errs = append(errs, fmt.Sprintf("unknown setting %q", key))
+			continue
+		}
+		s, err := fileValue(value)
+		if err == nil {
+			err = setField(v.Field(i), s)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", key, err))
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("config file %s: %s", path, strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+//	loadEnv Reading settings from environment variables, unset variables are skipped.
+func (c *Config) loadEnv() error {
+	v := reflect.ValueOf(c).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		name := v.Type().Field(i).Tag.Get("env")
+		if name == "" {
+			continue
+		}
+		if value, ok := os.LookupEnv(name); ok {
+			if err := setField(v.Field(i), value); err != nil {
+				return fmt.Errorf("%s: %w", name, err)
+			}
+		}
+	}
+	return nil
+}
+
+//	fileKey Returning the key of a field in config files, empty if the field can't be set in files.
+func fileKey(field reflect.StructField) string {
+	key := strings.Split(field.Tag.Get("json"), ",")[0]
+	if key == "-" {
+		return ""
+	}
+	return key
+}
+
+//	fileValue Converting a decoded file value to the string form shared with flags and variables.
+func fileValue(value interface{}) (string, error) {
+	switch value := value.(type) {
+	case string:
+		return value, nil
+	case bool, int, int64, float64, json.Number:
+		return fmt.Sprint(value), nil
+	case []interface{}:
+		items := make([]string, 0, len(value))
+		for _, item := range value {
+			s, err := fileValue(item)
+			if err != nil {
+				return "", err
+			}
+			items = append(items, s)
+		}
+		return strings.Join(items, ","), nil
+	case nil:
+		return "", nil
+	default:
+		return "", fmt.Errorf("unsupported value %v", value)
+	}
+}
+
+//	setField Setting a field from its string form.
+//	Lists are comma separated, durations are like "10s", plain numbers are nanoseconds.
+func setField(v reflect.Value, s string) error {
+	if v.Type() == durationType {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			n, errInt := strconv.ParseInt(s, 10, 64)
+			if errInt != nil {
+				return err
+			}
+			d = time.Duration(n)
+		}
+		v.SetInt(int64(d))
+		return nil
+	}
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(s)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Int:
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(n))
+	case reflect.Slice:
+		items := make([]string, 0)
+		for _, item := range strings.Split(s, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		v.Set(reflect.ValueOf(items))
+	default:
+		return errors.New("unsupported setting type " + v.Type().String())
+	}
+	return nil
+}
+
+//	formatField Returning the string form of a field.
+func formatField(v reflect.Value) string {
+	if v.Type() == durationType {
+		return time.Duration(v.Int()).String()
+	}
+	if v.Kind() == reflect.Slice {
+		return strings.Join(v.Interface().([]string), ",")
+	}
+	return fmt.Sprint(v.Interface())
+}
+
+//	fieldValue is a flag setting a field of the config.
+type fieldValue struct {
+	cfg   *Config
+	index int
+}
+
+func (f *fieldValue) field() reflect.Value {
+	return reflect.ValueOf(f.cfg).Elem().Field(f.index)
+}
+
+func (f *fieldValue) String() string {
+	if f == nil || f.cfg == nil {
+		return ""
+	}
+	return formatField(f.field())
+}
+
+func (f *fieldValue) Set(s string) error {
+	return setField(f.field(), s)
+}
+
+func (f *fieldValue) IsBoolFlag() bool {
+	return f.field().Kind() == reflect.Bool
+}
+
+//	register Defining flags of all settings.
+func register(fs *flag.FlagSet, cfg *Config) {
+	v := reflect.ValueOf(cfg).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+		names, ok := field.Tag.Lookup("flag")
+		if !ok {
+			continue
+		}
+		for _, name := range strings.Split(names, ",") {
+			fs.Var(&fieldValue{cfg: cfg, index: i}, name, field.Tag.Get("usage"))
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+//	mask replaces secret values in printed settings.
+const mask = "*****"
+
+var dsnPassword = regexp.MustCompile(`(password\s*=\s*)('(\\'|[^'])*'|\S+)`)
+
+//	setting is a printed setting with its file key.
+type setting struct {
+	key   string
+	value interface{}
+}
+
+//	Print Writing the settings as a config file of the format: json, yaml or toml.
+//	Secrets are masked.
+func (c *Config) Print(w io.Writer, format string) error {
+	settings := c.settings()
+	var buf bytes.Buffer
+	switch format {
+	case "json":
+		buf.WriteString("{\n")
+		for i, s := range settings {
+			value, err := json.Marshal(s.value)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(&buf, "  %q: %s", s.key, value)
+			if i < len(settings)-1 {
+				buf.WriteByte(',')
+			}
+			buf.WriteByte('\n')
+		}
+		buf.WriteString("}\n")
+	case "yaml", "yml":
+		doc := &yaml.Node{Kind: yaml.MappingNode}
+		for _, s := range settings {
+			var value yaml.Node
+			if err := value.Encode(s.value); err != nil {
+				return err
+			}
+			doc.Content = append(doc.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: s.key}, &value)
+		}
+		data, err := yaml.Marshal(doc)
+		if err != nil {
+			return err
+		}
+		buf.Write(data)
+	case "toml":
+		for _, s := range settings {
+			fmt.Fprintf(&buf, "%s = %s\n", s.key, tomlValue(s.value))
+		}
+	default:
+		return fmt.Errorf("unknown format %q, use json, yaml or toml", format)
+	}
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+//	settings Listing settings in the order of fields, durations as strings.
+func (c *Config) settings() []setting {
+	v := reflect.ValueOf(c).Elem()
+	settings := make([]setting, 0, v.NumField())
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+		key := fileKey(field)
+		if key == "" {
+			continue
+		}
+		var value interface{}
+		switch {
+		case field.Type == durationType:
+			value = formatField(v.Field(i))
+		case field.Type.Kind() == reflect.Slice:
+			value = append([]string{}, v.Field(i).Interface().([]string)...)
+		default:
+			value = v.Field(i).Interface()
+		}
+		if secret, ok := field.Tag.Lookup("secret"); ok {
+			if list, ok := value.([]string); ok {
+				for j := range list {
+					list[j] = maskSecret(secret, list[j])
+				}
+			} else {
+				value = maskSecret(secret, value.(string))
+			}
+		}
+		settings = append(settings, setting{key: key, value: value})
+	}
+	return settings
+}
+
+//	maskSecret Hiding a secret value, only the password is hidden in DSNs
+//	and the value in headers and keys.
+func maskSecret(kind, value string) string {
+	if value == "" {
+		return value
+	}
+	switch kind {
+	case "header":
+		if i := strings.IndexByte(value, ':'); i >= 0 {
+			return value[:i+1] + " " + mask
+		}
+		return mask
+	case "key":
+		if i := strings.IndexByte(value, ':'); i >= 0 {
+			return value[:i+1] + mask
+		}
+		return mask
+	case "dsn":
+	default:
+		return mask
+	}
+	if u, err := url.Parse(value); err == nil && u.Scheme != "" {
+		return u.Redacted()
+	}
+	return dsnPassword.ReplaceAllString(value, "${1}"+mask)
+}
+
+//	tomlValue Formatting a value for TOML.
+func tomlValue(value interface{}) string {
+	switch value := value.(type) {
+	case string:
+		return strconv.Quote(value)
+	case []string:
+		items := make([]string, 0, len(value))
+		for _, item := range value {
+			items = append(items, strconv.Quote(item))
+		}
+		return "[" + strings.Join(items, ", ") + "]"
+	default:
+		return fmt.Sprint(value)
+	}
+}
@@ -0,0 +1,162 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+
+	"github.com/ivanmyagkov/shortener.git/internal/canonical"
+	"github.com/ivanmyagkov/shortener.git/internal/keys"
+)
+
+//	Error is the list of problems found in settings.
+type Error []string
+
+func (e Error) Error() string {
+	return "invalid config: " + strings.Join(e, "; ")
+}
+
+//	Validate Checking settings, all problems are reported at once.
+func (c *Config) Validate() error {
+	var errs Error
+	check := func(name string, err error) {
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", name, err))
+		}
+	}
+
+	check("server_address", checkAddress(c.ServerAddress))
+	if c.HTTPRedirectAddress != "" {
+		check("http_redirect_address", checkAddress(c.HTTPRedirectAddress))
+	}
+	check("base_url", checkURL(c.BaseURL))
+	for _, domain := range c.Domains {
+		check("domains", checkURL(domain))
+	}
+	if c.ReputationURL != "" {
+		check("reputation_url", checkURL(c.ReputationURL))
+	}
+	if c.EventsURL != "" {
+		check("events_url", checkURL(c.EventsURL))
+	}
+	if c.DatabasePath != "" {
+		// the connector only parses the DSN, it doesn't connect
+		_, err := pq.NewConnector(c.DatabasePath)
+		check("database_dsn", err)
+	}
+	for _, key := range c.SessionKeys {
+		_, err := keys.ParseKey(key)
+		check("session_keys", err)
+	}
+	switch len(c.SessionLegacyKey) {
+	case 0, 16, 24, 32:
+	default:
+		check("session_legacy_key", fmt.Errorf("must have 16, 24 or 32 characters"))
+	}
+	if c.ReputationHeader != "" && !strings.Contains(c.ReputationHeader, ":") {
+		check("reputation_header", fmt.Errorf("%q is not like \"Name: value\"", c.ReputationHeader))
+	}
+
+	_, err := canonical.New(c.CanonicalSteps, c.TrackingParams)
+	check("url_canonical_steps", err)
+	switch c.RateLimitStore {
+	case "memory":
+	case "postgres":
+		if c.DatabasePath == "" {
+			check("rate_limit_store", fmt.Errorf("postgres requires database_dsn"))
+		}
+	default:
+		check("rate_limit_store", fmt.Errorf("unknown store %q, use memory or postgres", c.RateLimitStore))
+	}
+	switch c.TLSMinVersion {
+	case "", "1.0", "1.1", "1.2", "1.3":
+	default:
+		check("tls_min_version", fmt.Errorf("unknown TLS version %q", c.TLSMinVersion))
+	}
+	switch c.LogLevel {
+	case "debug", "info", "warn", "error", "off":
+	default:
+		check("log_level", fmt.Errorf("unknown level %q, use debug, info, warn, error or off", c.LogLevel))
+	}
+	if (c.TLSCertFile == "") != (c.TLSKeyFile == "") {
+		check("tls_cert_file", fmt.Errorf("both TLS certificate and key files are required"))
+	}
+
+	if c.HealthCheckWorkers < 1 {
+		check("health_check_workers", fmt.Errorf("must be positive"))
+	}
+	if c.MetaWorkers < 0 {
+		check("meta_workers", fmt.Errorf("must not be negative"))
+	}
+	if c.MetaMaxBytes < 1 {
+		check("meta_max_bytes", fmt.Errorf("must be positive"))
+	}
+	if c.WebhookWorkers < 0 {
+		check("webhook_workers", fmt.Errorf("must not be negative"))
+	}
+	if c.WebhookMaxAttempts < 1 {
+		check("webhook_max_attempts", fmt.Errorf("must be positive"))
+	}
+	if c.MaxURLLength < 0 {
+		check("url_max_length", fmt.Errorf("must not be negative"))
+	}
+	durations := []struct {
+		name  string
+		value time.Duration
+	}{
+		{"health_check_interval", c.HealthCheckInterval},
+		{"health_check_timeout", c.HealthCheckTimeout},
+		{"health_check_host_interval", c.HealthCheckHostInterval},
+		{"meta_timeout", c.MetaTimeout},
+		{"webhook_timeout", c.WebhookTimeout},
+		{"webhook_backoff", c.WebhookBackoff},
+		{"webhook_max_backoff", c.WebhookMaxBackoff},
+		{"webhook_retention", c.WebhookRetention},
+		{"events_interval", c.EventsInterval},
+		{"events_timeout", c.EventsTimeout},
+		{"reputation_timeout", c.ReputationTimeout},
+		{"reputation_cache_ttl", c.ReputationCacheTTL},
+	}
+	for _, d := range durations {
+		if d.value < 0 {
+			check(d.name, fmt.Errorf("must not be negative"))
+		}
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+//	checkAddress Checking a listen address like "host:port" or ":port".
+func checkAddress(addr string) error {
+	_, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	if n, err := strconv.Atoi(port); err != nil || n < 0 || n > 65535 {
+		return fmt.Errorf("invalid port %q", port)
+	}
+	return nil
+}
+
+//	checkURL Checking an absolute HTTP or HTTPS URL.
+func checkURL(rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("%q is not an http or https URL", rawURL)
+	}
+	if u.Host == "" {
+		return fmt.Errorf("%q has no host", rawURL)
+	}
+	return nil
+}
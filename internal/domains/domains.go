@@ -0,0 +1,112 @@
+//	Package domains for serving short URLs under several base URLs.
+//
+//	The first base URL is the primary domain. Storages key short URLs of the primary domain
+//	by their codes, so short URLs created before domains were added keep working, and short URLs
+//	of other domains by the host and the code like "go.example.com/abc". Codes never contain "/",
+//	so keys of different domains never clash and every domain has codes of its own.
+package domains
+
+import (
+	"net/url"
+	"strings"
+)
+
+//	Domains are the base URLs short URLs are served under.
+type Domains struct {
+	baseURLs []string
+	// hosts of the base URLs in lower case, the primary one first
+	hosts []string
+}
+
+//	New is function to create domains of the base URLs, the first one is primary.
+func New(baseURLs []string) Domains {
+	d := Domains{}
+	for _, baseURL := range baseURLs {
+		host := Host(baseURL)
+		if host == "" || d.has(host) {
+			continue
+		}
+		d.baseURLs = append(d.baseURLs, strings.TrimSuffix(baseURL, "/"))
+		d.hosts = append(d.hosts, host)
+	}
+	return d
+}
+
+//	Host Returning the host of the base URL in lower case with the port, "" if it has none.
+func Host(baseURL string) string {
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return ""
+	}
+	return strings.ToLower(u.Host)
+}
+
+func (d Domains) has(host string) bool {
+	return d.index(host) >= 0
+}
+
+func (d Domains) index(host string) int {
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	for i, h := range d.hosts {
+		if h == host {
+			return i
+		}
+	}
+	return -1
+}
+
+//	Lookup Returning the domain of the host to key short URLs with, "" for the primary domain.
+func (d Domains) Lookup(host string) (string, bool) {
+	i := d.index(host)
+	if i < 0 {
+		return "", false
+	}
+	if i == 0 {
+		return "", true
+	}
+	return d.hosts[i], true
+}
+
+//	Hosts Returning hosts of all domains, the primary one first.
+func (d Domains) Hosts() []string {
+	return append([]string(nil), d.hosts...)
+}
+
+//	Key Returning the storage key of the code of the domain, "" is the primary domain.
+func Key(domain, code string) string {
+	if domain == "" {
+		return code
+	}
+	return domain + "/" + code
+}
+
+//	Split Returning the domain and the code of the storage key.
+func Split(key string) (domain, code string) {
+	i := strings.LastIndex(key, "/")
+	if i < 0 {
+		return "", key
+	}
+	return key[:i], key[i+1:]
+}
+
+//	URL Returning the full short URL of the storage key.
+//	A short URL of a domain which is no longer served keeps its host with the scheme of the primary domain.
+func (d Domains) URL(key string) string {
+	domain, code := Split(key)
+	if domain == "" {
+		if len(d.baseURLs) == 0 {
+			return "/" + code
+		}
+		return d.baseURLs[0] + "/" + code
+	}
+	if i := d.index(domain); i > 0 {
+		return d.baseURLs[i] + "/" + code
+	}
+	scheme := "http"
+	if len(d.baseURLs) > 0 {
+		if u, err := url.Parse(d.baseURLs[0]); err == nil {
+			scheme = u.Scheme
+		}
+	}
+	return scheme + "://" + domain + "/" + code
+}
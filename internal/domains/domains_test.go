@@ -0,0 +1,45 @@
+package domains
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDomains(t *testing.T) {
+	d := New([]string{"http://localhost:8080", "https://Go.Example.com/", "https://go.example.com", "not a url", "https://s.example:8443"})
+	assert.Equal(t, []string{"localhost:8080", "go.example.com", "s.example:8443"}, d.Hosts())
+
+	tests := []struct {
+		host   string
+		domain string
+		ok     bool
+	}{
+		{"localhost:8080", "", true},
+		{"GO.example.com", "go.example.com", true},
+		{"go.example.com.", "go.example.com", true},
+		{"s.example:8443", "s.example:8443", true},
+		{"s.example", "", false},
+		{"other.example", "", false},
+	}
+	for _, tt := range tests {
+		domain, ok := d.Lookup(tt.host)
+		assert.Equal(t, tt.ok, ok, tt.host)
+		assert.Equal(t, tt.domain, domain, tt.host)
+	}
+
+	assert.Equal(t, "abc", Key("", "abc"))
+	assert.Equal(t, "go.example.com/abc", Key("go.example.com", "abc"))
+	domain, code := Split("go.example.com/abc")
+	assert.Equal(t, "go.example.com", domain)
+	assert.Equal(t, "abc", code)
+	domain, code = Split("abc")
+	assert.Equal(t, "", domain)
+	assert.Equal(t, "abc", code)
+
+	assert.Equal(t, "http://localhost:8080/abc", d.URL("abc"))
+	assert.Equal(t, "https://Go.Example.com/abc", d.URL("go.example.com/abc"))
+	assert.Equal(t, "https://s.example:8443/abc", d.URL("s.example:8443/abc"))
+	// domains removed from the settings keep their hosts
+	assert.Equal(t, "http://gone.example/abc", d.URL("gone.example/abc"))
+}
@@ -0,0 +1,87 @@
+//	Package geoip for finding countries of IP addresses in an offline database.
+package geoip
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strings"
+)
+
+//	Database finds countries of IP addresses by ranges read from a CSV file,
+//	in the format of free country databases like DB-IP Lite.
+//
+//	Each line is the first and the last address of a range and the country
+//	code: "1.0.0.0,1.0.0.255,AU". Fields may be quoted, IPv4 and IPv6 ranges
+//	may be mixed, "#" starts a comment. Ranges must not overlap.
+type Database struct {
+	ranges []ipRange
+}
+
+type ipRange struct {
+	first, last net.IP
+	country     string
+}
+
+//	Load is function to read the database from file.
+func Load(path string) (*Database, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var ranges []ipRange
+	scanner := bufio.NewScanner(file)
+	for n := 1; scanner.Scan(); n++ {
+		line := scanner.Text()
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		fields := strings.Split(line, ",")
+		if len(fields) < 3 {
+			return nil, fmt.Errorf("%s:%d: expected first address, last address and country", path, n)
+		}
+		for i := range fields {
+			fields[i] = strings.Trim(strings.TrimSpace(fields[i]), `"`)
+		}
+		first, last := net.ParseIP(fields[0]).To16(), net.ParseIP(fields[1]).To16()
+		if first == nil || last == nil || bytes.Compare(first, last) > 0 {
+			return nil, fmt.Errorf("%s:%d: invalid range %s-%s", path, n, fields[0], fields[1])
+		}
+		country := strings.ToUpper(fields[2])
+		if len(country) != 2 {
+			return nil, fmt.Errorf("%s:%d: invalid country %q", path, n, fields[2])
+		}
+		ranges = append(ranges, ipRange{first: first, last: last, country: country})
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, err
+	}
+	sort.Slice(ranges, func(i, j int) bool {
+		return bytes.Compare(ranges[i].first, ranges[j].first) < 0
+	})
+	return &Database{ranges: ranges}, nil
+}
+
+//	Country Returning the country code of the address, "" if no range has it.
+func (d *Database) Country(ip net.IP) string {
+	ip = ip.To16()
+	if ip == nil {
+		return ""
+	}
+	// the last range starting at the address or before it
+	i := sort.Search(len(d.ranges), func(i int) bool {
+		return bytes.Compare(d.ranges[i].first, ip) > 0
+	}) - 1
+	if i < 0 || bytes.Compare(ip, d.ranges[i].last) > 0 {
+		return ""
+	}
+	return d.ranges[i].country
+}
@@ -0,0 +1,62 @@
+package geoip
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDatabase(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "countries.csv")
+	data := `# first,last,country
+"2a00:1450::","2a00:1450:ffff:ffff:ffff:ffff:ffff:ffff","IE"
+1.0.0.0,1.0.0.255,AU
+5.255.255.0,5.255.255.255,ru
+
+8.8.8.0, 8.8.8.255, US
+`
+	require.NoError(t, os.WriteFile(path, []byte(data), 0600))
+	db, err := Load(path)
+	require.NoError(t, err)
+
+	tests := []struct {
+		ip   string
+		want string
+	}{
+		{ip: "1.0.0.0", want: "AU"},
+		{ip: "1.0.0.255", want: "AU"},
+		{ip: "1.0.1.0", want: ""},
+		{ip: "0.255.255.255", want: ""},
+		{ip: "5.255.255.77", want: "RU"},
+		{ip: "8.8.8.8", want: "US"},
+		{ip: "::ffff:8.8.8.8", want: "US"},
+		{ip: "2a00:1450:4001::1", want: "IE"},
+		{ip: "2a00:1451::1", want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.ip, func(t *testing.T) {
+			assert.Equal(t, tt.want, db.Country(net.ParseIP(tt.ip)))
+		})
+	}
+	assert.Equal(t, "", db.Country(nil))
+}
+
+func TestLoad_Invalid(t *testing.T) {
+	for name, data := range map[string]string{
+		"fields":   "1.0.0.0,AU\n",
+		"address":  "1.0.0.0,1.0.0.x,AU\n",
+		"reversed": "1.0.0.255,1.0.0.0,AU\n",
+		"country":  "1.0.0.0,1.0.0.255,AUS\n",
+	} {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "countries.csv")
+			require.NoError(t, os.WriteFile(path, []byte(data), 0600))
+			_, err := Load(path)
+			assert.Error(t, err)
+		})
+	}
+}
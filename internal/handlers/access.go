@@ -0,0 +1,59 @@
+package handlers
+
+import (
+	"bytes"
+	"html/template"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/ivanmyagkov/shortener.git/internal/access"
+)
+
+//	passwordPage is shown instead of a redirect to a link with a password,
+//	the form is posted back to the short URL with its query.
+var passwordPage = template.Must(template.New("password").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="robots" content="noindex">
+<title>Password required</title>
+</head>
+<body>
+<h1>This link is protected</h1>
+<form method="post">
+{{if .}}<p><strong>Wrong password, try again.</strong></p>
+{{end}}<p><label>Password <input type="password" name="password" autofocus required></label></p>
+<p><button type="submit">Continue</button></p>
+</form>
+</body>
+</html>
+`))
+
+//	passwordForm Rendering the password form for a link with the status.
+func passwordForm(c echo.Context, status int, wrong bool) error {
+	var buf bytes.Buffer
+	if err := passwordPage.Execute(&buf, wrong); err != nil {
+		return c.NoContent(http.StatusInternalServerError)
+	}
+	c.Response().Header().Set("Cache-Control", "no-store")
+	return c.HTMLBlob(status, buf.Bytes())
+}
+
+//	PostUnlock - Post request handler.
+//	Checking the password of the password form and redirecting to the original URL,
+//	with 303 so the browser doesn't post the password again.
+func (s Server) PostUnlock(c echo.Context) error {
+	shortURL, err := s.hostKey(c, c.Param("id"))
+	if err != nil {
+		return c.NoContent(http.StatusBadRequest)
+	}
+	link, err := s.storage.GetLink(shortURL)
+	if err != nil {
+		return linkError(c, err)
+	}
+	if !access.Unlock(link.Access, c.FormValue("password")) {
+		return passwordForm(c, http.StatusUnauthorized, true)
+	}
+	return s.follow(c, link, http.StatusSeeOther)
+}
@@ -0,0 +1,152 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/ivanmyagkov/shortener.git/internal/interfaces"
+)
+
+type credentials struct {
+	Login    string `json:"login"`
+	Password string `json:"password"`
+}
+
+type accountResponse struct {
+	UserID    string    `json:"user_id"`
+	Login     string    `json:"login"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type apiKeyResponse struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"created_at"`
+	// the key is shown only once, when it is issued
+	Key string `json:"key,omitempty"`
+}
+
+//	WithAccounts is option to register users and issue API keys.
+func WithAccounts(accounts interfaces.Accounts) Option {
+	return func(s *Server) {
+		s.accounts = accounts
+	}
+}
+
+func newAccountResponse(account interfaces.Account) accountResponse {
+	return accountResponse{
+		UserID:    account.UserID,
+		Login:     account.Login,
+		CreatedAt: account.CreatedAt,
+	}
+}
+
+//	PostRegister - Post request handler.
+//	Creating an account for the session user, the links of the session stay with the account.
+func (s Server) PostRegister(c echo.Context) error {
+	userID, err := s.userID(c)
+	if err != nil {
+		return c.NoContent(http.StatusBadRequest)
+	}
+	var request credentials
+	if err = json.NewDecoder(c.Request().Body).Decode(&request); err != nil {
+		return c.NoContent(http.StatusBadRequest)
+	}
+	account, err := s.accounts.Register(userID, request.Login, request.Password)
+	if err != nil {
+		var validationErr *interfaces.ValidationError
+		if errors.Is(err, interfaces.ErrAlreadyExists) {
+			return c.NoContent(http.StatusConflict)
+		} else if errors.As(err, &validationErr) {
+			return c.JSON(http.StatusUnprocessableEntity, validationErr)
+		}
+		return c.NoContent(http.StatusInternalServerError)
+	}
+	return c.JSON(http.StatusCreated, newAccountResponse(account))
+}
+
+//	PostLogin - Post request handler.
+//	Switching the session to the account, the links of the anonymous session are moved to the account.
+func (s Server) PostLogin(c echo.Context) error {
+	var request credentials
+	if err := json.NewDecoder(c.Request().Body).Decode(&request); err != nil {
+		return c.NoContent(http.StatusBadRequest)
+	}
+	sessionUserID, _ := s.userID(c)
+	account, err := s.accounts.Login(request.Login, request.Password, sessionUserID)
+	if errors.Is(err, interfaces.ErrUnauthorized) {
+		return c.NoContent(http.StatusUnauthorized)
+	} else if err != nil {
+		return c.NoContent(http.StatusInternalServerError)
+	}
+	value, err := s.user.CreateSissionID(account.UserID)
+	if err != nil {
+		return c.NoContent(http.StatusInternalServerError)
+	}
+	c.SetCookie(&http.Cookie{Name: "cookie", Path: "/", Value: value})
+	return c.JSON(http.StatusOK, newAccountResponse(account))
+}
+
+//	GetAPIKeys - Get request handler.
+//	Getting API keys of the account without the keys themselves.
+func (s Server) GetAPIKeys(c echo.Context) error {
+	userID, err := s.userID(c)
+	if err != nil {
+		return c.NoContent(http.StatusBadRequest)
+	}
+	keys, err := s.accounts.Keys(userID)
+	if errors.Is(err, interfaces.ErrNotFound) {
+		return c.NoContent(http.StatusForbidden)
+	} else if err != nil {
+		return c.NoContent(http.StatusInternalServerError)
+	}
+	response := make([]apiKeyResponse, 0, len(keys))
+	for _, key := range keys {
+		response = append(response, apiKeyResponse{ID: key.ID, Name: key.Name, CreatedAt: key.CreatedAt})
+	}
+	return c.JSON(http.StatusOK, response)
+}
+
+//	PostAPIKey - Post request handler.
+//	Issuing an API key of the account.
+func (s Server) PostAPIKey(c echo.Context) error {
+	userID, err := s.userID(c)
+	if err != nil {
+		return c.NoContent(http.StatusBadRequest)
+	}
+	var request struct {
+		Name string `json:"name"`
+	}
+	if c.Request().ContentLength != 0 {
+		if err = json.NewDecoder(c.Request().Body).Decode(&request); err != nil {
+			return c.NoContent(http.StatusBadRequest)
+		}
+	}
+	key, raw, err := s.accounts.IssueKey(userID, request.Name)
+	if errors.Is(err, interfaces.ErrNotFound) {
+		return c.NoContent(http.StatusForbidden)
+	} else if err != nil {
+		return c.NoContent(http.StatusInternalServerError)
+	}
+	return c.JSON(http.StatusCreated, apiKeyResponse{ID: key.ID, Name: key.Name, CreatedAt: key.CreatedAt, Key: raw})
+}
+
+//	DelAPIKey - DELETE request handler.
+//	Revoking an API key of the account.
+func (s Server) DelAPIKey(c echo.Context) error {
+	userID, err := s.userID(c)
+	if err != nil {
+		return c.NoContent(http.StatusBadRequest)
+	}
+	err = s.accounts.Revoke(userID, c.Param("id"))
+	if errors.Is(err, interfaces.ErrNotFound) {
+		return c.NoContent(http.StatusNotFound)
+	} else if err != nil {
+		return c.NoContent(http.StatusInternalServerError)
+	}
+	return c.NoContent(http.StatusNoContent)
+}
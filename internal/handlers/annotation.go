@@ -0,0 +1,43 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/ivanmyagkov/shortener.git/internal/annotation"
+	"github.com/ivanmyagkov/shortener.git/internal/interfaces"
+)
+
+//	PutAnnotation - Put request handler.
+//	Replacing the title, the notes and the tags of a user link, with the workspace header
+//	editors annotate links of the workspace. Users sharing a link annotate it each in their own way.
+func (s Server) PutAnnotation(c echo.Context) error {
+	userID, err := s.userID(c)
+	if err != nil {
+		return c.NoContent(http.StatusBadRequest)
+	}
+	workspaceID, err := s.workspace(c, userID, interfaces.RoleEditor)
+	if err != nil {
+		return workspaceError(c, err)
+	}
+	shortURL, err := s.apiKey(c)
+	if err != nil {
+		return c.NoContent(http.StatusBadRequest)
+	}
+	var request interfaces.Annotation
+	if err = json.NewDecoder(c.Request().Body).Decode(&request); err != nil {
+		return c.NoContent(http.StatusBadRequest)
+	}
+	notes, err := annotation.Normalize(request)
+	var validationErr *interfaces.ValidationError
+	if errors.As(err, &validationErr) {
+		return c.JSON(http.StatusUnprocessableEntity, validationErr)
+	}
+	if err = s.storage.SetAnnotation(userID, workspaceID, shortURL, notes); err != nil {
+		return patchError(c, err)
+	}
+	return c.JSON(http.StatusOK, notes)
+}
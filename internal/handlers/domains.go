@@ -0,0 +1,65 @@
+package handlers
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/ivanmyagkov/shortener.git/internal/domains"
+)
+
+//	domainParam selects the domain of links in API requests by its host, like "go.example.com".
+const domainParam = "domain"
+
+//	domains Returning the domains short URLs are served under, they may change on reload.
+func (s Server) domains() domains.Domains {
+	return domains.New(s.cfg.BaseURLs())
+}
+
+//	shortURL Returning the full short URL of the storage key.
+func (s Server) shortURL(key string) string {
+	return s.domains().URL(key)
+}
+
+//	errBadCode is a code which can't be a part of a storage key.
+var errBadCode = errors.New("invalid short URL")
+
+//	hostKey Returning the storage key of the code opened on the host of the request.
+//	Hosts other than the served domains, like internal addresses of the server, open the primary domain.
+func (s Server) hostKey(c echo.Context, code string) (string, error) {
+	if code == "" || strings.Contains(code, "/") {
+		// the key of a link of another domain
+		return "", errBadCode
+	}
+	domain, _ := s.domains().Lookup(c.Request().Host)
+	return domains.Key(domain, code), nil
+}
+
+//	apiDomain Returning the domain an API request acts in: the one of the domain parameter
+//	or, without it, the one the request was sent to. "" is the primary domain.
+func (s Server) apiDomain(c echo.Context) (string, error) {
+	host := c.QueryParam(domainParam)
+	if host == "" {
+		domain, _ := s.domains().Lookup(c.Request().Host)
+		return domain, nil
+	}
+	domain, ok := s.domains().Lookup(host)
+	if !ok {
+		return "", errors.New("unknown domain")
+	}
+	return domain, nil
+}
+
+//	apiKey Returning the storage key of the link of the id path parameter in the domain of the API request.
+func (s Server) apiKey(c echo.Context) (string, error) {
+	code := c.Param("id")
+	if code == "" || strings.Contains(code, "/") {
+		return "", errBadCode
+	}
+	domain, err := s.apiDomain(c)
+	if err != nil {
+		return "", err
+	}
+	return domains.Key(domain, code), nil
+}
@@ -0,0 +1,182 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/ivanmyagkov/shortener.git/internal/interfaces"
+)
+
+//	Sizes of batches of changes and how long a request waits for them.
+const (
+	eventsDefaultLimit = 100
+	eventsMaxLimit     = 1000
+	eventsMaxWait      = 60 * time.Second
+	// how often the change log is checked while a request waits
+	eventsPollInterval = time.Second
+	// how often an idle stream sends a comment, so proxies don't close it
+	eventsKeepAlive = 15 * time.Second
+)
+
+//	mimeEventStream is the content type of server-sent events.
+const mimeEventStream = "text/event-stream"
+
+//	eventsQuery is which changes a request asks for.
+type eventsQuery struct {
+	after int64
+	limit int
+	wait  time.Duration
+}
+
+//	eventsPage is a batch of changes with the offset to ask for the next ones with.
+type eventsPage struct {
+	Events []interfaces.Change `json:"events"`
+	Next   int64               `json:"next"`
+}
+
+//	readEventsQuery Reading the query parameters of a request for changes: after, the offset of the last change seen,
+//	or the Last-Event-ID header of a reconnected stream, limit and wait, the seconds to wait for changes.
+func readEventsQuery(c echo.Context) (eventsQuery, error) {
+	query := eventsQuery{limit: eventsDefaultLimit}
+	after := c.QueryParam("after")
+	if after == "" {
+		after = c.Request().Header.Get("Last-Event-ID")
+	}
+	if after != "" {
+		n, err := strconv.ParseInt(after, 10, 64)
+		if err != nil || n < 0 {
+			return query, errors.New("after is out of range")
+		}
+		query.after = n
+	}
+	if limit := c.QueryParam("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 1 || n > eventsMaxLimit {
+			return query, errors.New("limit is out of range")
+		}
+		query.limit = n
+	}
+	if wait := c.QueryParam("wait"); wait != "" {
+		n, err := strconv.Atoi(wait)
+		if err != nil || n < 0 || time.Duration(n)*time.Second > eventsMaxWait {
+			return query, errors.New("wait is out of range")
+		}
+		query.wait = time.Duration(n) * time.Second
+	}
+	return query, nil
+}
+
+//	GetEvents - Get request handler.
+//	Getting changes of user links, or links of the workspace of the header, past an offset, the oldest first.
+//	With wait the request waits for changes if there are none yet. With the Accept header of server-sent events
+//	changes are streamed as they come, a reconnected stream resumes after the Last-Event-ID.
+func (s Server) GetEvents(c echo.Context) error {
+	userID, err := s.userID(c)
+	if err != nil {
+		return c.NoContent(http.StatusBadRequest)
+	}
+	workspaceID, err := s.workspace(c, userID, interfaces.RoleViewer)
+	if err != nil {
+		return workspaceError(c, err)
+	}
+	query, err := readEventsQuery(c)
+	if err != nil {
+		return c.NoContent(http.StatusBadRequest)
+	}
+	if strings.Contains(c.Request().Header.Get(echo.HeaderAccept), mimeEventStream) {
+		return s.streamEvents(c, userID, workspaceID, query)
+	}
+	ctx := c.Request().Context()
+	deadline := time.Now().Add(query.wait)
+	for {
+		changes, err := s.changes(userID, workspaceID, query)
+		if err != nil {
+			return c.NoContent(http.StatusInternalServerError)
+		}
+		wait := time.Until(deadline)
+		if len(changes) > 0 || wait <= 0 {
+			page := eventsPage{Events: changes, Next: query.after}
+			if len(changes) > 0 {
+				page.Next = changes[len(changes)-1].Offset
+			}
+			return c.JSON(http.StatusOK, page)
+		}
+		if wait > eventsPollInterval {
+			wait = eventsPollInterval
+		}
+		select {
+		case <-ctx.Done():
+			// the client is gone
+			return nil
+		case <-time.After(wait):
+		}
+	}
+}
+
+//	streamEvents Sending changes as server-sent events until the client goes away,
+//	the id of an event is its offset.
+func (s Server) streamEvents(c echo.Context, userID, workspaceID string, query eventsQuery) error {
+	res := c.Response()
+	res.Header().Set(echo.HeaderContentType, mimeEventStream)
+	res.Header().Set("Cache-Control", "no-cache")
+	// proxies must not buffer the stream
+	res.Header().Set("X-Accel-Buffering", "no")
+	res.WriteHeader(http.StatusOK)
+	fmt.Fprintf(res, "retry: %d\n\n", eventsPollInterval.Milliseconds())
+	res.Flush()
+	ctx := c.Request().Context()
+	written := time.Now()
+	for {
+		changes, err := s.changes(userID, workspaceID, query)
+		if err != nil {
+			// the status is sent, the client reconnects
+			log.Println(err)
+			return nil
+		}
+		for _, change := range changes {
+			data, err := json.Marshal(change)
+			if err != nil {
+				log.Println(err)
+				return nil
+			}
+			fmt.Fprintf(res, "id: %d\nevent: %s\ndata: %s\n\n", change.Offset, change.Type, data)
+			query.after = change.Offset
+		}
+		if len(changes) > 0 {
+			res.Flush()
+			written = time.Now()
+		} else if time.Since(written) >= eventsKeepAlive {
+			fmt.Fprint(res, ": keep-alive\n\n")
+			res.Flush()
+			written = time.Now()
+		}
+		if len(changes) == query.limit {
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(eventsPollInterval):
+		}
+	}
+}
+
+//	changes Returning the changes the query asks for with full short URLs.
+func (s Server) changes(userID, workspaceID string, query eventsQuery) ([]interfaces.Change, error) {
+	changes, err := s.storage.GetChanges(userID, workspaceID, query.after, query.limit)
+	if err != nil {
+		return nil, err
+	}
+	for i := range changes {
+		changes[i].ShortURL = s.shortURL(changes[i].ShortURL)
+	}
+	return changes, nil
+}
@@ -2,57 +2,192 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
+	"log"
 	"net/http"
 	"net/url"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/labstack/echo/v4"
 
+	"github.com/ivanmyagkov/shortener.git/internal/access"
+	"github.com/ivanmyagkov/shortener.git/internal/annotation"
+	"github.com/ivanmyagkov/shortener.git/internal/domains"
 	"github.com/ivanmyagkov/shortener.git/internal/interfaces"
+	"github.com/ivanmyagkov/shortener.git/internal/middleware"
+	"github.com/ivanmyagkov/shortener.git/internal/redirect"
 	_ "github.com/ivanmyagkov/shortener.git/internal/storage"
 	"github.com/ivanmyagkov/shortener.git/internal/utils"
 )
 
 // Server struct
 type Server struct {
-	storage  interfaces.Storage
-	cfg      interfaces.Config
-	user     interfaces.Users
-	inWorker interfaces.InWorker
+	storage       interfaces.Storage
+	cfg           interfaces.Config
+	user          interfaces.Users
+	inWorker      interfaces.InWorker
+	canonicalizer interfaces.Canonicalizer
+	validator     interfaces.URLValidator
+	checker       interfaces.URLChecker
+	failOpen      bool
+	accounts      interfaces.Accounts
+	workspaces    interfaces.Workspaces
+	routing       interfaces.Routing
+	geo           interfaces.GeoResolver
+	meta          interfaces.MetaFetcher
+	webhooks      interfaces.Webhooks
 }
 
+//	Option is function to set optional server settings.
+type Option func(s *Server)
+
 //	New is function to set server settings.
-func New(storage interfaces.Storage, config interfaces.Config, user interfaces.Users, inWorker interfaces.InWorker) *Server {
-	return &Server{
+func New(storage interfaces.Storage, config interfaces.Config, user interfaces.Users, inWorker interfaces.InWorker, opts ...Option) *Server {
+	s := &Server{
 		storage:  storage,
 		cfg:      config,
 		user:     user,
 		inWorker: inWorker,
 	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+//	WithCanonicalizer is option to bring URLs to the canonical form before shortening.
+func WithCanonicalizer(c interfaces.Canonicalizer) Option {
+	return func(s *Server) {
+		s.canonicalizer = c
+	}
+}
+
+//	WithValidator is option to check URLs against the validation policy before shortening.
+func WithValidator(v interfaces.URLValidator) Option {
+	return func(s *Server) {
+		s.validator = v
+	}
+}
+
+//	WithURLChecker is option to consult a reputation service before shortening.
+//	With failOpen URLs are accepted when the service is unavailable, otherwise they are rejected.
+func WithURLChecker(checker interfaces.URLChecker, failOpen bool) Option {
+	return func(s *Server) {
+		s.checker = checker
+		s.failOpen = failOpen
+	}
+}
+
+//	WithMetaFetcher is option to fetch titles and icons of pages of new original URLs.
+func WithMetaFetcher(fetcher interfaces.MetaFetcher) Option {
+	return func(s *Server) {
+		s.meta = fetcher
+	}
+}
+
+//	userID Returning the user of the request: found by the session middleware or read from the cookie.
+func (s Server) userID(c echo.Context) (string, error) {
+	if userID, ok := middleware.UserID(c); ok {
+		return userID, nil
+	}
+	cookie, err := c.Request().Cookie("cookie")
+	if err != nil {
+		return "", err
+	}
+	userID, _ := s.user.ReadSessionID(cookie.Value)
+	return userID, nil
+}
+
+//	maxCodeAttempts is how many short URLs are tried for a URL whose codes were retargeted.
+const maxCodeAttempts = 10
+
+//	Modes of short URLs chosen by the mode query parameter: users shortening the same URL
+//	share its short URL, a private short URL belongs to one user or workspace.
+const (
+	modeShared  = "shared"
+	modePrivate = "private"
+)
+
+//	privateMode Reading the mode of the request, short URLs are shared by default.
+func privateMode(c echo.Context) (bool, error) {
+	switch c.QueryParam("mode") {
+	case "", modeShared:
+		return false, nil
+	case modePrivate:
+		return true, nil
+	}
+	return false, errors.New("mode must be shared or private")
+}
+
+//	workspaceHeader selects the workspace the request acts in, requests without it act for the user.
+const workspaceHeader = "X-Workspace-ID"
+
+//	workspace Returning the workspace of the request after checking the user has the role in it.
+//	The workspace is empty for requests acting for the user.
+func (s Server) workspace(c echo.Context, userID, role string) (string, error) {
+	workspaceID := c.Request().Header.Get(workspaceHeader)
+	if workspaceID == "" {
+		return "", nil
+	}
+	if s.workspaces == nil {
+		return "", interfaces.ErrNotFound
+	}
+	return workspaceID, s.workspaces.Authorize(userID, workspaceID, role)
+}
+
+//	workspaceError Responding to a request rejected by the workspace.
+func workspaceError(c echo.Context, err error) error {
+	if errors.Is(err, interfaces.ErrNotFound) {
+		return c.NoContent(http.StatusNotFound)
+	} else if errors.Is(err, interfaces.ErrForbidden) {
+		return c.NoContent(http.StatusForbidden)
+	}
+	return c.NoContent(http.StatusInternalServerError)
 }
 
 //	PostURL - Post request handler.
 //	Adding a link to an abbreviation.
 //	We get an abbreviated link.
 func (s Server) PostURL(c echo.Context) error {
-	cookie, err := c.Request().Cookie("cookie")
+	userID, err := s.userID(c)
+	if err != nil {
+		return c.NoContent(http.StatusBadRequest)
+	}
+	workspaceID, err := s.workspace(c, userID, interfaces.RoleEditor)
+	if err != nil {
+		return workspaceError(c, err)
+	}
+	isPrivate, err := privateMode(c)
+	if err != nil {
+		return c.NoContent(http.StatusBadRequest)
+	}
+	domain, err := s.apiDomain(c)
 	if err != nil {
 		return c.NoContent(http.StatusBadRequest)
 	}
-
-	userID, _ := s.user.ReadSessionID(cookie.Value)
 	body, err := io.ReadAll(c.Request().Body)
 
 	if err != nil {
 		return c.NoContent(http.StatusBadRequest)
 	}
 
-	ShortURL, err := s.shortenURL(userID, string(body))
+	ShortURL, err := s.shortenURL(c.Request().Context(), userID, workspaceID, string(body), linkOptions{private: isPrivate, domain: domain})
 	if err != nil {
+		var validationErr *interfaces.ValidationError
 		if errors.Is(err, interfaces.ErrAlreadyExists) {
 			return c.String(http.StatusConflict, ShortURL)
+		} else if errors.As(err, &validationErr) {
+			return c.JSON(http.StatusUnprocessableEntity, validationErr)
+		} else if errors.Is(err, interfaces.ErrCheckFailed) {
+			return c.NoContent(http.StatusServiceUnavailable)
+		} else if errors.Is(err, interfaces.ErrInvalidURL) {
+			return c.NoContent(http.StatusBadRequest)
 		} else {
 			return c.NoContent(http.StatusInternalServerError)
 		}
@@ -62,24 +197,76 @@ func (s Server) PostURL(c echo.Context) error {
 }
 
 //	GetURL - GET request handler.
-//	We get original link.
+//	We get original link, or its preview with the "+" suffix.
+//	The link is looked up in the domain of the Host header.
 func (s Server) GetURL(c echo.Context) error {
 	if c.Param("id") == "" {
 		return c.NoContent(http.StatusBadRequest)
 	}
-	shortURL := c.Param("id")
-	su, err := s.storage.GetURL(shortURL)
+	code := c.Param("id")
+	if strings.HasSuffix(code, previewSuffix) {
+		return s.preview(c, strings.TrimSuffix(code, previewSuffix))
+	}
+	shortURL, err := s.hostKey(c, code)
+	if err != nil {
+		return c.NoContent(http.StatusBadRequest)
+	}
+	link, err := s.storage.GetLink(shortURL)
 	if err != nil {
-		if errors.Is(err, interfaces.ErrNotFound) {
-			return c.NoContent(http.StatusBadRequest)
-		} else if errors.Is(err, interfaces.ErrWasDeleted) {
-			return c.NoContent(http.StatusGone)
-		} else {
-			return c.NoContent(http.StatusInternalServerError)
+		return linkError(c, err)
+	}
+	if len(link.Access.PasswordHash) > 0 {
+		return passwordForm(c, http.StatusOK, false)
+	}
+	return s.follow(c, link, redirect.StatusCode(link.Redirect))
+}
+
+//	follow Sending the visitor of the link on with the status, counting the visit if the link has a limit
+//	and telling webhooks about the click. Visitors shown the warning about a flagged URL are not counted.
+func (s Server) follow(c echo.Context, link interfaces.Link, status int) error {
+	target, destinationID, verdict, err := s.target(c, link)
+	if err != nil {
+		return c.NoContent(http.StatusInternalServerError)
+	}
+	if verdict.Flagged {
+		return interstitial(c, target, verdict)
+	}
+	if link.Access.MaxVisits > 0 {
+		// the storage checks the limit and counts the visit at once,
+		// so concurrent visitors can't get past the limit
+		visits, err := s.storage.ConsumeVisit(link.ShortURL)
+		if err != nil {
+			return linkError(c, err)
+		}
+		if s.webhooks != nil && visits == link.Access.MaxVisits {
+			s.webhooks.Expired(link.ShortURL, link.BaseURL)
 		}
 	}
-	c.Response().Header().Set("Location", su)
-	return c.NoContent(http.StatusTemporaryRedirect)
+	if s.webhooks != nil {
+		s.webhooks.Clicked(link.ShortURL, link.BaseURL)
+	}
+	if destinationID != "" {
+		// only redirected visitors are counted, and they are redirected even if the count is lost
+		if err := s.storage.AddServed(link.ShortURL, destinationID); err != nil {
+			log.Println(err)
+		}
+	}
+	if !link.Access.IsZero() {
+		// a cached redirect would skip the password or the count
+		c.Response().Header().Set("Cache-Control", "no-store")
+	}
+	c.Response().Header().Set("Location", redirect.Location(target, link.Redirect, c.Request().URL.RawQuery))
+	return c.NoContent(status)
+}
+
+//	linkError Responding to a visit of a link the storage didn't give.
+func linkError(c echo.Context, err error) error {
+	if errors.Is(err, interfaces.ErrNotFound) {
+		return c.NoContent(http.StatusBadRequest)
+	} else if errors.Is(err, interfaces.ErrWasDeleted) {
+		return c.NoContent(http.StatusGone)
+	}
+	return c.NoContent(http.StatusInternalServerError)
 }
 
 //	PostJSON - Post request handler.
@@ -87,29 +274,76 @@ func (s Server) GetURL(c echo.Context) error {
 //	Passing the link in the form of json.
 //	We get an abbreviated link.
 func (s Server) PostJSON(c echo.Context) error {
-	cookie, err := c.Request().Cookie("cookie")
+	userID, err := s.userID(c)
+	if err != nil {
+		return c.NoContent(http.StatusBadRequest)
+	}
+	workspaceID, err := s.workspace(c, userID, interfaces.RoleEditor)
+	if err != nil {
+		return workspaceError(c, err)
+	}
+	isPrivate, err := privateMode(c)
+	if err != nil {
+		return c.NoContent(http.StatusBadRequest)
+	}
+	qr, err := shortenQR(c)
+	if err != nil {
+		return c.NoContent(http.StatusBadRequest)
+	}
+	domain, err := s.apiDomain(c)
 	if err != nil {
 		return c.NoContent(http.StatusBadRequest)
 	}
-	userID, _ := s.user.ReadSessionID(cookie.Value)
 
 	var request struct {
-		URL string `json:"url"`
+		URL      string               `json:"url"`
+		Redirect *interfaces.Redirect `json:"redirect"`
+		// the first destination is the original URL if the URL is not set
+		Destinations []interfaces.Destination `json:"destinations"`
+		Password     string                   `json:"password"`
+		MaxVisits    int64                    `json:"max_visits"`
+		interfaces.Annotation
 	}
 
 	var response struct {
 		Result string `json:"result"`
+		// data URI of the QR code of the short URL, if it was asked for
+		QR string `json:"qr,omitempty"`
 	}
 	err = json.NewDecoder(c.Request().Body).Decode(&request)
 
 	if err != nil {
 		return c.NoContent(http.StatusBadRequest)
 	}
-	response.Result, err = s.shortenURL(userID, request.URL)
+	if request.URL == "" && len(request.Destinations) > 0 {
+		request.URL = request.Destinations[0].URL
+	}
+	response.Result, err = s.shortenURL(c.Request().Context(), userID, workspaceID, request.URL, linkOptions{
+		private:      isPrivate,
+		domain:       domain,
+		redirect:     request.Redirect,
+		destinations: request.Destinations,
+		password:     request.Password,
+		maxVisits:    request.MaxVisits,
+		annotation:   request.Annotation,
+	})
+	if qr != nil && response.Result != "" {
+		var qrErr error
+		if response.QR, qrErr = qr.dataURI(response.Result); qrErr != nil {
+			return c.NoContent(http.StatusInternalServerError)
+		}
+	}
 
 	if err != nil {
+		var validationErr *interfaces.ValidationError
 		if errors.Is(err, interfaces.ErrAlreadyExists) {
 			return c.JSON(http.StatusConflict, response)
+		} else if errors.As(err, &validationErr) {
+			return c.JSON(http.StatusUnprocessableEntity, validationErr)
+		} else if errors.Is(err, interfaces.ErrCheckFailed) {
+			return c.NoContent(http.StatusServiceUnavailable)
+		} else if errors.Is(err, interfaces.ErrInvalidURL) {
+			return c.NoContent(http.StatusBadRequest)
 		} else {
 			return c.NoContent(http.StatusInternalServerError)
 		}
@@ -118,25 +352,157 @@ func (s Server) PostJSON(c echo.Context) error {
 	return c.JSON(http.StatusCreated, response)
 }
 
+// linkOptions - settings of a new link besides its URL.
+type linkOptions struct {
+	private bool
+	// domain of the short URL, "" is the primary domain
+	domain   string
+	redirect *interfaces.Redirect
+	// destinations of a split link
+	destinations []interfaces.Destination
+	// the password asked before the redirect and the visits before the link is gone
+	password  string
+	maxVisits int64
+	// what the owner notes about the link, owners sharing a link note their own
+	annotation interfaces.Annotation
+}
+
 // shortenURL - Auxiliary link shortening functionю
-func (s Server) shortenURL(userID, URL string) (string, error) {
-	_, err := url.ParseRequestURI(URL)
+// The link is added to the workspace if it is set, otherwise to the user.
+// A private link gets a short URL of its own, made of the URL and its owner.
+// A link with redirect options or destinations is always private, they belong to the short URL,
+// so the same URL with other options gets another short URL.
+// A link with a password or a limit of visits is always a new one.
+// Codes are unique per domain, the same URL gets the same code in every domain.
+func (s Server) shortenURL(ctx context.Context, userID, workspaceID, URL string, options linkOptions) (string, error) {
+	private := options.private
+	if options.redirect != nil && options.redirect.IsZero() {
+		options.redirect = nil
+	}
+	if options.redirect != nil {
+		if err := redirect.Validate(*options.redirect); err != nil {
+			return "", err
+		}
+		private = true
+	}
+	if len(options.destinations) > 0 {
+		destinations, err := s.prepareDestinations(ctx, options.destinations)
+		if err != nil {
+			return "", err
+		}
+		options.destinations = destinations
+		private = true
+	}
+	var limits interfaces.Access
+	if options.password != "" || options.maxVisits != 0 {
+		var err error
+		if limits, err = access.SetPassword(limits, options.password); err != nil {
+			return "", err
+		}
+		if limits, err = access.SetMaxVisits(limits, options.maxVisits); err != nil {
+			return "", err
+		}
+		private = true
+	}
+	notes, err := annotation.Normalize(options.annotation)
 	if err != nil {
 		return "", err
 	}
-	shortURL := utils.MD5([]byte(URL))
-	err = s.storage.SetShortURL(userID, shortURL, URL)
+	URL, verdict, err := s.prepareURL(ctx, URL)
+	if err != nil {
+		return "", err
+	}
+	seed := URL
+	if private {
+		owner := userID
+		if workspaceID != "" {
+			owner = "workspace/" + workspaceID
+		}
+		seed = owner + "\n" + URL
+	}
+	// map keys are marshaled sorted, so the same options give the same seed
+	if options.redirect != nil {
+		b, err := json.Marshal(options.redirect)
+		if err != nil {
+			return "", err
+		}
+		seed += "\n" + string(b)
+	}
+	if len(options.destinations) > 0 {
+		b, err := json.Marshal(options.destinations)
+		if err != nil {
+			return "", err
+		}
+		seed += "\n" + string(b)
+	}
+	if !limits.IsZero() {
+		// visits of another link with the same password or limit must not count against this one
+		seed += "\n" + utils.CreateID(16)
+	}
+	shortURL := domains.Key(options.domain, utils.MD5([]byte(seed)))
+	// the link is saved with its options at once, so it never exists without them
+	create := interfaces.LinkOptions{Private: private, Access: limits, Destinations: options.destinations, Annotation: notes}
+	if options.redirect != nil {
+		create.Redirect = *options.redirect
+	}
+	for attempt := 1; ; attempt++ {
+		err = s.storage.CreateURL(userID, workspaceID, shortURL, URL, create)
+		if !errors.Is(err, interfaces.ErrCodeTaken) || attempt == maxCodeAttempts {
+			break
+		}
+		// the code was retargeted or is private, the next one is derived the same way every time
+		shortURL = domains.Key(options.domain, utils.MD5([]byte(seed+"#"+strconv.Itoa(attempt))))
+	}
+	if err == nil || errors.Is(err, interfaces.ErrAlreadyExists) {
+		if verdict != nil {
+			if err := s.storage.SetURLVerdict(URL, *verdict); err != nil {
+				return "", err
+			}
+		}
+	}
 	if err != nil {
 		if errors.Is(err, interfaces.ErrAlreadyExists) {
-			shortURL = utils.NewURL(s.cfg.HostName(), shortURL)
-			return shortURL, interfaces.ErrAlreadyExists
+			return s.shortURL(shortURL), interfaces.ErrAlreadyExists
 		} else {
 			return "", err
 		}
 	}
 
-	shortURL = utils.NewURL(s.cfg.HostName(), shortURL)
-	return shortURL, nil
+	if s.meta != nil {
+		s.meta.Enqueue(URL)
+	}
+	return s.shortURL(shortURL), nil
+}
+
+// prepareURL - Auxiliary function bringing a URL to the canonical form and checking it.
+// The verdict is nil if the reputation wasn't checked.
+func (s Server) prepareURL(ctx context.Context, URL string) (string, *interfaces.Verdict, error) {
+	_, err := url.ParseRequestURI(URL)
+	if err != nil {
+		return "", nil, err
+	}
+	if s.canonicalizer != nil {
+		if URL, err = s.canonicalizer.Canonicalize(URL); err != nil {
+			return "", nil, fmt.Errorf("%w: %v", interfaces.ErrInvalidURL, err)
+		}
+	}
+	if s.validator != nil {
+		if err = s.validator.Validate(URL); err != nil {
+			return "", nil, err
+		}
+	}
+	if s.checker != nil {
+		v, err := s.checker.CheckURL(ctx, URL)
+		if err != nil {
+			if !s.failOpen {
+				return "", nil, fmt.Errorf("%w: %v", interfaces.ErrCheckFailed, err)
+			}
+			log.Println(err)
+		} else {
+			return URL, &v, nil
+		}
+	}
+	return URL, nil, nil
 }
 
 //	GetPing - Ping database handler.
@@ -148,17 +514,26 @@ func (s Server) GetPing(c echo.Context) error {
 }
 
 //	GetURLsByUserID - Get request handler.
-//	Getting all the user's links.
+//	Getting all the user's links or, with the workspace header, the links of the workspace.
+//	Links are filtered by the tag parameters, all of them are required, and by the q parameter
+//	found in the original URL or the title.
 func (s Server) GetURLsByUserID(c echo.Context) error {
 
-	cookie, err := c.Request().Cookie("cookie")
+	userID, err := s.userID(c)
 	if err != nil {
 		return c.NoContent(http.StatusBadRequest)
 	}
 
-	userID, _ := s.user.ReadSessionID(cookie.Value)
+	workspaceID, err := s.workspace(c, userID, interfaces.RoleViewer)
+	if err != nil {
+		return workspaceError(c, err)
+	}
 
-	URLs, err := s.storage.GetAllURLsByUserID(userID)
+	filter, err := annotation.Filter(c.QueryParams()["tag"], c.QueryParam("q"))
+	if err != nil {
+		return c.NoContent(http.StatusBadRequest)
+	}
+	URLs, err := s.storage.FindURLs(userID, workspaceID, filter)
 	if err != nil {
 		return c.NoContent(http.StatusNoContent)
 	}
@@ -167,7 +542,15 @@ func (s Server) GetURLsByUserID(c echo.Context) error {
 	for _, v := range URLs {
 		var model interfaces.ModelURL
 		model.BaseURL = v.BaseURL
-		model.ShortURL = utils.NewURL(s.cfg.HostName(), v.ShortURL)
+		model.ShortURL = s.shortURL(v.ShortURL)
+		model.Private = v.Private
+		model.Redirect = v.Redirect
+		model.PasswordProtected = v.PasswordProtected
+		model.MaxVisits = v.MaxVisits
+		model.Visits = v.Visits
+		model.Meta = v.Meta
+		model.Annotation = v.Annotation
+		model.URLHealth = v.URLHealth
 		URLArray = append(URLArray, model)
 	}
 
@@ -179,11 +562,26 @@ func (s Server) GetURLsByUserID(c echo.Context) error {
 //	Passing the link in the form array  of json.
 //	We get an array of abbreviated link.
 func (s Server) PostBatch(c echo.Context) error {
-	cookie, err := c.Request().Cookie("cookie")
+	userID, err := s.userID(c)
+	if err != nil {
+		return c.NoContent(http.StatusBadRequest)
+	}
+	workspaceID, err := s.workspace(c, userID, interfaces.RoleEditor)
+	if err != nil {
+		return workspaceError(c, err)
+	}
+	isPrivate, err := privateMode(c)
+	if err != nil {
+		return c.NoContent(http.StatusBadRequest)
+	}
+	qr, err := shortenQR(c)
+	if err != nil {
+		return c.NoContent(http.StatusBadRequest)
+	}
+	domain, err := s.apiDomain(c)
 	if err != nil {
 		return c.NoContent(http.StatusBadRequest)
 	}
-	userID, _ := s.user.ReadSessionID(cookie.Value)
 	batchReq := make([]interfaces.BatchRequest, 0, 1000)
 	batchArr := make([]interfaces.BatchResponse, 0, 1000)
 	err = json.NewDecoder(c.Request().Body).Decode(&batchReq)
@@ -194,13 +592,31 @@ func (s Server) PostBatch(c echo.Context) error {
 	for _, batch := range batchReq {
 		var batchRes interfaces.BatchResponse
 		batchRes.CorrelationID = batch.CorrelationID
-		batchRes.ShortURL, err = s.shortenURL(userID, batch.OriginalURL)
+		batchRes.ShortURL, err = s.shortenURL(c.Request().Context(), userID, workspaceID, batch.OriginalURL, linkOptions{
+			private:    isPrivate,
+			domain:     domain,
+			redirect:   batch.Redirect,
+			annotation: batch.Annotation,
+		})
 		if err != nil {
+			var validationErr *interfaces.ValidationError
 			if errors.Is(err, interfaces.ErrAlreadyExists) {
 				return c.NoContent(http.StatusBadRequest)
+			} else if errors.As(err, &validationErr) {
+				return c.JSON(http.StatusUnprocessableEntity, interfaces.BatchError{
+					CorrelationID:   batch.CorrelationID,
+					ValidationError: validationErr,
+				})
+			} else if errors.Is(err, interfaces.ErrCheckFailed) {
+				return c.NoContent(http.StatusServiceUnavailable)
 			}
 			return c.NoContent(http.StatusBadRequest)
 		}
+		if qr != nil {
+			if batchRes.QR, err = qr.dataURI(batchRes.ShortURL); err != nil {
+				return c.NoContent(http.StatusInternalServerError)
+			}
+		}
 
 		batchArr = append(batchArr, batchRes)
 	}
@@ -208,15 +624,24 @@ func (s Server) PostBatch(c echo.Context) error {
 }
 
 //	DelURLsBATCH - DELETE request handler.
-//	delete user links.
+//	delete user links, with the workspace header editors delete links of the workspace.
+//	Codes are of the domain of the request.
 func (s Server) DelURLsBATCH(c echo.Context) error {
-	cookie, err := c.Request().Cookie("cookie")
+	userID, err := s.userID(c)
+	if err != nil {
+		return c.NoContent(http.StatusBadRequest)
+	}
+	workspaceID, err := s.workspace(c, userID, interfaces.RoleEditor)
+	if err != nil {
+		return workspaceError(c, err)
+	}
+	domain, err := s.apiDomain(c)
 	if err != nil {
 		return c.NoContent(http.StatusBadRequest)
 	}
-	userID, _ := s.user.ReadSessionID(cookie.Value)
 	var model interfaces.Task
 	model.ID = userID
+	model.WorkspaceID = workspaceID
 
 	body, err := io.ReadAll(c.Request().Body)
 	if err != nil || len(body) == 0 {
@@ -229,9 +654,141 @@ func (s Server) DelURLsBATCH(c echo.Context) error {
 	}
 
 	for _, deleteURL := range deleteURLs {
-		model.ShortURL = deleteURL
+		if deleteURL == "" || strings.Contains(deleteURL, "/") {
+			continue
+		}
+		model.ShortURL = domains.Key(domain, deleteURL)
 		s.inWorker.Do(model)
 	}
 
 	return c.NoContent(http.StatusAccepted)
 }
+
+//	PatchURL - PATCH request handler.
+//	Changing the original URL, the redirect options or the access limits of a user link,
+//	with the workspace header editors change links of the workspace.
+//	Links shared with other users can't be changed.
+func (s Server) PatchURL(c echo.Context) error {
+	userID, err := s.userID(c)
+	if err != nil {
+		return c.NoContent(http.StatusBadRequest)
+	}
+	workspaceID, err := s.workspace(c, userID, interfaces.RoleEditor)
+	if err != nil {
+		return workspaceError(c, err)
+	}
+	var request struct {
+		URL      string               `json:"url"`
+		Redirect *interfaces.Redirect `json:"redirect"`
+		// an empty password removes it, 0 max visits removes the limit
+		Password  *string `json:"password"`
+		MaxVisits *int64  `json:"max_visits"`
+	}
+	if err = json.NewDecoder(c.Request().Body).Decode(&request); err != nil {
+		return c.NoContent(http.StatusBadRequest)
+	}
+	if request.URL == "" && request.Redirect == nil && request.Password == nil && request.MaxVisits == nil {
+		return c.NoContent(http.StatusBadRequest)
+	}
+	if request.Redirect != nil {
+		var validationErr *interfaces.ValidationError
+		if err = redirect.Validate(*request.Redirect); errors.As(err, &validationErr) {
+			return c.JSON(http.StatusUnprocessableEntity, validationErr)
+		}
+	}
+	shortURL, err := s.apiKey(c)
+	if err != nil {
+		return c.NoContent(http.StatusBadRequest)
+	}
+	patch := interfaces.Patch{Redirect: request.Redirect, ChangedAt: time.Now().UTC()}
+	if request.Password != nil || request.MaxVisits != nil {
+		current, err := s.storage.GetAccess(userID, workspaceID, shortURL)
+		if err != nil {
+			return patchError(c, err)
+		}
+		if request.Password != nil {
+			current, err = access.SetPassword(current, *request.Password)
+		}
+		if err == nil && request.MaxVisits != nil {
+			current, err = access.SetMaxVisits(current, *request.MaxVisits)
+		}
+		var validationErr *interfaces.ValidationError
+		if errors.As(err, &validationErr) {
+			return c.JSON(http.StatusUnprocessableEntity, validationErr)
+		} else if err != nil {
+			return c.NoContent(http.StatusInternalServerError)
+		}
+		patch.Access = &current
+	}
+	if request.URL != "" {
+		patch.NewURL, patch.Verdict, err = s.prepareURL(c.Request().Context(), request.URL)
+		if err != nil {
+			var validationErr *interfaces.ValidationError
+			if errors.As(err, &validationErr) {
+				return c.JSON(http.StatusUnprocessableEntity, validationErr)
+			} else if errors.Is(err, interfaces.ErrCheckFailed) {
+				return c.NoContent(http.StatusServiceUnavailable)
+			}
+			return c.NoContent(http.StatusBadRequest)
+		}
+	}
+	// all fields are saved at once, so a failed patch changes nothing
+	revision, err := s.storage.PatchURL(userID, workspaceID, shortURL, patch)
+	if err != nil {
+		return patchError(c, err)
+	}
+	if s.meta != nil && revision.NewURL != revision.OldURL {
+		s.meta.Enqueue(revision.NewURL)
+	}
+	link, err := s.storage.GetLink(shortURL)
+	if errors.Is(err, interfaces.ErrWasDeleted) {
+		// the link has no visits left, the change is saved anyway
+		return c.NoContent(http.StatusGone)
+	} else if err != nil {
+		return c.NoContent(http.StatusInternalServerError)
+	}
+	model := interfaces.ModelURL{
+		ShortURL:          s.shortURL(link.ShortURL),
+		BaseURL:           link.BaseURL,
+		PasswordProtected: len(link.Access.PasswordHash) > 0,
+		MaxVisits:         link.Access.MaxVisits,
+	}
+	if !link.Redirect.IsZero() {
+		model.Redirect = &link.Redirect
+	}
+	return c.JSON(http.StatusOK, model)
+}
+
+//	patchError Responding to a change of a link rejected by the storage.
+func patchError(c echo.Context, err error) error {
+	if errors.Is(err, interfaces.ErrNotFound) {
+		return c.NoContent(http.StatusNotFound)
+	} else if errors.Is(err, interfaces.ErrShared) {
+		return c.NoContent(http.StatusConflict)
+	}
+	return c.NoContent(http.StatusInternalServerError)
+}
+
+//	GetURLHistory - Get request handler.
+//	Getting changes of the original URL of a user link, the oldest first.
+func (s Server) GetURLHistory(c echo.Context) error {
+	userID, err := s.userID(c)
+	if err != nil {
+		return c.NoContent(http.StatusBadRequest)
+	}
+	workspaceID, err := s.workspace(c, userID, interfaces.RoleViewer)
+	if err != nil {
+		return workspaceError(c, err)
+	}
+	shortURL, err := s.apiKey(c)
+	if err != nil {
+		return c.NoContent(http.StatusBadRequest)
+	}
+	revisions, err := s.storage.GetURLHistory(userID, workspaceID, shortURL)
+	if errors.Is(err, interfaces.ErrNotFound) {
+		return c.NoContent(http.StatusNotFound)
+	} else if err != nil {
+		return c.NoContent(http.StatusInternalServerError)
+	}
+	return c.JSON(http.StatusOK, revisions)
+}
@@ -2,24 +2,44 @@ package handlers
 
 import (
 	"context"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"image/png"
 	"io"
 	"log"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/labstack/echo/v4"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
+	"github.com/ivanmyagkov/shortener.git/internal/accounts"
+	"github.com/ivanmyagkov/shortener.git/internal/canonical"
+	"github.com/ivanmyagkov/shortener.git/internal/changes"
 	"github.com/ivanmyagkov/shortener.git/internal/config"
 	"github.com/ivanmyagkov/shortener.git/internal/interfaces"
+	"github.com/ivanmyagkov/shortener.git/internal/keys"
+	"github.com/ivanmyagkov/shortener.git/internal/middleware"
+	"github.com/ivanmyagkov/shortener.git/internal/pagemeta"
+	"github.com/ivanmyagkov/shortener.git/internal/routing"
 	"github.com/ivanmyagkov/shortener.git/internal/storage"
+	"github.com/ivanmyagkov/shortener.git/internal/urlpolicy"
+	"github.com/ivanmyagkov/shortener.git/internal/webhooks"
 	"github.com/ivanmyagkov/shortener.git/internal/workerpool"
+	"github.com/ivanmyagkov/shortener.git/internal/workspaces"
 )
 
+// the test cookie is a session of the old format, encrypted with the formerly hardcoded key
+var testKeys, _ = keys.NewRing([]keys.Key{{ID: "test", Secret: make([]byte, keys.Size)}}, []byte("vfktymrjqtkjxrt[jkjlyjpb"))
+
 func TestGetUrl(t *testing.T) {
 	type args struct {
 		db       *storage.DB
@@ -42,7 +62,7 @@ func TestGetUrl(t *testing.T) {
 			name: "without param",
 			args: args{
 				db:       storage.NewDBConn(),
-				usr:      storage.New(),
+				usr:      storage.New(testKeys),
 				cfg:      config.NewConfig(":8080", "http://localhost:8080/", "", "", false),
 				URL:      "https://www.yandex.ru",
 				shortURL: "http://localhost:8080/f845599b09851789",
@@ -55,7 +75,7 @@ func TestGetUrl(t *testing.T) {
 			name: "with empty bd",
 			args: args{
 				db:     storage.NewDBConn(),
-				usr:    storage.New(),
+				usr:    storage.New(testKeys),
 				cfg:    config.NewConfig(":8080", "http://localhost:8080/", "", "", false),
 				cookie: "a07a35a622236b60753719fbc9a9ff0c",
 			},
@@ -66,7 +86,7 @@ func TestGetUrl(t *testing.T) {
 			name: "with param",
 			args: args{
 				db:       storage.NewDBConn(),
-				usr:      storage.New(),
+				usr:      storage.New(testKeys),
 				cfg:      config.NewConfig(":8080", "http://localhost:8080", "", "", false),
 				URL:      "https://www.yandex.ru",
 				shortURL: "f845599b09851789",
@@ -127,7 +147,7 @@ func TestPostUrl(t *testing.T) {
 			value: "",
 			args: args{
 				db:     storage.NewDBConn(),
-				usr:    storage.New(),
+				usr:    storage.New(testKeys),
 				cfg:    config.NewConfig(":8080", "http://localhost:8080", "", "", false),
 				cookie: "a07a35a622236b60753719fbc9a9ff0c",
 			},
@@ -138,7 +158,7 @@ func TestPostUrl(t *testing.T) {
 			value: "https://www.yandex.ru",
 			args: args{
 				db:     storage.NewDBConn(),
-				usr:    storage.New(),
+				usr:    storage.New(testKeys),
 				cfg:    config.NewConfig(":8080", "http://localhost:8080", "", "", false),
 				cookie: "a07a35a622236b60753719fbc9a9ff0c",
 			},
@@ -198,7 +218,7 @@ func TestPostJSON(t *testing.T) {
 			value: "",
 			args: args{
 				db:     storage.NewDBConn(),
-				usr:    storage.New(),
+				usr:    storage.New(testKeys),
 				cfg:    config.NewConfig(":8080", "http://localhost:8080", "", "", false),
 				cookie: "a07a35a622236b60753719fbc9a9ff0c",
 			},
@@ -209,7 +229,7 @@ func TestPostJSON(t *testing.T) {
 			value: `{"url": ""}`,
 			args: args{
 				db:     storage.NewDBConn(),
-				usr:    storage.New(),
+				usr:    storage.New(testKeys),
 				cfg:    config.NewConfig(":8080", "http://localhost:8080", "", "", false),
 				cookie: "a07a35a622236b60753719fbc9a9ff0c",
 			},
@@ -220,7 +240,7 @@ func TestPostJSON(t *testing.T) {
 			value: `{"url" : "https://www.yandex.ru"}`,
 			args: args{
 				db:     storage.NewDBConn(),
-				usr:    storage.New(),
+				usr:    storage.New(testKeys),
 				cfg:    config.NewConfig(":8080", "http://localhost:8080", "", "", false),
 				cookie: "a07a35a622236b60753719fbc9a9ff0c",
 			},
@@ -288,7 +308,7 @@ func TestServer_GetURLsByUserID(t *testing.T) {
 			value: "",
 			args: args{
 				db:     storage.NewDBConn(),
-				usr:    storage.New(),
+				usr:    storage.New(testKeys),
 				cfg:    config.NewConfig(":8080", "http://localhost:8080", "", "", false),
 				cookie: "a07a35a622236b60753719fbc9a9ff0c",
 			},
@@ -322,3 +342,1825 @@ func TestServer_GetURLsByUserID(t *testing.T) {
 		})
 	}
 }
+
+func TestPostUrl_Canonical(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		want  string
+	}{
+		{
+			name:  "canonical",
+			value: "https://www.yandex.ru",
+			want:  "http://localhost:8080/f845599b09851789",
+		},
+		{
+			name:  "upper case and tracking",
+			value: "HTTPS://WWW.Yandex.RU:443/?utm_source=mail",
+			want:  "http://localhost:8080/f845599b09851789",
+		},
+	}
+	canonicalizer, err := canonical.New(canonical.DefaultSteps, canonical.DefaultTrackingParams)
+	require.NoError(t, err)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recordCh := make(chan interfaces.Task, 50)
+			doneCh := make(chan struct{})
+
+			inWorker := workerpool.NewInputWorker(recordCh, doneCh, context.Background())
+			e := echo.New()
+			cfg := config.NewConfig(":8080", "http://localhost:8080", "", "", false)
+			s := New(storage.NewDBConn(), cfg, storage.New(testKeys), inWorker, WithCanonicalizer(canonicalizer))
+			req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(tt.value))
+			rec := httptest.NewRecorder()
+			c := e.NewContext(req, rec)
+			cookies := new(http.Cookie)
+			cookies.Name = "cookie"
+			cookies.Path = "/"
+			cookies.Value = "a07a35a622236b60753719fbc9a9ff0c"
+			c.Request().AddCookie(cookies)
+			h := s.PostURL(c)
+			if assert.NoError(t, h) {
+				require.Equal(t, http.StatusCreated, rec.Code)
+				require.Equal(t, tt.want, rec.Body.String())
+			}
+		})
+	}
+}
+
+// failingCanonicalizer rejects every URL.
+type failingCanonicalizer struct{}
+
+func (failingCanonicalizer) Canonicalize(string) (string, error) {
+	return "", errors.New("can't canonicalize")
+}
+
+func TestPostUrl_CanonicalError(t *testing.T) {
+	recordCh := make(chan interfaces.Task, 50)
+	inWorker := workerpool.NewInputWorker(recordCh, make(chan struct{}), context.Background())
+	cfg := config.NewConfig(":8080", "http://localhost:8080", "", "", false)
+	s := New(storage.NewDBConn(), cfg, storage.New(testKeys), inWorker, WithCanonicalizer(failingCanonicalizer{}))
+	tests := []struct {
+		name    string
+		body    string
+		handler echo.HandlerFunc
+	}{
+		{name: "text", body: "https://a.example", handler: s.PostURL},
+		{name: "json", body: `{"url":"https://a.example"}`, handler: s.PostJSON},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(tt.body))
+			req.AddCookie(&http.Cookie{Name: "cookie", Value: "a07a35a622236b60753719fbc9a9ff0c"})
+			rec := httptest.NewRecorder()
+			require.NoError(t, tt.handler(echo.New().NewContext(req, rec)))
+			assert.Equal(t, http.StatusBadRequest, rec.Code)
+		})
+	}
+}
+
+func TestPostJSON_Validation(t *testing.T) {
+	policy, err := urlpolicy.New(urlpolicy.Settings{
+		Schemes:     []string{"http", "https"},
+		DenyPrivate: true,
+		SelfURLs:    []string{"http://localhost:8080"},
+	})
+	require.NoError(t, err)
+
+	tests := []struct {
+		name  string
+		value string
+		code  int
+		error string
+	}{
+		{name: "allowed", value: `{"url": "https://www.yandex.ru"}`, code: http.StatusCreated},
+		{name: "javascript", value: `{"url": "javascript:alert(1)"}`, code: http.StatusUnprocessableEntity, error: urlpolicy.CodeSchemeNotAllowed},
+		{name: "loopback", value: `{"url": "http://127.0.0.1/"}`, code: http.StatusUnprocessableEntity, error: urlpolicy.CodePrivateAddress},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var response interfaces.ValidationError
+			recordCh := make(chan interfaces.Task, 50)
+			doneCh := make(chan struct{})
+
+			inWorker := workerpool.NewInputWorker(recordCh, doneCh, context.Background())
+			e := echo.New()
+			cfg := config.NewConfig(":8080", "http://localhost:8080", "", "", false)
+			s := New(storage.NewDBConn(), cfg, storage.New(testKeys), inWorker, WithValidator(policy))
+			req := httptest.NewRequest(http.MethodPost, "/api/shorten", strings.NewReader(tt.value))
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+			rec := httptest.NewRecorder()
+			c := e.NewContext(req, rec)
+			cookies := new(http.Cookie)
+			cookies.Name = "cookie"
+			cookies.Path = "/"
+			cookies.Value = "a07a35a622236b60753719fbc9a9ff0c"
+			c.Request().AddCookie(cookies)
+			h := s.PostJSON(c)
+			if assert.NoError(t, h) {
+				require.Equal(t, tt.code, rec.Code)
+				if tt.error != "" {
+					require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &response))
+					require.Equal(t, tt.error, response.Code)
+				}
+			}
+		})
+	}
+}
+
+type stubChecker struct {
+	verdict interfaces.Verdict
+	err     error
+}
+
+func (c stubChecker) CheckURL(_ context.Context, _ string) (interfaces.Verdict, error) {
+	return c.verdict, c.err
+}
+
+func TestGetURL_Flagged(t *testing.T) {
+	tests := []struct {
+		name     string
+		checker  stubChecker
+		failOpen bool
+		postCode int
+		getCode  int
+	}{
+		{
+			name:     "clean",
+			checker:  stubChecker{},
+			postCode: http.StatusCreated,
+			getCode:  http.StatusTemporaryRedirect,
+		},
+		{
+			name:     "flagged",
+			checker:  stubChecker{verdict: interfaces.Verdict{Flagged: true, Reason: "malware"}},
+			postCode: http.StatusCreated,
+			getCode:  http.StatusOK,
+		},
+		{
+			name:     "fail open",
+			checker:  stubChecker{err: errors.New("unavailable")},
+			failOpen: true,
+			postCode: http.StatusCreated,
+			getCode:  http.StatusTemporaryRedirect,
+		},
+		{
+			name:     "fail closed",
+			checker:  stubChecker{err: errors.New("unavailable")},
+			postCode: http.StatusServiceUnavailable,
+			getCode:  http.StatusBadRequest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recordCh := make(chan interfaces.Task, 50)
+			doneCh := make(chan struct{})
+
+			inWorker := workerpool.NewInputWorker(recordCh, doneCh, context.Background())
+			e := echo.New()
+			cfg := config.NewConfig(":8080", "http://localhost:8080", "", "", false)
+			s := New(storage.NewDBConn(), cfg, storage.New(testKeys), inWorker, WithURLChecker(tt.checker, tt.failOpen))
+
+			req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("https://www.yandex.ru"))
+			rec := httptest.NewRecorder()
+			c := e.NewContext(req, rec)
+			cookies := new(http.Cookie)
+			cookies.Name = "cookie"
+			cookies.Path = "/"
+			cookies.Value = "a07a35a622236b60753719fbc9a9ff0c"
+			c.Request().AddCookie(cookies)
+			require.NoError(t, s.PostURL(c))
+			require.Equal(t, tt.postCode, rec.Code)
+
+			req = httptest.NewRequest(http.MethodGet, "/", nil)
+			rec = httptest.NewRecorder()
+			c = e.NewContext(req, rec)
+			c.SetPath("/:id")
+			c.SetParamNames("id")
+			c.SetParamValues("f845599b09851789")
+			require.NoError(t, s.GetURL(c))
+			require.Equal(t, tt.getCode, rec.Code)
+			if tt.getCode == http.StatusOK {
+				require.Contains(t, rec.Body.String(), "malware")
+				require.Empty(t, rec.Header().Get("Location"))
+			}
+		})
+	}
+}
+
+func TestGetURL_FlaggedLimited(t *testing.T) {
+	db := storage.NewDBConn()
+	usr := storage.New(testKeys)
+	inWorker := workerpool.NewInputWorker(make(chan interfaces.Task, 50), make(chan struct{}), context.Background())
+	cfg := config.NewConfig(":8080", "http://localhost:8080", "", "", false)
+	checker := stubChecker{verdict: interfaces.Verdict{Flagged: true, Reason: "malware"}}
+	s := New(db, cfg, usr, inWorker, WithURLChecker(checker, false))
+
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"url":"https://a.example","max_visits":1}`))
+	value, err := usr.CreateSissionID("00000000000000000000000000000001")
+	require.NoError(t, err)
+	req.AddCookie(&http.Cookie{Name: "cookie", Value: value})
+	rec := httptest.NewRecorder()
+	require.NoError(t, s.PostJSON(echo.New().NewContext(req, rec)))
+	require.Equal(t, http.StatusCreated, rec.Code)
+	var response struct {
+		Result string `json:"result"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &response))
+	code := strings.TrimPrefix(response.Result, "http://localhost:8080/")
+
+	// the warning page doesn't use up the only visit of the link
+	for i := 0; i < 2; i++ {
+		rec = httptest.NewRecorder()
+		c := echo.New().NewContext(httptest.NewRequest(http.MethodGet, "/", nil), rec)
+		c.SetParamNames("id")
+		c.SetParamValues(code)
+		require.NoError(t, s.GetURL(c))
+		require.Equal(t, http.StatusOK, rec.Code)
+		assert.Contains(t, rec.Body.String(), "malware")
+	}
+	link, err := db.GetLink(code)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), link.Access.MaxVisits)
+}
+
+func TestAccounts(t *testing.T) {
+	db := storage.NewDBConn()
+	usr := storage.New(testKeys)
+	service := accounts.New(db)
+	recordCh := make(chan interfaces.Task, 50)
+	inWorker := workerpool.NewInputWorker(recordCh, make(chan struct{}), context.Background())
+	cfg := config.NewConfig(":8080", "http://localhost:8080", "", "", false)
+	s := New(db, cfg, usr, inWorker, WithAccounts(service))
+
+	e := echo.New()
+	e.Use(middleware.New(usr, service).SessionWithCookies)
+	e.POST("/api/user/register", s.PostRegister)
+	e.POST("/api/user/login", s.PostLogin)
+	e.GET("/api/user/keys", s.GetAPIKeys)
+	e.POST("/api/user/keys", s.PostAPIKey)
+	e.DELETE("/api/user/keys/:id", s.DelAPIKey)
+	e.GET("/api/user/urls", s.GetURLsByUserID)
+
+	do := func(method, target, body string, header http.Header) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		for name, values := range header {
+			req.Header[name] = values
+		}
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec
+	}
+	session := func(rec *httptest.ResponseRecorder) http.Header {
+		// the last cookie wins, login replaces the cookie of the middleware
+		cookies := rec.Result().Cookies()
+		return http.Header{"Cookie": {cookies[len(cookies)-1].String()}}
+	}
+
+	rec := do(http.MethodGet, "/api/user/keys", "", nil)
+	require.Equal(t, http.StatusForbidden, rec.Code)
+	owner := session(rec)
+
+	rec = do(http.MethodPost, "/api/user/register", `{"login":"alice","password":"short"}`, owner)
+	require.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+	rec = do(http.MethodPost, "/api/user/register", `{"login":"alice","password":"password1"}`, owner)
+	require.Equal(t, http.StatusCreated, rec.Code)
+	rec = do(http.MethodPost, "/api/user/register", `{"login":"bob","password":"password1"}`, owner)
+	require.Equal(t, http.StatusConflict, rec.Code)
+
+	rec = do(http.MethodPost, "/api/user/login", `{"login":"alice","password":"wrong password"}`, nil)
+	require.Equal(t, http.StatusUnauthorized, rec.Code)
+	rec = do(http.MethodPost, "/api/user/login", `{"login":"alice","password":"password1"}`, nil)
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	rec = do(http.MethodPost, "/api/user/keys", `{"name":"ci"}`, session(rec))
+	require.Equal(t, http.StatusCreated, rec.Code)
+	var key struct {
+		ID  string `json:"id"`
+		Key string `json:"key"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &key))
+	require.NotEmpty(t, key.Key)
+
+	require.NoError(t, db.SetShortURL(mustUserID(t, usr, owner), "a", "http://a.example"))
+	apiKey := http.Header{"X-Api-Key": {key.Key}}
+	rec = do(http.MethodGet, "/api/user/urls", "", apiKey)
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Empty(t, rec.Result().Cookies())
+	assert.Contains(t, rec.Body.String(), "http://a.example")
+
+	rec = do(http.MethodGet, "/api/user/keys", "", apiKey)
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.NotContains(t, rec.Body.String(), key.Key)
+
+	rec = do(http.MethodDelete, "/api/user/keys/"+key.ID, "", owner)
+	require.Equal(t, http.StatusNoContent, rec.Code)
+	rec = do(http.MethodGet, "/api/user/urls", "", apiKey)
+	require.Equal(t, http.StatusUnauthorized, rec.Code)
+	rec = do(http.MethodDelete, "/api/user/keys/"+key.ID, "", owner)
+	require.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+func mustUserID(t *testing.T, usr *storage.DBUsers, header http.Header) string {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header = header
+	cookie, err := req.Cookie("cookie")
+	require.NoError(t, err)
+	userID, err := usr.ReadSessionID(cookie.Value)
+	require.NoError(t, err)
+	return userID
+}
+
+func TestWorkspaceURLs(t *testing.T) {
+	db := storage.NewDBConn()
+	usr := storage.New(testKeys)
+	service := workspaces.New(db)
+	// session user IDs are 16 bytes in hex
+	users := map[string]string{
+		"owner":    "00000000000000000000000000000001",
+		"viewer":   "00000000000000000000000000000002",
+		"stranger": "00000000000000000000000000000003",
+	}
+	require.NoError(t, db.CreateAccount(interfaces.Account{UserID: users["owner"], Login: "alice"}))
+	require.NoError(t, db.CreateAccount(interfaces.Account{UserID: users["viewer"], Login: "carol"}))
+	workspace, err := service.Create(users["owner"], "team")
+	require.NoError(t, err)
+	_, err = service.SetMember(users["owner"], workspace.ID, "carol", interfaces.RoleViewer)
+	require.NoError(t, err)
+
+	recordCh := make(chan interfaces.Task, 50)
+	inWorker := workerpool.NewInputWorker(recordCh, make(chan struct{}), context.Background())
+	cfg := config.NewConfig(":8080", "http://localhost:8080", "", "", false)
+	s := New(db, cfg, usr, inWorker, WithWorkspaces(service))
+
+	tests := []struct {
+		name      string
+		userID    string
+		method    string
+		target    string
+		body      string
+		handler   func(c echo.Context) error
+		want      int
+		workspace string
+	}{
+		{name: "viewer can't add", userID: "viewer", method: http.MethodPost, target: "/api/shorten", body: `{"url":"https://a.example"}`, handler: s.PostJSON, want: http.StatusForbidden},
+		{name: "owner adds", userID: "owner", method: http.MethodPost, target: "/api/shorten", body: `{"url":"https://a.example"}`, handler: s.PostJSON, want: http.StatusCreated},
+		{name: "stranger can't list", userID: "stranger", method: http.MethodGet, target: "/api/user/urls", handler: s.GetURLsByUserID, want: http.StatusNotFound},
+		{name: "viewer lists", userID: "viewer", method: http.MethodGet, target: "/api/user/urls", handler: s.GetURLsByUserID, want: http.StatusOK},
+		{name: "viewer can't delete", userID: "viewer", method: http.MethodDelete, target: "/api/user/urls", body: `["a"]`, handler: s.DelURLsBATCH, want: http.StatusForbidden},
+		{name: "unknown workspace", userID: "owner", method: http.MethodGet, target: "/api/user/urls", handler: s.GetURLsByUserID, want: http.StatusNotFound, workspace: "unknown"},
+		{name: "owner has no own links", userID: "owner", method: http.MethodGet, target: "/api/user/urls", handler: s.GetURLsByUserID, want: http.StatusNoContent, workspace: "-"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.target, strings.NewReader(tt.body))
+			switch tt.workspace {
+			case "":
+				req.Header.Set(workspaceHeader, workspace.ID)
+			case "-":
+			default:
+				req.Header.Set(workspaceHeader, tt.workspace)
+			}
+			value, err := usr.CreateSissionID(users[tt.userID])
+			require.NoError(t, err)
+			req.AddCookie(&http.Cookie{Name: "cookie", Value: value})
+			rec := httptest.NewRecorder()
+			if assert.NoError(t, tt.handler(echo.New().NewContext(req, rec))) {
+				require.Equal(t, tt.want, rec.Code)
+			}
+		})
+	}
+	urls, err := db.GetWorkspaceURLs(workspace.ID)
+	require.NoError(t, err)
+	assert.Len(t, urls, 1)
+}
+
+func TestPatchURL(t *testing.T) {
+	db := storage.NewDBConn()
+	usr := storage.New(testKeys)
+	recordCh := make(chan interfaces.Task, 50)
+	inWorker := workerpool.NewInputWorker(recordCh, make(chan struct{}), context.Background())
+	cfg := config.NewConfig(":8080", "http://localhost:8080", "", "", false)
+	s := New(db, cfg, usr, inWorker)
+	owner := "00000000000000000000000000000001"
+	other := "00000000000000000000000000000002"
+
+	do := func(userID, method, target, body string, handler func(c echo.Context) error, params ...string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		value, err := usr.CreateSissionID(userID)
+		require.NoError(t, err)
+		req.AddCookie(&http.Cookie{Name: "cookie", Value: value})
+		rec := httptest.NewRecorder()
+		c := echo.New().NewContext(req, rec)
+		if len(params) > 0 {
+			c.SetParamNames("id")
+			c.SetParamValues(params...)
+		}
+		require.NoError(t, handler(c))
+		return rec
+	}
+
+	rec := do(owner, http.MethodPost, "/", "https://a.example", s.PostURL)
+	require.Equal(t, http.StatusCreated, rec.Code)
+	code := strings.TrimPrefix(rec.Body.String(), "http://localhost:8080/")
+	rec = do(owner, http.MethodPost, "/", "https://shared.example", s.PostURL)
+	require.Equal(t, http.StatusCreated, rec.Code)
+	shared := strings.TrimPrefix(rec.Body.String(), "http://localhost:8080/")
+	rec = do(other, http.MethodPost, "/", "https://shared.example", s.PostURL)
+	require.Equal(t, http.StatusCreated, rec.Code)
+
+	tests := []struct {
+		name   string
+		userID string
+		code   string
+		body   string
+		want   int
+	}{
+		{name: "bad body", userID: owner, code: code, body: `{`, want: http.StatusBadRequest},
+		{name: "bad url", userID: owner, code: code, body: `{"url":"not a url"}`, want: http.StatusBadRequest},
+		{name: "not owner", userID: other, code: code, body: `{"url":"https://b.example"}`, want: http.StatusNotFound},
+		{name: "shared", userID: owner, code: shared, body: `{"url":"https://b.example"}`, want: http.StatusConflict},
+		{name: "ok", userID: owner, code: code, body: `{"url":"https://b.example"}`, want: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := do(tt.userID, http.MethodPatch, "/api/user/urls/"+tt.code, tt.body, s.PatchURL, tt.code)
+			require.Equal(t, tt.want, rec.Code)
+		})
+	}
+
+	baseURL, err := db.GetURL(code)
+	require.NoError(t, err)
+	assert.Equal(t, "https://b.example", baseURL)
+
+	rec = do(owner, http.MethodGet, "/api/user/urls/"+code+"/history", "", s.GetURLHistory, code)
+	require.Equal(t, http.StatusOK, rec.Code)
+	var revisions []interfaces.Revision
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &revisions))
+	require.Len(t, revisions, 1)
+	assert.Equal(t, "https://a.example", revisions[0].OldURL)
+	assert.Equal(t, "https://b.example", revisions[0].NewURL)
+	assert.Equal(t, owner, revisions[0].UserID)
+	rec = do(other, http.MethodGet, "/api/user/urls/"+code+"/history", "", s.GetURLHistory, code)
+	require.Equal(t, http.StatusNotFound, rec.Code)
+
+	// the old URL gets a new code, its former code leads to the new URL
+	rec = do(other, http.MethodPost, "/", "https://a.example", s.PostURL)
+	require.Equal(t, http.StatusCreated, rec.Code)
+	assert.NotEqual(t, "http://localhost:8080/"+code, rec.Body.String())
+	rec = do(other, http.MethodPost, "/", "https://a.example", s.PostURL)
+	require.Equal(t, http.StatusConflict, rec.Code)
+}
+
+// failingPatch is a storage which can't save patches.
+type failingPatch struct {
+	interfaces.Storage
+}
+
+func (failingPatch) PatchURL(string, string, string, interfaces.Patch) (interfaces.Revision, error) {
+	return interfaces.Revision{}, errors.New("storage is down")
+}
+
+func TestPatchURL_Failed(t *testing.T) {
+	db := storage.NewDBConn()
+	usr := storage.New(testKeys)
+	inWorker := workerpool.NewInputWorker(make(chan interfaces.Task, 50), make(chan struct{}), context.Background())
+	cfg := config.NewConfig(":8080", "http://localhost:8080", "", "", false)
+	owner := "00000000000000000000000000000001"
+	require.NoError(t, db.SetPrivateURL(owner, "", "code", "https://a.example"))
+	s := New(failingPatch{db}, cfg, usr, inWorker)
+
+	req := httptest.NewRequest(http.MethodPatch, "/api/user/urls/code",
+		strings.NewReader(`{"url":"https://b.example","redirect":{"status_code":301},"max_visits":5}`))
+	value, err := usr.CreateSissionID(owner)
+	require.NoError(t, err)
+	req.AddCookie(&http.Cookie{Name: "cookie", Value: value})
+	rec := httptest.NewRecorder()
+	c := echo.New().NewContext(req, rec)
+	c.SetParamNames("id")
+	c.SetParamValues("code")
+	require.NoError(t, s.PatchURL(c))
+	assert.Equal(t, http.StatusInternalServerError, rec.Code)
+
+	// none of the fields is saved
+	link, err := db.GetLink("code")
+	require.NoError(t, err)
+	assert.Equal(t, "https://a.example", link.BaseURL)
+	assert.True(t, link.Redirect.IsZero())
+	assert.True(t, link.Access.IsZero())
+}
+
+// createOnly is a storage which saves options of links only with the links themselves.
+type createOnly struct {
+	interfaces.Storage
+}
+
+func (createOnly) SetAccess(string, string, string, interfaces.Access) error {
+	return errors.New("storage is down")
+}
+
+func (createOnly) SetRedirect(string, string, string, interfaces.Redirect) error {
+	return errors.New("storage is down")
+}
+
+func (createOnly) SetDestinations(string, string, string, []interfaces.Destination) error {
+	return errors.New("storage is down")
+}
+
+func (createOnly) SetAnnotation(string, string, string, interfaces.Annotation) error {
+	return errors.New("storage is down")
+}
+
+func TestShorten_WithOptions(t *testing.T) {
+	db := storage.NewDBConn()
+	usr := storage.New(testKeys)
+	inWorker := workerpool.NewInputWorker(make(chan interfaces.Task, 50), make(chan struct{}), context.Background())
+	cfg := config.NewConfig(":8080", "http://localhost:8080", "", "", false)
+	s := New(createOnly{db}, cfg, usr, inWorker)
+	owner := "00000000000000000000000000000001"
+	shorten := func(body string) string {
+		req := httptest.NewRequest(http.MethodPost, "/api/shorten", strings.NewReader(body))
+		value, err := usr.CreateSissionID(owner)
+		require.NoError(t, err)
+		req.AddCookie(&http.Cookie{Name: "cookie", Value: value})
+		rec := httptest.NewRecorder()
+		require.NoError(t, s.PostJSON(echo.New().NewContext(req, rec)))
+		require.Equal(t, http.StatusCreated, rec.Code)
+		var response struct {
+			Result string `json:"result"`
+		}
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &response))
+		return strings.TrimPrefix(response.Result, "http://localhost:8080/")
+	}
+
+	// the options are saved with the link
+	link, err := db.GetLink(shorten(`{"url":"https://a.example","password":"secret","max_visits":5,"redirect":{"status_code":301}}`))
+	require.NoError(t, err)
+	assert.NotEmpty(t, link.Access.PasswordHash)
+	assert.Equal(t, int64(5), link.Access.MaxVisits)
+	assert.Equal(t, http.StatusMovedPermanently, link.Redirect.StatusCode)
+
+	destinations, err := db.GetDestinations(owner, "", shorten(`{"destinations":[{"url":"https://a.example","weight":50},{"url":"https://b.example","weight":50}]}`))
+	require.NoError(t, err)
+	assert.Len(t, destinations, 2)
+
+	code := shorten(`{"url":"https://c.example","title":"Launch","tags":["Release"]}`)
+	urls, err := db.FindURLs(owner, "", interfaces.URLFilter{Tags: []string{"release"}})
+	require.NoError(t, err)
+	require.Len(t, urls, 1)
+	assert.Equal(t, code, urls[0].ShortURL)
+}
+
+func TestPrivateURLs(t *testing.T) {
+	db := storage.NewDBConn()
+	usr := storage.New(testKeys)
+	recordCh := make(chan interfaces.Task, 50)
+	inWorker := workerpool.NewInputWorker(recordCh, make(chan struct{}), context.Background())
+	cfg := config.NewConfig(":8080", "http://localhost:8080", "", "", false)
+	s := New(db, cfg, usr, inWorker)
+	first := "00000000000000000000000000000001"
+	second := "00000000000000000000000000000002"
+
+	shorten := func(userID, mode string) (int, string) {
+		req := httptest.NewRequest(http.MethodPost, "/?mode="+mode, strings.NewReader("https://a.example"))
+		value, err := usr.CreateSissionID(userID)
+		require.NoError(t, err)
+		req.AddCookie(&http.Cookie{Name: "cookie", Value: value})
+		rec := httptest.NewRecorder()
+		require.NoError(t, s.PostURL(echo.New().NewContext(req, rec)))
+		return rec.Code, strings.TrimPrefix(rec.Body.String(), "http://localhost:8080/")
+	}
+
+	code, _ := shorten(first, "secret")
+	require.Equal(t, http.StatusBadRequest, code)
+
+	code, shared := shorten(first, "")
+	require.Equal(t, http.StatusCreated, code)
+	code, sharedAgain := shorten(second, "shared")
+	require.Equal(t, http.StatusCreated, code)
+	assert.Equal(t, shared, sharedAgain)
+
+	code, firstPrivate := shorten(first, "private")
+	require.Equal(t, http.StatusCreated, code)
+	code, secondPrivate := shorten(second, "private")
+	require.Equal(t, http.StatusCreated, code)
+	assert.NotEqual(t, shared, firstPrivate)
+	assert.NotEqual(t, firstPrivate, secondPrivate)
+	code, again := shorten(first, "private")
+	require.Equal(t, http.StatusConflict, code)
+	assert.Equal(t, firstPrivate, again)
+
+	urls, err := db.GetAllURLsByUserID(first)
+	require.NoError(t, err)
+	require.Len(t, urls, 2)
+	assert.False(t, urls[0].Private)
+	assert.True(t, urls[1].Private)
+
+	// a shared short URL is gone only when all its owners deleted it
+	require.NoError(t, db.DelBatchShortURLs([]interfaces.Task{{ID: second, ShortURL: shared}}))
+	_, err = db.GetURL(shared)
+	require.NoError(t, err)
+	require.NoError(t, db.DelBatchShortURLs([]interfaces.Task{{ID: first, ShortURL: shared}, {ID: first, ShortURL: firstPrivate}}))
+	_, err = db.GetURL(shared)
+	assert.ErrorIs(t, err, interfaces.ErrWasDeleted)
+	_, err = db.GetURL(firstPrivate)
+	assert.ErrorIs(t, err, interfaces.ErrWasDeleted)
+	_, err = db.GetURL(secondPrivate)
+	assert.NoError(t, err)
+}
+
+func TestRedirectOptions(t *testing.T) {
+	db := storage.NewDBConn()
+	usr := storage.New(testKeys)
+	recordCh := make(chan interfaces.Task, 50)
+	inWorker := workerpool.NewInputWorker(recordCh, make(chan struct{}), context.Background())
+	cfg := config.NewConfig(":8080", "http://localhost:8080", "", "", false)
+	s := New(db, cfg, usr, inWorker)
+	owner := "00000000000000000000000000000001"
+
+	do := func(method, target, body string, handler func(c echo.Context) error, params ...string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		value, err := usr.CreateSissionID(owner)
+		require.NoError(t, err)
+		req.AddCookie(&http.Cookie{Name: "cookie", Value: value})
+		rec := httptest.NewRecorder()
+		c := echo.New().NewContext(req, rec)
+		if len(params) > 0 {
+			c.SetParamNames("id")
+			c.SetParamValues(params...)
+		}
+		require.NoError(t, handler(c))
+		return rec
+	}
+	shorten := func(body string) (int, string) {
+		rec := do(http.MethodPost, "/api/shorten", body, s.PostJSON)
+		var response struct {
+			Result string `json:"result"`
+		}
+		_ = json.Unmarshal(rec.Body.Bytes(), &response)
+		return rec.Code, strings.TrimPrefix(response.Result, "http://localhost:8080/")
+	}
+
+	code, _ := shorten(`{"url":"https://a.example","redirect":{"status_code":303}}`)
+	require.Equal(t, http.StatusUnprocessableEntity, code)
+	code, _ = shorten(`{"url":"https://a.example","redirect":{"utm":{"ref":"x"}}}`)
+	require.Equal(t, http.StatusUnprocessableEntity, code)
+
+	code, plain := shorten(`{"url":"https://a.example/page?a=1#top"}`)
+	require.Equal(t, http.StatusCreated, code)
+	code, campaign := shorten(`{"url":"https://a.example/page?a=1#top","redirect":{"status_code":301,"forward_query":true,"utm":{"utm_source":"mail"}}}`)
+	require.Equal(t, http.StatusCreated, code)
+	assert.NotEqual(t, plain, campaign)
+
+	rec := do(http.MethodGet, "/"+plain+"?b=2", "", s.GetURL, plain)
+	assert.Equal(t, http.StatusTemporaryRedirect, rec.Code)
+	assert.Equal(t, "https://a.example/page?a=1#top", rec.Header().Get("Location"))
+	rec = do(http.MethodGet, "/"+campaign+"?b=2", "", s.GetURL, campaign)
+	assert.Equal(t, http.StatusMovedPermanently, rec.Code)
+	assert.Equal(t, "https://a.example/page?a=1&b=2&utm_source=mail#top", rec.Header().Get("Location"))
+
+	// options of a link are changed without its original URL
+	rec = do(http.MethodPatch, "/api/user/urls/"+campaign, `{"redirect":{"status_code":308,"drop_fragment":true}}`, s.PatchURL, campaign)
+	require.Equal(t, http.StatusOK, rec.Code)
+	var model interfaces.ModelURL
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &model))
+	assert.Equal(t, "https://a.example/page?a=1#top", model.BaseURL)
+	require.NotNil(t, model.Redirect)
+	assert.Equal(t, http.StatusPermanentRedirect, model.Redirect.StatusCode)
+	rec = do(http.MethodGet, "/"+campaign+"?b=2", "", s.GetURL, campaign)
+	assert.Equal(t, http.StatusPermanentRedirect, rec.Code)
+	assert.Equal(t, "https://a.example/page?a=1", rec.Header().Get("Location"))
+
+	rec = do(http.MethodPatch, "/api/user/urls/"+campaign, `{}`, s.PatchURL, campaign)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	rec = do(http.MethodPatch, "/api/user/urls/"+campaign, `{"redirect":{"status_code":200}}`, s.PatchURL, campaign)
+	assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+
+	rec = do(http.MethodGet, "/api/user/urls", "", s.GetURLsByUserID)
+	require.Equal(t, http.StatusOK, rec.Code)
+	var urls []interfaces.ModelURL
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &urls))
+	require.Len(t, urls, 2)
+	assert.Nil(t, urls[0].Redirect)
+	require.NotNil(t, urls[1].Redirect)
+	assert.True(t, urls[1].Private)
+	assert.True(t, urls[1].Redirect.DropFragment)
+}
+
+// countries is a GeoResolver of fixed addresses.
+type countries map[string]string
+
+func (c countries) Country(ip net.IP) string {
+	return c[ip.String()]
+}
+
+func TestRoutingRules(t *testing.T) {
+	db := storage.NewDBConn()
+	usr := storage.New(testKeys)
+	recordCh := make(chan interfaces.Task, 50)
+	inWorker := workerpool.NewInputWorker(recordCh, make(chan struct{}), context.Background())
+	cfg := config.NewConfig(":8080", "http://localhost:8080", "", "", false)
+	s := New(db, cfg, usr, inWorker, WithRouting(routing.New(db)), WithGeoResolver(countries{"192.0.2.7": "DE"}))
+	owner := "00000000000000000000000000000001"
+	other := "00000000000000000000000000000002"
+
+	do := func(userID, method, body string, handler func(c echo.Context) error, params ...string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/", strings.NewReader(body))
+		value, err := usr.CreateSissionID(userID)
+		require.NoError(t, err)
+		req.AddCookie(&http.Cookie{Name: "cookie", Value: value})
+		rec := httptest.NewRecorder()
+		c := echo.New().NewContext(req, rec)
+		names := []string{"id", "rule_id"}
+		c.SetParamNames(names[:len(params)]...)
+		c.SetParamValues(params...)
+		require.NoError(t, handler(c))
+		return rec
+	}
+	visit := func(code, userAgent, language, ip string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/"+code, nil)
+		req.Header.Set("User-Agent", userAgent)
+		req.Header.Set("Accept-Language", language)
+		req.RemoteAddr = ip + ":1234"
+		rec := httptest.NewRecorder()
+		c := echo.New().NewContext(req, rec)
+		c.SetParamNames("id")
+		c.SetParamValues(code)
+		require.NoError(t, s.GetURL(c))
+		return rec
+	}
+
+	rec := do(owner, http.MethodPost, "https://web.example", s.PostURL)
+	require.Equal(t, http.StatusCreated, rec.Code)
+	code := strings.TrimPrefix(rec.Body.String(), "http://localhost:8080/")
+
+	tests := []struct {
+		name   string
+		userID string
+		body   string
+		want   int
+	}{
+		{name: "bad body", userID: owner, body: `{`, want: http.StatusBadRequest},
+		{name: "bad url", userID: owner, body: `{"devices":["ios"],"url":"not a url"}`, want: http.StatusBadRequest},
+		{name: "no conditions", userID: owner, body: `{"url":"https://apps.example"}`, want: http.StatusUnprocessableEntity},
+		{name: "not owner", userID: other, body: `{"devices":["ios"],"url":"https://apps.example"}`, want: http.StatusNotFound},
+		{name: "ios", userID: owner, body: `{"devices":["ios"],"url":"https://apps.example"}`, want: http.StatusCreated},
+		{name: "android", userID: owner, body: `{"devices":["android"],"url":"https://play.example"}`, want: http.StatusCreated},
+		{name: "germany", userID: owner, body: `{"countries":["de"],"url":"https://de.example"}`, want: http.StatusCreated},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := do(tt.userID, http.MethodPost, tt.body, s.PostRule, code)
+			require.Equal(t, tt.want, rec.Code)
+		})
+	}
+
+	rec = do(owner, http.MethodGet, "", s.GetRules, code)
+	require.Equal(t, http.StatusOK, rec.Code)
+	var rules []interfaces.Rule
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &rules))
+	require.Len(t, rules, 3)
+	rec = do(other, http.MethodGet, "", s.GetRules, code)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	const iPhone = "Mozilla/5.0 (iPhone; CPU iPhone OS 17_0 like Mac OS X) Mobile/15E148"
+	const android = "Mozilla/5.0 (Linux; Android 14; Pixel 8) Mobile Safari/537.36"
+	const windows = "Mozilla/5.0 (Windows NT 10.0; Win64; x64) Chrome/120.0.0.0"
+	rec = visit(code, iPhone, "en", "192.0.2.7")
+	assert.Equal(t, "https://apps.example", rec.Header().Get("Location"))
+	assert.Contains(t, rec.Header().Get("Vary"), "User-Agent")
+	rec = visit(code, android, "en", "192.0.2.1")
+	assert.Equal(t, "https://play.example", rec.Header().Get("Location"))
+	rec = visit(code, windows, "en", "192.0.2.7")
+	assert.Equal(t, "https://de.example", rec.Header().Get("Location"))
+	rec = visit(code, windows, "en", "192.0.2.1")
+	assert.Equal(t, "https://web.example", rec.Header().Get("Location"))
+
+	// the android rule becomes a german one, the ios rule is removed
+	rec = do(owner, http.MethodPut, `{"languages":["de"],"url":"https://de.example/sprache"}`, s.PutRule, code, rules[1].ID)
+	require.Equal(t, http.StatusOK, rec.Code)
+	rec = do(owner, http.MethodPut, `{"languages":["de"],"url":"https://de.example"}`, s.PutRule, code, "unknown")
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	rec = do(owner, http.MethodDelete, "", s.DelRule, code, rules[0].ID)
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+	rec = do(owner, http.MethodDelete, "", s.DelRule, code, rules[0].ID)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	rec = visit(code, iPhone, "de-DE,en;q=0.5", "192.0.2.1")
+	assert.Equal(t, "https://de.example/sprache", rec.Header().Get("Location"))
+	rec = visit(code, android, "en", "192.0.2.1")
+	assert.Equal(t, "https://web.example", rec.Header().Get("Location"))
+}
+
+// verdicts is a URLChecker of fixed URLs, other URLs are clean.
+type verdicts map[string]interfaces.Verdict
+
+func (v verdicts) CheckURL(_ context.Context, rawURL string) (interfaces.Verdict, error) {
+	return v[rawURL], nil
+}
+
+func TestRoutingRules_Flagged(t *testing.T) {
+	db := storage.NewDBConn()
+	usr := storage.New(testKeys)
+	recordCh := make(chan interfaces.Task, 50)
+	inWorker := workerpool.NewInputWorker(recordCh, make(chan struct{}), context.Background())
+	cfg := config.NewConfig(":8080", "http://localhost:8080", "", "", false)
+	checker := verdicts{"https://apps.example": {Flagged: true, Reason: "phishing"}}
+	s := New(db, cfg, usr, inWorker, WithRouting(routing.New(db)), WithURLChecker(checker, false))
+	owner := "00000000000000000000000000000001"
+
+	do := func(method, body string, handler func(c echo.Context) error, params ...string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/", strings.NewReader(body))
+		value, err := usr.CreateSissionID(owner)
+		require.NoError(t, err)
+		req.AddCookie(&http.Cookie{Name: "cookie", Value: value})
+		rec := httptest.NewRecorder()
+		c := echo.New().NewContext(req, rec)
+		c.SetParamNames("id")
+		c.SetParamValues(params...)
+		require.NoError(t, handler(c))
+		return rec
+	}
+	visit := func(code, userAgent string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/"+code, nil)
+		req.Header.Set("User-Agent", userAgent)
+		rec := httptest.NewRecorder()
+		c := echo.New().NewContext(req, rec)
+		c.SetParamNames("id")
+		c.SetParamValues(code)
+		require.NoError(t, s.GetURL(c))
+		return rec
+	}
+
+	rec := do(http.MethodPost, "https://web.example", s.PostURL)
+	require.Equal(t, http.StatusCreated, rec.Code)
+	code := strings.TrimPrefix(rec.Body.String(), "http://localhost:8080/")
+	rec = do(http.MethodPost, `{"devices":["ios"],"url":"https://apps.example"}`, s.PostRule, code)
+	require.Equal(t, http.StatusCreated, rec.Code)
+
+	// the link is clean, the URL of the rule is not
+	rec = visit(code, "Mozilla/5.0 (iPhone; CPU iPhone OS 17_0 like Mac OS X) Mobile/15E148")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Empty(t, rec.Header().Get("Location"))
+	assert.Contains(t, rec.Body.String(), "https://apps.example")
+	assert.Contains(t, rec.Body.String(), "phishing")
+	rec = visit(code, "Mozilla/5.0 (Windows NT 10.0; Win64; x64) Chrome/120.0.0.0")
+	assert.Equal(t, http.StatusTemporaryRedirect, rec.Code)
+	assert.Equal(t, "https://web.example", rec.Header().Get("Location"))
+}
+
+func TestSplitDestinations(t *testing.T) {
+	db := storage.NewDBConn()
+	usr := storage.New(testKeys)
+	recordCh := make(chan interfaces.Task, 50)
+	inWorker := workerpool.NewInputWorker(recordCh, make(chan struct{}), context.Background())
+	cfg := config.NewConfig(":8080", "http://localhost:8080", "", "", false)
+	s := New(db, cfg, usr, inWorker)
+	owner := "00000000000000000000000000000001"
+
+	do := func(userID, method, body string, handler func(c echo.Context) error, params ...string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/", strings.NewReader(body))
+		value, err := usr.CreateSissionID(userID)
+		require.NoError(t, err)
+		req.AddCookie(&http.Cookie{Name: "cookie", Value: value})
+		rec := httptest.NewRecorder()
+		c := echo.New().NewContext(req, rec)
+		if len(params) > 0 {
+			c.SetParamNames("id")
+			c.SetParamValues(params...)
+		}
+		require.NoError(t, handler(c))
+		return rec
+	}
+
+	rec := do(owner, http.MethodPost, `{"destinations":[{"url":"https://a.example","weight":70}]}`, s.PostJSON)
+	require.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+	rec = do(owner, http.MethodPost, `{"destinations":[{"url":"https://a.example","weight":70},{"url":"https://b.example","weight":30}]}`, s.PostJSON)
+	require.Equal(t, http.StatusCreated, rec.Code)
+	var response struct {
+		Result string `json:"result"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &response))
+	code := strings.TrimPrefix(response.Result, "http://localhost:8080/")
+	link, err := db.GetLink(code)
+	require.NoError(t, err)
+	assert.Equal(t, "https://a.example", link.BaseURL)
+
+	served := make(map[string]int)
+	for i := 0; i < 200; i++ {
+		visitor := fmt.Sprintf("%032x", i+2)
+		location := ""
+		for j := 0; j < 2; j++ {
+			rec = do(visitor, http.MethodGet, "", s.GetURL, code)
+			require.Equal(t, http.StatusTemporaryRedirect, rec.Code)
+			if j > 0 {
+				// a visitor keeps the destination
+				require.Equal(t, location, rec.Header().Get("Location"))
+			}
+			location = rec.Header().Get("Location")
+		}
+		served[location]++
+	}
+	assert.Len(t, served, 2)
+	assert.Greater(t, served["https://a.example"], served["https://b.example"])
+
+	rec = do(owner, http.MethodGet, "", s.GetDestinations, code)
+	require.Equal(t, http.StatusOK, rec.Code)
+	var destinations []interfaces.Destination
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &destinations))
+	require.Len(t, destinations, 2)
+	assert.Equal(t, "a", destinations[0].ID)
+	assert.Equal(t, int64(2*served["https://a.example"]), destinations[0].Served)
+	assert.Equal(t, int64(2*served["https://b.example"]), destinations[1].Served)
+
+	rec = do(owner, http.MethodPut, `[{"id":"b","url":"https://b.example","weight":0},{"url":"https://c.example","weight":1}]`, s.PutDestinations, code)
+	assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+	rec = do("00000000000000000000000000000099", http.MethodPut, `[]`, s.PutDestinations, code)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	rec = do(owner, http.MethodPut, `[]`, s.PutDestinations, code)
+	require.Equal(t, http.StatusOK, rec.Code)
+	rec = do(owner, http.MethodGet, "", s.GetURL, code)
+	assert.Equal(t, "https://a.example", rec.Header().Get("Location"))
+}
+
+func TestSplitDestinations_Flagged(t *testing.T) {
+	db := storage.NewDBConn()
+	usr := storage.New(testKeys)
+	recordCh := make(chan interfaces.Task, 50)
+	inWorker := workerpool.NewInputWorker(recordCh, make(chan struct{}), context.Background())
+	cfg := config.NewConfig(":8080", "http://localhost:8080", "", "", false)
+	checker := verdicts{"https://b.example": {Flagged: true, Reason: "malware"}}
+	s := New(db, cfg, usr, inWorker, WithURLChecker(checker, false))
+	owner := "00000000000000000000000000000001"
+
+	do := func(userID, method, body string, handler func(c echo.Context) error, params ...string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/", strings.NewReader(body))
+		value, err := usr.CreateSissionID(userID)
+		require.NoError(t, err)
+		req.AddCookie(&http.Cookie{Name: "cookie", Value: value})
+		rec := httptest.NewRecorder()
+		c := echo.New().NewContext(req, rec)
+		if len(params) > 0 {
+			c.SetParamNames("id")
+			c.SetParamValues(params...)
+		}
+		require.NoError(t, handler(c))
+		return rec
+	}
+
+	rec := do(owner, http.MethodPost, `{"destinations":[{"url":"https://a.example","weight":50},{"url":"https://b.example","weight":50}]}`, s.PostJSON)
+	require.Equal(t, http.StatusCreated, rec.Code)
+	var response struct {
+		Result string `json:"result"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &response))
+	code := strings.TrimPrefix(response.Result, "http://localhost:8080/")
+
+	warned, redirected := 0, 0
+	for i := 0; i < 50; i++ {
+		rec = do(fmt.Sprintf("%032x", i+2), http.MethodGet, "", s.GetURL, code)
+		switch rec.Code {
+		case http.StatusOK:
+			warned++
+			assert.Empty(t, rec.Header().Get("Location"))
+			assert.Contains(t, rec.Body.String(), "https://b.example")
+		case http.StatusTemporaryRedirect:
+			redirected++
+			assert.Equal(t, "https://a.example", rec.Header().Get("Location"))
+		default:
+			t.Fatalf("unexpected status %d", rec.Code)
+		}
+	}
+	require.Positive(t, warned)
+	require.Positive(t, redirected)
+
+	// visitors warned away from the flagged destination are not counted as served
+	destinations, err := db.GetDestinations(owner, "", code)
+	require.NoError(t, err)
+	require.Len(t, destinations, 2)
+	assert.Equal(t, int64(redirected), destinations[0].Served)
+	assert.Zero(t, destinations[1].Served)
+}
+
+func TestProtectedLinks(t *testing.T) {
+	db := storage.NewDBConn()
+	usr := storage.New(testKeys)
+	recordCh := make(chan interfaces.Task, 50)
+	inWorker := workerpool.NewInputWorker(recordCh, make(chan struct{}), context.Background())
+	cfg := config.NewConfig(":8080", "http://localhost:8080", "", "", false)
+	s := New(db, cfg, usr, inWorker)
+	owner := "00000000000000000000000000000001"
+
+	do := func(method, body string, handler func(c echo.Context) error, params ...string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/", strings.NewReader(body))
+		if strings.HasPrefix(body, "password=") {
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationForm)
+		}
+		value, err := usr.CreateSissionID(owner)
+		require.NoError(t, err)
+		req.AddCookie(&http.Cookie{Name: "cookie", Value: value})
+		rec := httptest.NewRecorder()
+		c := echo.New().NewContext(req, rec)
+		if len(params) > 0 {
+			c.SetParamNames("id")
+			c.SetParamValues(params...)
+		}
+		require.NoError(t, handler(c))
+		return rec
+	}
+	shorten := func(body string) string {
+		rec := do(http.MethodPost, body, s.PostJSON)
+		require.Equal(t, http.StatusCreated, rec.Code)
+		var response struct {
+			Result string `json:"result"`
+		}
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &response))
+		return strings.TrimPrefix(response.Result, "http://localhost:8080/")
+	}
+
+	rec := do(http.MethodPost, `{"url":"https://a.example","password":"abc"}`, s.PostJSON)
+	assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+	rec = do(http.MethodPost, `{"url":"https://a.example","max_visits":-1}`, s.PostJSON)
+	assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+
+	code := shorten(`{"url":"https://a.example","password":"secret"}`)
+	// every protected link is a new one
+	assert.NotEqual(t, code, shorten(`{"url":"https://a.example","password":"secret"}`))
+	rec = do(http.MethodGet, "", s.GetURL, code)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Empty(t, rec.Header().Get("Location"))
+	assert.Contains(t, rec.Body.String(), `type="password"`)
+	rec = do(http.MethodPost, "password=wrong", s.PostUnlock, code)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	assert.Contains(t, rec.Body.String(), "Wrong password")
+	rec = do(http.MethodPost, "password=secret", s.PostUnlock, code)
+	assert.Equal(t, http.StatusSeeOther, rec.Code)
+	assert.Equal(t, "https://a.example", rec.Header().Get("Location"))
+	assert.Equal(t, "no-store", rec.Header().Get("Cache-Control"))
+
+	// the password is removed and the link opens at once
+	rec = do(http.MethodPatch, `{"password":""}`, s.PatchURL, code)
+	require.Equal(t, http.StatusOK, rec.Code)
+	rec = do(http.MethodGet, "", s.GetURL, code)
+	assert.Equal(t, http.StatusTemporaryRedirect, rec.Code)
+
+	code = shorten(`{"url":"https://b.example","max_visits":1}`)
+	rec = do(http.MethodGet, "", s.GetURL, code)
+	assert.Equal(t, http.StatusTemporaryRedirect, rec.Code)
+	rec = do(http.MethodGet, "", s.GetURL, code)
+	assert.Equal(t, http.StatusGone, rec.Code)
+	rec = do(http.MethodPatch, `{"max_visits":1000001}`, s.PatchURL, code)
+	assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+	rec = do(http.MethodPatch, `{"max_visits":20}`, s.PatchURL, code)
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	// concurrent visitors can't get past the limit
+	codes := make(chan int, 50)
+	for i := 0; i < cap(codes); i++ {
+		go func() {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			rec := httptest.NewRecorder()
+			c := echo.New().NewContext(req, rec)
+			c.SetParamNames("id")
+			c.SetParamValues(code)
+			_ = s.GetURL(c)
+			codes <- rec.Code
+		}()
+	}
+	redirected := 0
+	for i := 0; i < cap(codes); i++ {
+		if <-codes == http.StatusTemporaryRedirect {
+			redirected++
+		}
+	}
+	assert.Equal(t, 20, redirected)
+
+	rec = do(http.MethodGet, "", s.GetURLsByUserID)
+	require.Equal(t, http.StatusOK, rec.Code)
+	var models []interfaces.ModelURL
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &models))
+	for _, model := range models {
+		if strings.HasSuffix(model.ShortURL, "/"+code) {
+			assert.Equal(t, int64(20), model.MaxVisits)
+			assert.Equal(t, int64(20), model.Visits)
+		}
+	}
+}
+
+func TestLinkPreview(t *testing.T) {
+	db := storage.NewDBConn()
+	usr := storage.New(testKeys)
+	recordCh := make(chan interfaces.Task, 50)
+	inWorker := workerpool.NewInputWorker(recordCh, make(chan struct{}), context.Background())
+	cfg := config.NewConfig(":8080", "http://localhost:8080", "", "", false)
+	s := New(db, cfg, usr, inWorker)
+	owner := "00000000000000000000000000000001"
+
+	shorten := func(body string) string {
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+		value, err := usr.CreateSissionID(owner)
+		require.NoError(t, err)
+		req.AddCookie(&http.Cookie{Name: "cookie", Value: value})
+		rec := httptest.NewRecorder()
+		require.NoError(t, s.PostJSON(echo.New().NewContext(req, rec)))
+		require.Equal(t, http.StatusCreated, rec.Code)
+		var response struct {
+			Result string `json:"result"`
+		}
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &response))
+		return strings.TrimPrefix(response.Result, "http://localhost:8080/")
+	}
+	get := func(accept string, handler func(c echo.Context) error, id string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set(echo.HeaderAccept, accept)
+		rec := httptest.NewRecorder()
+		c := echo.New().NewContext(req, rec)
+		c.SetParamNames("id")
+		c.SetParamValues(id)
+		require.NoError(t, handler(c))
+		return rec
+	}
+	preview := func(id string) map[string]interface{} {
+		rec := get("application/json", s.GetLinkPreview, id)
+		require.Equal(t, http.StatusOK, rec.Code)
+		var response map[string]interface{}
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &response))
+		return response
+	}
+
+	code := shorten(`{"url":"https://a.example"}`)
+	rec := get("application/json", s.GetURL, code+"+")
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Empty(t, rec.Header().Get("Location"))
+	var response map[string]interface{}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &response))
+	assert.Equal(t, "active", response["status"])
+	assert.Equal(t, "https://a.example", response["original_url"])
+	assert.Equal(t, "http://localhost:8080/"+code, response["short_url"])
+	assert.NotEmpty(t, response["created_at"])
+	assert.Equal(t, response, preview(code))
+
+	rec = get("text/html,application/xhtml+xml", s.GetURL, code+"+")
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Header().Get(echo.HeaderContentType), echo.MIMETextHTML)
+	assert.Contains(t, rec.Body.String(), "https://a.example")
+
+	// the preview doesn't give away protected links or use their visits
+	code = shorten(`{"url":"https://b.example","password":"secret","max_visits":1}`)
+	response = preview(code)
+	assert.Equal(t, "active", response["status"])
+	assert.Nil(t, response["original_url"])
+	assert.Equal(t, true, response["password_protected"])
+	assert.Equal(t, true, response["limited"])
+	rec = get("text/html", s.GetURL, code+"+")
+	assert.NotContains(t, rec.Body.String(), "https://b.example")
+
+	code = shorten(`{"url":"https://c.example","max_visits":1}`)
+	rec = get("", s.GetURL, code)
+	require.Equal(t, http.StatusTemporaryRedirect, rec.Code)
+	response = preview(code)
+	assert.Equal(t, "expired", response["status"])
+	assert.Nil(t, response["original_url"])
+
+	code = shorten(`{"url":"https://d.example"}`)
+	require.NoError(t, db.DelBatchShortURLs([]interfaces.Task{{ID: owner, ShortURL: code}}))
+	assert.Equal(t, "deleted", preview(code)["status"])
+
+	rec = get("application/json", s.GetLinkPreview, "missing")
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+func TestPageMeta(t *testing.T) {
+	page := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprint(w, `<head><title>Example page</title><meta name="description" content="About the page"></head>`)
+	}))
+	defer page.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	db := storage.NewDBConn()
+	usr := storage.New(testKeys)
+	recordCh := make(chan interfaces.Task, 50)
+	inWorker := workerpool.NewInputWorker(recordCh, make(chan struct{}), context.Background())
+	cfg := config.NewConfig(":8080", "http://localhost:8080", "", "", false)
+	fetcher := pagemeta.New(ctx, db, pagemeta.Settings{Timeout: time.Second, MaxBytes: 4096})
+	go fetcher.Loop()
+	s := New(db, cfg, usr, inWorker, WithMetaFetcher(fetcher))
+	owner := "00000000000000000000000000000001"
+
+	do := func(method, body string, handler func(c echo.Context) error, params ...string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/", strings.NewReader(body))
+		value, err := usr.CreateSissionID(owner)
+		require.NoError(t, err)
+		req.AddCookie(&http.Cookie{Name: "cookie", Value: value})
+		rec := httptest.NewRecorder()
+		c := echo.New().NewContext(req, rec)
+		if len(params) > 0 {
+			c.SetParamNames("id")
+			c.SetParamValues(params...)
+		}
+		require.NoError(t, handler(c))
+		return rec
+	}
+
+	rec := do(http.MethodPost, page.URL+"/post", s.PostURL)
+	require.Equal(t, http.StatusCreated, rec.Code)
+	code := strings.TrimPrefix(rec.Body.String(), "http://localhost:8080/")
+
+	var models []interfaces.ModelURL
+	require.Eventually(t, func() bool {
+		rec := do(http.MethodGet, "", s.GetURLsByUserID)
+		require.Equal(t, http.StatusOK, rec.Code)
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &models))
+		return len(models) == 1 && models[0].Meta != nil
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, "Example page", models[0].Meta.Title)
+	assert.Equal(t, "About the page", models[0].Meta.Description)
+	assert.Equal(t, page.URL+"/favicon.ico", models[0].Meta.FaviconURL)
+
+	rec = do(http.MethodGet, "", s.GetLinkPreview, code)
+	require.Equal(t, http.StatusOK, rec.Code)
+	var preview struct {
+		Meta *interfaces.PageMeta `json:"meta"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &preview))
+	require.NotNil(t, preview.Meta)
+	assert.Equal(t, "Example page", preview.Meta.Title)
+}
+
+func TestQRCode(t *testing.T) {
+	db := storage.NewDBConn()
+	usr := storage.New(testKeys)
+	recordCh := make(chan interfaces.Task, 50)
+	inWorker := workerpool.NewInputWorker(recordCh, make(chan struct{}), context.Background())
+	cfg := config.NewConfig(":8080", "http://localhost:8080", "", "", false)
+	s := New(db, cfg, usr, inWorker)
+	owner := "00000000000000000000000000000001"
+	value, err := usr.CreateSissionID(owner)
+	require.NoError(t, err)
+
+	post := func(handler func(c echo.Context) error, target, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, target, strings.NewReader(body))
+		req.AddCookie(&http.Cookie{Name: "cookie", Value: value})
+		rec := httptest.NewRecorder()
+		require.NoError(t, handler(echo.New().NewContext(req, rec)))
+		return rec
+	}
+	get := func(id, query string, etag ...string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/"+id+"/qr"+query, nil)
+		for _, tag := range etag {
+			req.Header.Set("If-None-Match", tag)
+		}
+		rec := httptest.NewRecorder()
+		c := echo.New().NewContext(req, rec)
+		c.SetParamNames("id")
+		c.SetParamValues(id)
+		require.NoError(t, s.GetQR(c))
+		return rec
+	}
+
+	rec := post(s.PostJSON, "/api/shorten?qr=svg&qr_size=128", `{"url":"https://a.example"}`)
+	require.Equal(t, http.StatusCreated, rec.Code)
+	var response struct {
+		Result string `json:"result"`
+		QR     string `json:"qr"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &response))
+	require.True(t, strings.HasPrefix(response.QR, "data:image/svg+xml;base64,"))
+	svg, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(response.QR, "data:image/svg+xml;base64,"))
+	require.NoError(t, err)
+	assert.Contains(t, string(svg), `width="128"`)
+	code := strings.TrimPrefix(response.Result, "http://localhost:8080/")
+
+	rec = get(code, "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "image/png", rec.Header().Get(echo.HeaderContentType))
+	assert.Equal(t, "no-cache", rec.Header().Get("Cache-Control"))
+	etag := rec.Header().Get("ETag")
+	require.NotEmpty(t, etag)
+	img, err := png.Decode(rec.Body)
+	require.NoError(t, err)
+	assert.LessOrEqual(t, img.Bounds().Dx(), 256)
+	assert.Greater(t, img.Bounds().Dx(), 128)
+
+	rec = get(code, "", etag)
+	assert.Equal(t, http.StatusNotModified, rec.Code)
+	assert.Empty(t, rec.Body.Bytes())
+
+	rec = get(code, "?format=svg&size=64&margin=0&ec=H", etag)
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "image/svg+xml", rec.Header().Get(echo.HeaderContentType))
+	assert.Contains(t, rec.Body.String(), `width="64"`)
+
+	for _, query := range []string{"?format=gif", "?size=10", "?size=big", "?margin=-1", "?ec=Z"} {
+		assert.Equal(t, http.StatusBadRequest, get(code, query).Code, query)
+	}
+	assert.Equal(t, http.StatusNotFound, get("missing", "").Code)
+	require.NoError(t, db.DelBatchShortURLs([]interfaces.Task{{ID: owner, ShortURL: code}}))
+	assert.Equal(t, http.StatusGone, get(code, "").Code)
+	assert.Equal(t, http.StatusGone, get(code, "", etag).Code)
+
+	rec = post(s.PostBatch, "/api/shorten/batch?qr=png", `[{"correlation_id":"1","original_url":"https://b.example"},{"correlation_id":"2","original_url":"https://c.example"}]`)
+	require.Equal(t, http.StatusCreated, rec.Code)
+	var batch []interfaces.BatchResponse
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &batch))
+	require.Len(t, batch, 2)
+	for _, res := range batch {
+		assert.True(t, strings.HasPrefix(res.QR, "data:image/png;base64,"))
+	}
+
+	rec = post(s.PostJSON, "/api/shorten?qr=jpeg", `{"url":"https://d.example"}`)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	rec = post(s.PostJSON, "/api/shorten", `{"url":"https://e.example"}`)
+	assert.NotContains(t, rec.Body.String(), `"qr"`)
+}
+
+func TestDomains(t *testing.T) {
+	db := storage.NewDBConn()
+	usr := storage.New(testKeys)
+	recordCh := make(chan interfaces.Task, 50)
+	inWorker := workerpool.NewInputWorker(recordCh, make(chan struct{}), context.Background())
+	cfg := config.NewConfig(":8080", "http://localhost:8080", "", "", false)
+	cfg.Domains = []string{"https://go.example.com"}
+	s := New(db, cfg, usr, inWorker)
+	owner := "00000000000000000000000000000001"
+
+	do := func(host, method, target, body string, handler func(c echo.Context) error, params ...string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		req.Host = host
+		value, err := usr.CreateSissionID(owner)
+		require.NoError(t, err)
+		req.AddCookie(&http.Cookie{Name: "cookie", Value: value})
+		rec := httptest.NewRecorder()
+		c := echo.New().NewContext(req, rec)
+		if len(params) > 0 {
+			c.SetParamNames("id")
+			c.SetParamValues(params...)
+		}
+		require.NoError(t, handler(c))
+		return rec
+	}
+
+	// the same URL gets the same code in every domain
+	rec := do("localhost:8080", http.MethodPost, "/?domain=go.example.com", "https://a.example", s.PostURL)
+	require.Equal(t, http.StatusCreated, rec.Code)
+	require.True(t, strings.HasPrefix(rec.Body.String(), "https://go.example.com/"))
+	code := strings.TrimPrefix(rec.Body.String(), "https://go.example.com/")
+	rec = do("localhost:8080", http.MethodPost, "/", "https://a.example", s.PostURL)
+	require.Equal(t, http.StatusCreated, rec.Code)
+	assert.Equal(t, "http://localhost:8080/"+code, rec.Body.String())
+	// requests sent to a domain create links in it
+	rec = do("go.example.com", http.MethodPost, "/", "https://a.example", s.PostURL)
+	assert.Equal(t, http.StatusConflict, rec.Code)
+	assert.Equal(t, "https://go.example.com/"+code, rec.Body.String())
+	rec = do("localhost:8080", http.MethodPost, "/?domain=other.example", "https://a.example", s.PostURL)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	// codes are changed in one domain only
+	rec = do("localhost:8080", http.MethodPatch, "/api/user/urls/"+code+"?domain=go.example.com", `{"url":"https://b.example"}`, s.PatchURL, code)
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), "https://go.example.com/"+code)
+	for host, want := range map[string]string{
+		"go.example.com": "https://b.example",
+		"GO.EXAMPLE.COM": "https://b.example",
+		"localhost:8080": "https://a.example",
+		// hosts other than the domains open the primary one
+		"10.0.0.1:8080": "https://a.example",
+	} {
+		rec = do(host, http.MethodGet, "/"+code, "", s.GetURL, code)
+		require.Equal(t, http.StatusTemporaryRedirect, rec.Code, host)
+		assert.Equal(t, want, rec.Header().Get("Location"), host)
+	}
+	rec = do("localhost:8080", http.MethodGet, "/", "", s.GetURL, "go.example.com/"+code)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec = do("localhost:8080", http.MethodGet, "/api/links/"+code+"?domain=go.example.com", "", s.GetLinkPreview, code)
+	require.Equal(t, http.StatusOK, rec.Code)
+	var preview linkPreview
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &preview))
+	assert.Equal(t, "https://go.example.com/"+code, preview.ShortURL)
+	assert.Equal(t, "https://b.example", preview.BaseURL)
+
+	rec = do("localhost:8080", http.MethodGet, "/api/user/urls", "", s.GetURLsByUserID)
+	require.Equal(t, http.StatusOK, rec.Code)
+	var links []interfaces.ModelURL
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &links))
+	short := make([]string, 0, len(links))
+	for _, link := range links {
+		short = append(short, link.ShortURL)
+	}
+	assert.ElementsMatch(t, []string{"http://localhost:8080/" + code, "https://go.example.com/" + code}, short)
+}
+
+func TestAnnotations(t *testing.T) {
+	db := storage.NewDBConn()
+	usr := storage.New(testKeys)
+	recordCh := make(chan interfaces.Task, 50)
+	inWorker := workerpool.NewInputWorker(recordCh, make(chan struct{}), context.Background())
+	cfg := config.NewConfig(":8080", "http://localhost:8080", "", "", false)
+	s := New(db, cfg, usr, inWorker)
+	owner := "00000000000000000000000000000001"
+	other := "00000000000000000000000000000002"
+
+	do := func(userID, method, target, body string, handler func(c echo.Context) error, params ...string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		value, err := usr.CreateSissionID(userID)
+		require.NoError(t, err)
+		req.AddCookie(&http.Cookie{Name: "cookie", Value: value})
+		rec := httptest.NewRecorder()
+		c := echo.New().NewContext(req, rec)
+		if len(params) > 0 {
+			c.SetParamNames("id")
+			c.SetParamValues(params...)
+		}
+		require.NoError(t, handler(c))
+		return rec
+	}
+	shorten := func(userID, body string) string {
+		rec := do(userID, http.MethodPost, "/api/shorten", body, s.PostJSON)
+		require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+		var response struct {
+			Result string `json:"result"`
+		}
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &response))
+		return strings.TrimPrefix(response.Result, "http://localhost:8080/")
+	}
+	list := func(userID, query string) []interfaces.ModelURL {
+		rec := do(userID, http.MethodGet, "/api/user/urls"+query, "", s.GetURLsByUserID)
+		require.Equal(t, http.StatusOK, rec.Code)
+		var models []interfaces.ModelURL
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &models))
+		return models
+	}
+
+	post := shorten(owner, `{"url":"https://blog.example/launch","title":"Launch post","notes":"for the newsletter","tags":["Blog","news"]}`)
+	video := shorten(owner, `{"url":"https://video.example","tags":["news"]}`)
+	shorten(owner, `{"url":"https://plain.example"}`)
+	rec := do(owner, http.MethodPost, "/api/shorten", `{"url":"https://bad.example","tags":["two words"]}`, s.PostJSON)
+	assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+
+	rec = do(owner, http.MethodPost, "/api/shorten/batch", `[{"correlation_id":"1","original_url":"https://batch.example","title":"From a batch","tags":["batch"]}]`, s.PostBatch)
+	require.Equal(t, http.StatusCreated, rec.Code)
+
+	assert.Len(t, list(owner, ""), 4)
+	models := list(owner, "?tag=blog")
+	require.Len(t, models, 1)
+	assert.Equal(t, "http://localhost:8080/"+post, models[0].ShortURL)
+	assert.Equal(t, interfaces.Annotation{Title: "Launch post", Notes: "for the newsletter", Tags: []string{"blog", "news"}}, models[0].Annotation)
+	assert.Len(t, list(owner, "?tag=NEWS"), 2)
+	assert.Len(t, list(owner, "?tag=news&tag=blog"), 1)
+	assert.Len(t, list(owner, "?q=launch"), 1)
+	assert.Len(t, list(owner, "?q=batch"), 1)
+	assert.Len(t, list(owner, "?q=VIDEO.example&tag=news"), 1)
+	assert.Empty(t, list(owner, "?tag=missing"))
+	rec = do(owner, http.MethodGet, "/api/user/urls?tag=a+b", "", s.GetURLsByUserID)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	tests := []struct {
+		name   string
+		userID string
+		code   string
+		body   string
+		want   int
+	}{
+		{name: "bad body", userID: owner, code: video, body: `{`, want: http.StatusBadRequest},
+		{name: "bad title", userID: owner, code: video, body: `{"title":"one\ntwo"}`, want: http.StatusUnprocessableEntity},
+		{name: "not owner", userID: other, code: video, body: `{"title":"Mine"}`, want: http.StatusNotFound},
+		{name: "ok", userID: owner, code: video, body: `{"title":"Launch video","tags":["video","news"]}`, want: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := do(tt.userID, http.MethodPut, "/api/user/urls/"+tt.code+"/annotation", tt.body, s.PutAnnotation, tt.code)
+			require.Equal(t, tt.want, rec.Code)
+		})
+	}
+	assert.Len(t, list(owner, "?q=launch"), 2)
+	assert.Len(t, list(owner, "?tag=video"), 1)
+
+	// users sharing a link annotate it each in their own way
+	assert.Equal(t, video, shorten(other, `{"url":"https://video.example","title":"Watch later"}`))
+	models = list(other, "?q=later")
+	require.Len(t, models, 1)
+	assert.Empty(t, models[0].Tags)
+	models = list(owner, "?tag=video")
+	require.Len(t, models, 1)
+	assert.Equal(t, "Launch video", models[0].Title)
+
+	// an empty annotation clears it
+	rec = do(owner, http.MethodPut, "/api/user/urls/"+video+"/annotation", `{}`, s.PutAnnotation, video)
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Empty(t, list(owner, "?tag=video"))
+}
+
+func TestSearch(t *testing.T) {
+	db := storage.NewDBConn()
+	usr := storage.New(testKeys)
+	recordCh := make(chan interfaces.Task, 50)
+	inWorker := workerpool.NewInputWorker(recordCh, make(chan struct{}), context.Background())
+	cfg := config.NewConfig(":8080", "http://localhost:8080", "", "", false)
+	s := New(db, cfg, usr, inWorker)
+	owner := "00000000000000000000000000000001"
+	other := "00000000000000000000000000000002"
+
+	do := func(userID, target string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		value, err := usr.CreateSissionID(userID)
+		require.NoError(t, err)
+		req.AddCookie(&http.Cookie{Name: "cookie", Value: value})
+		rec := httptest.NewRecorder()
+		require.NoError(t, s.GetSearch(echo.New().NewContext(req, rec)))
+		return rec
+	}
+	shorten := func(userID, body string) string {
+		req := httptest.NewRequest(http.MethodPost, "/api/shorten", strings.NewReader(body))
+		value, err := usr.CreateSissionID(userID)
+		require.NoError(t, err)
+		req.AddCookie(&http.Cookie{Name: "cookie", Value: value})
+		rec := httptest.NewRecorder()
+		require.NoError(t, s.PostJSON(echo.New().NewContext(req, rec)))
+		require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+		var response struct {
+			Result string `json:"result"`
+		}
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &response))
+		return response.Result
+	}
+	type page struct {
+		Total  int                    `json:"total"`
+		Offset int                    `json:"offset"`
+		Limit  int                    `json:"limit"`
+		Items  []interfaces.SearchHit `json:"items"`
+	}
+	search := func(userID, query string) page {
+		rec := do(userID, "/api/user/urls/search"+query)
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		var result page
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &result))
+		return result
+	}
+
+	docs := shorten(owner, `{"url":"https://go.dev/doc/effective_go","title":"Effective Go","tags":["docs"]}`)
+	blog := shorten(owner, `{"url":"https://blog.example/golang-generics","notes":"effective use of generics"}`)
+	shorten(owner, `{"url":"https://video.example/watch","title":"Conference talk"}`)
+	shorten(other, `{"url":"https://effective.example"}`)
+
+	result := search(owner, "?q=Effective")
+	assert.Equal(t, 2, result.Total)
+	assert.Equal(t, 20, result.Limit)
+	require.Len(t, result.Items, 2)
+	assert.Equal(t, docs, result.Items[0].ShortURL)
+	assert.Equal(t, "Effective Go", result.Items[0].Title)
+	assert.Equal(t, []string{"docs"}, result.Items[0].Tags)
+	assert.Equal(t, blog, result.Items[1].ShortURL)
+	assert.Greater(t, result.Items[0].Score, result.Items[1].Score)
+
+	// typos and prefixes
+	result = search(owner, "?q=efective")
+	require.Len(t, result.Items, 2)
+	assert.Equal(t, docs, result.Items[0].ShortURL)
+	result = search(owner, "?q=golang+generi")
+	require.Len(t, result.Items, 1)
+	assert.Equal(t, blog, result.Items[0].ShortURL)
+	// domains are searched by their words
+	result = search(owner, "?q=blog.example")
+	require.Len(t, result.Items, 2)
+	assert.Equal(t, blog, result.Items[0].ShortURL)
+
+	result = search(owner, "?q=example&offset=1&limit=1")
+	assert.Equal(t, 2, result.Total)
+	assert.Equal(t, 1, result.Offset)
+	assert.Len(t, result.Items, 1)
+	assert.Empty(t, search(owner, "?q=missing").Items)
+	assert.Equal(t, 1, search(other, "?q=effective").Total)
+
+	for _, query := range []string{"", "?q=", "?q=+-+", "?q=go&limit=0", "?q=go&limit=101", "?q=go&offset=-1", "?q=go&offset=x",
+		"?q=" + strings.Repeat("a+", 11), "?q=" + strings.Repeat("a", 201)} {
+		assert.Equal(t, http.StatusBadRequest, do(owner, "/api/user/urls/search"+query).Code, query)
+	}
+}
+
+func TestWebhooks(t *testing.T) {
+	var mu sync.Mutex
+	var events []webhooks.Payload
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload webhooks.Payload
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		mu.Lock()
+		events = append(events, payload)
+		mu.Unlock()
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer receiver.Close()
+	received := func() []webhooks.Payload {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]webhooks.Payload(nil), events...)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	db := storage.NewDBConn()
+	usr := storage.New(testKeys)
+	recordCh := make(chan interfaces.Task, 50)
+	doneCh := make(chan struct{})
+	inWorker := workerpool.NewInputWorker(recordCh, doneCh, ctx)
+	cfg := config.NewConfig(":8080", "http://localhost:8080", "", "", false)
+	hooks := webhooks.New(ctx, db, webhooks.Settings{Timeout: 5 * time.Second, MaxAttempts: 3, Config: cfg})
+	outWorker := workerpool.NewOutputWorker(1, recordCh, doneCh, ctx, db)
+	go outWorker.Do()
+	go hooks.CountLoop()
+	relay := changes.New(ctx, db, "webhooks", hooks, changes.Settings{Config: cfg})
+	s := New(db, cfg, usr, inWorker, WithWebhooks(hooks))
+	owner := "00000000000000000000000000000001"
+
+	do := func(method, target, body string, handler echo.HandlerFunc, params ...string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		value, err := usr.CreateSissionID(owner)
+		require.NoError(t, err)
+		req.AddCookie(&http.Cookie{Name: "cookie", Value: value})
+		rec := httptest.NewRecorder()
+		c := echo.New().NewContext(req, rec)
+		if len(params) > 0 {
+			c.SetParamNames("id")
+			c.SetParamValues(params...)
+		}
+		require.NoError(t, handler(c))
+		return rec
+	}
+
+	rec := do(http.MethodPost, "/api/user/webhooks", `{"url":"ftp://hooks.example","events":["link.created"]}`, s.PostWebhook)
+	assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+	assert.Contains(t, rec.Body.String(), webhooks.CodeInvalidURL)
+	rec = do(http.MethodPost, "/api/user/webhooks", `{"url":`, s.PostWebhook)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	rec = do(http.MethodPost, "/api/user/webhooks",
+		`{"url":"`+receiver.URL+`","events":["link.created","link.deleted","link.expired"]}`, s.PostWebhook)
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+	var webhook interfaces.Webhook
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &webhook))
+	assert.NotEmpty(t, webhook.Secret)
+	assert.Equal(t, owner, webhook.UserID)
+
+	rec = do(http.MethodGet, "/api/user/webhooks", "", s.GetWebhooks)
+	require.Equal(t, http.StatusOK, rec.Code)
+	var list []interfaces.Webhook
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &list))
+	require.Len(t, list, 1)
+	assert.Equal(t, webhook.ID, list[0].ID)
+	assert.Empty(t, list[0].Secret)
+
+	rec = do(http.MethodPost, "/api/shorten", `{"url":"https://a.example","max_visits":1}`, s.PostJSON)
+	require.Equal(t, http.StatusCreated, rec.Code)
+	var response struct {
+		Result string `json:"result"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &response))
+	code := strings.TrimPrefix(response.Result, "http://localhost:8080/")
+	// the created link is told from the change log
+	_, err := relay.Publish()
+	require.NoError(t, err)
+	rec = do(http.MethodGet, "/"+code, "", s.GetURL, code)
+	require.Equal(t, http.StatusTemporaryRedirect, rec.Code)
+	hooks.Dispatch()
+	got := received()
+	require.Len(t, got, 2)
+	assert.Equal(t, interfaces.EventLinkCreated, got[0].Event)
+	assert.Equal(t, webhooks.Data{ShortURL: response.Result, BaseURL: "https://a.example"}, got[0].Data)
+	assert.Equal(t, interfaces.EventLinkExpired, got[1].Event)
+	assert.Equal(t, response.Result, got[1].Data.ShortURL)
+
+	// deletions are told once the worker has made them, links the user doesn't have are skipped
+	rec = do(http.MethodPost, "/api/shorten", `{"url":"https://b.example"}`, s.PostJSON)
+	require.Equal(t, http.StatusCreated, rec.Code)
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &response))
+	rec = do(http.MethodDelete, "/api/user/urls", `["missing","`+strings.TrimPrefix(response.Result, "http://localhost:8080/")+`"]`, s.DelURLsBATCH)
+	require.Equal(t, http.StatusAccepted, rec.Code)
+	doneCh <- struct{}{}
+	require.Eventually(t, func() bool {
+		if _, err := relay.Publish(); err != nil {
+			return false
+		}
+		hooks.Dispatch()
+		return len(received()) == 4
+	}, 5*time.Second, 10*time.Millisecond)
+	got = received()
+	assert.Equal(t, interfaces.EventLinkDeleted, got[3].Event)
+	assert.Equal(t, response.Result, got[3].Data.ShortURL)
+
+	rec = do(http.MethodGet, "/api/user/webhooks/"+webhook.ID+"/deliveries?limit=2", "", s.GetWebhookDeliveries, webhook.ID)
+	require.Equal(t, http.StatusOK, rec.Code)
+	var deliveries []interfaces.WebhookDelivery
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &deliveries))
+	require.Len(t, deliveries, 2)
+	assert.Equal(t, interfaces.EventLinkDeleted, deliveries[0].Event)
+	assert.Equal(t, interfaces.DeliveryDelivered, deliveries[0].Status)
+	assert.Equal(t, http.StatusOK, deliveries[0].ResponseStatus)
+	rec = do(http.MethodGet, "/api/user/webhooks/"+webhook.ID+"/deliveries?limit=0", "", s.GetWebhookDeliveries, webhook.ID)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec = do(http.MethodDelete, "/api/user/webhooks/"+webhook.ID, "", s.DelWebhook, webhook.ID)
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+	rec = do(http.MethodDelete, "/api/user/webhooks/"+webhook.ID, "", s.DelWebhook, webhook.ID)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	rec = do(http.MethodGet, "/api/user/webhooks/"+webhook.ID+"/deliveries", "", s.GetWebhookDeliveries, webhook.ID)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	// without webhooks configured there is nothing to manage
+	s = New(db, cfg, usr, inWorker)
+	rec = do(http.MethodGet, "/api/user/webhooks", "", s.GetWebhooks)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+func TestEvents(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	db := storage.NewDBConn()
+	usr := storage.New(testKeys)
+	recordCh := make(chan interfaces.Task, 50)
+	doneCh := make(chan struct{})
+	inWorker := workerpool.NewInputWorker(recordCh, doneCh, ctx)
+	cfg := config.NewConfig(":8080", "http://localhost:8080", "", "", false)
+	s := New(db, cfg, usr, inWorker, WithWorkspaces(workspaces.New(db)))
+	owner := "00000000000000000000000000000001"
+	other := "00000000000000000000000000000002"
+	require.NoError(t, db.CreateWorkspace(interfaces.Workspace{ID: "ws", Name: "team"}, owner))
+
+	require.NoError(t, db.SetShortURL(owner, "a", "https://a.example"))
+	require.NoError(t, db.SetShortURL(other, "b", "https://b.example"))
+	require.NoError(t, db.SetWorkspaceURL("ws", owner, "c", "https://c.example"))
+	require.NoError(t, db.SetShortURL(owner, "d", "https://d.example"))
+	require.NoError(t, db.DelBatchShortURLs([]interfaces.Task{{ID: owner, ShortURL: "a"}}))
+
+	do := func(ctx context.Context, target string, header map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, target, nil).WithContext(ctx)
+		value, err := usr.CreateSissionID(owner)
+		require.NoError(t, err)
+		req.AddCookie(&http.Cookie{Name: "cookie", Value: value})
+		for name, value := range header {
+			req.Header.Set(name, value)
+		}
+		rec := httptest.NewRecorder()
+		require.NoError(t, s.GetEvents(echo.New().NewContext(req, rec)))
+		return rec
+	}
+	page := func(rec *httptest.ResponseRecorder) eventsPage {
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		var page eventsPage
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &page))
+		return page
+	}
+
+	// links of other users and of workspaces are not listed
+	events := page(do(ctx, "/api/events", nil))
+	require.Len(t, events.Events, 3)
+	assert.Equal(t, "http://localhost:8080/a", events.Events[0].ShortURL)
+	assert.Equal(t, interfaces.EventLinkCreated, events.Events[0].Type)
+	assert.Equal(t, "http://localhost:8080/d", events.Events[1].ShortURL)
+	assert.Equal(t, interfaces.EventLinkDeleted, events.Events[2].Type)
+	assert.Equal(t, int64(5), events.Next)
+
+	events = page(do(ctx, "/api/events?limit=1", nil))
+	require.Len(t, events.Events, 1)
+	assert.Equal(t, int64(1), events.Next)
+	events = page(do(ctx, "/api/events?limit=1&after=1", nil))
+	require.Len(t, events.Events, 1)
+	assert.Equal(t, int64(4), events.Next)
+
+	events = page(do(ctx, "/api/events", map[string]string{workspaceHeader: "ws"}))
+	require.Len(t, events.Events, 1)
+	assert.Equal(t, "http://localhost:8080/c", events.Events[0].ShortURL)
+	assert.Equal(t, "ws", events.Events[0].WorkspaceID)
+
+	// nothing new, the position stays
+	events = page(do(ctx, "/api/events?after=5", nil))
+	assert.Empty(t, events.Events)
+	assert.Equal(t, int64(5), events.Next)
+
+	for _, target := range []string{"/api/events?after=-1", "/api/events?limit=0", "/api/events?limit=x", "/api/events?wait=61"} {
+		assert.Equal(t, http.StatusBadRequest, do(ctx, target, nil).Code, target)
+	}
+
+	// a waiting request gets a change made meanwhile
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		assert.NoError(t, db.SetShortURL(owner, "e", "https://e.example"))
+	}()
+	events = page(do(ctx, "/api/events?after=5&wait=5", nil))
+	require.Len(t, events.Events, 1)
+	assert.Equal(t, "http://localhost:8080/e", events.Events[0].ShortURL)
+	assert.Equal(t, int64(6), events.Next)
+
+	// a reconnected stream resumes after the last event
+	streamCtx, stop := context.WithTimeout(ctx, 300*time.Millisecond)
+	defer stop()
+	rec := do(streamCtx, "/api/events", map[string]string{echo.HeaderAccept: mimeEventStream, "Last-Event-ID": "4"})
+	assert.Equal(t, mimeEventStream, rec.Header().Get(echo.HeaderContentType))
+	body := rec.Body.String()
+	assert.True(t, strings.HasPrefix(body, "retry: "), body)
+	assert.Contains(t, body, "id: 5\nevent: link.deleted\ndata: ")
+	assert.Contains(t, body, "id: 6\nevent: link.created\ndata: ")
+	assert.NotContains(t, body, "id: 4\n")
+}
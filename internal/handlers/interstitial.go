@@ -0,0 +1,42 @@
+package handlers
+
+import (
+	"bytes"
+	"html/template"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/ivanmyagkov/shortener.git/internal/interfaces"
+)
+
+//	interstitialPage is shown instead of a redirect to a flagged URL.
+var interstitialPage = template.Must(template.New("interstitial").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="robots" content="noindex">
+<title>Warning: suspicious link</title>
+</head>
+<body>
+<h1>This link may be unsafe</h1>
+<p>The link leads to <code>{{.URL}}</code>, which has been flagged{{if .Reason}} as <strong>{{.Reason}}</strong>{{end}}.</p>
+<p>Visiting it may harm your device or steal your personal data.</p>
+<p><a href="{{.URL}}" rel="noopener noreferrer nofollow">Continue to the site anyway</a></p>
+</body>
+</html>
+`))
+
+//	interstitial Rendering the warning page for a flagged URL the link leads to.
+func interstitial(c echo.Context, URL string, verdict interfaces.Verdict) error {
+	var buf bytes.Buffer
+	data := struct {
+		URL string
+		interfaces.Verdict
+	}{URL, verdict}
+	if err := interstitialPage.Execute(&buf, data); err != nil {
+		return c.NoContent(http.StatusInternalServerError)
+	}
+	c.Response().Header().Set("Cache-Control", "no-store")
+	return c.HTMLBlob(http.StatusOK, buf.Bytes())
+}
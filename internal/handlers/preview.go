@@ -0,0 +1,133 @@
+package handlers
+
+import (
+	"bytes"
+	"errors"
+	"html/template"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/ivanmyagkov/shortener.git/internal/interfaces"
+)
+
+//	Statuses of previewed links.
+const (
+	statusActive  = "active"
+	statusDeleted = "deleted"
+	statusExpired = "expired"
+)
+
+//	previewSuffix opens the preview of a short URL instead of the redirect.
+const previewSuffix = "+"
+
+//	linkPreview tells where a short URL goes without following it.
+//	The original URL of a link with a password or a limit of visits is kept secret.
+type linkPreview struct {
+	ShortURL string `json:"short_url"`
+	Status   string `json:"status"`
+	BaseURL  string `json:"original_url,omitempty"`
+	// missing for links created before creation times were kept
+	CreatedAt *time.Time `json:"created_at,omitempty"`
+	Flagged   bool       `json:"flagged,omitempty"`
+	Reason    string     `json:"reason,omitempty"`
+	// visitors may be sent elsewhere by rules or a split
+	Varies            bool `json:"varies,omitempty"`
+	PasswordProtected bool `json:"password_protected,omitempty"`
+	Limited           bool `json:"limited,omitempty"`
+	// what the page of the original URL tells about itself, kept secret like the URL
+	Meta *interfaces.PageMeta `json:"meta,omitempty"`
+}
+
+//	previewPage is the preview of a short URL for browsers.
+var previewPage = template.Must(template.New("preview").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="robots" content="noindex">
+<title>Where does {{.ShortURL}} go?</title>
+</head>
+<body>
+<h1>{{.ShortURL}}</h1>
+{{if eq .Status "active"}}{{with .Meta}}<p>{{if .FaviconURL}}<img src="{{.FaviconURL}}" alt="" width="16" height="16"> {{end}}<strong>{{if .Title}}{{.Title}}{{else}}{{.SiteName}}{{end}}</strong></p>
+{{if .Description}}<p>{{.Description}}</p>
+{{end}}{{end}}<dl>
+<dt>Leads to</dt>
+<dd>{{if .BaseURL}}<code>{{.BaseURL}}</code>{{else}}A hidden URL{{end}}{{if .Varies}}, or another URL depending on the visitor{{end}}</dd>
+{{if .CreatedAt}}<dt>Created</dt>
+<dd>{{.CreatedAt.Format "2 January 2006"}}</dd>
+{{end}}{{if .PasswordProtected}}<dt>Password</dt>
+<dd>Required</dd>
+{{end}}{{if .Limited}}<dt>Visits</dt>
+<dd>Limited, the link is gone after a number of visits</dd>
+{{end}}</dl>
+{{if .Flagged}}<p><strong>The link has been flagged{{if .Reason}} as {{.Reason}}{{end}}, visiting it may be unsafe.</strong></p>
+{{end}}<p><a href="{{.ShortURL}}" rel="noopener noreferrer nofollow">Go to the link</a></p>
+{{else if eq .Status "expired"}}<p>The link has no visits left.</p>
+{{else}}<p>The link was deleted.</p>
+{{end}}</body>
+</html>
+`))
+
+//	GetLinkPreview - Get request handler.
+//	Telling where a short URL goes without redirecting, as HTML or JSON by the Accept header.
+//	The domain parameter selects the domain of the link.
+func (s Server) GetLinkPreview(c echo.Context) error {
+	shortURL, err := s.apiKey(c)
+	if err != nil {
+		return c.NoContent(http.StatusBadRequest)
+	}
+	return s.previewKey(c, shortURL)
+}
+
+//	preview Responding with the preview of the code, looked up like for a redirect.
+func (s Server) preview(c echo.Context, code string) error {
+	shortURL, err := s.hostKey(c, code)
+	if err != nil {
+		return c.NoContent(http.StatusBadRequest)
+	}
+	return s.previewKey(c, shortURL)
+}
+
+//	previewKey Responding with the preview of the short URL of the storage key.
+func (s Server) previewKey(c echo.Context, shortURL string) error {
+	preview := linkPreview{
+		ShortURL: s.shortURL(shortURL),
+		Status:   statusActive,
+	}
+	link, err := s.storage.GetLink(shortURL)
+	if errors.Is(err, interfaces.ErrNotFound) {
+		return c.NoContent(http.StatusNotFound)
+	} else if errors.Is(err, interfaces.ErrExpired) {
+		preview.Status = statusExpired
+	} else if errors.Is(err, interfaces.ErrWasDeleted) {
+		preview.Status = statusDeleted
+	} else if err != nil {
+		return c.NoContent(http.StatusInternalServerError)
+	} else {
+		preview.PasswordProtected = len(link.Access.PasswordHash) > 0
+		preview.Limited = link.Access.MaxVisits > 0
+		if link.Access.IsZero() {
+			// showing the original URL would let visitors past the password or the limit
+			preview.BaseURL = link.BaseURL
+			preview.Meta = link.Meta
+		}
+		if !link.CreatedAt.IsZero() {
+			preview.CreatedAt = &link.CreatedAt
+		}
+		preview.Flagged = link.Flagged
+		preview.Reason = link.Reason
+		preview.Varies = len(link.Rules) > 0 || len(link.Destinations) > 0
+	}
+	c.Response().Header().Add("Vary", "Accept")
+	if !strings.Contains(c.Request().Header.Get(echo.HeaderAccept), echo.MIMETextHTML) {
+		return c.JSON(http.StatusOK, preview)
+	}
+	var buf bytes.Buffer
+	if err = previewPage.Execute(&buf, preview); err != nil {
+		return c.NoContent(http.StatusInternalServerError)
+	}
+	return c.HTMLBlob(http.StatusOK, buf.Bytes())
+}
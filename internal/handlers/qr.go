@@ -0,0 +1,146 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/ivanmyagkov/shortener.git/internal/interfaces"
+	"github.com/ivanmyagkov/shortener.git/internal/qrcode"
+)
+
+//	Formats of QR code images.
+const (
+	qrPNG = "png"
+	qrSVG = "svg"
+)
+
+//	Defaults and limits of QR code images, sizes in pixels and margins in modules.
+const (
+	qrDefaultSize = 256
+	qrMinSize     = 32
+	qrMaxSize     = 4096
+	qrMaxMargin   = 32
+)
+
+//	qrOptions are how a QR code image is drawn.
+type qrOptions struct {
+	format string
+	size   int
+	margin int
+	level  qrcode.Level
+}
+
+//	qrQuery Reading options of a QR code image from query parameters with the prefix:
+//	format, size, margin and ec, the error correction level.
+func qrQuery(c echo.Context, prefix string) (qrOptions, error) {
+	options := qrOptions{format: qrPNG, size: qrDefaultSize, margin: qrcode.QuietZone, level: qrcode.M}
+	if format := c.QueryParam(prefix + "format"); format != "" {
+		if format != qrPNG && format != qrSVG {
+			return options, errors.New("format must be png or svg")
+		}
+		options.format = format
+	}
+	if size := c.QueryParam(prefix + "size"); size != "" {
+		n, err := strconv.Atoi(size)
+		if err != nil || n < qrMinSize || n > qrMaxSize {
+			return options, errors.New("size is out of range")
+		}
+		options.size = n
+	}
+	if margin := c.QueryParam(prefix + "margin"); margin != "" {
+		n, err := strconv.Atoi(margin)
+		if err != nil || n < 0 || n > qrMaxMargin {
+			return options, errors.New("margin is out of range")
+		}
+		options.margin = n
+	}
+	if ec := c.QueryParam(prefix + "ec"); ec != "" {
+		level, ok := qrcode.ParseLevel(ec)
+		if !ok {
+			return options, errors.New("ec must be L, M, Q or H")
+		}
+		options.level = level
+	}
+	return options, nil
+}
+
+//	render Drawing the QR code of the text, returning the content type and the image.
+func (o qrOptions) render(text string) (string, []byte, error) {
+	code, err := qrcode.Encode(text, o.level)
+	if err != nil {
+		return "", nil, err
+	}
+	if o.format == qrSVG {
+		return "image/svg+xml", code.SVG(o.size, o.margin), nil
+	}
+	b, err := code.PNG(o.size, o.margin)
+	return "image/png", b, err
+}
+
+//	dataURI Drawing the QR code of the text as a data URI to embed in responses.
+func (o qrOptions) dataURI(text string) (string, error) {
+	contentType, b, err := o.render(text)
+	if err != nil {
+		return "", err
+	}
+	return "data:" + contentType + ";base64," + base64.StdEncoding.EncodeToString(b), nil
+}
+
+//	shortenQR Reading whether a shorten request asks for QR codes of the short URLs in the response,
+//	by the qr parameter with the format and qr_size, qr_margin and qr_ec.
+func shortenQR(c echo.Context) (*qrOptions, error) {
+	if c.QueryParam("qr") == "" {
+		return nil, nil
+	}
+	options, err := qrQuery(c, "qr_")
+	if err != nil {
+		return nil, err
+	}
+	switch c.QueryParam("qr") {
+	case qrPNG, qrSVG:
+		options.format = c.QueryParam("qr")
+	default:
+		return nil, errors.New("qr must be png or svg")
+	}
+	return &options, nil
+}
+
+//	GetQR - Get request handler.
+//	Drawing the QR code of the full short URL of the domain of the Host header as PNG or SVG.
+func (s Server) GetQR(c echo.Context) error {
+	shortURL, err := s.hostKey(c, c.Param("id"))
+	if err != nil {
+		return c.NoContent(http.StatusBadRequest)
+	}
+	options, err := qrQuery(c, "")
+	if err != nil {
+		return c.NoContent(http.StatusBadRequest)
+	}
+	if _, err = s.storage.GetLink(shortURL); errors.Is(err, interfaces.ErrNotFound) {
+		return c.NoContent(http.StatusNotFound)
+	} else if errors.Is(err, interfaces.ErrWasDeleted) {
+		return c.NoContent(http.StatusGone)
+	} else if err != nil {
+		return c.NoContent(http.StatusInternalServerError)
+	}
+	contentType, b, err := options.render(s.shortURL(shortURL))
+	if err != nil {
+		return c.NoContent(http.StatusInternalServerError)
+	}
+	// the code of a short URL never changes, but the link may be deleted,
+	// so caches check it again every time and get the image only if theirs is a different one
+	sum := sha256.Sum256(b)
+	etag := `"` + hex.EncodeToString(sum[:16]) + `"`
+	c.Response().Header().Set("Cache-Control", "no-cache")
+	c.Response().Header().Set("ETag", etag)
+	if c.Request().Header.Get("If-None-Match") == etag {
+		return c.NoContent(http.StatusNotModified)
+	}
+	return c.Blob(http.StatusOK, contentType, b)
+}
@@ -0,0 +1,182 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/ivanmyagkov/shortener.git/internal/interfaces"
+	"github.com/ivanmyagkov/shortener.git/internal/routing"
+	"github.com/ivanmyagkov/shortener.git/internal/split"
+)
+
+//	WithRouting is option to manage routing rules of links.
+func WithRouting(routing interfaces.Routing) Option {
+	return func(s *Server) {
+		s.routing = routing
+	}
+}
+
+//	WithGeoResolver is option to find countries of visitors for routing rules by country,
+//	without it such rules never match.
+func WithGeoResolver(geo interfaces.GeoResolver) Option {
+	return func(s *Server) {
+		s.geo = geo
+	}
+}
+
+//	target Returning the URL the visitor of the link goes to with its reputation: the URL of the first
+//	matching rule, the destination of the visitor if the link is split, or the original URL.
+//	The ID of the destination is empty unless the visitor goes to one.
+func (s Server) target(c echo.Context, link interfaces.Link) (string, string, interfaces.Verdict, error) {
+	if len(link.Rules) > 0 {
+		if URL, ok := s.matchRule(c, link.Rules); ok {
+			verdict, err := s.verdict(link, URL)
+			return URL, "", verdict, err
+		}
+	}
+	if len(link.Destinations) > 0 {
+		destination := split.Pick(link.Destinations, link.ShortURL+"\n"+s.visitorKey(c))
+		verdict, err := s.verdict(link, destination.URL)
+		return destination.URL, destination.ID, verdict, err
+	}
+	return link.BaseURL, "", link.Verdict, nil
+}
+
+//	verdict Returning the reputation of a URL the link leads to. URLs of rules and destinations
+//	are checked when they are saved, their verdicts are kept apart from the link.
+func (s Server) verdict(link interfaces.Link, URL string) (interfaces.Verdict, error) {
+	if URL == link.BaseURL {
+		return link.Verdict, nil
+	}
+	return s.storage.GetURLVerdict(URL)
+}
+
+//	matchRule Returning the URL of the first rule matching the visitor.
+func (s Server) matchRule(c echo.Context, rules []interfaces.Rule) (string, bool) {
+	// the redirect depends on the visitor, caches must not share it
+	c.Response().Header().Add("Vary", "User-Agent, Accept-Language")
+	visitor := routing.Visitor{
+		UserAgent:      c.Request().UserAgent(),
+		AcceptLanguage: c.Request().Header.Get("Accept-Language"),
+	}
+	if s.geo != nil && routing.UsesCountry(rules) {
+		if ip := net.ParseIP(c.RealIP()); ip != nil {
+			visitor.Country = s.geo.Country(ip)
+		}
+	}
+	return routing.Match(rules, visitor)
+}
+
+//	GetRules - Get request handler.
+//	Getting routing rules of a user link in the order they are checked.
+func (s Server) GetRules(c echo.Context) error {
+	userID, err := s.userID(c)
+	if err != nil {
+		return c.NoContent(http.StatusBadRequest)
+	}
+	workspaceID, err := s.workspace(c, userID, interfaces.RoleViewer)
+	if err != nil {
+		return workspaceError(c, err)
+	}
+	shortURL, err := s.apiKey(c)
+	if err != nil {
+		return c.NoContent(http.StatusBadRequest)
+	}
+	rules, err := s.routing.List(userID, workspaceID, shortURL)
+	if err != nil {
+		return ruleError(c, err)
+	}
+	return c.JSON(http.StatusOK, rules)
+}
+
+//	PostRule - Post request handler.
+//	Adding a routing rule after the rules of a user link, with the workspace header editors add rules to links of the workspace.
+func (s Server) PostRule(c echo.Context) error {
+	return s.saveRule(c, http.StatusCreated, func(userID, workspaceID, shortURL string, rule interfaces.Rule) (interfaces.Rule, error) {
+		return s.routing.Add(userID, workspaceID, shortURL, rule)
+	})
+}
+
+//	PutRule - Put request handler.
+//	Replacing a routing rule of a user link, it keeps its place among the rules.
+func (s Server) PutRule(c echo.Context) error {
+	return s.saveRule(c, http.StatusOK, func(userID, workspaceID, shortURL string, rule interfaces.Rule) (interfaces.Rule, error) {
+		rule.ID = c.Param("rule_id")
+		return s.routing.Update(userID, workspaceID, shortURL, rule)
+	})
+}
+
+//	saveRule Reading a rule from the request, checking its URL like an original URL and saving it.
+func (s Server) saveRule(c echo.Context, status int, save func(userID, workspaceID, shortURL string, rule interfaces.Rule) (interfaces.Rule, error)) error {
+	userID, err := s.userID(c)
+	if err != nil {
+		return c.NoContent(http.StatusBadRequest)
+	}
+	workspaceID, err := s.workspace(c, userID, interfaces.RoleEditor)
+	if err != nil {
+		return workspaceError(c, err)
+	}
+	shortURL, err := s.apiKey(c)
+	if err != nil {
+		return c.NoContent(http.StatusBadRequest)
+	}
+	var rule interfaces.Rule
+	if err = json.NewDecoder(c.Request().Body).Decode(&rule); err != nil {
+		return c.NoContent(http.StatusBadRequest)
+	}
+	URL, verdict, err := s.prepareURL(c.Request().Context(), rule.URL)
+	if err != nil {
+		var validationErr *interfaces.ValidationError
+		if errors.As(err, &validationErr) {
+			return c.JSON(http.StatusUnprocessableEntity, validationErr)
+		} else if errors.Is(err, interfaces.ErrCheckFailed) {
+			return c.NoContent(http.StatusServiceUnavailable)
+		}
+		return c.NoContent(http.StatusBadRequest)
+	}
+	rule.URL = URL
+	rule, err = save(userID, workspaceID, shortURL, rule)
+	if err != nil {
+		return ruleError(c, err)
+	}
+	if verdict != nil {
+		if err = s.storage.SetURLVerdict(URL, *verdict); err != nil {
+			return c.NoContent(http.StatusInternalServerError)
+		}
+	}
+	return c.JSON(status, rule)
+}
+
+//	DelRule - DELETE request handler.
+//	Removing a routing rule of a user link.
+func (s Server) DelRule(c echo.Context) error {
+	userID, err := s.userID(c)
+	if err != nil {
+		return c.NoContent(http.StatusBadRequest)
+	}
+	workspaceID, err := s.workspace(c, userID, interfaces.RoleEditor)
+	if err != nil {
+		return workspaceError(c, err)
+	}
+	shortURL, err := s.apiKey(c)
+	if err != nil {
+		return c.NoContent(http.StatusBadRequest)
+	}
+	if err = s.routing.Delete(userID, workspaceID, shortURL, c.Param("rule_id")); err != nil {
+		return ruleError(c, err)
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+//	ruleError Responding to a change of routing rules rejected by the service.
+func ruleError(c echo.Context, err error) error {
+	var validationErr *interfaces.ValidationError
+	if errors.As(err, &validationErr) {
+		return c.JSON(http.StatusUnprocessableEntity, validationErr)
+	}
+	return patchError(c, err)
+}
@@ -0,0 +1,77 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/ivanmyagkov/shortener.git/internal/interfaces"
+	"github.com/ivanmyagkov/shortener.git/internal/search"
+)
+
+//	Sizes of pages of search results.
+const (
+	searchDefaultLimit = 20
+	searchMaxLimit     = 100
+)
+
+//	searchPage is a page of search results with where it starts and how long it may be.
+type searchPage struct {
+	interfaces.SearchResult
+	Offset int `json:"offset"`
+	Limit  int `json:"limit"`
+}
+
+//	searchQuery Reading a search from query parameters: q, the text, offset and limit of the page.
+func searchQuery(c echo.Context) (interfaces.SearchQuery, error) {
+	query := interfaces.SearchQuery{Text: c.QueryParam("q"), Limit: searchDefaultLimit}
+	if len(query.Text) > search.MaxQuery {
+		return query, errors.New("q is too long")
+	}
+	if words := search.Words(query.Text); len(words) == 0 || len(words) > search.MaxWords {
+		return query, errors.New("q must have words")
+	}
+	if offset := c.QueryParam("offset"); offset != "" {
+		n, err := strconv.Atoi(offset)
+		if err != nil || n < 0 {
+			return query, errors.New("offset is out of range")
+		}
+		query.Offset = n
+	}
+	if limit := c.QueryParam("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 1 || n > searchMaxLimit {
+			return query, errors.New("limit is out of range")
+		}
+		query.Limit = n
+	}
+	return query, nil
+}
+
+//	GetSearch - Get request handler.
+//	Searching user links, or links of the workspace of the header, by words of the original URL, its domain,
+//	the title and the notes. Words match by prefix and despite typos, the best matches come first.
+func (s Server) GetSearch(c echo.Context) error {
+	userID, err := s.userID(c)
+	if err != nil {
+		return c.NoContent(http.StatusBadRequest)
+	}
+	workspaceID, err := s.workspace(c, userID, interfaces.RoleViewer)
+	if err != nil {
+		return workspaceError(c, err)
+	}
+	query, err := searchQuery(c)
+	if err != nil {
+		return c.NoContent(http.StatusBadRequest)
+	}
+	result, err := s.storage.SearchURLs(userID, workspaceID, query)
+	if err != nil {
+		return c.NoContent(http.StatusInternalServerError)
+	}
+	for i := range result.Hits {
+		result.Hits[i].ShortURL = s.shortURL(result.Hits[i].ShortURL)
+	}
+	return c.JSON(http.StatusOK, searchPage{SearchResult: result, Offset: query.Offset, Limit: query.Limit})
+}
@@ -0,0 +1,102 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/ivanmyagkov/shortener.git/internal/interfaces"
+	"github.com/ivanmyagkov/shortener.git/internal/split"
+)
+
+//	prepareDestinations Checking URLs of destinations like original URLs and the destinations themselves.
+func (s Server) prepareDestinations(ctx context.Context, destinations []interfaces.Destination) ([]interfaces.Destination, error) {
+	prepared := make([]interfaces.Destination, 0, len(destinations))
+	for _, destination := range destinations {
+		URL, verdict, err := s.prepareURL(ctx, destination.URL)
+		if err != nil {
+			return nil, err
+		}
+		if verdict != nil {
+			if err = s.storage.SetURLVerdict(URL, *verdict); err != nil {
+				return nil, err
+			}
+		}
+		destination.URL = URL
+		prepared = append(prepared, destination)
+	}
+	return split.Normalize(prepared)
+}
+
+//	visitorKey Returning what a visitor is told apart by: the session, or the address and the browser without it.
+func (s Server) visitorKey(c echo.Context) string {
+	if userID, err := s.userID(c); err == nil && userID != "" {
+		return userID
+	}
+	return c.RealIP() + "\n" + c.Request().UserAgent()
+}
+
+//	GetDestinations - Get request handler.
+//	Getting destinations of a split user link with the times each of them was served.
+func (s Server) GetDestinations(c echo.Context) error {
+	userID, err := s.userID(c)
+	if err != nil {
+		return c.NoContent(http.StatusBadRequest)
+	}
+	workspaceID, err := s.workspace(c, userID, interfaces.RoleViewer)
+	if err != nil {
+		return workspaceError(c, err)
+	}
+	shortURL, err := s.apiKey(c)
+	if err != nil {
+		return c.NoContent(http.StatusBadRequest)
+	}
+	destinations, err := s.storage.GetDestinations(userID, workspaceID, shortURL)
+	if err != nil {
+		return patchError(c, err)
+	}
+	return c.JSON(http.StatusOK, destinations)
+}
+
+//	PutDestinations - Put request handler.
+//	Replacing destinations of a user link, an empty array turns the split off.
+//	Destinations keeping their IDs keep the times they were served.
+func (s Server) PutDestinations(c echo.Context) error {
+	userID, err := s.userID(c)
+	if err != nil {
+		return c.NoContent(http.StatusBadRequest)
+	}
+	workspaceID, err := s.workspace(c, userID, interfaces.RoleEditor)
+	if err != nil {
+		return workspaceError(c, err)
+	}
+	shortURL, err := s.apiKey(c)
+	if err != nil {
+		return c.NoContent(http.StatusBadRequest)
+	}
+	var destinations []interfaces.Destination
+	if err = json.NewDecoder(c.Request().Body).Decode(&destinations); err != nil {
+		return c.NoContent(http.StatusBadRequest)
+	}
+	destinations, err = s.prepareDestinations(c.Request().Context(), destinations)
+	if err != nil {
+		var validationErr *interfaces.ValidationError
+		if errors.As(err, &validationErr) {
+			return c.JSON(http.StatusUnprocessableEntity, validationErr)
+		} else if errors.Is(err, interfaces.ErrCheckFailed) {
+			return c.NoContent(http.StatusServiceUnavailable)
+		}
+		return c.NoContent(http.StatusBadRequest)
+	}
+	if err = s.storage.SetDestinations(userID, workspaceID, shortURL, destinations); err != nil {
+		return patchError(c, err)
+	}
+	destinations, err = s.storage.GetDestinations(userID, workspaceID, shortURL)
+	if err != nil {
+		return c.NoContent(http.StatusInternalServerError)
+	}
+	return c.JSON(http.StatusOK, destinations)
+}
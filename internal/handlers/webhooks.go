@@ -0,0 +1,132 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/ivanmyagkov/shortener.git/internal/interfaces"
+)
+
+//	Defaults and limits of the delivery log.
+const (
+	deliveriesDefaultLimit = 50
+	deliveriesMaxLimit     = 200
+)
+
+//	WithWebhooks is option to tell webhooks about events of links.
+func WithWebhooks(webhooks interfaces.Webhooks) Option {
+	return func(s *Server) {
+		s.webhooks = webhooks
+	}
+}
+
+//	PostWebhook - Post request handler.
+//	Subscribing a webhook of the user or, with the workspace header, of the workspace to events of its links.
+//	The response tells the secret of signatures, it is never shown again.
+func (s Server) PostWebhook(c echo.Context) error {
+	if s.webhooks == nil {
+		return c.NoContent(http.StatusNotFound)
+	}
+	userID, err := s.userID(c)
+	if err != nil {
+		return c.NoContent(http.StatusBadRequest)
+	}
+	// only owners manage webhooks of a workspace
+	workspaceID, err := s.workspace(c, userID, interfaces.RoleOwner)
+	if err != nil {
+		return workspaceError(c, err)
+	}
+	var request struct {
+		URL        string   `json:"url"`
+		Events     []string `json:"events"`
+		Thresholds []int64  `json:"click_thresholds"`
+	}
+	if err = json.NewDecoder(c.Request().Body).Decode(&request); err != nil {
+		return c.NoContent(http.StatusBadRequest)
+	}
+	webhook, err := s.webhooks.Subscribe(userID, workspaceID, interfaces.Webhook{
+		URL:        request.URL,
+		Events:     request.Events,
+		Thresholds: request.Thresholds,
+	})
+	if err != nil {
+		var validationErr *interfaces.ValidationError
+		if errors.As(err, &validationErr) {
+			return c.JSON(http.StatusUnprocessableEntity, validationErr)
+		}
+		return c.NoContent(http.StatusInternalServerError)
+	}
+	return c.JSON(http.StatusCreated, webhook)
+}
+
+//	GetWebhooks - Get request handler.
+//	Getting webhooks of the user or the workspace without their secrets.
+func (s Server) GetWebhooks(c echo.Context) error {
+	if s.webhooks == nil {
+		return c.NoContent(http.StatusNotFound)
+	}
+	userID, err := s.userID(c)
+	if err != nil {
+		return c.NoContent(http.StatusBadRequest)
+	}
+	workspaceID, err := s.workspace(c, userID, interfaces.RoleOwner)
+	if err != nil {
+		return workspaceError(c, err)
+	}
+	webhooks, err := s.webhooks.List(userID, workspaceID)
+	if err != nil {
+		return c.NoContent(http.StatusInternalServerError)
+	}
+	return c.JSON(http.StatusOK, webhooks)
+}
+
+//	DelWebhook - DELETE request handler.
+//	Unsubscribing the webhook, its pending deliveries are dropped.
+func (s Server) DelWebhook(c echo.Context) error {
+	if s.webhooks == nil {
+		return c.NoContent(http.StatusNotFound)
+	}
+	userID, err := s.userID(c)
+	if err != nil {
+		return c.NoContent(http.StatusBadRequest)
+	}
+	workspaceID, err := s.workspace(c, userID, interfaces.RoleOwner)
+	if err != nil {
+		return workspaceError(c, err)
+	}
+	if err = s.webhooks.Unsubscribe(userID, workspaceID, c.Param("id")); err != nil {
+		return workspaceError(c, err)
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+//	GetWebhookDeliveries - Get request handler.
+//	Getting the latest deliveries of the webhook, the newest first, at most limit of them.
+func (s Server) GetWebhookDeliveries(c echo.Context) error {
+	if s.webhooks == nil {
+		return c.NoContent(http.StatusNotFound)
+	}
+	userID, err := s.userID(c)
+	if err != nil {
+		return c.NoContent(http.StatusBadRequest)
+	}
+	workspaceID, err := s.workspace(c, userID, interfaces.RoleOwner)
+	if err != nil {
+		return workspaceError(c, err)
+	}
+	limit := deliveriesDefaultLimit
+	if value := c.QueryParam("limit"); value != "" {
+		if limit, err = strconv.Atoi(value); err != nil || limit < 1 || limit > deliveriesMaxLimit {
+			return c.NoContent(http.StatusBadRequest)
+		}
+	}
+	deliveries, err := s.webhooks.Deliveries(userID, workspaceID, c.Param("id"), limit)
+	if err != nil {
+		return workspaceError(c, err)
+	}
+	return c.JSON(http.StatusOK, deliveries)
+}
@@ -0,0 +1,115 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/ivanmyagkov/shortener.git/internal/interfaces"
+)
+
+//	WithWorkspaces is option to share links in workspaces.
+func WithWorkspaces(workspaces interfaces.Workspaces) Option {
+	return func(s *Server) {
+		s.workspaces = workspaces
+	}
+}
+
+//	PostWorkspace - Post request handler.
+//	Creating a workspace owned by the user.
+func (s Server) PostWorkspace(c echo.Context) error {
+	userID, err := s.userID(c)
+	if err != nil {
+		return c.NoContent(http.StatusBadRequest)
+	}
+	var request struct {
+		Name string `json:"name"`
+	}
+	if err = json.NewDecoder(c.Request().Body).Decode(&request); err != nil {
+		return c.NoContent(http.StatusBadRequest)
+	}
+	workspace, err := s.workspaces.Create(userID, request.Name)
+	if err != nil {
+		var validationErr *interfaces.ValidationError
+		if errors.Is(err, interfaces.ErrNotFound) {
+			return c.NoContent(http.StatusForbidden)
+		} else if errors.As(err, &validationErr) {
+			return c.JSON(http.StatusUnprocessableEntity, validationErr)
+		}
+		return c.NoContent(http.StatusInternalServerError)
+	}
+	return c.JSON(http.StatusCreated, workspace)
+}
+
+//	GetWorkspaces - Get request handler.
+//	Getting workspaces of the user with its roles.
+func (s Server) GetWorkspaces(c echo.Context) error {
+	userID, err := s.userID(c)
+	if err != nil {
+		return c.NoContent(http.StatusBadRequest)
+	}
+	workspaces, err := s.workspaces.List(userID)
+	if err != nil {
+		return c.NoContent(http.StatusInternalServerError)
+	}
+	return c.JSON(http.StatusOK, workspaces)
+}
+
+//	GetMembers - Get request handler.
+//	Getting members of the workspace.
+func (s Server) GetMembers(c echo.Context) error {
+	userID, err := s.userID(c)
+	if err != nil {
+		return c.NoContent(http.StatusBadRequest)
+	}
+	members, err := s.workspaces.Members(userID, c.Param("id"))
+	if err != nil {
+		return workspaceError(c, err)
+	}
+	return c.JSON(http.StatusOK, members)
+}
+
+//	PutMember - Put request handler.
+//	Adding an account to the workspace or changing its role.
+func (s Server) PutMember(c echo.Context) error {
+	userID, err := s.userID(c)
+	if err != nil {
+		return c.NoContent(http.StatusBadRequest)
+	}
+	var request struct {
+		Login string `json:"login"`
+		Role  string `json:"role"`
+	}
+	if err = json.NewDecoder(c.Request().Body).Decode(&request); err != nil {
+		return c.NoContent(http.StatusBadRequest)
+	}
+	member, err := s.workspaces.SetMember(userID, c.Param("id"), request.Login, request.Role)
+	if err != nil {
+		var validationErr *interfaces.ValidationError
+		if errors.Is(err, interfaces.ErrLastOwner) {
+			return c.NoContent(http.StatusConflict)
+		} else if errors.As(err, &validationErr) {
+			return c.JSON(http.StatusUnprocessableEntity, validationErr)
+		}
+		return workspaceError(c, err)
+	}
+	return c.JSON(http.StatusOK, member)
+}
+
+//	DelMember - DELETE request handler.
+//	Removing a member from the workspace.
+func (s Server) DelMember(c echo.Context) error {
+	userID, err := s.userID(c)
+	if err != nil {
+		return c.NoContent(http.StatusBadRequest)
+	}
+	err = s.workspaces.RemoveMember(userID, c.Param("id"), c.Param("user_id"))
+	if errors.Is(err, interfaces.ErrLastOwner) {
+		return c.NoContent(http.StatusConflict)
+	} else if err != nil {
+		return workspaceError(c, err)
+	}
+	return c.NoContent(http.StatusNoContent)
+}
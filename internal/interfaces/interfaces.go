@@ -1,7 +1,16 @@
 //	Package interfaces for storing interfaces
 package interfaces
 
-import "errors"
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"time"
+)
 
 var (
 	ErrNotFound      = errors.New("not found")
@@ -10,13 +19,96 @@ var (
 	ErrCreateTable   = errors.New("create tables error")
 	ErrPingDB        = errors.New("ping Db error")
 	ErrWasDeleted    = errors.New("was deleted")
+	ErrCheckFailed   = errors.New("URL reputation check failed")
+	ErrInvalidURL    = errors.New("invalid URL")
+	ErrBadSession    = errors.New("invalid session")
+	ErrUnauthorized  = errors.New("wrong credentials")
+	ErrForbidden     = errors.New("forbidden")
+	ErrLastOwner     = errors.New("workspace must keep an owner")
+	ErrCodeTaken     = errors.New("short URL leads to another URL")
+	ErrShared        = errors.New("short URL is shared by other owners")
+	// a short URL with no visits left is gone like a deleted one
+	ErrExpired = fmt.Errorf("%w: no visits left", ErrWasDeleted)
+)
+
+//	Roles of workspace members, each one can do everything the next ones can.
+const (
+	RoleOwner  = "owner"
+	RoleEditor = "editor"
+	RoleViewer = "viewer"
 )
 
+//	ValidationError is a rejection of a URL by the validation policy.
+type ValidationError struct {
+	Code    string `json:"error"`
+	Message string `json:"message"`
+}
+
+func (e *ValidationError) Error() string {
+	return e.Message
+}
+
 type Storage interface {
 	GetURL(shortURL string) (string, error)
+	GetLink(shortURL string) (Link, error)
 	GetAllURLsByUserID(userID string) ([]ModelURL, error)
+	FindURLs(userID, workspaceID string, filter URLFilter) ([]ModelURL, error)
+	SetAnnotation(userID, workspaceID, shortURL string, annotation Annotation) error
+	SearchURLs(userID, workspaceID string, query SearchQuery) (SearchResult, error)
 	SetShortURL(userID, shortURL, baseURL string) error
 	DelBatchShortURLs(tasks []Task) error
+	GetBaseURLs() ([]string, error)
+	SetURLHealth(baseURL string, health URLHealth) error
+	SetURLVerdict(baseURL string, verdict Verdict) error
+	GetURLVerdict(baseURL string) (Verdict, error)
+	SetPageMeta(baseURL string, meta PageMeta) error
+	ClaimURLs(fromUserID, toUserID string) error
+	CreateAccount(account Account) error
+	GetAccount(login string) (Account, error)
+	GetAccountByUserID(userID string) (Account, error)
+	SetAPIKey(key APIKey) error
+	GetAPIKeys(userID string) ([]APIKey, error)
+	GetAPIKeyByHash(hash string) (APIKey, error)
+	DelAPIKey(userID, id string) error
+	CreateWorkspace(workspace Workspace, ownerID string) error
+	GetWorkspaces(userID string) ([]Workspace, error)
+	SetWorkspaceURL(workspaceID, userID, shortURL, baseURL string) error
+	SetPrivateURL(userID, workspaceID, shortURL, baseURL string) error
+	// the URL is saved with its options or not at all
+	CreateURL(userID, workspaceID, shortURL, baseURL string, options LinkOptions) error
+	GetWorkspaceURLs(workspaceID string) ([]ModelURL, error)
+	SetMember(member Member) error
+	GetMember(workspaceID, userID string) (Member, error)
+	GetMembers(workspaceID string) ([]Member, error)
+	DelMember(workspaceID, userID string) error
+	RetargetURL(workspaceID string, revision Revision) (Revision, error)
+	GetURLHistory(userID, workspaceID, shortURL string) ([]Revision, error)
+	SetRedirect(userID, workspaceID, shortURL string, redirect Redirect) error
+	GetRules(userID, workspaceID, shortURL string) ([]Rule, error)
+	SetRules(userID, workspaceID, shortURL string, rules []Rule) error
+	GetDestinations(userID, workspaceID, shortURL string) ([]Destination, error)
+	SetDestinations(userID, workspaceID, shortURL string, destinations []Destination) error
+	AddServed(shortURL, destinationID string) error
+	GetAccess(userID, workspaceID, shortURL string) (Access, error)
+	SetAccess(userID, workspaceID, shortURL string, access Access) error
+	// all of the patch is saved or none of it
+	PatchURL(userID, workspaceID, shortURL string, patch Patch) (Revision, error)
+	ConsumeVisit(shortURL string) (int64, error)
+	AddClick(shortURL string) (int64, error)
+	GetOwners(shortURL string) ([]Owner, error)
+	CreateWebhook(webhook Webhook) error
+	GetWebhooks(userID, workspaceID string) ([]Webhook, error)
+	GetWebhook(id string) (Webhook, error)
+	DelWebhook(userID, workspaceID, id string) error
+	GetClickThresholds() ([]int64, error)
+	AddDeliveries(deliveries []WebhookDelivery) error
+	ClaimDeliveries(now time.Time, lease time.Duration, limit int) ([]WebhookDelivery, error)
+	SetDelivery(delivery WebhookDelivery) error
+	GetDeliveries(userID, workspaceID, webhookID string, limit int) ([]WebhookDelivery, error)
+	PruneDeliveries(before time.Time) error
+	GetChanges(userID, workspaceID string, after int64, limit int) ([]Change, error)
+	GetChangeCursor(consumer string) (int64, error)
+	SetChangeCursor(consumer string, offset int64) error
 	Ping() error
 	Close() error
 }
@@ -24,6 +116,8 @@ type Storage interface {
 type Config interface {
 	SrvAddr() string
 	HostName() string
+	// base URLs of all domains short URLs are served under, the primary one first
+	BaseURLs() []string
 }
 
 type Users interface {
@@ -31,6 +125,94 @@ type Users interface {
 	ReadSessionID(id string) (string, error)
 }
 
+//	Accounts registers users and authenticates them by passwords and API keys.
+type Accounts interface {
+	Register(userID, login, password string) (Account, error)
+	Login(login, password, sessionUserID string) (Account, error)
+	IssueKey(userID, name string) (APIKey, string, error)
+	Keys(userID string) ([]APIKey, error)
+	Revoke(userID, id string) error
+	Authenticate(apiKey string) (string, error)
+}
+
+//	Workspaces manages workspaces and checks the roles of their members.
+type Workspaces interface {
+	Create(userID, name string) (Workspace, error)
+	List(userID string) ([]Workspace, error)
+	Members(userID, workspaceID string) ([]Member, error)
+	SetMember(userID, workspaceID, login, role string) (Member, error)
+	RemoveMember(userID, workspaceID, memberUserID string) error
+	Authorize(userID, workspaceID, role string) error
+}
+
+//	LinkEvents is told what happens to visits of links, to notify subscribers. Failures are logged, not returned,
+//	so links work even if nobody can be notified. Created and deleted links are told by the change log.
+type LinkEvents interface {
+	// a visit of a link with a limit of visits used the last one
+	Expired(shortURL, baseURL string)
+	// a link was followed, it counts the click
+	Clicked(shortURL, baseURL string)
+}
+
+//	Webhooks manages webhook subscriptions of users and workspaces and tells them about events of their links.
+type Webhooks interface {
+	LinkEvents
+	Subscribe(userID, workspaceID string, webhook Webhook) (Webhook, error)
+	List(userID, workspaceID string) ([]Webhook, error)
+	Unsubscribe(userID, workspaceID, id string) error
+	Deliveries(userID, workspaceID, id string, limit int) ([]WebhookDelivery, error)
+}
+
+//	Routing manages routing rules of links.
+type Routing interface {
+	List(userID, workspaceID, shortURL string) ([]Rule, error)
+	Add(userID, workspaceID, shortURL string, rule Rule) (Rule, error)
+	Update(userID, workspaceID, shortURL string, rule Rule) (Rule, error)
+	Delete(userID, workspaceID, shortURL, ruleID string) error
+}
+
+//	ChangePublisher sends changes of links to a downstream system in the order of the change log.
+//	A batch may be sent again if saving the position after it fails.
+type ChangePublisher interface {
+	Publish(ctx context.Context, changes []Change) error
+}
+
+//	MetaFetcher fetches metadata of pages of original URLs in the background.
+type MetaFetcher interface {
+	Enqueue(baseURL string)
+}
+
+//	GeoResolver finds the country of an IP address, "" if it is unknown.
+type GeoResolver interface {
+	Country(ip net.IP) string
+}
+
+type Canonicalizer interface {
+	Canonicalize(rawURL string) (string, error)
+}
+
+type URLValidator interface {
+	Validate(rawURL string) error
+}
+
+//	URLChecker consults a reputation service about a URL.
+type URLChecker interface {
+	CheckURL(ctx context.Context, rawURL string) (Verdict, error)
+}
+
+//	RateLimit is a token bucket: Rate tokens per second up to Burst tokens.
+type RateLimit struct {
+	Rate  float64
+	Burst int
+}
+
+//	RateStore keeps token buckets.
+type RateStore interface {
+	Take(key string, cost int, limit RateLimit) (allowed bool, retryAfter time.Duration, err error)
+	// Refund returns tokens taken for a request that wasn't served.
+	Refund(key string, cost int, limit RateLimit) error
+}
+
 type InWorker interface {
 	Do(t Task)
 	Loop() error
@@ -38,18 +220,333 @@ type InWorker interface {
 type Task struct {
 	ID       string
 	ShortURL string
+	// workspace of the link, empty for links of the user
+	WorkspaceID string
 }
 
 type ModelURL struct {
 	ShortURL string `json:"short_url"`
 	BaseURL  string `json:"original_url"`
+	// the short URL belongs to its owner only and is never shared with other users
+	Private  bool      `json:"private,omitempty"`
+	Redirect *Redirect `json:"redirect,omitempty"`
+	// the short URL asks for a password or is gone after max visits
+	PasswordProtected bool  `json:"password_protected,omitempty"`
+	MaxVisits         int64 `json:"max_visits,omitempty"`
+	Visits            int64 `json:"visits,omitempty"`
+	// what the page of the original URL tells about itself, once it was fetched
+	Meta *PageMeta `json:"meta,omitempty"`
+	// the title, notes and tags the owner gave the short URL
+	Annotation
+	*URLHealth
+}
+
+//	Annotation is what the owner of a short URL noted to find it later.
+//	Owners sharing a short URL annotate it each in their own way.
+type Annotation struct {
+	Title string `json:"title,omitempty"`
+	Notes string `json:"notes,omitempty"`
+	// in lower case, sorted
+	Tags []string `json:"tags,omitempty"`
+}
+
+//	IsZero Checking nothing was noted.
+func (a Annotation) IsZero() bool {
+	return a.Title == "" && a.Notes == "" && len(a.Tags) == 0
+}
+
+//	URLFilter selects short URLs of a listing, an empty filter selects all of them.
+type URLFilter struct {
+	// short URLs having all the tags
+	Tags []string
+	// text found in the original URL or the title regardless of case
+	Query string
+}
+
+//	Match Checking the short URL of a listing passes the filter, tags of the short URL are sorted.
+func (f URLFilter) Match(model ModelURL) bool {
+	for _, tag := range f.Tags {
+		i := sort.SearchStrings(model.Tags, tag)
+		if i == len(model.Tags) || model.Tags[i] != tag {
+			return false
+		}
+	}
+	if f.Query == "" {
+		return true
+	}
+	query := strings.ToLower(f.Query)
+	return strings.Contains(strings.ToLower(model.BaseURL), query) || strings.Contains(strings.ToLower(model.Title), query)
+}
+
+//	SearchQuery asks for a page of short URLs best matching the text.
+type SearchQuery struct {
+	// words looked for in the original URL, its domain, the title and the notes
+	Text string
+	// how many of the matches are skipped and returned, no limit if zero
+	Offset int
+	Limit  int
+}
+
+//	SearchHit is a short URL found by a search, a higher score is a better match.
+//	Scores compare hits of one search only.
+type SearchHit struct {
+	ModelURL
+	Score float64 `json:"score"`
+}
+
+//	SearchResult is a page of hits, the best first, with the number of all matches.
+type SearchResult struct {
+	Total int         `json:"total"`
+	Hits  []SearchHit `json:"items"`
+}
+
+//	Link is a short URL with everything needed to follow it.
+type Link struct {
+	ShortURL string `json:"short_url"`
+	BaseURL  string `json:"original_url"`
+	Verdict
+	Redirect Redirect `json:"redirect"`
+	Rules    []Rule   `json:"rules,omitempty"`
+	// visitors matching no rule are split between destinations, if there are any
+	Destinations []Destination `json:"destinations,omitempty"`
+	Access       Access        `json:"-"`
+	// zero for short URLs created before creation times were kept
+	CreatedAt time.Time `json:"created_at"`
+	Meta      *PageMeta `json:"meta,omitempty"`
+}
+
+//	PageMeta is what the page of an original URL tells about itself: its title,
+//	description and images from the head of the page.
+type PageMeta struct {
+	Title       string `json:"title,omitempty"`
+	Description string `json:"description,omitempty"`
+	// og:image and og:site_name of the page
+	Image    string `json:"image,omitempty"`
+	SiteName string `json:"site_name,omitempty"`
+	// the icon linked by the page or /favicon.ico of its host
+	FaviconURL string    `json:"favicon_url,omitempty"`
+	FetchedAt  time.Time `json:"fetched_at"`
+}
+
+//	Access limits who may follow a short URL and how many times.
+type Access struct {
+	// bcrypt hash of the password asked before the redirect, empty if there is none
+	PasswordHash []byte `json:"password_hash,omitempty"`
+	// visits after which the short URL is gone, 0 is unlimited
+	MaxVisits int64 `json:"max_visits,omitempty"`
+}
+
+//	IsZero Checking the short URL is open to anyone.
+func (a Access) IsZero() bool {
+	return len(a.PasswordHash) == 0 && a.MaxVisits == 0
+}
+
+//	LinkOptions are settings a short URL is created with, empty ones are not set.
+type LinkOptions struct {
+	// nobody else may add the short URL
+	Private  bool
+	Access   Access
+	Redirect Redirect
+	// destinations of a split URL
+	Destinations []Destination
+	// annotation of the owner
+	Annotation Annotation
+}
+
+//	Destination is one of the URLs visitors of a split link are sent to in proportion to its weight.
+type Destination struct {
+	ID     string `json:"id"`
+	URL    string `json:"url"`
+	Weight int    `json:"weight"`
+	// visitors the destination was served to
+	Served int64 `json:"served"`
+}
+
+//	Rule routes visitors matching all its conditions to its URL instead of the original URL.
+type Rule struct {
+	ID string `json:"id"`
+	// ios, android, windows, macos, linux, mobile or desktop
+	Devices []string `json:"devices,omitempty"`
+	// languages like "en" or "pt-BR", matched against the language the visitor prefers
+	Languages []string `json:"languages,omitempty"`
+	// ISO 3166-1 alpha-2 country codes like "US"
+	Countries []string `json:"countries,omitempty"`
+	URL       string   `json:"url"`
+}
+
+//	Redirect is how a short URL redirects to its original URL.
+type Redirect struct {
+	// 301, 302, 307 or 308, 307 if not set
+	StatusCode int `json:"status_code,omitempty"`
+	// the query the short URL was opened with is added to the original URL
+	ForwardQuery bool `json:"forward_query,omitempty"`
+	// UTM parameters added to the original URL unless it already has them
+	UTM map[string]string `json:"utm,omitempty"`
+	// the fragment of the original URL is dropped, so browsers keep the one the short URL was opened with
+	DropFragment bool `json:"drop_fragment,omitempty"`
+}
+
+//	IsZero Checking no redirect option is set.
+func (r Redirect) IsZero() bool {
+	return r.StatusCode == 0 && !r.ForwardQuery && len(r.UTM) == 0 && !r.DropFragment
+}
+
+//	Verdict is the reputation of an original URL.
+type Verdict struct {
+	Flagged bool   `json:"flagged,omitempty"`
+	Reason  string `json:"flag_reason,omitempty"`
+}
+
+//	URLHealth is the result of the last availability check of an original URL.
+type URLHealth struct {
+	StatusCode int       `json:"status_code"`
+	Error      string    `json:"check_error,omitempty"`
+	CheckedAt  time.Time `json:"checked_at"`
+}
+
+//	Revision is a change of the original URL of a short URL.
+type Revision struct {
+	ShortURL  string    `json:"short_url"`
+	OldURL    string    `json:"old_url"`
+	NewURL    string    `json:"new_url"`
+	UserID    string    `json:"user_id"`
+	ChangedAt time.Time `json:"changed_at"`
+}
+
+//	Patch is a change of several settings of a short URL at once, settings left empty are kept.
+type Patch struct {
+	// new original URL, a revision is kept if it differs
+	NewURL    string
+	ChangedAt time.Time
+	// reputation verdict of the original URL it has after the patch
+	Verdict  *Verdict
+	Redirect *Redirect
+	Access   *Access
+}
+
+//	Account is a registered user, its user ID is the one of the session it was created from.
+type Account struct {
+	UserID       string
+	Login        string
+	PasswordHash []byte
+	CreatedAt    time.Time
+}
+
+//	APIKey is a key of an account for server-to-server requests, only its hash is kept.
+type APIKey struct {
+	ID        string
+	UserID    string
+	Name      string
+	Hash      string
+	CreatedAt time.Time
+}
+
+//	Workspace is a group of users sharing links.
+type Workspace struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"created_at"`
+	// role of the user the workspace is listed for
+	Role string `json:"role,omitempty"`
+}
+
+//	Member is a user of a workspace with its role.
+type Member struct {
+	WorkspaceID string    `json:"workspace_id"`
+	UserID      string    `json:"user_id"`
+	Login       string    `json:"login,omitempty"`
+	Role        string    `json:"role"`
+	AddedAt     time.Time `json:"added_at"`
+}
+
+//	Owner is a user or, if WorkspaceID is set, a workspace having a short URL.
+type Owner struct {
+	UserID      string
+	WorkspaceID string
+}
+
+//	Is Checking the owner is the workspace, or the user if workspaceID is empty.
+func (o Owner) Is(userID, workspaceID string) bool {
+	if workspaceID != "" {
+		return o.WorkspaceID == workspaceID
+	}
+	return o.WorkspaceID == "" && o.UserID == userID
+}
+
+//	Events of links webhooks subscribe to.
+const (
+	EventLinkCreated = "link.created"
+	EventLinkDeleted = "link.deleted"
+	EventLinkExpired = "link.expired"
+	EventLinkClicks  = "link.clicks"
+)
+
+//	Webhook is a subscription of a user or a workspace to events of its links.
+type Webhook struct {
+	ID string `json:"id"`
+	// the user who subscribed, and the workspace the webhook belongs to if any
+	UserID      string `json:"user_id"`
+	WorkspaceID string `json:"workspace_id,omitempty"`
+	URL         string `json:"url"`
+	// key of HMAC signatures of payloads
+	Secret string   `json:"secret"`
+	Events []string `json:"events"`
+	// numbers of clicks of a link at which link.clicks is sent, the smallest first
+	Thresholds []int64   `json:"click_thresholds,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+//	Statuses of webhook deliveries.
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryFailed    = "failed"
+)
+
+//	WebhookDelivery is an event sent to a webhook, kept in the outbox until it is delivered or fails.
+type WebhookDelivery struct {
+	ID        string          `json:"id"`
+	WebhookID string          `json:"webhook_id"`
+	Event     string          `json:"event"`
+	Payload   json.RawMessage `json:"payload"`
+	Status    string          `json:"status"`
+	Attempts  int             `json:"attempts"`
+	// when a pending delivery is attempted next
+	NextAttemptAt time.Time `json:"next_attempt_at"`
+	// what the last attempt got
+	ResponseStatus int       `json:"response_status,omitempty"`
+	Error          string    `json:"error,omitempty"`
+	CreatedAt      time.Time `json:"created_at"`
+	UpdatedAt      time.Time `json:"updated_at"`
+}
+
+//	Change is a record of the change log of links, kept for downstream systems.
+//	Types of changes are the events link.created and link.deleted.
+type Change struct {
+	// position in the log, later changes have greater offsets
+	Offset   int64  `json:"offset"`
+	Type     string `json:"type"`
+	ShortURL string `json:"short_url"`
+	BaseURL  string `json:"original_url"`
+	// the user who made the change, and the workspace of the link if any
+	UserID      string    `json:"user_id"`
+	WorkspaceID string    `json:"workspace_id,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
 }
 
 type BatchRequest struct {
-	CorrelationID string `json:"correlation_id"`
-	OriginalURL   string `json:"original_url"`
+	CorrelationID string    `json:"correlation_id"`
+	OriginalURL   string    `json:"original_url"`
+	Redirect      *Redirect `json:"redirect,omitempty"`
+	Annotation
 }
 type BatchResponse struct {
 	CorrelationID string `json:"correlation_id"`
 	ShortURL      string `json:"short_url"`
+	// data URI of the QR code of the short URL, if it was asked for
+	QR string `json:"qr,omitempty"`
+}
+type BatchError struct {
+	CorrelationID string `json:"correlation_id"`
+	*ValidationError
 }
@@ -0,0 +1,220 @@
+//	Package keys for loading the secret keys of user sessions.
+//
+//	A key is written as "id:hex", the hex part is 32 random bytes.
+//	Several keys may be active at once for rotation: sessions are encrypted
+//	with the primary key and read with any of them.
+package keys
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+//	Size is the length of a key secret in bytes.
+const Size = 32
+
+//	Key is a secret key with its identifier.
+type Key struct {
+	ID     string
+	Secret []byte
+}
+
+//	String Writing the key as "id:hex".
+func (k Key) String() string {
+	return k.ID + ":" + hex.EncodeToString(k.Secret)
+}
+
+//	ParseKey Reading a key written as "id:hex".
+func ParseKey(s string) (Key, error) {
+	parts := strings.SplitN(strings.TrimSpace(s), ":", 2)
+	if len(parts) != 2 || parts[0] == "" {
+		return Key{}, errors.New(`key is not like "id:hex"`)
+	}
+	return newKey(parts[0], parts[1])
+}
+
+func newKey(id, secret string) (Key, error) {
+	b, err := hex.DecodeString(strings.TrimSpace(secret))
+	if err != nil {
+		return Key{}, fmt.Errorf("key %q: %w", id, err)
+	}
+	if len(b) != Size {
+		return Key{}, fmt.Errorf("key %q has %d bytes, %d are required", id, len(b), Size)
+	}
+	return Key{ID: id, Secret: b}, nil
+}
+
+//	Generate is function to create a random key, the id is the creation time.
+func Generate() (Key, error) {
+	secret := make([]byte, Size)
+	if _, err := rand.Read(secret); err != nil {
+		return Key{}, err
+	}
+	return Key{ID: time.Now().UTC().Format("20060102150405"), Secret: secret}, nil
+}
+
+//	Ring is the set of active keys.
+type Ring struct {
+	keys   []Key
+	legacy []byte
+}
+
+//	NewRing is function to create a set of keys, the first one is primary.
+//	legacy is the key of sessions created before keys were introduced, it may be empty.
+func NewRing(keys []Key, legacy []byte) (*Ring, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("no session keys")
+	}
+	seen := make(map[string]struct{}, len(keys))
+	for _, key := range keys {
+		if len(key.Secret) != Size {
+			return nil, fmt.Errorf("key %q has %d bytes, %d are required", key.ID, len(key.Secret), Size)
+		}
+		if _, ok := seen[key.ID]; ok {
+			return nil, fmt.Errorf("duplicate key %q", key.ID)
+		}
+		seen[key.ID] = struct{}{}
+	}
+	switch len(legacy) {
+	case 0, 16, 24, 32:
+	default:
+		return nil, fmt.Errorf("legacy key has %d bytes, 16, 24 or 32 are required", len(legacy))
+	}
+	return &Ring{keys: append([]Key{}, keys...), legacy: legacy}, nil
+}
+
+//	Primary Returning the key to encrypt with.
+func (r *Ring) Primary() Key {
+	return r.keys[0]
+}
+
+//	Keys Returning all active keys, the primary one first.
+func (r *Ring) Keys() []Key {
+	return r.keys
+}
+
+//	Legacy Returning the key of old sessions, nil if they are not accepted.
+func (r *Ring) Legacy() []byte {
+	return r.legacy
+}
+
+//	Settings of key sources.
+type Settings struct {
+	// keys like "id:hex"
+	Keys []string
+	// file with a key like "id:hex" per line, empty lines and "#" comments are skipped
+	File string
+	// directory with a key per file, the file name is the key id
+	Dir string
+	// id of the primary key, the first found key by default
+	Primary string
+	// key of sessions created before keys were introduced
+	Legacy string
+}
+
+//	Load is function to read keys from all sources: the list, then the file, then the directory.
+func Load(settings Settings) (*Ring, error) {
+	var keys []Key
+	for _, s := range settings.Keys {
+		key, err := ParseKey(s)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	if settings.File != "" {
+		fileKeys, err := loadFile(settings.File)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, fileKeys...)
+	}
+	if settings.Dir != "" {
+		dirKeys, err := loadDir(settings.Dir)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, dirKeys...)
+	}
+	if settings.Primary != "" {
+		i := 0
+		for i < len(keys) && keys[i].ID != settings.Primary {
+			i++
+		}
+		if i == len(keys) {
+			return nil, fmt.Errorf("primary key %q not found", settings.Primary)
+		}
+		keys[0], keys[i] = keys[i], keys[0]
+	}
+	var legacy []byte
+	if settings.Legacy != "" {
+		legacy = []byte(settings.Legacy)
+	}
+	return NewRing(keys, legacy)
+}
+
+func loadFile(path string) ([]Key, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var keys []Key
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		key, err := ParseKey(text)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		keys = append(keys, key)
+	}
+	return keys, scanner.Err()
+}
+
+//	loadDir Reading keys of a mounted secret, hidden files and directories are skipped.
+func loadDir(dir string) ([]Key, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		if !strings.HasPrefix(entry.Name(), ".") {
+			names = append(names, entry.Name())
+		}
+	}
+	sort.Strings(names)
+	var keys []Key
+	for _, name := range names {
+		path := filepath.Join(dir, name)
+		stat, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if stat.IsDir() {
+			continue
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		key, err := newKey(name, string(data))
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
@@ -0,0 +1,56 @@
+package keys
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoad(t *testing.T) {
+	a, b, c := Key{ID: "a", Secret: make([]byte, Size)}, Key{ID: "b", Secret: make([]byte, Size)}, Key{ID: "c", Secret: make([]byte, Size)}
+	c.Secret[0] = 1
+
+	dir := t.TempDir()
+	file := filepath.Join(dir, "keys")
+	require.NoError(t, os.WriteFile(file, []byte("# rotated monthly\n"+b.String()+"\n\n"), 0600))
+	secrets := filepath.Join(dir, "secrets")
+	require.NoError(t, os.MkdirAll(filepath.Join(secrets, "..data"), 0700))
+	require.NoError(t, os.WriteFile(filepath.Join(secrets, "c"), []byte(strings.TrimPrefix(c.String(), "c:")+"\n"), 0600))
+
+	ring, err := Load(Settings{Keys: []string{a.String()}, File: file, Dir: secrets, Primary: "c"})
+	require.NoError(t, err)
+	assert.Equal(t, c, ring.Primary())
+	assert.Len(t, ring.Keys(), 3)
+	assert.Nil(t, ring.Legacy())
+
+	tests := []struct {
+		name     string
+		settings Settings
+	}{
+		{name: "no keys", settings: Settings{}},
+		{name: "short key", settings: Settings{Keys: []string{"a:00ff"}}},
+		{name: "no id", settings: Settings{Keys: []string{strings.TrimPrefix(a.String(), "a:")}}},
+		{name: "duplicate", settings: Settings{Keys: []string{a.String(), a.String()}}},
+		{name: "unknown primary", settings: Settings{Keys: []string{a.String()}, Primary: "b"}},
+		{name: "legacy length", settings: Settings{Keys: []string{a.String()}, Legacy: "short"}},
+		{name: "missing file", settings: Settings{File: filepath.Join(dir, "none")}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Load(tt.settings)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestGenerate(t *testing.T) {
+	key, err := Generate()
+	require.NoError(t, err)
+	parsed, err := ParseKey(key.String())
+	require.NoError(t, err)
+	assert.Equal(t, key, parsed)
+}
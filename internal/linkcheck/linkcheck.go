@@ -0,0 +1,183 @@
+//	Package linkcheck for periodic availability checks of original URLs.
+package linkcheck
+
+import (
+	"context"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/ivanmyagkov/shortener.git/internal/interfaces"
+	"github.com/ivanmyagkov/shortener.git/internal/urlpolicy"
+)
+
+//	maxBodySize is how much of a GET response body is read before the connection is dropped.
+const maxBodySize = 64 << 10
+
+//	Settings of the checker.
+type Settings struct {
+	// pause between check rounds
+	Interval time.Duration
+	// number of concurrent requests
+	Workers int
+	// timeout of a single request
+	Timeout time.Duration
+	// minimal pause between requests to the same host
+	HostInterval time.Duration
+	// refuse to connect to private, loopback and link-local addresses
+	DenyPrivate bool
+}
+
+type Checker struct {
+	mu       sync.Mutex
+	storage  interfaces.Storage
+	client   *http.Client
+	settings Settings
+	hosts    map[string]time.Time
+	ctx      context.Context
+}
+
+//	New is function to create a checker of original URLs.
+func New(ctx context.Context, storage interfaces.Storage, settings Settings) *Checker {
+	if settings.Workers < 1 {
+		settings.Workers = 1
+	}
+	transport := &http.Transport{
+		DialContext:           urlpolicy.Dialer(settings.Timeout, settings.DenyPrivate).DialContext,
+		TLSHandshakeTimeout:   settings.Timeout,
+		ResponseHeaderTimeout: settings.Timeout,
+	}
+	return &Checker{
+		storage:  storage,
+		client:   &http.Client{Timeout: settings.Timeout, Transport: transport},
+		settings: settings,
+		hosts:    make(map[string]time.Time),
+		ctx:      ctx,
+	}
+}
+
+//	Loop Checking all original URLs every interval until the context is done.
+func (c *Checker) Loop() error {
+	ticker := time.NewTicker(c.settings.Interval)
+	defer ticker.Stop()
+	for {
+		if err := c.CheckAll(); err != nil {
+			log.Println(err)
+		}
+		select {
+		case <-c.ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+//	CheckAll Checking all original URLs once and saving the results.
+func (c *Checker) CheckAll() error {
+	baseURLs, err := c.storage.GetBaseURLs()
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	c.hosts = make(map[string]time.Time)
+	c.mu.Unlock()
+
+	urlCh := make(chan string)
+	var wg sync.WaitGroup
+	for i := 0; i < c.settings.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for baseURL := range urlCh {
+				health, ok := c.Check(baseURL)
+				if !ok {
+					continue
+				}
+				if err := c.storage.SetURLHealth(baseURL, health); err != nil {
+					log.Println(err)
+				}
+			}
+		}()
+	}
+
+loop:
+	for _, baseURL := range baseURLs {
+		select {
+		case <-c.ctx.Done():
+			break loop
+		case urlCh <- baseURL:
+		}
+	}
+	close(urlCh)
+	wg.Wait()
+	return nil
+}
+
+//	Check Requesting the original URL.
+//	HEAD is tried first, GET is used when the server does not support HEAD.
+//	ok is false if the URL was not checked.
+func (c *Checker) Check(baseURL string) (health interfaces.URLHealth, ok bool) {
+	u, err := url.Parse(baseURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return health, false
+	}
+	if err = c.wait(u.Host); err != nil {
+		return health, false
+	}
+
+	health.StatusCode, err = c.do(http.MethodHead, baseURL)
+	if err == nil && (health.StatusCode == http.StatusMethodNotAllowed || health.StatusCode == http.StatusNotImplemented) {
+		if err = c.wait(u.Host); err != nil {
+			return health, false
+		}
+		health.StatusCode, err = c.do(http.MethodGet, baseURL)
+	}
+	if err != nil {
+		if c.ctx.Err() != nil {
+			return health, false
+		}
+		health.Error = err.Error()
+	}
+	health.CheckedAt = time.Now().UTC()
+	return health, true
+}
+
+func (c *Checker) do(method, baseURL string) (int, error) {
+	req, err := http.NewRequestWithContext(c.ctx, method, baseURL, nil)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("User-Agent", "shortener-linkcheck")
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, maxBodySize))
+	return resp.StatusCode, nil
+}
+
+//	wait Waiting for the turn of the host so that it gets
+//	no more than one request per HostInterval.
+func (c *Checker) wait(host string) error {
+	c.mu.Lock()
+	now := time.Now()
+	next := c.hosts[host]
+	if next.Before(now) {
+		next = now
+	}
+	c.hosts[host] = next.Add(c.settings.HostInterval)
+	c.mu.Unlock()
+
+	timer := time.NewTimer(time.Until(next))
+	defer timer.Stop()
+	select {
+	case <-c.ctx.Done():
+		return c.ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,150 @@
+package linkcheck
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ivanmyagkov/shortener.git/internal/storage"
+)
+
+func TestChecker_CheckAll(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ok", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	mux.HandleFunc("/gone", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	})
+	mux.HandleFunc("/head-not-allowed", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodHead {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+	mux.HandleFunc("/slow", func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+		w.WriteHeader(http.StatusOK)
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	type want struct {
+		code     int
+		hasError bool
+	}
+	tests := []struct {
+		name string
+		path string
+		want want
+	}{
+		{name: "available", path: "/ok", want: want{code: http.StatusOK}},
+		{name: "not found", path: "/gone", want: want{code: http.StatusNotFound}},
+		{name: "head not allowed", path: "/head-not-allowed", want: want{code: http.StatusOK}},
+		{name: "timeout", path: "/slow", want: want{code: 0, hasError: true}},
+	}
+
+	db := storage.NewDBConn()
+	for _, tt := range tests {
+		require.NoError(t, db.SetShortURL("user", tt.name, srv.URL+tt.path))
+	}
+	require.NoError(t, db.SetShortURL("user", "ftp", "ftp://example.com/file"))
+
+	checker := New(context.Background(), db, Settings{
+		Workers: 2,
+		Timeout: 100 * time.Millisecond,
+	})
+	require.NoError(t, checker.CheckAll())
+
+	URLs, err := db.GetAllURLsByUserID("user")
+	require.NoError(t, err)
+	for _, model := range URLs {
+		if model.ShortURL == "ftp" {
+			assert.Nil(t, model.URLHealth)
+		}
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, model := range URLs {
+				if model.ShortURL != tt.name {
+					continue
+				}
+				require.NotNil(t, model.URLHealth)
+				assert.Equal(t, tt.want.code, model.StatusCode)
+				assert.Equal(t, tt.want.hasError, model.Error != "")
+				assert.False(t, model.CheckedAt.IsZero())
+			}
+		})
+	}
+}
+
+func TestChecker_HostInterval(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	db := storage.NewDBConn()
+	for _, path := range []string{"/a", "/b", "/c"} {
+		require.NoError(t, db.SetShortURL("user", path, srv.URL+path))
+	}
+
+	checker := New(context.Background(), db, Settings{
+		Workers:      3,
+		Timeout:      time.Second,
+		HostInterval: 50 * time.Millisecond,
+	})
+	start := time.Now()
+	require.NoError(t, checker.CheckAll())
+	assert.GreaterOrEqual(t, time.Since(start), 100*time.Millisecond)
+}
+
+func TestChecker_HeadFallbackWaits(t *testing.T) {
+	var mu sync.Mutex
+	var times []time.Time
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		times = append(times, time.Now())
+		mu.Unlock()
+		if r.Method == http.MethodHead {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	checker := New(context.Background(), storage.NewDBConn(), Settings{
+		Timeout:      time.Second,
+		HostInterval: 100 * time.Millisecond,
+	})
+	health, ok := checker.Check(srv.URL)
+	require.True(t, ok)
+	assert.Equal(t, http.StatusOK, health.StatusCode)
+	require.Len(t, times, 2)
+	assert.GreaterOrEqual(t, times[1].Sub(times[0]), 90*time.Millisecond)
+}
+
+func TestChecker_DenyPrivate(t *testing.T) {
+	requested := false
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requested = true
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	// the test server listens on a loopback address
+	checker := New(context.Background(), storage.NewDBConn(), Settings{Timeout: time.Second, DenyPrivate: true})
+	health, ok := checker.Check(srv.URL)
+	require.True(t, ok)
+	assert.Zero(t, health.StatusCode)
+	assert.NotEmpty(t, health.Error)
+	assert.False(t, requested)
+}
@@ -2,6 +2,7 @@
 package middleware
 
 import (
+	"errors"
 	"net/http"
 
 	"github.com/labstack/echo/v4"
@@ -10,40 +11,78 @@ import (
 	"github.com/ivanmyagkov/shortener.git/internal/utils"
 )
 
+//	userKey is the key of the user ID in the request context.
+const userKey = "userID"
+
 type MW struct {
-	users interfaces.Users
+	users    interfaces.Users
+	accounts interfaces.Accounts
 }
 
 //	New is function to Create a user.
-func New(users interfaces.Users) *MW {
+//	accounts may be nil, then API keys are not accepted.
+func New(users interfaces.Users, accounts interfaces.Accounts) *MW {
 	return &MW{
-		users: users,
+		users:    users,
+		accounts: accounts,
 	}
 }
 
+//	UserID Returning the user of the request found by the session middleware.
+func UserID(c echo.Context) (string, bool) {
+	userID, ok := c.Get(userKey).(string)
+	return userID, ok && userID != ""
+}
+
 //	SessionWithCookies - Intermediate function for validating and creating cookies.
+//	Requests with the X-API-Key header are authenticated by the key and get no cookie.
+//	Sessions of a new user or with an invalid cookie get a new user ID,
+//	sessions encrypted with an old key are encrypted again with the primary one.
 func (M *MW) SessionWithCookies(next echo.HandlerFunc) echo.HandlerFunc {
 	return func(c echo.Context) error {
-		cookie, err := c.Cookie("cookie")
-		if err != nil {
-			uid := utils.CreateID(16)
-			cookie := new(http.Cookie)
-			cookie.Name = "cookie"
-			cookie.Path = "/"
-			cookie.Value, _ = M.users.CreateSissionID(uid)
-			c.SetCookie(cookie)
-			c.Request().AddCookie(cookie)
-		} else {
-			if _, err := M.users.ReadSessionID(cookie.Value); err != nil {
-				uid := utils.CreateID(16)
-				cookie := new(http.Cookie)
-				cookie.Name = "cookie"
-				cookie.Path = "/"
-				cookie.Value, _ = M.users.CreateSissionID(uid)
-				c.SetCookie(cookie)
-				c.Request().AddCookie(cookie)
+		if apiKey := c.Request().Header.Get("X-API-Key"); apiKey != "" && M.accounts != nil {
+			userID, err := M.accounts.Authenticate(apiKey)
+			if errors.Is(err, interfaces.ErrUnauthorized) {
+				return c.NoContent(http.StatusUnauthorized)
+			} else if err != nil {
+				return c.NoContent(http.StatusInternalServerError)
+			}
+			c.Set(userKey, userID)
+			return next(c)
+		}
+
+		value := ""
+		if cookie, err := c.Cookie("cookie"); err == nil {
+			if uid, err := M.users.ReadSessionID(cookie.Value); err == nil {
+				c.Set(userKey, uid)
+				if value, err = M.users.CreateSissionID(uid); err != nil || value == cookie.Value {
+					return next(c)
+				}
 			}
 		}
+		if value == "" {
+			uid := utils.CreateID(16)
+			c.Set(userKey, uid)
+			value, _ = M.users.CreateSissionID(uid)
+		}
+		cookie := new(http.Cookie)
+		cookie.Name = "cookie"
+		cookie.Path = "/"
+		cookie.Value = value
+		c.SetCookie(cookie)
+		replaceCookie(c.Request(), cookie)
 		return next(c)
 	}
 }
+
+//	replaceCookie Replacing the request cookie so handlers read the new one.
+func replaceCookie(r *http.Request, cookie *http.Cookie) {
+	cookies := r.Cookies()
+	r.Header.Del("Cookie")
+	for _, c := range cookies {
+		if c.Name != cookie.Name {
+			r.AddCookie(c)
+		}
+	}
+	r.AddCookie(cookie)
+}
@@ -25,6 +25,10 @@ func CompressHandle(next echo.HandlerFunc) echo.HandlerFunc {
 		if !strings.Contains(c.Request().Header.Get("Accept-Encoding"), "gzip") {
 			return next(c)
 		}
+		// events of a stream must not wait in the buffer of the compressor
+		if strings.Contains(c.Request().Header.Get(echo.HeaderAccept), "text/event-stream") {
+			return next(c)
+		}
 		gz, err := gzip.NewWriterLevel(c.Response().Writer, gzip.BestSpeed)
 		if err != nil {
 			return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
@@ -0,0 +1,258 @@
+//	Package pagemeta for fetching titles, descriptions and icons of pages of original URLs.
+package pagemeta
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/charset"
+
+	"github.com/ivanmyagkov/shortener.git/internal/interfaces"
+	"github.com/ivanmyagkov/shortener.git/internal/urlpolicy"
+)
+
+//	maxText is how many bytes of a title or a description are kept.
+const maxText = 500
+
+var errNotHTML = errors.New("not an HTML page")
+
+//	Settings of the fetcher.
+type Settings struct {
+	// number of concurrent requests
+	Workers int
+	// URLs waiting for a worker, more are dropped
+	QueueSize int
+	// timeout of a single request, redirects included
+	Timeout time.Duration
+	// how much of a page is read, the head of a page is expected to fit
+	MaxBytes int64
+	// refuse to connect to private, loopback and link-local addresses
+	DenyPrivate bool
+}
+
+type Fetcher struct {
+	storage  interfaces.Storage
+	client   *http.Client
+	settings Settings
+	queue    chan string
+	ctx      context.Context
+}
+
+//	New is function to create a fetcher of page metadata.
+func New(ctx context.Context, storage interfaces.Storage, settings Settings) *Fetcher {
+	if settings.Workers < 1 {
+		settings.Workers = 1
+	}
+	if settings.QueueSize < 1 {
+		settings.QueueSize = 1000
+	}
+	dialer := urlpolicy.Dialer(settings.Timeout, settings.DenyPrivate)
+	transport := &http.Transport{
+		DialContext:           dialer.DialContext,
+		TLSHandshakeTimeout:   settings.Timeout,
+		ResponseHeaderTimeout: settings.Timeout,
+		MaxIdleConnsPerHost:   1,
+	}
+	return &Fetcher{
+		storage:  storage,
+		client:   &http.Client{Timeout: settings.Timeout, Transport: transport},
+		settings: settings,
+		queue:    make(chan string, settings.QueueSize),
+		ctx:      ctx,
+	}
+}
+
+//	Enqueue Adding the original URL to the fetch queue, the URL is dropped if the queue is full.
+func (f *Fetcher) Enqueue(baseURL string) {
+	select {
+	case f.queue <- baseURL:
+	default:
+		log.Printf("page metadata queue is full, %s is skipped", baseURL)
+	}
+}
+
+//	Loop Fetching queued URLs and saving their metadata until the context is done.
+func (f *Fetcher) Loop() error {
+	done := make(chan struct{})
+	for i := 0; i < f.settings.Workers; i++ {
+		go func() {
+			defer func() { done <- struct{}{} }()
+			for {
+				select {
+				case <-f.ctx.Done():
+					return
+				case baseURL := <-f.queue:
+					meta, err := f.Fetch(baseURL)
+					if err != nil {
+						if f.ctx.Err() == nil {
+							log.Printf("page metadata of %s: %v", baseURL, err)
+						}
+						continue
+					}
+					if err = f.storage.SetPageMeta(baseURL, meta); err != nil {
+						log.Println(err)
+					}
+				}
+			}
+		}()
+	}
+	for i := 0; i < f.settings.Workers; i++ {
+		<-done
+	}
+	return nil
+}
+
+//	Fetch Requesting the page of the original URL and reading metadata from its head.
+func (f *Fetcher) Fetch(baseURL string) (interfaces.PageMeta, error) {
+	u, err := url.Parse(baseURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return interfaces.PageMeta{}, fmt.Errorf("unsupported URL %q", baseURL)
+	}
+	req, err := http.NewRequestWithContext(f.ctx, http.MethodGet, baseURL, nil)
+	if err != nil {
+		return interfaces.PageMeta{}, err
+	}
+	req.Header.Set("User-Agent", "shortener-pagemeta")
+	req.Header.Set("Accept", "text/html")
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return interfaces.PageMeta{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return interfaces.PageMeta{}, fmt.Errorf("status %d", resp.StatusCode)
+	}
+	contentType := resp.Header.Get("Content-Type")
+	if mediaType, _, err := mime.ParseMediaType(contentType); err != nil || (mediaType != "text/html" && mediaType != "application/xhtml+xml") {
+		return interfaces.PageMeta{}, errNotHTML
+	}
+	body, err := charset.NewReader(io.LimitReader(resp.Body, f.settings.MaxBytes), contentType)
+	if err != nil {
+		return interfaces.PageMeta{}, err
+	}
+	// links of the page are relative to where the redirects ended
+	meta := Parse(body, resp.Request.URL)
+	meta.FetchedAt = time.Now().UTC()
+	return meta, nil
+}
+
+//	Parse Reading the title, the description and the icons from the head of the page.
+//	Open Graph tags are used when the page has no title or description of its own.
+func Parse(r io.Reader, base *url.URL) interfaces.PageMeta {
+	var meta interfaces.PageMeta
+	var ogTitle, ogDescription string
+	z := html.NewTokenizer(r)
+	inTitle := false
+loop:
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			// the end of the page or of what was read of it
+			break loop
+		case html.TextToken:
+			if inTitle {
+				meta.Title += string(z.Text())
+			}
+		case html.EndTagToken:
+			name, _ := z.TagName()
+			switch string(name) {
+			case "title":
+				inTitle = false
+			case "head":
+				break loop
+			}
+		case html.StartTagToken, html.SelfClosingTagToken:
+			name, hasAttr := z.TagName()
+			attrs := make(map[string]string)
+			for hasAttr {
+				var key, value []byte
+				key, value, hasAttr = z.TagAttr()
+				attrs[string(key)] = string(value)
+			}
+			switch string(name) {
+			case "title":
+				inTitle = meta.Title == ""
+			case "meta":
+				content := attrs["content"]
+				switch strings.ToLower(attrs["property"]) {
+				case "og:title":
+					ogTitle = content
+				case "og:description":
+					ogDescription = content
+				case "og:image":
+					meta.Image = resolve(base, content)
+				case "og:site_name":
+					meta.SiteName = content
+				}
+				if strings.ToLower(attrs["name"]) == "description" {
+					meta.Description = content
+				}
+			case "link":
+				if meta.FaviconURL == "" && isIcon(attrs["rel"]) {
+					meta.FaviconURL = resolve(base, attrs["href"])
+				}
+			case "body":
+				break loop
+			}
+		}
+	}
+	meta.Title = clean(meta.Title)
+	if meta.Title == "" {
+		meta.Title = clean(ogTitle)
+	}
+	meta.Description = clean(meta.Description)
+	if meta.Description == "" {
+		meta.Description = clean(ogDescription)
+	}
+	meta.SiteName = clean(meta.SiteName)
+	if meta.FaviconURL == "" && base != nil {
+		meta.FaviconURL = resolve(base, "/favicon.ico")
+	}
+	return meta
+}
+
+//	isIcon Checking the rel attribute of a link names an icon, "icon" or "shortcut icon".
+func isIcon(rel string) bool {
+	for _, value := range strings.Fields(strings.ToLower(rel)) {
+		if value == "icon" {
+			return true
+		}
+	}
+	return false
+}
+
+//	resolve Returning the absolute http(s) URL of a link of the page, "" for other links.
+func resolve(base *url.URL, ref string) string {
+	ref = strings.TrimSpace(ref)
+	if ref == "" || base == nil {
+		return ""
+	}
+	u, err := base.Parse(ref)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return ""
+	}
+	return u.String()
+}
+
+//	clean Collapsing white space of the text and cutting it to maxText bytes.
+func clean(text string) string {
+	text = strings.Join(strings.Fields(text), " ")
+	if len(text) <= maxText {
+		return text
+	}
+	text = text[:maxText]
+	for !utf8.ValidString(text) {
+		text = text[:len(text)-1]
+	}
+	return text
+}
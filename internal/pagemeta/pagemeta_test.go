@@ -0,0 +1,126 @@
+package pagemeta
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ivanmyagkov/shortener.git/internal/interfaces"
+	"github.com/ivanmyagkov/shortener.git/internal/storage"
+	"github.com/ivanmyagkov/shortener.git/internal/urlpolicy"
+)
+
+func TestParse(t *testing.T) {
+	base, _ := url.Parse("https://example.com/blog/post")
+	page := `<!DOCTYPE html><html><head>
+<meta charset="utf-8">
+<title>  A &amp; B
+  post </title>
+<meta property="og:title" content="Ignored">
+<meta property="og:description" content="Open Graph description">
+<meta property="og:image" content="/img/cover.png">
+<meta property="og:site_name" content="Example">
+<link rel="stylesheet" href="/style.css">
+<link rel="shortcut icon" href="icon.png">
+</head><body><title>Not the title</title></body></html>`
+	meta := Parse(strings.NewReader(page), base)
+	assert.Equal(t, interfaces.PageMeta{
+		Title:       "A & B post",
+		Description: "Open Graph description",
+		Image:       "https://example.com/img/cover.png",
+		SiteName:    "Example",
+		FaviconURL:  "https://example.com/blog/icon.png",
+	}, meta)
+
+	meta = Parse(strings.NewReader(`<head><meta name="description" content="Own"><meta property="og:description" content="OG">`+
+		`<meta property="og:image" content="javascript:alert(1)"></head>`), base)
+	assert.Equal(t, "Own", meta.Description)
+	assert.Empty(t, meta.Image)
+	assert.Equal(t, "https://example.com/favicon.ico", meta.FaviconURL)
+
+	meta = Parse(strings.NewReader("<title>"+strings.Repeat("я", maxText)+"</title>"), base)
+	assert.LessOrEqual(t, len(meta.Title), maxText)
+	assert.True(t, strings.HasPrefix(meta.Title, "яя"))
+}
+
+func TestFetcher(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/page", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=windows-1251")
+		// "Привет" in windows-1251
+		fmt.Fprint(w, "<html><head><title>\xcf\xf0\xe8\xe2\xe5\xf2</title></head></html>")
+	})
+	mux.HandleFunc("/moved", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/docs/page", http.StatusFound)
+	})
+	mux.HandleFunc("/docs/page", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprint(w, `<head><link rel="icon" href="favicon.svg"><title>Docs</title></head>`)
+	})
+	mux.HandleFunc("/large", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprint(w, "<head>"+strings.Repeat("<!-- padding -->", 1000)+"<title>Too far</title></head>")
+	})
+	mux.HandleFunc("/slow", func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(time.Second)
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprint(w, "<title>Slow</title>")
+	})
+	mux.HandleFunc("/image", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+	})
+	mux.HandleFunc("/missing", http.NotFound)
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	db := storage.NewDBConn()
+	f := New(ctx, db, Settings{Workers: 2, Timeout: 200 * time.Millisecond, MaxBytes: 4096})
+
+	meta, err := f.Fetch(srv.URL + "/page")
+	require.NoError(t, err)
+	assert.Equal(t, "Привет", meta.Title)
+	assert.Equal(t, srv.URL+"/favicon.ico", meta.FaviconURL)
+	assert.False(t, meta.FetchedAt.IsZero())
+
+	meta, err = f.Fetch(srv.URL + "/moved")
+	require.NoError(t, err)
+	assert.Equal(t, "Docs", meta.Title)
+	assert.Equal(t, srv.URL+"/docs/favicon.svg", meta.FaviconURL)
+
+	meta, err = f.Fetch(srv.URL + "/large")
+	require.NoError(t, err)
+	assert.Empty(t, meta.Title)
+
+	for _, path := range []string{"/slow", "/image", "/missing"} {
+		_, err = f.Fetch(srv.URL + path)
+		assert.Error(t, err, path)
+	}
+	_, err = f.Fetch("ftp://example.com/")
+	assert.Error(t, err)
+
+	// the test server listens on a loopback address
+	private := New(ctx, db, Settings{Timeout: time.Second, MaxBytes: 4096, DenyPrivate: true})
+	_, err = private.Fetch(srv.URL + "/page")
+	assert.ErrorIs(t, err, urlpolicy.ErrPrivateAddress)
+
+	require.NoError(t, db.SetShortURL("user", "code", srv.URL+"/docs/page"))
+	go f.Loop()
+	f.Enqueue(srv.URL + "/docs/page")
+	require.Eventually(t, func() bool {
+		link, err := db.GetLink("code")
+		return err == nil && link.Meta != nil
+	}, 5*time.Second, 10*time.Millisecond)
+	link, err := db.GetLink("code")
+	require.NoError(t, err)
+	assert.Equal(t, "Docs", link.Meta.Title)
+}
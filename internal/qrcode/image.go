@@ -0,0 +1,73 @@
+package qrcode
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+)
+
+//	QuietZone is the margin around a code readers expect, in modules.
+const QuietZone = 4
+
+//	Image Returning the code with the margin in modules as a black and white image
+//	of the largest whole number of pixels per module fitting in size pixels, at least one.
+func (c *Code) Image(size, margin int) *image.Paletted {
+	total := c.Size + 2*margin
+	scale := size / total
+	if scale < 1 {
+		scale = 1
+	}
+	side := total * scale
+	img := image.NewPaletted(image.Rect(0, 0, side, side), color.Palette{color.White, color.Black})
+	for y := 0; y < c.Size; y++ {
+		for x := 0; x < c.Size; x++ {
+			if !c.Black(x, y) {
+				continue
+			}
+			for py := (y + margin) * scale; py < (y+margin+1)*scale; py++ {
+				for px := (x + margin) * scale; px < (x+margin+1)*scale; px++ {
+					img.SetColorIndex(px, py, 1)
+				}
+			}
+		}
+	}
+	return img
+}
+
+//	PNG Returning the image of the code as PNG.
+func (c *Code) PNG(size, margin int) ([]byte, error) {
+	var buf bytes.Buffer
+	encoder := png.Encoder{CompressionLevel: png.BestCompression}
+	if err := encoder.Encode(&buf, c.Image(size, margin)); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+//	SVG Returning the code with the margin in modules as an SVG image of size pixels,
+//	dark modules of each row are joined in runs.
+func (c *Code) SVG(size, margin int) []byte {
+	total := c.Size + 2*margin
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" shape-rendering="crispEdges">`, size, size, total, total)
+	fmt.Fprintf(&buf, `<rect width="%d" height="%d" fill="#fff"/><path fill="#000" d="`, total, total)
+	for y := 0; y < c.Size; y++ {
+		for x := 0; x < c.Size; {
+			if !c.Black(x, y) {
+				x++
+				continue
+			}
+			run := 1
+			for c.Black(x+run, y) {
+				run++
+			}
+			fmt.Fprintf(&buf, "M%d %dh%dv1h-%dz", x+margin, y+margin, run, run)
+			x += run
+		}
+	}
+	buf.WriteString(`"/></svg>`)
+	buf.WriteByte('\n')
+	return buf.Bytes()
+}
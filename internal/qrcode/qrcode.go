@@ -0,0 +1,508 @@
+//	Package qrcode for encoding text as QR codes, model 2 in byte mode,
+//	and drawing them as PNG and SVG images.
+package qrcode
+
+import (
+	"errors"
+	"strings"
+)
+
+//	Level is the error correction level of a code, a higher one survives more damage
+//	and needs a bigger code for the same text.
+type Level int
+
+//	Error correction levels, about 7, 15, 25 and 30 percent of the code may be damaged.
+const (
+	L Level = iota
+	M
+	Q
+	H
+)
+
+//	ErrTooLong is returned for text which doesn't fit in the biggest code.
+var ErrTooLong = errors.New("text is too long for a QR code")
+
+const (
+	minVersion = 1
+	maxVersion = 40
+)
+
+//	eccPerBlock is the number of error correction codewords of each block by level and version.
+var eccPerBlock = [4][maxVersion + 1]int{
+	{0, 7, 10, 15, 20, 26, 18, 20, 24, 30, 18, 20, 24, 26, 30, 22, 24, 28, 30, 28, 28, 28, 28, 30, 30, 26, 28, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30},
+	{0, 10, 16, 26, 18, 24, 16, 18, 22, 22, 26, 30, 22, 22, 24, 24, 28, 28, 26, 26, 26, 26, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28},
+	{0, 13, 22, 18, 26, 18, 24, 18, 22, 20, 24, 28, 26, 24, 20, 30, 24, 28, 28, 26, 30, 28, 30, 30, 30, 30, 28, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30},
+	{0, 17, 28, 22, 16, 22, 28, 26, 26, 24, 28, 24, 28, 22, 24, 24, 30, 28, 28, 26, 28, 30, 24, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30},
+}
+
+//	blocks is the number of error correction blocks by level and version.
+var blocks = [4][maxVersion + 1]int{
+	{0, 1, 1, 1, 1, 1, 2, 2, 2, 2, 4, 4, 4, 4, 4, 6, 6, 6, 6, 7, 8, 8, 9, 9, 10, 12, 12, 12, 13, 14, 15, 16, 17, 18, 19, 19, 20, 21, 22, 24, 25},
+	{0, 1, 1, 1, 2, 2, 4, 4, 4, 5, 5, 5, 8, 9, 9, 10, 10, 11, 13, 14, 16, 17, 17, 18, 20, 21, 23, 25, 26, 28, 29, 31, 33, 35, 37, 38, 40, 43, 45, 47, 49},
+	{0, 1, 1, 2, 2, 4, 4, 6, 6, 8, 8, 8, 10, 12, 16, 12, 17, 16, 18, 21, 20, 23, 23, 25, 27, 29, 34, 34, 35, 38, 40, 43, 45, 48, 51, 53, 56, 59, 62, 65, 68},
+	{0, 1, 1, 2, 4, 4, 4, 5, 6, 8, 8, 11, 11, 16, 16, 18, 16, 19, 21, 25, 25, 25, 34, 30, 32, 35, 37, 40, 42, 45, 48, 51, 54, 57, 60, 63, 66, 70, 74, 77, 81},
+}
+
+//	formatLevel is how each level is written in the format information.
+var formatLevel = [4]int{L: 1, M: 0, Q: 3, H: 2}
+
+//	ParseLevel Returning the level by its letter, in any case.
+func ParseLevel(s string) (Level, bool) {
+	switch strings.ToUpper(s) {
+	case "L":
+		return L, true
+	case "M":
+		return M, true
+	case "Q":
+		return Q, true
+	case "H":
+		return H, true
+	}
+	return 0, false
+}
+
+//	Code is a square of dark and light modules, without the quiet zone around it.
+type Code struct {
+	// modules on each side
+	Size     int
+	modules  []bool
+	function []bool
+}
+
+//	Black Checking the module in column x and row y is dark.
+func (c *Code) Black(x, y int) bool {
+	return x >= 0 && y >= 0 && x < c.Size && y < c.Size && c.modules[y*c.Size+x]
+}
+
+//	Encode Encoding the text in the smallest code with the level,
+//	with the mask giving the fewest patterns confusing readers.
+func Encode(text string, level Level) (*Code, error) {
+	if level < L || level > H {
+		return nil, errors.New("unknown error correction level")
+	}
+	for version := minVersion; version <= maxVersion; version++ {
+		if 4+countBits(version)+8*len(text) <= 8*dataCodewords(version, level) {
+			return encode(text, level, version, -1), nil
+		}
+	}
+	return nil, ErrTooLong
+}
+
+//	encode Encoding the text in the code of the version, with the mask or with the best one if the mask is -1.
+func encode(text string, level Level, version, mask int) *Code {
+	data := codewords(text, level, version)
+	c := &Code{Size: 4*version + 17}
+	c.modules = make([]bool, c.Size*c.Size)
+	c.function = make([]bool, c.Size*c.Size)
+	c.drawFunctionPatterns(version)
+	c.drawCodewords(addECC(data, level, version))
+	if mask < 0 {
+		best := 0
+		for m := 0; m < 8; m++ {
+			c.applyMask(m)
+			c.drawFormat(level, m)
+			if p := c.penalty(); m == 0 || p < best {
+				mask, best = m, p
+			}
+			// masking again restores the modules
+			c.applyMask(m)
+		}
+	}
+	c.applyMask(mask)
+	c.drawFormat(level, mask)
+	c.function = nil
+	return c
+}
+
+//	countBits Returning the length of the character count of byte mode in the version.
+func countBits(version int) int {
+	if version <= 9 {
+		return 8
+	}
+	return 16
+}
+
+//	rawModules Returning the number of modules of the version left for data and error correction.
+func rawModules(version int) int {
+	n := (16*version+128)*version + 64
+	if version >= 2 {
+		align := version/7 + 2
+		n -= (25*align-10)*align - 55
+		if version >= 7 {
+			n -= 36
+		}
+	}
+	return n
+}
+
+//	dataCodewords Returning the number of data codewords of the version and the level.
+func dataCodewords(version int, level Level) int {
+	return rawModules(version)/8 - eccPerBlock[level][version]*blocks[level][version]
+}
+
+//	codewords Returning data codewords of the text: the mode, the count, the bytes and the padding.
+func codewords(text string, level Level, version int) []byte {
+	capacity := dataCodewords(version, level)
+	var b bitBuffer
+	b.append(0x4, 4)
+	b.append(len(text), countBits(version))
+	for i := 0; i < len(text); i++ {
+		b.append(int(text[i]), 8)
+	}
+	terminator := 8*capacity - b.len
+	if terminator > 4 {
+		terminator = 4
+	}
+	b.append(0, terminator)
+	b.append(0, (8-b.len%8)%8)
+	for pad := 0xEC; len(b.bytes) < capacity; pad ^= 0xEC ^ 0x11 {
+		b.append(pad, 8)
+	}
+	return b.bytes
+}
+
+//	addECC Splitting data codewords into blocks, adding error correction to each of them
+//	and interleaving the blocks.
+func addECC(data []byte, level Level, version int) []byte {
+	numBlocks := blocks[level][version]
+	eccLen := eccPerBlock[level][version]
+	raw := rawModules(version) / 8
+	numShort := numBlocks - raw%numBlocks
+	shortLen := raw / numBlocks
+	divisor := rsDivisor(eccLen)
+
+	dataBlocks := make([][]byte, numBlocks)
+	eccBlocks := make([][]byte, numBlocks)
+	k := 0
+	for i := 0; i < numBlocks; i++ {
+		n := shortLen - eccLen
+		if i >= numShort {
+			n++
+		}
+		dataBlocks[i] = data[k : k+n]
+		eccBlocks[i] = rsRemainder(dataBlocks[i], divisor)
+		k += n
+	}
+	result := make([]byte, 0, raw)
+	for i := 0; i <= shortLen-eccLen; i++ {
+		for _, block := range dataBlocks {
+			if i < len(block) {
+				result = append(result, block[i])
+			}
+		}
+	}
+	for i := 0; i < eccLen; i++ {
+		for _, block := range eccBlocks {
+			result = append(result, block[i])
+		}
+	}
+	return result
+}
+
+func (c *Code) set(x, y int, dark bool) {
+	c.modules[y*c.Size+x] = dark
+	c.function[y*c.Size+x] = true
+}
+
+//	drawFunctionPatterns Drawing finder, alignment and timing patterns, the version information
+//	and reserving the place of the format information.
+func (c *Code) drawFunctionPatterns(version int) {
+	for i := 0; i < c.Size; i++ {
+		c.set(6, i, i%2 == 0)
+		c.set(i, 6, i%2 == 0)
+	}
+	c.drawFinder(3, 3)
+	c.drawFinder(c.Size-4, 3)
+	c.drawFinder(3, c.Size-4)
+
+	positions := alignmentPositions(version, c.Size)
+	last := len(positions) - 1
+	for i, x := range positions {
+		for j, y := range positions {
+			// the corners are taken by finder patterns
+			if i == 0 && j == 0 || i == 0 && j == last || i == last && j == 0 {
+				continue
+			}
+			for dy := -2; dy <= 2; dy++ {
+				for dx := -2; dx <= 2; dx++ {
+					c.set(x+dx, y+dy, max(abs(dx), abs(dy)) != 1)
+				}
+			}
+		}
+	}
+
+	c.drawFormat(L, 0)
+	if version >= 7 {
+		rem := version
+		for i := 0; i < 12; i++ {
+			rem = rem<<1 ^ (rem>>11)*0x1F25
+		}
+		bits := version<<12 | rem
+		for i := 0; i < 18; i++ {
+			a, b := c.Size-11+i%3, i/3
+			dark := bits>>i&1 != 0
+			c.set(a, b, dark)
+			c.set(b, a, dark)
+		}
+	}
+}
+
+//	drawFinder Drawing a finder pattern with its separator around the center.
+func (c *Code) drawFinder(x, y int) {
+	for dy := -4; dy <= 4; dy++ {
+		for dx := -4; dx <= 4; dx++ {
+			xx, yy := x+dx, y+dy
+			if xx < 0 || yy < 0 || xx >= c.Size || yy >= c.Size {
+				continue
+			}
+			d := max(abs(dx), abs(dy))
+			c.set(xx, yy, d != 2 && d != 4)
+		}
+	}
+}
+
+//	alignmentPositions Returning coordinates of centers of alignment patterns of the version.
+func alignmentPositions(version, size int) []int {
+	if version == 1 {
+		return nil
+	}
+	n := version/7 + 2
+	step := (version*8 + n*3 + 5) / (n*4 - 4) * 2
+	positions := make([]int, n)
+	positions[0] = 6
+	for i, pos := n-1, size-7; i >= 1; i, pos = i-1, pos-step {
+		positions[i] = pos
+	}
+	return positions
+}
+
+//	drawFormat Drawing both copies of the format information of the level and the mask.
+func (c *Code) drawFormat(level Level, mask int) {
+	data := formatLevel[level]<<3 | mask
+	rem := data
+	for i := 0; i < 10; i++ {
+		rem = rem<<1 ^ (rem>>9)*0x537
+	}
+	bits := (data<<10 | rem) ^ 0x5412
+	bit := func(i int) bool { return bits>>i&1 != 0 }
+
+	for i := 0; i <= 5; i++ {
+		c.set(8, i, bit(i))
+	}
+	c.set(8, 7, bit(6))
+	c.set(8, 8, bit(7))
+	c.set(7, 8, bit(8))
+	for i := 9; i < 15; i++ {
+		c.set(14-i, 8, bit(i))
+	}
+
+	for i := 0; i < 8; i++ {
+		c.set(c.Size-1-i, 8, bit(i))
+	}
+	for i := 8; i < 15; i++ {
+		c.set(8, c.Size-15+i, bit(i))
+	}
+	// the dark module
+	c.set(8, c.Size-8, true)
+}
+
+//	drawCodewords Placing codewords in pairs of columns from the bottom right corner,
+//	going up and down in turn and skipping function patterns.
+func (c *Code) drawCodewords(data []byte) {
+	i := 0
+	for right := c.Size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			// the vertical timing pattern
+			right = 5
+		}
+		upward := (right+1)&2 == 0
+		for vert := 0; vert < c.Size; vert++ {
+			for j := 0; j < 2; j++ {
+				x, y := right-j, vert
+				if upward {
+					y = c.Size - 1 - vert
+				}
+				if !c.function[y*c.Size+x] && i < len(data)*8 {
+					c.modules[y*c.Size+x] = data[i>>3]>>(7-i&7)&1 != 0
+					i++
+				}
+			}
+		}
+	}
+}
+
+//	applyMask Inverting data modules selected by the mask, applying it twice undoes it.
+func (c *Code) applyMask(mask int) {
+	for y := 0; y < c.Size; y++ {
+		for x := 0; x < c.Size; x++ {
+			var invert bool
+			switch mask {
+			case 0:
+				invert = (x+y)%2 == 0
+			case 1:
+				invert = y%2 == 0
+			case 2:
+				invert = x%3 == 0
+			case 3:
+				invert = (x+y)%3 == 0
+			case 4:
+				invert = (x/3+y/2)%2 == 0
+			case 5:
+				invert = x*y%2+x*y%3 == 0
+			case 6:
+				invert = (x*y%2+x*y%3)%2 == 0
+			case 7:
+				invert = ((x+y)%2+x*y%3)%2 == 0
+			}
+			if invert && !c.function[y*c.Size+x] {
+				c.modules[y*c.Size+x] = !c.modules[y*c.Size+x]
+			}
+		}
+	}
+}
+
+//	Weights of the penalty rules.
+const (
+	penaltyRun    = 3
+	penaltyBox    = 3
+	penaltyFinder = 40
+	penaltyRatio  = 10
+)
+
+//	finderLike are patterns which readers may take for a finder pattern.
+var finderLike = [2][11]bool{
+	{true, false, true, true, true, false, true, false, false, false, false},
+	{false, false, false, false, true, false, true, true, true, false, true},
+}
+
+//	penalty Scoring patterns of the code which make it harder to read, lower is better.
+func (c *Code) penalty() int {
+	result := 0
+	for i := 0; i < c.Size; i++ {
+		row := func(j int) bool { return c.Black(j, i) }
+		column := func(j int) bool { return c.Black(i, j) }
+		result += c.linePenalty(row) + c.linePenalty(column)
+	}
+	for y := 0; y < c.Size-1; y++ {
+		for x := 0; x < c.Size-1; x++ {
+			dark := c.Black(x, y)
+			if dark == c.Black(x+1, y) && dark == c.Black(x, y+1) && dark == c.Black(x+1, y+1) {
+				result += penaltyBox
+			}
+		}
+	}
+	dark := 0
+	for _, module := range c.modules {
+		if module {
+			dark++
+		}
+	}
+	total := len(c.modules)
+	k := (abs(dark*20-total*10)+total-1)/total - 1
+	return result + k*penaltyRatio
+}
+
+//	linePenalty Scoring runs of modules of the same color and finder-like patterns in a row or a column.
+func (c *Code) linePenalty(module func(int) bool) int {
+	result := 0
+	run := 1
+	for j := 1; j <= c.Size; j++ {
+		if j < c.Size && module(j) == module(j-1) {
+			run++
+			continue
+		}
+		if run >= 5 {
+			result += penaltyRun + run - 5
+		}
+		run = 1
+	}
+	for j := 0; j+11 <= c.Size; j++ {
+		for _, pattern := range finderLike {
+			matches := true
+			for k, dark := range pattern {
+				if module(j+k) != dark {
+					matches = false
+					break
+				}
+			}
+			if matches {
+				result += penaltyFinder
+			}
+		}
+	}
+	return result
+}
+
+//	bitBuffer collects bits from the most significant one.
+type bitBuffer struct {
+	bytes []byte
+	len   int
+}
+
+func (b *bitBuffer) append(value, n int) {
+	for i := n - 1; i >= 0; i-- {
+		if b.len%8 == 0 {
+			b.bytes = append(b.bytes, 0)
+		}
+		if value>>i&1 != 0 {
+			b.bytes[b.len/8] |= 0x80 >> (b.len % 8)
+		}
+		b.len++
+	}
+}
+
+//	rsDivisor Returning the Reed-Solomon generator polynomial of the degree,
+//	coefficients from the highest power without the leading 1.
+func rsDivisor(degree int) []byte {
+	result := make([]byte, degree)
+	result[degree-1] = 1
+	root := byte(1)
+	for i := 0; i < degree; i++ {
+		for j := range result {
+			result[j] = gfMultiply(result[j], root)
+			if j+1 < len(result) {
+				result[j] ^= result[j+1]
+			}
+		}
+		root = gfMultiply(root, 0x02)
+	}
+	return result
+}
+
+//	rsRemainder Returning error correction codewords of the data.
+func rsRemainder(data, divisor []byte) []byte {
+	result := make([]byte, len(divisor))
+	for _, b := range data {
+		factor := b ^ result[0]
+		copy(result, result[1:])
+		result[len(result)-1] = 0
+		for i := range result {
+			result[i] ^= gfMultiply(divisor[i], factor)
+		}
+	}
+	return result
+}
+
+//	gfMultiply Multiplying in GF(2^8) modulo x^8 + x^4 + x^3 + x^2 + 1.
+func gfMultiply(x, y byte) byte {
+	z := 0
+	for i := 7; i >= 0; i-- {
+		z = z<<1 ^ (z>>7)*0x11D
+		z ^= int(y>>i&1) * int(x)
+	}
+	return byte(z)
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+func max(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
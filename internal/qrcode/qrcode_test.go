@@ -0,0 +1,123 @@
+package qrcode
+
+import (
+	"bytes"
+	"image/png"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRSRemainder(t *testing.T) {
+	// "HELLO WORLD" in alphanumeric mode, version 1-M
+	data := []byte{32, 91, 11, 120, 209, 114, 220, 77, 67, 64, 236, 17, 236, 17, 236, 17}
+	assert.Equal(t, []byte{196, 35, 39, 119, 235, 215, 231, 226, 93, 23}, rsRemainder(data, rsDivisor(10)))
+}
+
+func TestEncode_Capacity(t *testing.T) {
+	tests := []struct {
+		level  Level
+		length int
+		size   int
+	}{
+		{L, 17, 21},
+		{L, 18, 25},
+		{M, 14, 21},
+		{Q, 11, 21},
+		{H, 7, 21},
+		{H, 8, 25},
+		{M, 26, 25},
+		{L, 2953, 177},
+	}
+	for _, tt := range tests {
+		code, err := Encode(strings.Repeat("a", tt.length), tt.level)
+		require.NoError(t, err)
+		assert.Equal(t, tt.size, code.Size, "level %d, length %d", tt.level, tt.length)
+	}
+	_, err := Encode(strings.Repeat("a", 2954), L)
+	assert.ErrorIs(t, err, ErrTooLong)
+}
+
+func TestEncode_Patterns(t *testing.T) {
+	for level := L; level <= H; level++ {
+		code, err := Encode("http://localhost:8080/0123456789abcdef", level)
+		require.NoError(t, err)
+		n := code.Size
+		for _, corner := range [][2]int{{0, 0}, {n - 7, 0}, {0, n - 7}} {
+			for i := 0; i < 7; i++ {
+				// the dark border of finder patterns
+				assert.True(t, code.Black(corner[0]+i, corner[1]))
+				assert.True(t, code.Black(corner[0], corner[1]+i))
+			}
+			assert.False(t, code.Black(corner[0]+1, corner[1]+1))
+			assert.True(t, code.Black(corner[0]+3, corner[1]+3))
+		}
+		for i := 8; i < n-8; i++ {
+			assert.Equal(t, i%2 == 0, code.Black(i, 6))
+			assert.Equal(t, i%2 == 0, code.Black(6, i))
+		}
+		assert.True(t, code.Black(8, n-8))
+
+		// both copies of the format information tell the level
+		first, second := 0, 0
+		for i := 0; i <= 5; i++ {
+			first |= bit(code.Black(8, i)) << i
+		}
+		first |= bit(code.Black(8, 7))<<6 | bit(code.Black(8, 8))<<7 | bit(code.Black(7, 8))<<8
+		for i := 9; i < 15; i++ {
+			first |= bit(code.Black(14-i, 8)) << i
+		}
+		for i := 0; i < 8; i++ {
+			second |= bit(code.Black(n-1-i, 8)) << i
+		}
+		for i := 8; i < 15; i++ {
+			second |= bit(code.Black(8, n-15+i)) << i
+		}
+		assert.Equal(t, first, second)
+		assert.Equal(t, formatLevel[level], (first^0x5412)>>13)
+	}
+}
+
+func bit(dark bool) int {
+	if dark {
+		return 1
+	}
+	return 0
+}
+
+func TestParseLevel(t *testing.T) {
+	level, ok := ParseLevel("q")
+	assert.True(t, ok)
+	assert.Equal(t, Q, level)
+	_, ok = ParseLevel("X")
+	assert.False(t, ok)
+}
+
+func TestImages(t *testing.T) {
+	code, err := Encode("http://localhost:8080/abc", M)
+	require.NoError(t, err)
+	b, err := code.PNG(200, QuietZone)
+	require.NoError(t, err)
+	img, err := png.Decode(bytes.NewReader(b))
+	require.NoError(t, err)
+	total := code.Size + 2*QuietZone
+	scale := 200 / total
+	assert.Equal(t, total*scale, img.Bounds().Dx())
+	for y := 0; y < total; y++ {
+		for x := 0; x < total; x++ {
+			r, _, _, _ := img.At(x*scale, y*scale).RGBA()
+			assert.Equal(t, code.Black(x-QuietZone, y-QuietZone), r == 0, "module %d,%d", x, y)
+		}
+	}
+	// too small a size still gives a pixel per module
+	img = code.Image(10, 0)
+	assert.Equal(t, code.Size, img.Bounds().Dx())
+
+	svg := string(code.SVG(300, 2))
+	assert.True(t, strings.HasPrefix(svg, `<svg xmlns="http://www.w3.org/2000/svg" width="300" height="300"`))
+	assert.Contains(t, svg, `viewBox="0 0 29 29"`)
+	// the top row of finder patterns is one run each
+	assert.Contains(t, svg, "M2 2h7v1h-7z")
+}
@@ -0,0 +1,97 @@
+package ratelimit
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/ivanmyagkov/shortener.git/internal/interfaces"
+)
+
+//	sweepInterval is how often full buckets are removed from memory.
+const sweepInterval = time.Minute
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+	limit  interfaces.RateLimit
+}
+
+//	MemoryStore keeps token buckets in memory of one instance.
+type MemoryStore struct {
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	swept   time.Time
+	now     func() time.Time
+}
+
+//	NewMemoryStore is function to create token bucket storage in memory.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		buckets: make(map[string]*bucket),
+		swept:   time.Now(),
+		now:     time.Now,
+	}
+}
+
+//	Take Taking cost tokens from the bucket of the key.
+func (m *MemoryStore) Take(key string, cost int, limit interfaces.RateLimit) (bool, time.Duration, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := m.now()
+	if now.Sub(m.swept) > sweepInterval {
+		m.sweep(now)
+	}
+
+	b, ok := m.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(limit.Burst), last: now}
+		m.buckets[key] = b
+	}
+	b.limit = limit
+	b.tokens = refill(b.tokens, now.Sub(b.last), limit)
+	b.last = now
+
+	if b.tokens >= float64(cost) {
+		b.tokens -= float64(cost)
+		return true, 0, nil
+	}
+	return false, retryAfter(b.tokens, cost, limit), nil
+}
+
+//	Refund Returning cost tokens to the bucket of the key.
+func (m *MemoryStore) Refund(key string, cost int, limit interfaces.RateLimit) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	b, ok := m.buckets[key]
+	if !ok {
+		// swept buckets are full
+		return nil
+	}
+	now := m.now()
+	b.tokens = math.Min(float64(limit.Burst), refill(b.tokens, now.Sub(b.last), limit)+float64(cost))
+	b.last = now
+	return nil
+}
+
+//	sweep Removing buckets which are full again, they are the same as new ones.
+func (m *MemoryStore) sweep(now time.Time) {
+	for key, b := range m.buckets {
+		if refill(b.tokens, now.Sub(b.last), b.limit) >= float64(b.limit.Burst) {
+			delete(m.buckets, key)
+		}
+	}
+	m.swept = now
+}
+
+func refill(tokens float64, elapsed time.Duration, limit interfaces.RateLimit) float64 {
+	return math.Min(float64(limit.Burst), tokens+elapsed.Seconds()*limit.Rate)
+}
+
+//	retryAfter Time until the bucket has cost tokens.
+func retryAfter(tokens float64, cost int, limit interfaces.RateLimit) time.Duration {
+	if limit.Rate <= 0 || cost > limit.Burst {
+		return time.Duration(math.MaxInt64)
+	}
+	return time.Duration((float64(cost) - tokens) / limit.Rate * float64(time.Second))
+}
@@ -0,0 +1,268 @@
+//	Package ratelimit for limiting request rates per user, per client IP, per link and per account.
+package ratelimit
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/ivanmyagkov/shortener.git/internal/interfaces"
+	"github.com/ivanmyagkov/shortener.git/internal/middleware"
+)
+
+//	Classes of routes with separate budgets.
+const (
+	ClassCreate   = "create"
+	ClassRedirect = "redirect"
+	ClassDelete   = "delete"
+	// password attempts of protected links
+	ClassUnlock = "unlock"
+	// registration, logins and API keys
+	ClassAccount = "account"
+	// failed logins of an account
+	ClassLogin = "login"
+)
+
+//	CostFunc tells how many tokens a request takes.
+type CostFunc func(c echo.Context) int
+
+type Limiter struct {
+	mu     sync.RWMutex
+	store  interfaces.RateStore
+	users  interfaces.Users
+	limits map[string]interfaces.RateLimit
+}
+
+//	New is function to create a limiter with limits per route class.
+func New(store interfaces.RateStore, users interfaces.Users, limits map[string]interfaces.RateLimit) *Limiter {
+	l := &Limiter{
+		store: store,
+		users: users,
+	}
+	l.SetLimits(limits)
+	return l
+}
+
+//	SetLimits Replacing limits of route classes, a class without limit is not limited.
+func (l *Limiter) SetLimits(limits map[string]interfaces.RateLimit) {
+	copied := make(map[string]interfaces.RateLimit, len(limits))
+	for class, limit := range limits {
+		copied[class] = limit
+	}
+	l.mu.Lock()
+	l.limits = copied
+	l.mu.Unlock()
+}
+
+func (l *Limiter) limit(class string) (interfaces.RateLimit, bool) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	limit, ok := l.limits[class]
+	return limit, ok && limit.Burst > 0
+}
+
+//	Limit - Intermediate function limiting the route class by the session user and by the client IP.
+//	cost may be nil, then every request takes one token. A rejected request takes no tokens.
+//	The limit fails open: a bucket whose store fails is skipped and the error is only logged,
+//	so an unavailable store doesn't take the routes down with it.
+func (l *Limiter) Limit(class string, cost CostFunc) echo.MiddlewareFunc {
+	return l.limitBy(class, cost, l.userKey)
+}
+
+//	LimitLink - Intermediate function limiting the route class by the client IP and by the link
+//	of the id parameter, so that guesses at one link are limited however many addresses they come from.
+func (l *Limiter) LimitLink(class string) echo.MiddlewareFunc {
+	return l.limitBy(class, nil, linkKey)
+}
+
+//	limitBy Limiting the route class by the client IP and by the bucket of the key, if there is one.
+func (l *Limiter) limitBy(class string, cost CostFunc, key func(c echo.Context) (string, bool)) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			limit, ok := l.limit(class)
+			if !ok {
+				return next(c)
+			}
+			n := 1
+			if cost != nil {
+				n = cost(c)
+			}
+			if n > limit.Burst {
+				return c.NoContent(http.StatusRequestEntityTooLarge)
+			}
+
+			keys := []string{class + ":ip:" + c.RealIP()}
+			if k, ok := key(c); ok {
+				keys = append(keys, class+":"+k)
+			}
+			taken := make([]string, 0, len(keys))
+			for _, key := range keys {
+				allowed, wait, err := l.store.Take(key, n, limit)
+				if err != nil {
+					log.Println(err)
+					continue
+				}
+				if !allowed {
+					l.refund(taken, n, limit)
+					return tooMany(c, wait)
+				}
+				taken = append(taken, key)
+			}
+			return next(c)
+		}
+	}
+}
+
+//	LimitLogin - Intermediate function limiting failed logins to the account of the login in the request body,
+//	so that guesses at one account are limited however many addresses they come from.
+//	A successful login gives its token back, only failed ones use up the budget of the account.
+func (l *Limiter) LimitLogin(class string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			limit, ok := l.limit(class)
+			if !ok {
+				return next(c)
+			}
+			login, ok := loginKey(c)
+			if !ok {
+				return next(c)
+			}
+			key := class + ":" + login
+			allowed, wait, err := l.store.Take(key, 1, limit)
+			if err != nil {
+				log.Println(err)
+				return next(c)
+			}
+			if !allowed {
+				return tooMany(c, wait)
+			}
+			err = next(c)
+			if status := c.Response().Status; status >= 200 && status <= 299 {
+				l.refund([]string{key}, 1, limit)
+			}
+			return err
+		}
+	}
+}
+
+//	tooMany Rejecting the request, telling when the bucket has tokens again.
+func tooMany(c echo.Context, wait time.Duration) error {
+	seconds := int64(math.Ceil(wait.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	c.Response().Header().Set("Retry-After", strconv.FormatInt(seconds, 10))
+	return c.NoContent(http.StatusTooManyRequests)
+}
+
+//	userKey Returning the bucket of the session user.
+func (l *Limiter) userKey(c echo.Context) (string, bool) {
+	if userID, ok := middleware.UserID(c); ok {
+		return "user:" + userID, true
+	}
+	if cookie, err := c.Cookie("cookie"); err == nil {
+		if userID, err := l.users.ReadSessionID(cookie.Value); err == nil {
+			return "user:" + userID, true
+		}
+	}
+	return "", false
+}
+
+//	linkKey Returning the bucket of the link, codes are scoped per domain.
+func linkKey(c echo.Context) (string, bool) {
+	return "link:" + c.Request().Host + "/" + c.Param("id"), true
+}
+
+//	loginKey Returning the bucket of the account of the login in the request body, the body is kept for the handler.
+func loginKey(c echo.Context) (string, bool) {
+	body, err := io.ReadAll(c.Request().Body)
+	if err != nil {
+		return "", false
+	}
+	c.Request().Body = io.NopCloser(bytes.NewReader(body))
+	var credentials struct {
+		Login string `json:"login"`
+	}
+	if err = json.Unmarshal(body, &credentials); err != nil {
+		return "", false
+	}
+	login := strings.TrimSpace(credentials.Login)
+	if login == "" {
+		return "", false
+	}
+	return "account:" + login, true
+}
+
+//	refund Returning tokens of a rejected request to the buckets it was taken from.
+func (l *Limiter) refund(keys []string, cost int, limit interfaces.RateLimit) {
+	for _, key := range keys {
+		if err := l.store.Refund(key, cost, limit); err != nil {
+			log.Println(err)
+		}
+	}
+}
+
+//	BatchCost takes one token per URL of a batch request.
+func BatchCost(c echo.Context) int {
+	body, err := io.ReadAll(c.Request().Body)
+	if err != nil {
+		return 1
+	}
+	c.Request().Body = io.NopCloser(bytes.NewReader(body))
+	var batch []json.RawMessage
+	if err = json.Unmarshal(body, &batch); err != nil || len(batch) == 0 {
+		return 1
+	}
+	return len(batch)
+}
+
+//	ParseLimit Parsing a limit like "60/m:120": 60 requests per minute with bursts up to 120.
+//	Without burst it equals the number of requests, an empty string means no limit.
+func ParseLimit(s string) (interfaces.RateLimit, error) {
+	var limit interfaces.RateLimit
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return limit, nil
+	}
+	rate, burst := s, ""
+	if i := strings.IndexByte(s, ':'); i >= 0 {
+		rate, burst = s[:i], s[i+1:]
+	}
+	parts := strings.SplitN(rate, "/", 2)
+	if len(parts) != 2 {
+		return limit, fmt.Errorf("invalid rate limit %q", s)
+	}
+	n, err := strconv.ParseFloat(parts[0], 64)
+	if err != nil || n < 0 {
+		return limit, fmt.Errorf("invalid rate limit %q", s)
+	}
+	var unit time.Duration
+	switch parts[1] {
+	case "s":
+		unit = time.Second
+	case "m":
+		unit = time.Minute
+	case "h":
+		unit = time.Hour
+	default:
+		return limit, fmt.Errorf("invalid rate limit unit %q", parts[1])
+	}
+	limit.Rate = n / unit.Seconds()
+	limit.Burst = int(math.Ceil(n))
+	if burst != "" {
+		if limit.Burst, err = strconv.Atoi(burst); err != nil || limit.Burst < 0 {
+			return limit, fmt.Errorf("invalid rate limit burst %q", burst)
+		}
+	}
+	return limit, nil
+}
@@ -0,0 +1,241 @@
+package ratelimit
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ivanmyagkov/shortener.git/internal/interfaces"
+	"github.com/ivanmyagkov/shortener.git/internal/keys"
+	"github.com/ivanmyagkov/shortener.git/internal/storage"
+)
+
+// the test cookie is a session of the old format, encrypted with the formerly hardcoded key
+var testKeys, _ = keys.NewRing([]keys.Key{{ID: "test", Secret: make([]byte, keys.Size)}}, []byte("vfktymrjqtkjxrt[jkjlyjpb"))
+
+func TestMemoryStore_Take(t *testing.T) {
+	now := time.Now()
+	m := NewMemoryStore()
+	m.now = func() time.Time { return now }
+	limit := interfaces.RateLimit{Rate: 1, Burst: 2}
+
+	for i := 0; i < 2; i++ {
+		allowed, _, err := m.Take("key", 1, limit)
+		require.NoError(t, err)
+		require.True(t, allowed)
+	}
+	allowed, wait, err := m.Take("key", 1, limit)
+	require.NoError(t, err)
+	require.False(t, allowed)
+	assert.Equal(t, time.Second, wait)
+
+	allowed, _, err = m.Take("other", 1, limit)
+	require.NoError(t, err)
+	assert.True(t, allowed)
+
+	now = now.Add(time.Second)
+	allowed, _, err = m.Take("key", 1, limit)
+	require.NoError(t, err)
+	assert.True(t, allowed)
+}
+
+func TestLimiter_Limit(t *testing.T) {
+	usr := storage.New(testKeys)
+	limiter := New(NewMemoryStore(), usr, map[string]interfaces.RateLimit{
+		ClassCreate: {Rate: 0.1, Burst: 2},
+	})
+	e := echo.New()
+	e.IPExtractor = echo.ExtractIPDirect()
+	ok := func(c echo.Context) error {
+		return c.NoContent(http.StatusCreated)
+	}
+	e.POST("/", ok, limiter.Limit(ClassCreate, nil))
+	e.POST("/api/shorten/batch", ok, limiter.Limit(ClassCreate, BatchCost))
+	e.GET("/:id", ok, limiter.Limit(ClassRedirect, nil))
+
+	do := func(method, target, body, ip, cookie string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		req.RemoteAddr = ip + ":1234"
+		if cookie != "" {
+			req.AddCookie(&http.Cookie{Name: "cookie", Value: cookie})
+		}
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec
+	}
+
+	user := "a07a35a622236b60753719fbc9a9ff0c"
+	require.Equal(t, http.StatusCreated, do(http.MethodPost, "/", "", "10.0.0.1", user).Code)
+	require.Equal(t, http.StatusCreated, do(http.MethodPost, "/", "", "10.0.0.1", user).Code)
+	rec := do(http.MethodPost, "/", "", "10.0.0.1", user)
+	require.Equal(t, http.StatusTooManyRequests, rec.Code)
+	assert.Equal(t, "10", rec.Header().Get("Retry-After"))
+
+	// the same user from another address is still limited by the user budget
+	assert.Equal(t, http.StatusTooManyRequests, do(http.MethodPost, "/", "", "10.0.0.2", user).Code)
+	// another user from another address has its own budget
+	assert.Equal(t, http.StatusCreated, do(http.MethodPost, "/", "", "10.0.0.3", "").Code)
+	// redirects are not limited
+	assert.Equal(t, http.StatusCreated, do(http.MethodGet, "/f845599b09851789", "", "10.0.0.1", user).Code)
+	// a batch takes a token per URL
+	batch := `[{"correlation_id":"1","original_url":"http://a.ru"},{"correlation_id":"2","original_url":"http://b.ru"},{"correlation_id":"3","original_url":"http://c.ru"}]`
+	assert.Equal(t, http.StatusRequestEntityTooLarge, do(http.MethodPost, "/api/shorten/batch", batch, "10.0.0.4", "").Code)
+}
+
+func TestLimiter_Refund(t *testing.T) {
+	usr := storage.New(testKeys)
+	limiter := New(NewMemoryStore(), usr, map[string]interfaces.RateLimit{
+		ClassCreate: {Rate: 0.1, Burst: 2},
+	})
+	e := echo.New()
+	e.IPExtractor = echo.ExtractIPDirect()
+	e.POST("/", func(c echo.Context) error {
+		return c.NoContent(http.StatusCreated)
+	}, limiter.Limit(ClassCreate, nil))
+	do := func(ip, cookie string) int {
+		req := httptest.NewRequest(http.MethodPost, "/", nil)
+		req.RemoteAddr = ip + ":1234"
+		if cookie != "" {
+			req.AddCookie(&http.Cookie{Name: "cookie", Value: cookie})
+		}
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	user := "a07a35a622236b60753719fbc9a9ff0c"
+	require.Equal(t, http.StatusCreated, do("10.0.0.1", user))
+	require.Equal(t, http.StatusCreated, do("10.0.0.2", user))
+	// rejected by the user budget, the address keeps its tokens
+	require.Equal(t, http.StatusTooManyRequests, do("10.0.0.3", user))
+	assert.Equal(t, http.StatusCreated, do("10.0.0.3", ""))
+	assert.Equal(t, http.StatusCreated, do("10.0.0.3", ""))
+	assert.Equal(t, http.StatusTooManyRequests, do("10.0.0.3", ""))
+}
+
+func TestLimiter_LimitLink(t *testing.T) {
+	limiter := New(NewMemoryStore(), storage.New(testKeys), map[string]interfaces.RateLimit{
+		ClassUnlock: {Rate: 0.1, Burst: 2},
+	})
+	e := echo.New()
+	e.IPExtractor = echo.ExtractIPDirect()
+	e.POST("/:id", func(c echo.Context) error {
+		return c.NoContent(http.StatusUnauthorized)
+	}, limiter.LimitLink(ClassUnlock))
+	do := func(ip, id string) int {
+		req := httptest.NewRequest(http.MethodPost, "/"+id, nil)
+		req.RemoteAddr = ip + ":1234"
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	require.Equal(t, http.StatusUnauthorized, do("10.0.0.1", "a"))
+	require.Equal(t, http.StatusUnauthorized, do("10.0.0.2", "a"))
+	// the link is limited whichever address guesses
+	assert.Equal(t, http.StatusTooManyRequests, do("10.0.0.3", "a"))
+	assert.Equal(t, http.StatusUnauthorized, do("10.0.0.3", "b"))
+	// and an address is limited whichever link it guesses at
+	assert.Equal(t, http.StatusUnauthorized, do("10.0.0.1", "b"))
+	assert.Equal(t, http.StatusTooManyRequests, do("10.0.0.1", "c"))
+}
+
+func TestLimiter_LimitLogin(t *testing.T) {
+	limiter := New(NewMemoryStore(), storage.New(testKeys), map[string]interfaces.RateLimit{
+		ClassLogin: {Rate: 0.1, Burst: 2},
+	})
+	e := echo.New()
+	e.IPExtractor = echo.ExtractIPDirect()
+	e.POST("/api/user/login", func(c echo.Context) error {
+		var credentials struct {
+			Login    string `json:"login"`
+			Password string `json:"password"`
+		}
+		if err := json.NewDecoder(c.Request().Body).Decode(&credentials); err != nil {
+			return c.NoContent(http.StatusBadRequest)
+		}
+		if credentials.Password != "right" {
+			return c.NoContent(http.StatusUnauthorized)
+		}
+		return c.NoContent(http.StatusOK)
+	}, limiter.LimitLogin(ClassLogin))
+	do := func(ip, login, password string) int {
+		req := httptest.NewRequest(http.MethodPost, "/api/user/login", strings.NewReader(`{"login":"`+login+`","password":"`+password+`"}`))
+		req.RemoteAddr = ip + ":1234"
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	// successful logins don't use up the budget of the account
+	for i := 0; i < 3; i++ {
+		require.Equal(t, http.StatusOK, do("10.0.0.1", "alice", "right"))
+	}
+	require.Equal(t, http.StatusUnauthorized, do("10.0.0.1", "alice", "wrong"))
+	require.Equal(t, http.StatusUnauthorized, do("10.0.0.2", "alice", "wrong"))
+	// the account is limited whichever address guesses, even with the right password
+	assert.Equal(t, http.StatusTooManyRequests, do("10.0.0.3", "alice", "wrong"))
+	assert.Equal(t, http.StatusTooManyRequests, do("10.0.0.3", " alice", "right"))
+	assert.Equal(t, http.StatusUnauthorized, do("10.0.0.3", "bob", "wrong"))
+}
+
+// failingStore fails every call.
+type failingStore struct{}
+
+func (failingStore) Take(string, int, interfaces.RateLimit) (bool, time.Duration, error) {
+	return false, 0, errors.New("unavailable")
+}
+
+func (failingStore) Refund(string, int, interfaces.RateLimit) error {
+	return errors.New("unavailable")
+}
+
+func TestLimiter_FailOpen(t *testing.T) {
+	limiter := New(failingStore{}, storage.New(testKeys), map[string]interfaces.RateLimit{
+		ClassCreate: {Rate: 0.1, Burst: 1},
+	})
+	e := echo.New()
+	e.POST("/", func(c echo.Context) error {
+		return c.NoContent(http.StatusCreated)
+	}, limiter.Limit(ClassCreate, nil))
+	for i := 0; i < 3; i++ {
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/", nil))
+		assert.Equal(t, http.StatusCreated, rec.Code)
+	}
+}
+
+func TestParseLimit(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    interfaces.RateLimit
+		wantErr bool
+	}{
+		{value: "", want: interfaces.RateLimit{}},
+		{value: "10/s", want: interfaces.RateLimit{Rate: 10, Burst: 10}},
+		{value: "60/m:120", want: interfaces.RateLimit{Rate: 1, Burst: 120}},
+		{value: "3600/h:1", want: interfaces.RateLimit{Rate: 1, Burst: 1}},
+		{value: "10", wantErr: true},
+		{value: "10/d", wantErr: true},
+		{value: "10/s:x", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := ParseLimit(tt.value)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
@@ -0,0 +1,98 @@
+//	Package redirect for building redirects of short URLs by their options.
+package redirect
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/ivanmyagkov/shortener.git/internal/interfaces"
+)
+
+//	CodeInvalidRedirect is the code of rejected redirect options.
+const CodeInvalidRedirect = "invalid_redirect"
+
+//	UTMParams are the UTM parameters a short URL may add.
+var UTMParams = []string{"utm_source", "utm_medium", "utm_campaign", "utm_term", "utm_content"}
+
+//	Validate Checking redirect options.
+func Validate(r interfaces.Redirect) error {
+	switch r.StatusCode {
+	case 0, http.StatusMovedPermanently, http.StatusFound, http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+	default:
+		return &interfaces.ValidationError{Code: CodeInvalidRedirect, Message: fmt.Sprintf("status code %d is not 301, 302, 307 or 308", r.StatusCode)}
+	}
+	for name, value := range r.UTM {
+		if !isUTM(name) {
+			return &interfaces.ValidationError{Code: CodeInvalidRedirect, Message: fmt.Sprintf("%q is not one of %s", name, strings.Join(UTMParams, ", "))}
+		}
+		if value == "" {
+			return &interfaces.ValidationError{Code: CodeInvalidRedirect, Message: fmt.Sprintf("%q has no value", name)}
+		}
+	}
+	return nil
+}
+
+func isUTM(name string) bool {
+	for _, param := range UTMParams {
+		if param == name {
+			return true
+		}
+	}
+	return false
+}
+
+//	StatusCode Returning the status of the redirect, 307 by default.
+func StatusCode(r interfaces.Redirect) int {
+	if r.StatusCode == 0 {
+		return http.StatusTemporaryRedirect
+	}
+	return r.StatusCode
+}
+
+//	Location Returning the URL to redirect to from the original URL
+//	and the raw query the short URL was opened with.
+func Location(baseURL string, r interfaces.Redirect, rawQuery string) string {
+	if !r.ForwardQuery && len(r.UTM) == 0 && !r.DropFragment {
+		return baseURL
+	}
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		// original URLs are checked before they are saved
+		return baseURL
+	}
+	query := u.RawQuery
+	if r.ForwardQuery && rawQuery != "" {
+		query = join(query, rawQuery)
+	}
+	if len(r.UTM) > 0 {
+		have, _ := url.ParseQuery(query)
+		names := make([]string, 0, len(r.UTM))
+		for name := range r.UTM {
+			if _, ok := have[name]; !ok {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			query = join(query, url.QueryEscape(name)+"="+url.QueryEscape(r.UTM[name]))
+		}
+	}
+	u.RawQuery = query
+	if r.DropFragment {
+		// browsers keep the fragment of the short URL only if the location has none,
+		// the server never sees it
+		u.Fragment = ""
+		u.RawFragment = ""
+	}
+	return u.String()
+}
+
+func join(query, params string) string {
+	if query == "" {
+		return params
+	}
+	return query + "&" + params
+}
@@ -0,0 +1,79 @@
+package redirect
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/ivanmyagkov/shortener.git/internal/interfaces"
+)
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name     string
+		redirect interfaces.Redirect
+		wantErr  bool
+	}{
+		{name: "default", redirect: interfaces.Redirect{}},
+		{name: "permanent", redirect: interfaces.Redirect{StatusCode: http.StatusMovedPermanently}},
+		{name: "utm", redirect: interfaces.Redirect{UTM: map[string]string{"utm_source": "mail", "utm_campaign": "spring"}}},
+		{name: "not a redirect", redirect: interfaces.Redirect{StatusCode: http.StatusOK}, wantErr: true},
+		{name: "see other", redirect: interfaces.Redirect{StatusCode: http.StatusSeeOther}, wantErr: true},
+		{name: "unknown parameter", redirect: interfaces.Redirect{UTM: map[string]string{"ref": "mail"}}, wantErr: true},
+		{name: "empty value", redirect: interfaces.Redirect{UTM: map[string]string{"utm_source": ""}}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Validate(tt.redirect)
+			if !tt.wantErr {
+				assert.NoError(t, err)
+				return
+			}
+			var validationErr *interfaces.ValidationError
+			if assert.True(t, errors.As(err, &validationErr)) {
+				assert.Equal(t, CodeInvalidRedirect, validationErr.Code)
+			}
+		})
+	}
+}
+
+func TestLocation(t *testing.T) {
+	tests := []struct {
+		name     string
+		baseURL  string
+		redirect interfaces.Redirect
+		query    string
+		want     string
+	}{
+		{name: "no options", baseURL: "https://a.example/p?x=1#top", query: "y=2", want: "https://a.example/p?x=1#top"},
+		{name: "query is not forwarded", baseURL: "https://a.example/p", redirect: interfaces.Redirect{StatusCode: http.StatusFound}, query: "y=2", want: "https://a.example/p"},
+		{name: "forward query", baseURL: "https://a.example/p?x=1#top", redirect: interfaces.Redirect{ForwardQuery: true}, query: "y=2&z=%20", want: "https://a.example/p?x=1&y=2&z=%20#top"},
+		{name: "forward empty query", baseURL: "https://a.example/p", redirect: interfaces.Redirect{ForwardQuery: true}, want: "https://a.example/p"},
+		{
+			name:     "utm sorted",
+			baseURL:  "https://a.example/p",
+			redirect: interfaces.Redirect{UTM: map[string]string{"utm_source": "mail", "utm_campaign": "spring sale"}},
+			want:     "https://a.example/p?utm_campaign=spring+sale&utm_source=mail",
+		},
+		{
+			name:     "utm of the request wins",
+			baseURL:  "https://a.example/p?utm_medium=banner",
+			redirect: interfaces.Redirect{ForwardQuery: true, UTM: map[string]string{"utm_source": "mail", "utm_medium": "email"}},
+			query:    "utm_source=friend",
+			want:     "https://a.example/p?utm_medium=banner&utm_source=friend",
+		},
+		{name: "drop fragment", baseURL: "https://a.example/p?x=1#top", redirect: interfaces.Redirect{DropFragment: true}, want: "https://a.example/p?x=1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, Location(tt.baseURL, tt.redirect, tt.query))
+		})
+	}
+}
+
+func TestStatusCode(t *testing.T) {
+	assert.Equal(t, http.StatusTemporaryRedirect, StatusCode(interfaces.Redirect{}))
+	assert.Equal(t, http.StatusMovedPermanently, StatusCode(interfaces.Redirect{StatusCode: http.StatusMovedPermanently}))
+}
@@ -0,0 +1,79 @@
+//	Package reload for replacing parts of the running server when settings are reloaded.
+package reload
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+
+	"github.com/ivanmyagkov/shortener.git/internal/interfaces"
+)
+
+//	Canonicalizer passes URLs to the current canonicalizer, which may be replaced at any time.
+type Canonicalizer struct {
+	v atomic.Value
+}
+
+type canonicalizerBox struct {
+	interfaces.Canonicalizer
+}
+
+//	NewCanonicalizer is function to create a replaceable canonicalizer.
+func NewCanonicalizer(c interfaces.Canonicalizer) *Canonicalizer {
+	r := &Canonicalizer{}
+	r.Store(c)
+	return r
+}
+
+//	Store Replacing the canonicalizer.
+func (r *Canonicalizer) Store(c interfaces.Canonicalizer) {
+	r.v.Store(canonicalizerBox{c})
+}
+
+//	Canonicalize Bringing the URL to the canonical form with the current canonicalizer.
+func (r *Canonicalizer) Canonicalize(rawURL string) (string, error) {
+	return r.v.Load().(canonicalizerBox).Canonicalize(rawURL)
+}
+
+//	Validator passes URLs to the current validator, which may be replaced at any time.
+type Validator struct {
+	v atomic.Value
+}
+
+type validatorBox struct {
+	interfaces.URLValidator
+}
+
+//	NewValidator is function to create a replaceable validator.
+func NewValidator(v interfaces.URLValidator) *Validator {
+	r := &Validator{}
+	r.Store(v)
+	return r
+}
+
+//	Store Replacing the validator.
+func (r *Validator) Store(v interfaces.URLValidator) {
+	r.v.Store(validatorBox{v})
+}
+
+//	Validate Checking the URL with the current validator.
+func (r *Validator) Validate(rawURL string) error {
+	return r.v.Load().(validatorBox).Validate(rawURL)
+}
+
+//	OnHangup Calling fn on every SIGHUP until the context is done.
+func OnHangup(ctx context.Context, fn func()) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			fn()
+		}
+	}
+}
@@ -0,0 +1,34 @@
+package reload
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type upper struct{}
+
+func (upper) Canonicalize(rawURL string) (string, error) {
+	return strings.ToUpper(rawURL), nil
+}
+
+type lower struct{}
+
+func (lower) Canonicalize(rawURL string) (string, error) {
+	return strings.ToLower(rawURL), nil
+}
+
+func TestCanonicalizer_Store(t *testing.T) {
+	c := NewCanonicalizer(upper{})
+	got, err := c.Canonicalize("http://Ya.ru")
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP://YA.RU", got)
+
+	// implementations of different types may replace each other
+	c.Store(lower{})
+	got, err = c.Canonicalize("http://Ya.ru")
+	require.NoError(t, err)
+	assert.Equal(t, "http://ya.ru", got)
+}
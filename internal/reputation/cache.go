@@ -0,0 +1,94 @@
+package reputation
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/ivanmyagkov/shortener.git/internal/interfaces"
+)
+
+type cacheEntry struct {
+	verdict interfaces.Verdict
+	expires time.Time
+}
+
+//	Cache keeps verdicts of another checker for a while. Errors are not cached.
+type Cache struct {
+	mu      sync.Mutex
+	checker interfaces.URLChecker
+	ttl     time.Duration
+	size    int
+	entries map[string]cacheEntry
+}
+
+//	NewCache is function to cache verdicts of the checker for ttl, keeping at most size URLs.
+func NewCache(checker interfaces.URLChecker, ttl time.Duration, size int) *Cache {
+	return &Cache{
+		checker: checker,
+		ttl:     ttl,
+		size:    size,
+		entries: make(map[string]cacheEntry),
+	}
+}
+
+//	CheckURL Getting the verdict from cache or from the checker.
+func (c *Cache) CheckURL(ctx context.Context, rawURL string) (interfaces.Verdict, error) {
+	now := time.Now()
+	c.mu.Lock()
+	entry, ok := c.entries[rawURL]
+	c.mu.Unlock()
+	if ok && now.Before(entry.expires) {
+		return entry.verdict, nil
+	}
+
+	verdict, err := c.checker.CheckURL(ctx, rawURL)
+	if err != nil {
+		return verdict, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.entries) >= c.size {
+		c.evict(now)
+	}
+	c.entries[rawURL] = cacheEntry{verdict: verdict, expires: now.Add(c.ttl)}
+	return verdict, nil
+}
+
+//	evict Removing expired entries, or an arbitrary half of entries if none expired.
+func (c *Cache) evict(now time.Time) {
+	for key, entry := range c.entries {
+		if !now.Before(entry.expires) {
+			delete(c.entries, key)
+		}
+	}
+	for key := range c.entries {
+		if len(c.entries) < c.size/2+1 {
+			break
+		}
+		delete(c.entries, key)
+	}
+}
+
+//	Chain asks every checker in turn. The first flagging verdict wins,
+//	an error is returned only if no checker flagged the URL.
+type Chain []interfaces.URLChecker
+
+//	CheckURL Asking all checkers about the URL.
+func (ch Chain) CheckURL(ctx context.Context, rawURL string) (interfaces.Verdict, error) {
+	var firstErr error
+	for _, checker := range ch {
+		verdict, err := checker.CheckURL(ctx, rawURL)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		if verdict.Flagged {
+			return verdict, nil
+		}
+	}
+	return interfaces.Verdict{}, firstErr
+}
@@ -0,0 +1,156 @@
+package reputation
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/ivanmyagkov/shortener.git/internal/interfaces"
+)
+
+//	defaultReason is used for hash prefixes listed without a reason.
+const defaultReason = "listed"
+
+//	HashPrefixList flags URLs whose SHA-256 hash starts with a listed prefix,
+//	in the manner of Safe Browsing lists.
+//
+//	Each line of the file is a hex prefix of 4 to 32 bytes, optionally followed
+//	by a reason: "1a2b3c4d malware". "#" starts a comment. Hashes are taken of
+//	host/path expressions, so listing "evil.com/" flags every page of evil.com
+//	and its subdomains.
+type HashPrefixList struct {
+	mu       sync.RWMutex
+	path     string
+	prefixes map[string]string
+	lengths  map[int]struct{}
+}
+
+//	LoadHashPrefixList is function to read a hash prefix list from file.
+func LoadHashPrefixList(path string) (*HashPrefixList, error) {
+	l := &HashPrefixList{path: path}
+	if err := l.Reload(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+//	Reload Reading the file again.
+func (l *HashPrefixList) Reload() error {
+	file, err := os.Open(l.path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	prefixes := make(map[string]string)
+	lengths := make(map[int]struct{})
+	scanner := bufio.NewScanner(file)
+	for n := 1; scanner.Scan(); n++ {
+		line := scanner.Text()
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		prefix, err := hex.DecodeString(fields[0])
+		if err != nil || len(prefix) < 4 || len(prefix) > sha256.Size {
+			return fmt.Errorf("%s:%d: invalid hash prefix %q", l.path, n, fields[0])
+		}
+		reason := defaultReason
+		if len(fields) > 1 {
+			reason = strings.Join(fields[1:], " ")
+		}
+		prefixes[string(prefix)] = reason
+		lengths[len(prefix)] = struct{}{}
+	}
+	if err = scanner.Err(); err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.prefixes = prefixes
+	l.lengths = lengths
+	return nil
+}
+
+//	CheckURL Looking for hashes of the URL expressions in the list.
+func (l *HashPrefixList) CheckURL(_ context.Context, rawURL string) (interfaces.Verdict, error) {
+	var verdict interfaces.Verdict
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return verdict, err
+	}
+
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	for _, expr := range Expressions(u) {
+		hash := sha256.Sum256([]byte(expr))
+		for length := range l.lengths {
+			if reason, ok := l.prefixes[string(hash[:length])]; ok {
+				verdict.Flagged = true
+				verdict.Reason = reason
+				return verdict, nil
+			}
+		}
+	}
+	return verdict, nil
+}
+
+//	Expressions Host suffix and path prefix combinations of the URL which are looked up in lists,
+//	e.g. for http://a.b.c/1/2?x=y: a.b.c/1/2?x=y, a.b.c/1/2, a.b.c/1/, a.b.c/, b.c/1/2?x=y, ...
+func Expressions(u *url.URL) []string {
+	host := strings.TrimSuffix(strings.ToLower(u.Hostname()), ".")
+	hosts := []string{host}
+	if net.ParseIP(host) == nil {
+		labels := strings.Split(host, ".")
+		if len(labels) > 5 {
+			labels = labels[len(labels)-5:]
+		}
+		for i := 1; i < len(labels)-1; i++ {
+			hosts = append(hosts, strings.Join(labels[i:], "."))
+		}
+	}
+
+	path := u.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	paths := make([]string, 0, 6)
+	if u.RawQuery != "" {
+		paths = append(paths, path+"?"+u.RawQuery)
+	}
+	paths = append(paths, path, "/")
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+	prefix := "/"
+	for _, segment := range segments[:len(segments)-1] {
+		if len(paths) >= 6 {
+			break
+		}
+		prefix += segment + "/"
+		paths = append(paths, prefix)
+	}
+
+	exprs := make([]string, 0, len(hosts)*len(paths))
+	seen := make(map[string]struct{})
+	for _, h := range hosts {
+		for _, p := range paths {
+			expr := h + p
+			if _, ok := seen[expr]; ok {
+				continue
+			}
+			seen[expr] = struct{}{}
+			exprs = append(exprs, expr)
+		}
+	}
+	return exprs
+}
@@ -0,0 +1,79 @@
+//	Package reputation for checking original URLs against reputation services
+//	before they are shortened.
+package reputation
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/ivanmyagkov/shortener.git/internal/interfaces"
+)
+
+//	maxResponseSize limits the lookup API response.
+const maxResponseSize = 64 << 10
+
+//	HTTPChecker asks a lookup API about the URL.
+//
+//	The API receives POST with {"url": "..."} and answers 200 with
+//	{"flagged": true, "reason": "malware"}. Any other status is an error.
+type HTTPChecker struct {
+	endpoint string
+	headers  map[string]string
+	client   *http.Client
+}
+
+//	NewHTTPChecker is function to create a checker for the lookup API at endpoint.
+//	headers are added to every request, e.g. for authorization.
+func NewHTTPChecker(endpoint string, headers map[string]string, timeout time.Duration) *HTTPChecker {
+	return &HTTPChecker{
+		endpoint: endpoint,
+		headers:  headers,
+		client:   &http.Client{Timeout: timeout},
+	}
+}
+
+//	CheckURL Asking the lookup API about the URL.
+func (h *HTTPChecker) CheckURL(ctx context.Context, rawURL string) (interfaces.Verdict, error) {
+	var verdict interfaces.Verdict
+	var request struct {
+		URL string `json:"url"`
+	}
+	var response struct {
+		Flagged bool   `json:"flagged"`
+		Reason  string `json:"reason"`
+	}
+
+	request.URL = rawURL
+	body, err := json.Marshal(request)
+	if err != nil {
+		return verdict, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return verdict, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for name, value := range h.headers {
+		req.Header.Set(name, value)
+	}
+
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return verdict, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return verdict, fmt.Errorf("lookup API answered %s", resp.Status)
+	}
+	if err = json.NewDecoder(io.LimitReader(resp.Body, maxResponseSize)).Decode(&response); err != nil {
+		return verdict, err
+	}
+	verdict.Flagged = response.Flagged
+	verdict.Reason = response.Reason
+	return verdict, nil
+}
@@ -0,0 +1,113 @@
+package reputation
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHTTPChecker_CheckURL(t *testing.T) {
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		var request struct {
+			URL string `json:"url"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if request.URL == "http://evil.com/" {
+			_, _ = w.Write([]byte(`{"flagged": true, "reason": "phishing"}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"flagged": false}`))
+	}))
+	defer srv.Close()
+
+	checker := NewHTTPChecker(srv.URL, map[string]string{"Authorization": "Bearer token"}, time.Second)
+	verdict, err := checker.CheckURL(context.Background(), "http://evil.com/")
+	require.NoError(t, err)
+	assert.True(t, verdict.Flagged)
+	assert.Equal(t, "phishing", verdict.Reason)
+
+	verdict, err = checker.CheckURL(context.Background(), "https://www.yandex.ru")
+	require.NoError(t, err)
+	assert.False(t, verdict.Flagged)
+
+	unauthorized := NewHTTPChecker(srv.URL, nil, time.Second)
+	_, err = unauthorized.CheckURL(context.Background(), "https://www.yandex.ru")
+	assert.Error(t, err)
+
+	cache := NewCache(checker, time.Minute, 10)
+	calls = 0
+	for i := 0; i < 3; i++ {
+		verdict, err = cache.CheckURL(context.Background(), "http://evil.com/")
+		require.NoError(t, err)
+		assert.True(t, verdict.Flagged)
+	}
+	assert.Equal(t, 1, calls)
+}
+
+func TestHashPrefixList_CheckURL(t *testing.T) {
+	hash := sha256.Sum256([]byte("evil.com/"))
+	path := filepath.Join(t.TempDir(), "list.txt")
+	content := "# test list\n" + hex.EncodeToString(hash[:4]) + " malware\n"
+	require.NoError(t, os.WriteFile(path, []byte(content), 0600))
+
+	list, err := LoadHashPrefixList(path)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name  string
+		value string
+		want  bool
+	}{
+		{name: "host", value: "http://evil.com", want: true},
+		{name: "page", value: "http://evil.com/a/b?c=d", want: true},
+		{name: "subdomain", value: "https://www.Evil.com/login", want: true},
+		{name: "other", value: "https://www.yandex.ru/", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			verdict, err := list.CheckURL(context.Background(), tt.value)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, verdict.Flagged)
+			if tt.want {
+				assert.Equal(t, "malware", verdict.Reason)
+			}
+		})
+	}
+}
+
+func TestChain_CheckURL(t *testing.T) {
+	failing := NewHTTPChecker("http://127.0.0.1:1", nil, 100*time.Millisecond)
+	hash := sha256.Sum256([]byte("evil.com/"))
+	path := filepath.Join(t.TempDir(), "list.txt")
+	require.NoError(t, os.WriteFile(path, []byte(hex.EncodeToString(hash[:8])+"\n"), 0600))
+	list, err := LoadHashPrefixList(path)
+	require.NoError(t, err)
+
+	chain := Chain{failing, list}
+	verdict, err := chain.CheckURL(context.Background(), "http://evil.com/")
+	require.NoError(t, err)
+	assert.True(t, verdict.Flagged)
+
+	_, err = chain.CheckURL(context.Background(), "https://www.yandex.ru/")
+	assert.Error(t, err)
+}
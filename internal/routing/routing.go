@@ -0,0 +1,202 @@
+//	Package routing for sending visitors of a short URL to different URLs
+//	by their device, language and country.
+//
+//	Rules of a link are checked in order and the first one matching the
+//	visitor wins, visitors matching none go to the original URL. A rule
+//	matches when every condition it has does, a condition with several
+//	values matches any of them.
+package routing
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/ivanmyagkov/shortener.git/internal/interfaces"
+)
+
+//	Codes of rejected rules.
+const (
+	CodeInvalidRule  = "invalid_rule"
+	CodeTooManyRules = "too_many_rules"
+)
+
+//	MaxRules is the number of rules a link may have.
+const MaxRules = 20
+
+//	Devices a rule may target.
+const (
+	DeviceIOS     = "ios"
+	DeviceAndroid = "android"
+	DeviceWindows = "windows"
+	DeviceMacOS   = "macos"
+	DeviceLinux   = "linux"
+	DeviceMobile  = "mobile"
+	DeviceDesktop = "desktop"
+)
+
+var devices = map[string]struct{}{
+	DeviceIOS:     {},
+	DeviceAndroid: {},
+	DeviceWindows: {},
+	DeviceMacOS:   {},
+	DeviceLinux:   {},
+	DeviceMobile:  {},
+	DeviceDesktop: {},
+}
+
+type Service struct {
+	storage interfaces.Storage
+}
+
+//	New is function to create the routing service.
+func New(storage interfaces.Storage) *Service {
+	return &Service{storage: storage}
+}
+
+//	List Listing rules of the link in the order they are checked.
+func (s *Service) List(userID, workspaceID, shortURL string) ([]interfaces.Rule, error) {
+	return s.storage.GetRules(userID, workspaceID, shortURL)
+}
+
+//	Add Adding the rule after the existing rules of the link.
+//	The URL of the rule is expected to be checked already.
+func (s *Service) Add(userID, workspaceID, shortURL string, rule interfaces.Rule) (interfaces.Rule, error) {
+	rule, err := normalize(rule)
+	if err != nil {
+		return interfaces.Rule{}, err
+	}
+	rules, err := s.storage.GetRules(userID, workspaceID, shortURL)
+	if err != nil {
+		return interfaces.Rule{}, err
+	}
+	if len(rules) >= MaxRules {
+		return interfaces.Rule{}, &interfaces.ValidationError{Code: CodeTooManyRules, Message: fmt.Sprintf("a link may have up to %d rules", MaxRules)}
+	}
+	if rule.ID, err = random(8); err != nil {
+		return interfaces.Rule{}, err
+	}
+	if err = s.storage.SetRules(userID, workspaceID, shortURL, append(rules, rule)); err != nil {
+		return interfaces.Rule{}, err
+	}
+	return rule, nil
+}
+
+//	Update Replacing the rule with the same ID, it keeps its place.
+//	Returns interfaces.ErrNotFound if the link has no such rule.
+func (s *Service) Update(userID, workspaceID, shortURL string, rule interfaces.Rule) (interfaces.Rule, error) {
+	rule, err := normalize(rule)
+	if err != nil {
+		return interfaces.Rule{}, err
+	}
+	rules, err := s.storage.GetRules(userID, workspaceID, shortURL)
+	if err != nil {
+		return interfaces.Rule{}, err
+	}
+	i := index(rules, rule.ID)
+	if i < 0 {
+		return interfaces.Rule{}, interfaces.ErrNotFound
+	}
+	rules[i] = rule
+	if err = s.storage.SetRules(userID, workspaceID, shortURL, rules); err != nil {
+		return interfaces.Rule{}, err
+	}
+	return rule, nil
+}
+
+//	Delete Removing the rule from the link.
+//	Returns interfaces.ErrNotFound if the link has no such rule.
+func (s *Service) Delete(userID, workspaceID, shortURL, ruleID string) error {
+	rules, err := s.storage.GetRules(userID, workspaceID, shortURL)
+	if err != nil {
+		return err
+	}
+	i := index(rules, ruleID)
+	if i < 0 {
+		return interfaces.ErrNotFound
+	}
+	return s.storage.SetRules(userID, workspaceID, shortURL, append(rules[:i], rules[i+1:]...))
+}
+
+//	normalize Checking conditions of the rule and bringing them to lower case devices and languages
+//	and upper case countries.
+func normalize(rule interfaces.Rule) (interfaces.Rule, error) {
+	if len(rule.Devices) == 0 && len(rule.Languages) == 0 && len(rule.Countries) == 0 {
+		return rule, &interfaces.ValidationError{Code: CodeInvalidRule, Message: "a rule needs devices, languages or countries"}
+	}
+	if rule.URL == "" {
+		return rule, &interfaces.ValidationError{Code: CodeInvalidRule, Message: "a rule needs a URL"}
+	}
+	normalized := rule
+	normalized.Devices = make([]string, 0, len(rule.Devices))
+	for _, device := range rule.Devices {
+		device = strings.ToLower(strings.TrimSpace(device))
+		if _, ok := devices[device]; !ok {
+			return rule, &interfaces.ValidationError{Code: CodeInvalidRule, Message: fmt.Sprintf("unknown device %q, expected ios, android, windows, macos, linux, mobile or desktop", device)}
+		}
+		normalized.Devices = append(normalized.Devices, device)
+	}
+	normalized.Languages = make([]string, 0, len(rule.Languages))
+	for _, language := range rule.Languages {
+		language = strings.ToLower(strings.TrimSpace(language))
+		if !isLanguage(language) {
+			return rule, &interfaces.ValidationError{Code: CodeInvalidRule, Message: fmt.Sprintf("invalid language %q, expected a tag like \"en\" or \"pt-BR\"", language)}
+		}
+		normalized.Languages = append(normalized.Languages, language)
+	}
+	normalized.Countries = make([]string, 0, len(rule.Countries))
+	for _, country := range rule.Countries {
+		country = strings.ToUpper(strings.TrimSpace(country))
+		if len(country) != 2 || !isLetters(country) {
+			return rule, &interfaces.ValidationError{Code: CodeInvalidRule, Message: fmt.Sprintf("invalid country %q, expected a two letter code like \"US\"", country)}
+		}
+		normalized.Countries = append(normalized.Countries, country)
+	}
+	return normalized, nil
+}
+
+//	isLanguage Checking the tag is made of a primary language and optional subtags of letters and digits.
+func isLanguage(tag string) bool {
+	parts := strings.Split(tag, "-")
+	if len(parts[0]) < 2 || len(parts[0]) > 3 || !isLetters(parts[0]) {
+		return false
+	}
+	for _, part := range parts[1:] {
+		if part == "" || len(part) > 8 {
+			return false
+		}
+		for _, r := range part {
+			if !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9') {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func isLetters(s string) bool {
+	for _, r := range strings.ToLower(s) {
+		if r < 'a' || r > 'z' {
+			return false
+		}
+	}
+	return true
+}
+
+func index(rules []interfaces.Rule, id string) int {
+	for i, rule := range rules {
+		if rule.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+func random(size int) (string, error) {
+	b := make([]byte, size)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,128 @@
+package routing
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ivanmyagkov/shortener.git/internal/interfaces"
+	"github.com/ivanmyagkov/shortener.git/internal/storage"
+)
+
+const (
+	iPhone  = "Mozilla/5.0 (iPhone; CPU iPhone OS 17_0 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.0 Mobile/15E148 Safari/604.1"
+	android = "Mozilla/5.0 (Linux; Android 14; Pixel 8) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Mobile Safari/537.36"
+	mac     = "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.0 Safari/605.1.15"
+	linux   = "Mozilla/5.0 (X11; Linux x86_64; rv:121.0) Gecko/20100101 Firefox/121.0"
+)
+
+func TestDevices(t *testing.T) {
+	assert.Equal(t, []string{DeviceIOS, DeviceMobile}, Devices(iPhone))
+	assert.Equal(t, []string{DeviceAndroid, DeviceMobile}, Devices(android))
+	assert.Equal(t, []string{DeviceMacOS, DeviceDesktop}, Devices(mac))
+	assert.Equal(t, []string{DeviceLinux, DeviceDesktop}, Devices(linux))
+	assert.Empty(t, Devices("curl/8.0"))
+}
+
+func TestPreferredLanguage(t *testing.T) {
+	assert.Equal(t, "de-de", PreferredLanguage("de-DE,de;q=0.9,en;q=0.8"))
+	assert.Equal(t, "fr", PreferredLanguage("en;q=0.5, fr"))
+	assert.Equal(t, "en", PreferredLanguage("ru;q=0, en"))
+	assert.Equal(t, "", PreferredLanguage("*"))
+	assert.Equal(t, "", PreferredLanguage(""))
+}
+
+func TestMatch(t *testing.T) {
+	rules := []interfaces.Rule{
+		{ID: "1", Devices: []string{DeviceIOS}, URL: "https://apps.apple.example"},
+		{ID: "2", Devices: []string{DeviceAndroid}, URL: "https://play.example"},
+		{ID: "3", Languages: []string{"de"}, Countries: []string{"DE", "AT"}, URL: "https://de.example"},
+	}
+	tests := []struct {
+		name    string
+		visitor Visitor
+		want    string
+	}{
+		{name: "ios", visitor: Visitor{UserAgent: iPhone, AcceptLanguage: "de", Country: "DE"}, want: "https://apps.apple.example"},
+		{name: "android", visitor: Visitor{UserAgent: android}, want: "https://play.example"},
+		{name: "language and country", visitor: Visitor{UserAgent: mac, AcceptLanguage: "de-AT,en;q=0.5", Country: "AT"}, want: "https://de.example"},
+		{name: "language without country", visitor: Visitor{UserAgent: mac, AcceptLanguage: "de"}},
+		{name: "country without language", visitor: Visitor{UserAgent: mac, AcceptLanguage: "en", Country: "DE"}},
+		{name: "nothing known", visitor: Visitor{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			URL, ok := Match(rules, tt.visitor)
+			assert.Equal(t, tt.want != "", ok)
+			assert.Equal(t, tt.want, URL)
+		})
+	}
+	assert.True(t, UsesCountry(rules))
+	assert.False(t, UsesCountry(rules[:2]))
+}
+
+func TestService(t *testing.T) {
+	db := storage.NewDBConn()
+	require.NoError(t, db.SetShortURL("user", "code", "https://a.example"))
+	s := New(db)
+
+	_, err := s.Add("user", "", "code", interfaces.Rule{URL: "https://b.example"})
+	assertCode(t, err, CodeInvalidRule)
+	_, err = s.Add("user", "", "code", interfaces.Rule{Devices: []string{"tv"}, URL: "https://b.example"})
+	assertCode(t, err, CodeInvalidRule)
+	_, err = s.Add("user", "", "code", interfaces.Rule{Languages: []string{"english"}, URL: "https://b.example"})
+	assertCode(t, err, CodeInvalidRule)
+	_, err = s.Add("user", "", "code", interfaces.Rule{Countries: []string{"USA"}, URL: "https://b.example"})
+	assertCode(t, err, CodeInvalidRule)
+	_, err = s.Add("other", "", "code", interfaces.Rule{Devices: []string{"ios"}, URL: "https://b.example"})
+	assert.ErrorIs(t, err, interfaces.ErrNotFound)
+
+	first, err := s.Add("user", "", "code", interfaces.Rule{Devices: []string{" iOS"}, URL: "https://b.example"})
+	require.NoError(t, err)
+	assert.NotEmpty(t, first.ID)
+	assert.Equal(t, []string{DeviceIOS}, first.Devices)
+	second, err := s.Add("user", "", "code", interfaces.Rule{Languages: []string{"pt-BR"}, Countries: []string{"br"}, URL: "https://c.example"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"pt-br"}, second.Languages)
+	assert.Equal(t, []string{"BR"}, second.Countries)
+
+	first.Devices = []string{DeviceAndroid}
+	_, err = s.Update("user", "", "code", first)
+	require.NoError(t, err)
+	_, err = s.Update("user", "", "code", interfaces.Rule{ID: "unknown", Devices: []string{DeviceIOS}, URL: "https://b.example"})
+	assert.ErrorIs(t, err, interfaces.ErrNotFound)
+	rules, err := s.List("user", "", "code")
+	require.NoError(t, err)
+	require.Len(t, rules, 2)
+	assert.Equal(t, first, rules[0])
+	assert.Equal(t, second, rules[1])
+
+	require.NoError(t, s.Delete("user", "", "code", first.ID))
+	assert.ErrorIs(t, s.Delete("user", "", "code", first.ID), interfaces.ErrNotFound)
+	link, err := db.GetLink("code")
+	require.NoError(t, err)
+	assert.Equal(t, []interfaces.Rule{second}, link.Rules)
+
+	for i := len(link.Rules); i < MaxRules; i++ {
+		_, err = s.Add("user", "", "code", interfaces.Rule{Devices: []string{DeviceIOS}, URL: "https://b.example"})
+		require.NoError(t, err)
+	}
+	_, err = s.Add("user", "", "code", interfaces.Rule{Devices: []string{DeviceIOS}, URL: "https://b.example"})
+	assertCode(t, err, CodeTooManyRules)
+
+	// rules of a shared link would change it for everybody
+	require.NoError(t, db.SetShortURL("other", "code", "https://a.example"))
+	_, err = s.Update("user", "", "code", second)
+	assert.ErrorIs(t, err, interfaces.ErrShared)
+	assert.ErrorIs(t, s.Delete("user", "", "code", second.ID), interfaces.ErrShared)
+}
+
+func assertCode(t *testing.T, err error, code string) {
+	t.Helper()
+	var validationErr *interfaces.ValidationError
+	if assert.True(t, errors.As(err, &validationErr), err) {
+		assert.Equal(t, code, validationErr.Code)
+	}
+}
@@ -0,0 +1,138 @@
+package routing
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ivanmyagkov/shortener.git/internal/interfaces"
+)
+
+//	Visitor is what rules are matched against.
+type Visitor struct {
+	UserAgent      string
+	AcceptLanguage string
+	// country of the visitor IP address, empty if it is unknown
+	Country string
+}
+
+//	Match Returning the URL of the first rule matching the visitor, false if none does.
+func Match(rules []interfaces.Rule, visitor Visitor) (string, bool) {
+	var visitorDevices []string
+	var language string
+	if len(rules) > 0 {
+		visitorDevices = Devices(visitor.UserAgent)
+		language = PreferredLanguage(visitor.AcceptLanguage)
+	}
+	for _, rule := range rules {
+		if len(rule.Devices) > 0 && !intersects(rule.Devices, visitorDevices) {
+			continue
+		}
+		if len(rule.Languages) > 0 && !matchLanguage(rule.Languages, language) {
+			continue
+		}
+		if len(rule.Countries) > 0 && !contains(rule.Countries, strings.ToUpper(visitor.Country)) {
+			continue
+		}
+		return rule.URL, true
+	}
+	return "", false
+}
+
+//	UsesCountry Checking some of the rules depend on the country, so it is worth resolving.
+func UsesCountry(rules []interfaces.Rule) bool {
+	for _, rule := range rules {
+		if len(rule.Countries) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+//	Devices Returning the operating system of the user agent with its device class, nothing if it is unknown.
+func Devices(userAgent string) []string {
+	switch {
+	case strings.Contains(userAgent, "iPhone") || strings.Contains(userAgent, "iPad") || strings.Contains(userAgent, "iPod"):
+		return []string{DeviceIOS, DeviceMobile}
+	case strings.Contains(userAgent, "Android"):
+		return []string{DeviceAndroid, DeviceMobile}
+	case strings.Contains(userAgent, "Windows Phone"):
+		return []string{DeviceMobile}
+	case strings.Contains(userAgent, "Windows"):
+		return []string{DeviceWindows, DeviceDesktop}
+	case strings.Contains(userAgent, "Macintosh") || strings.Contains(userAgent, "Mac OS X"):
+		return []string{DeviceMacOS, DeviceDesktop}
+	case strings.Contains(userAgent, "Mobile"):
+		return []string{DeviceMobile}
+	case strings.Contains(userAgent, "Linux") || strings.Contains(userAgent, "X11"):
+		return []string{DeviceLinux, DeviceDesktop}
+	}
+	return nil
+}
+
+//	PreferredLanguage Returning the lower case language tag of Accept-Language with the highest weight,
+//	the first one of equal weights. Returns "" for "*" or no languages.
+func PreferredLanguage(acceptLanguage string) string {
+	type weighted struct {
+		tag    string
+		weight float64
+	}
+	var tags []weighted
+	for _, part := range strings.Split(acceptLanguage, ",") {
+		params := strings.Split(part, ";")
+		tag := strings.ToLower(strings.TrimSpace(params[0]))
+		if tag == "" {
+			continue
+		}
+		weight := 1.0
+		for _, param := range params[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if q, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					weight = q
+				}
+			}
+		}
+		if weight > 0 {
+			tags = append(tags, weighted{tag: tag, weight: weight})
+		}
+	}
+	sort.SliceStable(tags, func(i, j int) bool {
+		return tags[i].weight > tags[j].weight
+	})
+	if len(tags) == 0 || tags[0].tag == "*" {
+		return ""
+	}
+	return tags[0].tag
+}
+
+//	matchLanguage Checking the language is one of the languages or a variant of one, "en" matches "en-GB".
+func matchLanguage(languages []string, language string) bool {
+	if language == "" {
+		return false
+	}
+	for _, l := range languages {
+		if language == l || strings.HasPrefix(language, l+"-") {
+			return true
+		}
+	}
+	return false
+}
+
+func intersects(a, b []string) bool {
+	for _, value := range b {
+		if contains(a, value) {
+			return true
+		}
+	}
+	return false
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,242 @@
+//	Package search for finding short URLs by words of their original URLs, domains, titles and notes.
+//
+//	Index is an inverted index of words. A word of a query matches words of the index equal to it,
+//	starting with it or, for typos, sharing enough trigrams with it, like pg_trgm does. Documents
+//	are ranked by the sum over words of the query of their best match weighted by the field it was found in.
+package search
+
+import (
+	"net/url"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+//	Field is a part of a document, fields are weighted by how much they tell about the document.
+type Field uint8
+
+//	Fields of documents.
+const (
+	Title Field = iota
+	Domain
+	URL
+	Notes
+	fieldCount
+)
+
+//	weights of fields, like the default weights of ts_rank.
+var weights = [fieldCount]float64{Title: 1, Domain: 0.4, URL: 0.2, Notes: 0.1}
+
+//	Limits of queries, every word of a query is looked up in the index.
+const (
+	MaxQuery = 200
+	MaxWords = 10
+)
+
+//	Similarities of matches, a fuzzy match is scored by the share of common trigrams.
+const (
+	exactMatch  = 1
+	prefixMatch = 0.8
+	// the default pg_trgm similarity threshold
+	fuzzyThreshold = 0.3
+	// shorter words are matched exactly or by prefix
+	minFuzzyWord  = 3
+	minPrefixWord = 2
+)
+
+//	Document is what a short URL is found by.
+type Document struct {
+	// the original URL, its host is the domain
+	URL   string
+	Title string
+	Notes string
+}
+
+//	Hit is a document found by a query, a higher score is a better match.
+type Hit struct {
+	ID    string
+	Score float64
+}
+
+//	Index of documents by their words. It is not safe for concurrent use.
+type Index struct {
+	// fields of documents each word is found in
+	words map[string]map[string]fieldSet
+	// words of each document, to remove them
+	docs map[string][]string
+	// words by their trigrams
+	grams map[string]map[string]struct{}
+}
+
+//	fieldSet is a bit set of fields.
+type fieldSet uint8
+
+//	weight Returning the weight of the heaviest field of the set.
+func (f fieldSet) weight() float64 {
+	for field := Field(0); field < fieldCount; field++ {
+		if f&(1<<field) != 0 {
+			return weights[field]
+		}
+	}
+	return 0
+}
+
+//	NewIndex is function to create an empty index.
+func NewIndex() *Index {
+	return &Index{
+		words: make(map[string]map[string]fieldSet),
+		docs:  make(map[string][]string),
+		grams: make(map[string]map[string]struct{}),
+	}
+}
+
+//	Len Returning the number of documents in the index.
+func (ix *Index) Len() int {
+	return len(ix.docs)
+}
+
+//	Add Adding the document to the index, replacing the one with the same ID.
+func (ix *Index) Add(id string, doc Document) {
+	ix.Remove(id)
+	fields := make(map[string]fieldSet)
+	add := func(field Field, text string) {
+		for _, word := range Words(text) {
+			fields[word] |= 1 << field
+		}
+	}
+	add(Title, doc.Title)
+	add(Domain, domain(doc.URL))
+	add(URL, doc.URL)
+	add(Notes, doc.Notes)
+	words := make([]string, 0, len(fields))
+	for word, set := range fields {
+		if ix.words[word] == nil {
+			ix.words[word] = make(map[string]fieldSet)
+			for _, gram := range trigrams(word) {
+				if ix.grams[gram] == nil {
+					ix.grams[gram] = make(map[string]struct{})
+				}
+				ix.grams[gram][word] = struct{}{}
+			}
+		}
+		ix.words[word][id] = set
+		words = append(words, word)
+	}
+	ix.docs[id] = words
+}
+
+//	Remove Removing the document from the index.
+func (ix *Index) Remove(id string) {
+	for _, word := range ix.docs[id] {
+		delete(ix.words[word], id)
+		if len(ix.words[word]) > 0 {
+			continue
+		}
+		delete(ix.words, word)
+		for _, gram := range trigrams(word) {
+			delete(ix.grams[gram], word)
+			if len(ix.grams[gram]) == 0 {
+				delete(ix.grams, gram)
+			}
+		}
+	}
+	delete(ix.docs, id)
+}
+
+//	Search Returning documents matching any word of the query, the best matches first.
+func (ix *Index) Search(query string) []Hit {
+	scores := make(map[string]float64)
+	for _, word := range Words(query) {
+		best := make(map[string]float64)
+		for match, similarity := range ix.matches(word) {
+			for id, set := range ix.words[match] {
+				if score := similarity * set.weight(); score > best[id] {
+					best[id] = score
+				}
+			}
+		}
+		for id, score := range best {
+			scores[id] += score
+		}
+	}
+	hits := make([]Hit, 0, len(scores))
+	for id, score := range scores {
+		hits = append(hits, Hit{ID: id, Score: score})
+	}
+	sort.Slice(hits, func(i, j int) bool {
+		if hits[i].Score != hits[j].Score {
+			return hits[i].Score > hits[j].Score
+		}
+		return hits[i].ID < hits[j].ID
+	})
+	return hits
+}
+
+//	matches Returning words of the index matching the word of a query with their similarity.
+func (ix *Index) matches(word string) map[string]float64 {
+	matches := make(map[string]float64)
+	if _, ok := ix.words[word]; ok {
+		matches[word] = exactMatch
+	}
+	if len([]rune(word)) < minPrefixWord {
+		return matches
+	}
+	grams := trigrams(word)
+	candidates := make(map[string]int)
+	for _, gram := range grams {
+		for candidate := range ix.grams[gram] {
+			candidates[candidate]++
+		}
+	}
+	for candidate, common := range candidates {
+		if candidate == word {
+			continue
+		}
+		if strings.HasPrefix(candidate, word) {
+			matches[candidate] = prefixMatch
+			continue
+		}
+		if len([]rune(word)) < minFuzzyWord {
+			continue
+		}
+		// the Jaccard index of trigram sets, words have no repeated trigrams in practice
+		similarity := float64(common) / float64(len(grams)+len(trigrams(candidate))-common)
+		if similarity >= fuzzyThreshold {
+			// a fuzzy match is never better than a prefix one
+			matches[candidate] = similarity * prefixMatch
+		}
+	}
+	return matches
+}
+
+//	Words Returning words of the text in lower case, letters and digits separated by anything else.
+func Words(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+//	domain Returning the host of the URL without "www.", "" if it has none.
+func domain(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimPrefix(strings.ToLower(u.Hostname()), "www.")
+}
+
+//	trigrams Returning distinct trigrams of the word padded like pg_trgm does, two spaces before and one after.
+func trigrams(word string) []string {
+	runes := []rune("  " + word + " ")
+	seen := make(map[string]struct{}, len(runes))
+	grams := make([]string, 0, len(runes))
+	for i := 0; i+3 <= len(runes); i++ {
+		gram := string(runes[i : i+3])
+		if _, ok := seen[gram]; ok {
+			continue
+		}
+		seen[gram] = struct{}{}
+		grams = append(grams, gram)
+	}
+	return grams
+}
@@ -0,0 +1,63 @@
+package search
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWords(t *testing.T) {
+	assert.Equal(t, []string{"https", "go", "dev", "doc", "effective", "go"}, Words("https://go.dev/doc/Effective_Go"))
+	assert.Equal(t, []string{"привет", "мир", "2024"}, Words("Привет, мир! 2024"))
+	assert.Empty(t, Words(" -/- "))
+}
+
+func TestTrigrams(t *testing.T) {
+	assert.Equal(t, []string{"  c", " ca", "cat", "at "}, trigrams("cat"))
+	assert.Equal(t, []string{"  a", " aa", "aaa", "aa "}, trigrams("aaaa"))
+}
+
+func hitIDs(hits []Hit) []string {
+	ids := make([]string, 0, len(hits))
+	for _, hit := range hits {
+		ids = append(ids, hit.ID)
+	}
+	return ids
+}
+
+func TestIndex_Search(t *testing.T) {
+	ix := NewIndex()
+	ix.Add("docs", Document{URL: "https://go.dev/doc/effective_go", Title: "Effective Go"})
+	ix.Add("blog", Document{URL: "https://www.blog.example/posts/golang-generics", Notes: "read about effective generics"})
+	ix.Add("video", Document{URL: "https://video.example/watch?v=1", Title: "Conference talk", Notes: "golang"})
+	require.Equal(t, 3, ix.Len())
+
+	// a title match outweighs a match in notes
+	assert.Equal(t, []string{"docs", "blog"}, hitIDs(ix.Search("effective")))
+	// words match by prefix
+	assert.Equal(t, []string{"video"}, hitIDs(ix.Search("conf")))
+	// domains are found without www.
+	hits := ix.Search("blog")
+	require.Len(t, hits, 1)
+	assert.Equal(t, weights[Domain], hits[0].Score)
+	// words with typos match by trigrams
+	assert.Equal(t, []string{"docs", "blog"}, hitIDs(ix.Search("efective")))
+	assert.Empty(t, ix.Search("zebra"))
+	// documents matching more words come first
+	assert.Equal(t, []string{"blog", "video"}, hitIDs(ix.Search("golang generics")))
+	// short words are matched exactly or by prefix only
+	assert.Equal(t, []string{"docs", "blog", "video"}, hitIDs(ix.Search("go")))
+	assert.Empty(t, ix.Search("oc"))
+
+	ix.Add("docs", Document{URL: "https://go.dev/tour", Title: "Tour"})
+	assert.Equal(t, []string{"blog"}, hitIDs(ix.Search("effective")))
+	ix.Remove("blog")
+	ix.Remove("missing")
+	assert.Empty(t, ix.Search("effective"))
+	assert.Equal(t, 2, ix.Len())
+	ix.Remove("docs")
+	ix.Remove("video")
+	assert.Empty(t, ix.words)
+	assert.Empty(t, ix.grams)
+}
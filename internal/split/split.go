@@ -0,0 +1,109 @@
+//	Package split for sending visitors of a short URL to several destinations in proportion to their weights.
+//
+//	A visitor is assigned to a destination by a hash of the short URL and the
+//	visitor key, the session of the visitor, so the visitor keeps getting the
+//	same destination while the destinations stay the same.
+package split
+
+import (
+	"fmt"
+	"hash/fnv"
+	"strings"
+
+	"github.com/ivanmyagkov/shortener.git/internal/interfaces"
+)
+
+//	CodeInvalidDestinations is the code of rejected destinations.
+const CodeInvalidDestinations = "invalid_destinations"
+
+//	Limits of destinations of a link.
+const (
+	MinDestinations = 2
+	MaxDestinations = 10
+	MaxWeight       = 1000
+	maxID           = 32
+)
+
+//	Normalize Checking destinations and giving IDs to the ones without, the first free letter.
+//	No destinations are valid, they turn the split off.
+//	URLs of destinations are expected to be checked already.
+func Normalize(destinations []interfaces.Destination) ([]interfaces.Destination, error) {
+	if len(destinations) == 0 {
+		return nil, nil
+	}
+	if len(destinations) < MinDestinations || len(destinations) > MaxDestinations {
+		return nil, invalid("a link needs %d to %d destinations", MinDestinations, MaxDestinations)
+	}
+	ids := make(map[string]struct{}, len(destinations))
+	normalized := make([]interfaces.Destination, 0, len(destinations))
+	for _, destination := range destinations {
+		destination.ID = strings.TrimSpace(destination.ID)
+		destination.Served = 0
+		if destination.URL == "" {
+			return nil, invalid("a destination needs a URL")
+		}
+		if destination.Weight < 1 || destination.Weight > MaxWeight {
+			return nil, invalid("weight of %s must be 1 to %d", destination.URL, MaxWeight)
+		}
+		if destination.ID != "" {
+			if !isID(destination.ID) {
+				return nil, invalid("id %q must have 1 to %d letters, digits, \"-\" or \"_\"", destination.ID, maxID)
+			}
+			if _, ok := ids[destination.ID]; ok {
+				return nil, invalid("id %q is used twice", destination.ID)
+			}
+			ids[destination.ID] = struct{}{}
+		}
+		normalized = append(normalized, destination)
+	}
+	for i := range normalized {
+		if normalized[i].ID != "" {
+			continue
+		}
+		for letter := 'a'; ; letter++ {
+			if _, ok := ids[string(letter)]; !ok {
+				normalized[i].ID = string(letter)
+				ids[normalized[i].ID] = struct{}{}
+				break
+			}
+		}
+	}
+	return normalized, nil
+}
+
+//	Pick Returning the destination of the visitor with the key.
+func Pick(destinations []interfaces.Destination, key string) interfaces.Destination {
+	total := 0
+	for _, destination := range destinations {
+		total += destination.Weight
+	}
+	if total <= 0 {
+		return destinations[0]
+	}
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	point := int(h.Sum64() % uint64(total))
+	for _, destination := range destinations {
+		if point < destination.Weight {
+			return destination
+		}
+		point -= destination.Weight
+	}
+	return destinations[len(destinations)-1]
+}
+
+func isID(id string) bool {
+	if len(id) > maxID {
+		return false
+	}
+	for _, r := range id {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_') {
+			return false
+		}
+	}
+	return true
+}
+
+func invalid(format string, args ...interface{}) error {
+	return &interfaces.ValidationError{Code: CodeInvalidDestinations, Message: fmt.Sprintf(format, args...)}
+}
@@ -0,0 +1,65 @@
+package split
+
+import (
+	"errors"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ivanmyagkov/shortener.git/internal/interfaces"
+)
+
+func TestNormalize(t *testing.T) {
+	destinations, err := Normalize(nil)
+	require.NoError(t, err)
+	assert.Nil(t, destinations)
+
+	destinations, err = Normalize([]interfaces.Destination{
+		{URL: "https://a.example", Weight: 70, Served: 5},
+		{ID: "a", URL: "https://b.example", Weight: 20},
+		{URL: "https://c.example", Weight: 10},
+	})
+	require.NoError(t, err)
+	require.Len(t, destinations, 3)
+	assert.Equal(t, "b", destinations[0].ID)
+	assert.Equal(t, int64(0), destinations[0].Served)
+	assert.Equal(t, "a", destinations[1].ID)
+	assert.Equal(t, "c", destinations[2].ID)
+
+	for name, destinations := range map[string][]interfaces.Destination{
+		"one":        {{URL: "https://a.example", Weight: 1}},
+		"no weight":  {{URL: "https://a.example"}, {URL: "https://b.example", Weight: 1}},
+		"heavy":      {{URL: "https://a.example", Weight: MaxWeight + 1}, {URL: "https://b.example", Weight: 1}},
+		"no url":     {{Weight: 1}, {URL: "https://b.example", Weight: 1}},
+		"same id":    {{ID: "x", URL: "https://a.example", Weight: 1}, {ID: "x", URL: "https://b.example", Weight: 1}},
+		"invalid id": {{ID: "a b", URL: "https://a.example", Weight: 1}, {URL: "https://b.example", Weight: 1}},
+		"too many":   make([]interfaces.Destination, MaxDestinations+1),
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := Normalize(destinations)
+			var validationErr *interfaces.ValidationError
+			if assert.True(t, errors.As(err, &validationErr)) {
+				assert.Equal(t, CodeInvalidDestinations, validationErr.Code)
+			}
+		})
+	}
+}
+
+func TestPick(t *testing.T) {
+	destinations := []interfaces.Destination{
+		{ID: "a", URL: "https://a.example", Weight: 70},
+		{ID: "b", URL: "https://b.example", Weight: 30},
+	}
+	served := make(map[string]int)
+	for i := 0; i < 10000; i++ {
+		key := "code\n" + strconv.Itoa(i)
+		destination := Pick(destinations, key)
+		// a visitor keeps the destination
+		assert.Equal(t, destination, Pick(destinations, key))
+		served[destination.ID]++
+	}
+	assert.InDelta(t, 7000, served["a"], 300)
+	assert.InDelta(t, 3000, served["b"], 300)
+}
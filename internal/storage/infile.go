@@ -4,25 +4,101 @@ package storage
 import (
 	"bufio"
 	"encoding/json"
+	"errors"
 	"os"
 	"sync"
+	"time"
 
 	"github.com/ivanmyagkov/shortener.git/internal/interfaces"
 )
 
+//	InFile keeps URLs in memory and appends every change to a file,
+//	the file is replayed on start. Webhook deliveries are appended to an outbox file
+//	of their own, which is rewritten when finished deliveries are pruned.
 type InFile struct {
-	sync.Mutex
-	file     *os.File
-	DataFile ModelFile `json:"data_file"`
-	Storage  map[string]string
-	cache    map[string][]interfaces.ModelURL
-	encoder  *json.Encoder
+	*DB
+	mu      sync.Mutex
+	file    *os.File
+	encoder *json.Encoder
+	// the outbox file and the name it is kept under
+	outbox        *os.File
+	outboxEncoder *json.Encoder
+	outboxName    string
+	// deliveries are read from the outbox file, records of them in the file are left from before it had one
+	outboxed bool
 }
 
+//	outboxSuffix Suffix of the outbox file name after the file name.
+const outboxSuffix = ".outbox"
+
+//	Kinds of file records. Records without kind are shortened URLs.
+const (
+	kindURL       = ""
+	kindHealth    = "health"
+	kindVerdict   = "verdict"
+	kindClaim     = "claim"
+	kindAccount   = "account"
+	kindAPIKey    = "api_key"
+	kindDelKey    = "api_key_deleted"
+	kindWorkspace = "workspace"
+	kindMember    = "member"
+	kindDelMember = "member_deleted"
+	kindRevision  = "revision"
+	kindDeleted   = "deleted"
+	kindRedirect  = "redirect"
+	kindRules     = "rules"
+	kindSplit     = "destinations"
+	kindServed    = "served"
+	kindAccess    = "access"
+	kindPatch     = "patch"
+	kindVisit     = "visit"
+	kindMeta      = "meta"
+	kindAnnotate  = "annotation"
+	kindClick     = "click"
+	kindWebhook   = "webhook"
+	kindDelHook   = "webhook_deleted"
+	kindDelivery  = "delivery"
+	kindPrune     = "deliveries_pruned"
+	kindChange    = "change"
+	kindCursor    = "change_cursor"
+)
+
 type ModelFile struct {
-	UserID   string `json:"user_id"`
-	ShortURL string `json:"short_url"`
-	BaseURL  string `json:"base_url"`
+	Kind     string                `json:"kind,omitempty"`
+	UserID   string                `json:"user_id"`
+	ShortURL string                `json:"short_url"`
+	BaseURL  string                `json:"base_url"`
+	Health   *interfaces.URLHealth `json:"health,omitempty"`
+	Verdict  *interfaces.Verdict   `json:"verdict,omitempty"`
+	Owner    string                `json:"owner,omitempty"`
+	Account  *interfaces.Account   `json:"account,omitempty"`
+	APIKey   *interfaces.APIKey    `json:"api_key,omitempty"`
+	KeyID    string                `json:"key_id,omitempty"`
+	// workspace of the URL or the member record, empty for URLs of the user
+	WorkspaceID string                `json:"workspace_id,omitempty"`
+	Workspace   *interfaces.Workspace `json:"workspace,omitempty"`
+	Member      *interfaces.Member    `json:"member,omitempty"`
+	Revision    *interfaces.Revision  `json:"revision,omitempty"`
+	// records of URLs written before private URLs were introduced are shared
+	Private  bool                 `json:"private,omitempty"`
+	Redirect *interfaces.Redirect `json:"redirect,omitempty"`
+	Rules    []interfaces.Rule    `json:"rules,omitempty"`
+	// destinations of a split URL, or the ID of the served one
+	Destinations  []interfaces.Destination `json:"destinations,omitempty"`
+	DestinationID string                   `json:"destination_id,omitempty"`
+	Access        *interfaces.Access       `json:"access,omitempty"`
+	Meta          *interfaces.PageMeta     `json:"meta,omitempty"`
+	Annotation    *interfaces.Annotation   `json:"annotation,omitempty"`
+	Webhook       *interfaces.Webhook      `json:"webhook,omitempty"`
+	// the last state of a webhook delivery, or the time finished deliveries were pruned before,
+	// the file has records of pruning only from before the outbox had a file of its own
+	Delivery *interfaces.WebhookDelivery `json:"delivery,omitempty"`
+	Before   *time.Time                  `json:"before,omitempty"`
+	// a record of the change log, or the offset a consumer of the log has reached, by consumer in KeyID
+	Change *interfaces.Change `json:"change,omitempty"`
+	Offset int64              `json:"offset,omitempty"`
+	// time the URL was first created, records written before it was kept have none
+	CreatedAt *time.Time `json:"created_at,omitempty"`
 }
 
 //	NewInFile Creating a file to save URLs and getting existing ones.
@@ -31,89 +107,769 @@ func NewInFile(fileName string) (interfaces.Storage, error) {
 	if err != nil {
 		return nil, err
 	}
-	var modelURL interfaces.ModelURL
-	data := make(map[string][]interfaces.ModelURL)
-	stor := make(map[string]string)
-	var dataFile ModelFile
+	s := &InFile{
+		DB:         NewDBConn(),
+		file:       file,
+		encoder:    json.NewEncoder(file),
+		outboxName: fileName + outboxSuffix,
+	}
+
+	// deliveries are read before the file, so deleted webhooks take their deliveries with them
+	outbox, err := os.Open(s.outboxName)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+	case err != nil:
+		return nil, err
+	default:
+		err = s.read(outbox)
+		outbox.Close()
+		if err != nil {
+			return nil, err
+		}
+		s.outboxed = true
+	}
 
 	if stat, _ := file.Stat(); stat.Size() != 0 {
-		scanner := bufio.NewScanner(file)
-		for scanner.Scan() {
-			err := json.Unmarshal(scanner.Bytes(), &dataFile)
-			if err != nil {
-				return nil, err
-			}
-			stor[dataFile.ShortURL] = dataFile.BaseURL
-			modelURL.ShortURL = dataFile.ShortURL
-			modelURL.BaseURL = dataFile.BaseURL
-			data[dataFile.UserID] = append(data[dataFile.UserID], modelURL)
+		// changes are read from their own records
+		s.DB.replay = true
+		if err = s.read(file); err != nil {
+			return nil, err
 		}
+		s.DB.replay = false
+	}
+	s.outboxed = true
+
+	if err = s.writeOutbox(); err != nil {
+		return nil, err
 	}
+	return s, nil
+}
 
-	return &InFile{
-		file:     file,
-		DataFile: dataFile,
-		Storage:  stor,
-		cache:    data,
-		encoder:  json.NewEncoder(file),
-	}, nil
+//	read Replay the records of the file.
+func (s *InFile) read(file *os.File) error {
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var dataFile ModelFile
+		if err := json.Unmarshal(scanner.Bytes(), &dataFile); err != nil {
+			return err
+		}
+		if err := s.replay(dataFile); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
 }
 
-func (s *InFile) Close() error {
-	s.cache = nil
-	err := s.file.Close()
-	return err
+//	writeOutbox Rewrite the outbox file with the deliveries in memory, the old file is replaced at once.
+func (s *InFile) writeOutbox() error {
+	file, err := os.OpenFile(s.outboxName+".tmp", os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0777)
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(file)
+	s.DB.Lock()
+	deliveries := make([]interfaces.WebhookDelivery, 0, len(s.DB.deliveries))
+	for _, delivery := range s.DB.deliveries {
+		deliveries = append(deliveries, delivery)
+	}
+	s.DB.Unlock()
+	for i := range deliveries {
+		if err = encoder.Encode(&ModelFile{Kind: kindDelivery, Delivery: &deliveries[i]}); err != nil {
+			file.Close()
+			return err
+		}
+	}
+	if err = file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	if err = os.Rename(file.Name(), s.outboxName); err != nil {
+		file.Close()
+		return err
+	}
+	if s.outbox != nil {
+		s.outbox.Close()
+	}
+	s.outbox = file
+	s.outboxEncoder = encoder
+	return nil
+}
 
+//	writeDelivery Append a record of the delivery to the outbox file.
+func (s *InFile) writeDelivery(delivery interfaces.WebhookDelivery) error {
+	return s.outboxEncoder.Encode(&ModelFile{Kind: kindDelivery, Delivery: &delivery})
 }
 
-//	GetURL Get original URL from file.
-func (s *InFile) GetURL(key string) (string, error) {
-	s.Lock()
-	defer s.Unlock()
-	if _, ok := s.Storage[key]; ok {
-		return s.Storage[key], nil
+//	replay Apply a file record to the memory storage.
+func (s *InFile) replay(dataFile ModelFile) error {
+	switch dataFile.Kind {
+	case kindURL:
+		_, existed := s.DB.Storage[dataFile.ShortURL]
+		err := s.DB.CreateURL(dataFile.UserID, dataFile.WorkspaceID, dataFile.ShortURL, dataFile.BaseURL, dataFile.options())
+		if err != nil && !errors.Is(err, interfaces.ErrAlreadyExists) {
+			return err
+		}
+		if !existed {
+			var createdAt time.Time
+			if dataFile.CreatedAt != nil {
+				createdAt = *dataFile.CreatedAt
+			}
+			s.DB.setCreatedAt(dataFile.ShortURL, createdAt)
+		}
+	case kindHealth:
+		if dataFile.Health != nil {
+			return s.DB.SetURLHealth(dataFile.BaseURL, *dataFile.Health)
+		}
+	case kindMeta:
+		if dataFile.Meta != nil {
+			return s.DB.SetPageMeta(dataFile.BaseURL, *dataFile.Meta)
+		}
+	case kindVerdict:
+		if dataFile.Verdict != nil {
+			return s.DB.SetURLVerdict(dataFile.BaseURL, *dataFile.Verdict)
+		}
+	case kindClaim:
+		return s.DB.ClaimURLs(dataFile.UserID, dataFile.Owner)
+	case kindAccount:
+		if dataFile.Account != nil {
+			return s.DB.CreateAccount(*dataFile.Account)
+		}
+	case kindAPIKey:
+		if dataFile.APIKey != nil {
+			return s.DB.SetAPIKey(*dataFile.APIKey)
+		}
+	case kindDelKey:
+		err := s.DB.DelAPIKey(dataFile.UserID, dataFile.KeyID)
+		if err != nil && !errors.Is(err, interfaces.ErrNotFound) {
+			return err
+		}
+	case kindWorkspace:
+		if dataFile.Workspace != nil {
+			return s.DB.CreateWorkspace(*dataFile.Workspace, dataFile.UserID)
+		}
+	case kindMember:
+		if dataFile.Member != nil {
+			return s.DB.SetMember(*dataFile.Member)
+		}
+	case kindDelMember:
+		err := s.DB.DelMember(dataFile.WorkspaceID, dataFile.UserID)
+		if err != nil && !errors.Is(err, interfaces.ErrNotFound) {
+			return err
+		}
+	case kindDeleted:
+		return s.DB.DelBatchShortURLs([]interfaces.Task{{ID: dataFile.UserID, ShortURL: dataFile.ShortURL, WorkspaceID: dataFile.WorkspaceID}})
+	case kindRevision:
+		if dataFile.Revision != nil {
+			_, err := s.DB.RetargetURL(dataFile.WorkspaceID, *dataFile.Revision)
+			if err != nil && !errors.Is(err, interfaces.ErrNotFound) {
+				return err
+			}
+		}
+	case kindRedirect:
+		if dataFile.Redirect != nil {
+			err := s.DB.SetRedirect(dataFile.UserID, dataFile.WorkspaceID, dataFile.ShortURL, *dataFile.Redirect)
+			if err != nil && !errors.Is(err, interfaces.ErrNotFound) {
+				return err
+			}
+		}
+	case kindRules:
+		err := s.DB.SetRules(dataFile.UserID, dataFile.WorkspaceID, dataFile.ShortURL, dataFile.Rules)
+		if err != nil && !errors.Is(err, interfaces.ErrNotFound) {
+			return err
+		}
+	case kindSplit:
+		err := s.DB.SetDestinations(dataFile.UserID, dataFile.WorkspaceID, dataFile.ShortURL, dataFile.Destinations)
+		if err != nil && !errors.Is(err, interfaces.ErrNotFound) {
+			return err
+		}
+	case kindServed:
+		return s.DB.AddServed(dataFile.ShortURL, dataFile.DestinationID)
+	case kindAccess:
+		if dataFile.Access != nil {
+			err := s.DB.SetAccess(dataFile.UserID, dataFile.WorkspaceID, dataFile.ShortURL, *dataFile.Access)
+			if err != nil && !errors.Is(err, interfaces.ErrNotFound) {
+				return err
+			}
+		}
+	case kindPatch:
+		patch := interfaces.Patch{Verdict: dataFile.Verdict, Redirect: dataFile.Redirect, Access: dataFile.Access}
+		if dataFile.Revision != nil {
+			patch.NewURL, patch.ChangedAt = dataFile.Revision.NewURL, dataFile.Revision.ChangedAt
+		}
+		_, err := s.DB.PatchURL(dataFile.UserID, dataFile.WorkspaceID, dataFile.ShortURL, patch)
+		if err != nil && !errors.Is(err, interfaces.ErrNotFound) {
+			return err
+		}
+	case kindAnnotate:
+		if dataFile.Annotation != nil {
+			err := s.DB.SetAnnotation(dataFile.UserID, dataFile.WorkspaceID, dataFile.ShortURL, *dataFile.Annotation)
+			if err != nil && !errors.Is(err, interfaces.ErrNotFound) {
+				return err
+			}
+		}
+	case kindVisit:
+		_, err := s.DB.ConsumeVisit(dataFile.ShortURL)
+		if err != nil && !errors.Is(err, interfaces.ErrNotFound) && !errors.Is(err, interfaces.ErrWasDeleted) {
+			return err
+		}
+	case kindClick:
+		_, err := s.DB.AddClick(dataFile.ShortURL)
+		if err != nil && !errors.Is(err, interfaces.ErrNotFound) {
+			return err
+		}
+	case kindWebhook:
+		if dataFile.Webhook != nil {
+			return s.DB.CreateWebhook(*dataFile.Webhook)
+		}
+	case kindDelHook:
+		err := s.DB.DelWebhook(dataFile.UserID, dataFile.WorkspaceID, dataFile.KeyID)
+		if err != nil && !errors.Is(err, interfaces.ErrNotFound) {
+			return err
+		}
+	case kindDelivery:
+		if dataFile.Delivery != nil && !s.outboxed {
+			// a delivery is recorded when it is added and after every attempt, the last record wins
+			s.DB.Lock()
+			s.DB.putDelivery(*dataFile.Delivery)
+			s.DB.Unlock()
+		}
+	case kindPrune:
+		if dataFile.Before != nil && !s.outboxed {
+			return s.DB.PruneDeliveries(*dataFile.Before)
+		}
+	case kindChange:
+		if dataFile.Change != nil {
+			s.DB.addChange(*dataFile.Change)
+		}
+	case kindCursor:
+		return s.DB.SetChangeCursor(dataFile.KeyID, dataFile.Offset)
 	}
+	return nil
+}
 
-	return "", interfaces.ErrNotFound
+//	options Returning the options a URL of the record was created with.
+func (dataFile ModelFile) options() interfaces.LinkOptions {
+	options := interfaces.LinkOptions{Private: dataFile.Private}
+	if dataFile.Access != nil {
+		options.Access = *dataFile.Access
+	}
+	if dataFile.Redirect != nil {
+		options.Redirect = *dataFile.Redirect
+	}
+	options.Destinations = dataFile.Destinations
+	if dataFile.Annotation != nil {
+		options.Annotation = *dataFile.Annotation
+	}
+	return options
 }
 
-//	GetAllURLsByUserID Get all user URLs from file.
-func (s *InFile) GetAllURLsByUserID(userID string) ([]interfaces.ModelURL, error) {
-	if _, ok := s.cache[userID]; ok {
-		return s.cache[userID], nil
+//	createdAt Returning the time the short URL was first created for a record.
+func (s *InFile) createdAt(shortURL string) *time.Time {
+	createdAt := s.DB.createdAt(shortURL)
+	if createdAt.IsZero() {
+		return nil
 	}
-	return nil, interfaces.ErrNotFound
+	return &createdAt
 }
 
-func (s *InFile) DelBatchShortURLs(tasks []interfaces.Task) error {
+//	write Append a record to the file.
+func (s *InFile) write(dataFile ModelFile) error {
+	return s.encoder.Encode(&dataFile)
+}
 
+//	writeChanges Append records of the changes past the offset, after the records of what was changed.
+func (s *InFile) writeChanges(after int64) error {
+	for _, change := range s.DB.changesAfter(after) {
+		change := change
+		if err := s.write(ModelFile{Kind: kindChange, Change: &change}); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
+func (s *InFile) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.DB.Close(); err != nil {
+		return err
+	}
+	if err := s.outbox.Close(); err != nil {
+		return err
+	}
+	return s.file.Close()
+}
+
 //	SetShortURL Add new URL in file.
 func (s *InFile) SetShortURL(userID, key, value string) error {
-	s.Lock()
-	defer s.Unlock()
-	s.DataFile.UserID = userID
-	s.DataFile.ShortURL = key
-	s.DataFile.BaseURL = value
-	modelURL := interfaces.ModelURL{
-		ShortURL: key,
-		BaseURL:  value,
-	}
-	if _, ok := s.cache[userID]; ok {
-		for _, val := range s.cache[userID] {
-			if val.ShortURL == key {
-				return interfaces.ErrAlreadyExists
-			}
+	return s.CreateURL(userID, "", key, value, interfaces.LinkOptions{})
+}
+
+//	SetPrivateURL Add new private URL of the user or the workspace in file.
+func (s *InFile) SetPrivateURL(userID, workspaceID, key, value string) error {
+	return s.CreateURL(userID, workspaceID, key, value, interfaces.LinkOptions{Private: true})
+}
+
+//	CreateURL Add new URL of the user or the workspace with its options in file, they are written as one record.
+func (s *InFile) CreateURL(userID, workspaceID, key, value string, options interfaces.LinkOptions) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	last := s.DB.lastChange()
+	if err := s.DB.CreateURL(userID, workspaceID, key, value, options); err != nil {
+		return err
+	}
+	dataFile := ModelFile{
+		UserID:      userID,
+		ShortURL:    key,
+		BaseURL:     value,
+		WorkspaceID: workspaceID,
+		Private:     options.Private,
+		CreatedAt:   s.createdAt(key),
+	}
+	if !options.Access.IsZero() {
+		dataFile.Access = &options.Access
+	}
+	if !options.Redirect.IsZero() {
+		dataFile.Redirect = &options.Redirect
+	}
+	dataFile.Destinations = options.Destinations
+	if !options.Annotation.IsZero() {
+		dataFile.Annotation = &options.Annotation
+	}
+	if err := s.write(dataFile); err != nil {
+		return err
+	}
+	return s.writeChanges(last)
+}
+
+//	DelBatchShortURLs Delete user URLs in file.
+func (s *InFile) DelBatchShortURLs(tasks []interfaces.Task) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	last := s.DB.lastChange()
+	if err := s.DB.DelBatchShortURLs(tasks); err != nil {
+		return err
+	}
+	for _, task := range tasks {
+		err := s.write(ModelFile{
+			Kind:        kindDeleted,
+			UserID:      task.ID,
+			ShortURL:    task.ShortURL,
+			WorkspaceID: task.WorkspaceID,
+		})
+		if err != nil {
+			return err
 		}
 	}
-	s.cache[userID] = append(s.cache[userID], modelURL)
-	s.Storage[modelURL.ShortURL] = modelURL.BaseURL
-	return s.encoder.Encode(&s.DataFile)
+	return s.writeChanges(last)
+}
+
+//	SetURLHealth Save the last check result of the original URL in file.
+//	The record is appended only when the result has changed, so periodic checks
+//	don't grow the file; the check time of unchanged results is kept in memory.
+func (s *InFile) SetURLHealth(baseURL string, health interfaces.URLHealth) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.DB.Lock()
+	prev, ok := s.DB.health[baseURL]
+	s.DB.Unlock()
+	if err := s.DB.SetURLHealth(baseURL, health); err != nil {
+		return err
+	}
+	if ok && prev.StatusCode == health.StatusCode && prev.Error == health.Error {
+		return nil
+	}
+	return s.write(ModelFile{
+		Kind:    kindHealth,
+		BaseURL: baseURL,
+		Health:  &health,
+	})
+}
+
+//	SetPageMeta Save metadata of the page of the original URL in file.
+func (s *InFile) SetPageMeta(baseURL string, meta interfaces.PageMeta) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.DB.SetPageMeta(baseURL, meta); err != nil {
+		return err
+	}
+	return s.write(ModelFile{
+		Kind:    kindMeta,
+		BaseURL: baseURL,
+		Meta:    &meta,
+	})
+}
+
+//	SetURLVerdict Save the reputation of the original URL in file.
+func (s *InFile) SetURLVerdict(baseURL string, verdict interfaces.Verdict) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.DB.Lock()
+	prev := s.DB.verdicts[baseURL]
+	s.DB.Unlock()
+	if err := s.DB.SetURLVerdict(baseURL, verdict); err != nil {
+		return err
+	}
+	if prev == verdict {
+		return nil
+	}
+	return s.write(ModelFile{
+		Kind:    kindVerdict,
+		BaseURL: baseURL,
+		Verdict: &verdict,
+	})
+}
+
+//	ClaimURLs Move URLs of one user to another in file.
+func (s *InFile) ClaimURLs(fromUserID, toUserID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.DB.ClaimURLs(fromUserID, toUserID); err != nil {
+		return err
+	}
+	return s.write(ModelFile{
+		Kind:   kindClaim,
+		UserID: fromUserID,
+		Owner:  toUserID,
+	})
+}
+
+//	CreateAccount Add new account in file.
+func (s *InFile) CreateAccount(account interfaces.Account) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.DB.CreateAccount(account); err != nil {
+		return err
+	}
+	return s.write(ModelFile{
+		Kind:    kindAccount,
+		UserID:  account.UserID,
+		Account: &account,
+	})
+}
+
+//	SetAPIKey Add new API key in file.
+func (s *InFile) SetAPIKey(key interfaces.APIKey) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.DB.SetAPIKey(key); err != nil {
+		return err
+	}
+	return s.write(ModelFile{
+		Kind:   kindAPIKey,
+		UserID: key.UserID,
+		APIKey: &key,
+	})
+}
+
+//	DelAPIKey Delete API key of the user in file.
+func (s *InFile) DelAPIKey(userID, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.DB.DelAPIKey(userID, id); err != nil {
+		return err
+	}
+	return s.write(ModelFile{
+		Kind:   kindDelKey,
+		UserID: userID,
+		KeyID:  id,
+	})
+}
+
+//	SetWorkspaceURL Add new workspace URL in file.
+func (s *InFile) SetWorkspaceURL(workspaceID, userID, key, value string) error {
+	return s.CreateURL(userID, workspaceID, key, value, interfaces.LinkOptions{})
+}
+
+//	CreateWorkspace Add new workspace with its owner in file.
+func (s *InFile) CreateWorkspace(workspace interfaces.Workspace, ownerID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.DB.CreateWorkspace(workspace, ownerID); err != nil {
+		return err
+	}
+	return s.write(ModelFile{
+		Kind:      kindWorkspace,
+		UserID:    ownerID,
+		Workspace: &workspace,
+	})
+}
+
+//	SetMember Add a member to the workspace or change its role in file.
+func (s *InFile) SetMember(member interfaces.Member) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.DB.SetMember(member); err != nil {
+		return err
+	}
+	return s.write(ModelFile{
+		Kind:        kindMember,
+		UserID:      member.UserID,
+		WorkspaceID: member.WorkspaceID,
+		Member:      &member,
+	})
+}
+
+//	DelMember Delete a member of the workspace in file.
+func (s *InFile) DelMember(workspaceID, userID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.DB.DelMember(workspaceID, userID); err != nil {
+		return err
+	}
+	return s.write(ModelFile{
+		Kind:        kindDelMember,
+		UserID:      userID,
+		WorkspaceID: workspaceID,
+	})
+}
+
+//	RetargetURL Change the original URL of the short URL in file.
+func (s *InFile) RetargetURL(workspaceID string, revision interfaces.Revision) (interfaces.Revision, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	revision, err := s.DB.RetargetURL(workspaceID, revision)
+	if err != nil || revision.OldURL == revision.NewURL {
+		return revision, err
+	}
+	return revision, s.write(ModelFile{
+		Kind:        kindRevision,
+		UserID:      revision.UserID,
+		ShortURL:    revision.ShortURL,
+		BaseURL:     revision.NewURL,
+		WorkspaceID: workspaceID,
+		Revision:    &revision,
+	})
+}
+
+//	SetRedirect Change how the short URL redirects in file.
+func (s *InFile) SetRedirect(userID, workspaceID, shortURL string, redirect interfaces.Redirect) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.DB.SetRedirect(userID, workspaceID, shortURL, redirect); err != nil {
+		return err
+	}
+	return s.write(ModelFile{
+		Kind:        kindRedirect,
+		UserID:      userID,
+		ShortURL:    shortURL,
+		WorkspaceID: workspaceID,
+		Redirect:    &redirect,
+	})
+}
+
+//	SetRules Replace routing rules of the short URL in file.
+func (s *InFile) SetRules(userID, workspaceID, shortURL string, rules []interfaces.Rule) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.DB.SetRules(userID, workspaceID, shortURL, rules); err != nil {
+		return err
+	}
+	return s.write(ModelFile{
+		Kind:        kindRules,
+		UserID:      userID,
+		ShortURL:    shortURL,
+		WorkspaceID: workspaceID,
+		Rules:       rules,
+	})
+}
+
+//	SetDestinations Replace destinations of the short URL in file.
+func (s *InFile) SetDestinations(userID, workspaceID, shortURL string, destinations []interfaces.Destination) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.DB.SetDestinations(userID, workspaceID, shortURL, destinations); err != nil {
+		return err
+	}
+	return s.write(ModelFile{
+		Kind:         kindSplit,
+		UserID:       userID,
+		ShortURL:     shortURL,
+		WorkspaceID:  workspaceID,
+		Destinations: destinations,
+	})
+}
+
+//	AddServed Count a visitor sent to the destination of the short URL in file.
+func (s *InFile) AddServed(shortURL, destinationID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.DB.AddServed(shortURL, destinationID); err != nil {
+		return err
+	}
+	return s.write(ModelFile{
+		Kind:          kindServed,
+		ShortURL:      shortURL,
+		DestinationID: destinationID,
+	})
+}
+
+//	SetAccess Change access limits of the short URL in file.
+func (s *InFile) SetAccess(userID, workspaceID, shortURL string, access interfaces.Access) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.DB.SetAccess(userID, workspaceID, shortURL, access); err != nil {
+		return err
+	}
+	return s.write(ModelFile{
+		Kind:        kindAccess,
+		UserID:      userID,
+		ShortURL:    shortURL,
+		WorkspaceID: workspaceID,
+		Access:      &access,
+	})
+}
+
+//	PatchURL Apply the patch to the short URL in file, it is written as one record.
+func (s *InFile) PatchURL(userID, workspaceID, shortURL string, patch interfaces.Patch) (interfaces.Revision, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	revision, err := s.DB.PatchURL(userID, workspaceID, shortURL, patch)
+	if err != nil {
+		return revision, err
+	}
+	dataFile := ModelFile{
+		Kind:        kindPatch,
+		UserID:      userID,
+		ShortURL:    shortURL,
+		BaseURL:     revision.NewURL,
+		WorkspaceID: workspaceID,
+		Verdict:     patch.Verdict,
+		Redirect:    patch.Redirect,
+		Access:      patch.Access,
+	}
+	if revision.OldURL != revision.NewURL {
+		dataFile.Revision = &revision
+	}
+	return revision, s.write(dataFile)
+}
 
+//	ConsumeVisit Count a visit of the short URL in file.
+//	Visits are counted under the lock, so concurrent visits can't exceed the limit,
+//	and only once written, so a visit the file missed isn't counted until a restart.
+func (s *InFile) ConsumeVisit(shortURL string) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.DB.Lock()
+	err := s.DB.visitable(shortURL)
+	s.DB.Unlock()
+	if err != nil {
+		return 0, err
+	}
+	if err := s.write(ModelFile{Kind: kindVisit, ShortURL: shortURL}); err != nil {
+		return 0, err
+	}
+	return s.DB.ConsumeVisit(shortURL)
 }
 
-func (s *InFile) Ping() error {
+//	AddClick Count a click of the short URL in file, once it is written.
+func (s *InFile) AddClick(shortURL string) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.DB.Lock()
+	_, ok := s.DB.Storage[shortURL]
+	s.DB.Unlock()
+	if !ok {
+		return 0, interfaces.ErrNotFound
+	}
+	if err := s.write(ModelFile{Kind: kindClick, ShortURL: shortURL}); err != nil {
+		return 0, err
+	}
+	return s.DB.AddClick(shortURL)
+}
+
+//	CreateWebhook Add new webhook in file.
+func (s *InFile) CreateWebhook(webhook interfaces.Webhook) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.DB.CreateWebhook(webhook); err != nil {
+		return err
+	}
+	return s.write(ModelFile{
+		Kind:    kindWebhook,
+		UserID:  webhook.UserID,
+		Webhook: &webhook,
+	})
+}
+
+//	DelWebhook Delete webhook of the user or the workspace with its deliveries in file.
+func (s *InFile) DelWebhook(userID, workspaceID, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.DB.DelWebhook(userID, workspaceID, id); err != nil {
+		return err
+	}
+	return s.write(ModelFile{
+		Kind:        kindDelHook,
+		UserID:      userID,
+		WorkspaceID: workspaceID,
+		KeyID:       id,
+	})
+}
+
+//	AddDeliveries Add new webhook deliveries to the outbox in file, deliveries it has are kept as they are.
+func (s *InFile) AddDeliveries(deliveries []interfaces.WebhookDelivery) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.DB.Lock()
+	added := s.DB.addDeliveries(deliveries)
+	s.DB.Unlock()
+	for _, delivery := range added {
+		if err := s.writeDelivery(delivery); err != nil {
+			return err
+		}
+	}
 	return nil
 }
+
+//	SetDelivery Change the webhook delivery after an attempt in file.
+//	Claims of deliveries are not recorded, pending deliveries are due again after a restart.
+func (s *InFile) SetDelivery(delivery interfaces.WebhookDelivery) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.DB.SetDelivery(delivery); err != nil {
+		return err
+	}
+	return s.writeDelivery(delivery)
+}
+
+//	PruneDeliveries Delete delivered and failed webhook deliveries last changed before the time in file.
+//	The outbox file is rewritten with the deliveries left, so it doesn't grow with every attempt.
+func (s *InFile) PruneDeliveries(before time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.DB.PruneDeliveries(before); err != nil {
+		return err
+	}
+	return s.writeOutbox()
+}
+
+//	SetChangeCursor Save the offset of the last change the consumer has taken in file.
+func (s *InFile) SetChangeCursor(consumer string, offset int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.DB.SetChangeCursor(consumer, offset); err != nil {
+		return err
+	}
+	return s.write(ModelFile{
+		Kind:   kindCursor,
+		KeyID:  consumer,
+		Offset: offset,
+	})
+}
+
+//	SetAnnotation Change the title, the notes and the tags of the short URL in file.
+func (s *InFile) SetAnnotation(userID, workspaceID, shortURL string, annotation interfaces.Annotation) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.DB.SetAnnotation(userID, workspaceID, shortURL, annotation); err != nil {
+		return err
+	}
+	return s.write(ModelFile{
+		Kind:        kindAnnotate,
+		UserID:      userID,
+		ShortURL:    shortURL,
+		WorkspaceID: workspaceID,
+		Annotation:  &annotation,
+	})
+}
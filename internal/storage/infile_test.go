@@ -0,0 +1,688 @@
+package storage
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ivanmyagkov/shortener.git/internal/interfaces"
+)
+
+func TestInFile_RetargetURL(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "storage.json")
+	db, err := NewInFile(path)
+	require.NoError(t, err)
+	require.NoError(t, db.SetShortURL("user", "code", "https://a.example"))
+	revision, err := db.RetargetURL("", interfaces.Revision{ShortURL: "code", NewURL: "https://b.example", UserID: "user", ChangedAt: time.Now().UTC()})
+	require.NoError(t, err)
+	assert.Equal(t, "https://a.example", revision.OldURL)
+	assert.ErrorIs(t, db.SetShortURL("other", "code", "https://a.example"), interfaces.ErrCodeTaken)
+	require.NoError(t, db.Close())
+
+	db, err = NewInFile(path)
+	require.NoError(t, err)
+	defer db.Close()
+	baseURL, err := db.GetURL("code")
+	require.NoError(t, err)
+	assert.Equal(t, "https://b.example", baseURL)
+	revisions, err := db.GetURLHistory("user", "", "code")
+	require.NoError(t, err)
+	require.Len(t, revisions, 1)
+	assert.Equal(t, "https://a.example", revisions[0].OldURL)
+	_, err = db.GetURLHistory("other", "", "code")
+	assert.ErrorIs(t, err, interfaces.ErrNotFound)
+
+	require.NoError(t, db.SetShortURL("other", "code", "https://b.example"))
+	_, err = db.RetargetURL("", interfaces.Revision{ShortURL: "code", NewURL: "https://c.example", UserID: "user"})
+	assert.ErrorIs(t, err, interfaces.ErrShared)
+}
+
+func TestInFile_PrivateAndDeleted(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "storage.json")
+	db, err := NewInFile(path)
+	require.NoError(t, err)
+	require.NoError(t, db.SetPrivateURL("user", "", "private", "https://a.example"))
+	require.NoError(t, db.SetShortURL("user", "shared", "https://a.example"))
+	require.NoError(t, db.SetShortURL("other", "shared", "https://a.example"))
+	assert.ErrorIs(t, db.SetPrivateURL("other", "", "private", "https://a.example"), interfaces.ErrCodeTaken)
+	assert.ErrorIs(t, db.SetShortURL("other", "private", "https://a.example"), interfaces.ErrCodeTaken)
+	require.NoError(t, db.DelBatchShortURLs([]interfaces.Task{{ID: "user", ShortURL: "shared"}, {ID: "user", ShortURL: "private"}}))
+	require.NoError(t, db.Close())
+
+	db, err = NewInFile(path)
+	require.NoError(t, err)
+	defer db.Close()
+	_, err = db.GetURL("private")
+	assert.ErrorIs(t, err, interfaces.ErrWasDeleted)
+	_, err = db.GetURL("shared")
+	assert.NoError(t, err)
+	_, err = db.GetAllURLsByUserID("user")
+	assert.ErrorIs(t, err, interfaces.ErrNotFound)
+
+	require.NoError(t, db.SetPrivateURL("user", "", "private", "https://a.example"))
+	urls, err := db.GetAllURLsByUserID("user")
+	require.NoError(t, err)
+	require.Len(t, urls, 1)
+	assert.True(t, urls[0].Private)
+	_, err = db.GetURL("private")
+	assert.NoError(t, err)
+}
+
+func TestInFile_SetRedirect(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "storage.json")
+	db, err := NewInFile(path)
+	require.NoError(t, err)
+	require.NoError(t, db.SetPrivateURL("user", "", "code", "https://a.example"))
+	redirect := interfaces.Redirect{StatusCode: 301, ForwardQuery: true, UTM: map[string]string{"utm_source": "mail"}}
+	require.NoError(t, db.SetRedirect("user", "", "code", redirect))
+	assert.ErrorIs(t, db.SetRedirect("other", "", "code", redirect), interfaces.ErrNotFound)
+	require.NoError(t, db.Close())
+
+	db, err = NewInFile(path)
+	require.NoError(t, err)
+	defer db.Close()
+	link, err := db.GetLink("code")
+	require.NoError(t, err)
+	assert.Equal(t, redirect, link.Redirect)
+	urls, err := db.GetAllURLsByUserID("user")
+	require.NoError(t, err)
+	require.Len(t, urls, 1)
+	require.NotNil(t, urls[0].Redirect)
+	assert.Equal(t, redirect, *urls[0].Redirect)
+
+	require.NoError(t, db.SetRedirect("user", "", "code", interfaces.Redirect{}))
+	link, err = db.GetLink("code")
+	require.NoError(t, err)
+	assert.True(t, link.Redirect.IsZero())
+
+	require.NoError(t, db.SetShortURL("user", "shared", "https://b.example"))
+	require.NoError(t, db.SetShortURL("other", "shared", "https://b.example"))
+	assert.ErrorIs(t, db.SetRedirect("user", "", "shared", redirect), interfaces.ErrShared)
+}
+
+func TestInFile_SetRules(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "storage.json")
+	db, err := NewInFile(path)
+	require.NoError(t, err)
+	require.NoError(t, db.SetShortURL("user", "code", "https://a.example"))
+	rules := []interfaces.Rule{
+		{ID: "1", Devices: []string{"ios"}, URL: "https://apps.example"},
+		{ID: "2", Countries: []string{"DE"}, Languages: []string{"de"}, URL: "https://de.example"},
+	}
+	require.NoError(t, db.SetRules("user", "", "code", rules))
+	assert.ErrorIs(t, db.SetRules("other", "", "code", rules), interfaces.ErrNotFound)
+	require.NoError(t, db.Close())
+
+	db, err = NewInFile(path)
+	require.NoError(t, err)
+	defer db.Close()
+	got, err := db.GetRules("user", "", "code")
+	require.NoError(t, err)
+	assert.Equal(t, rules, got)
+	link, err := db.GetLink("code")
+	require.NoError(t, err)
+	assert.Equal(t, rules, link.Rules)
+	_, err = db.GetRules("other", "", "code")
+	assert.ErrorIs(t, err, interfaces.ErrNotFound)
+
+	require.NoError(t, db.SetRules("user", "", "code", nil))
+	got, err = db.GetRules("user", "", "code")
+	require.NoError(t, err)
+	assert.Empty(t, got)
+}
+
+func TestInFile_Destinations(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "storage.json")
+	db, err := NewInFile(path)
+	require.NoError(t, err)
+	require.NoError(t, db.SetPrivateURL("user", "", "code", "https://a.example"))
+	destinations := []interfaces.Destination{
+		{ID: "a", URL: "https://a.example", Weight: 70},
+		{ID: "b", URL: "https://b.example", Weight: 30},
+	}
+	require.NoError(t, db.SetDestinations("user", "", "code", destinations))
+	assert.ErrorIs(t, db.SetDestinations("other", "", "code", destinations), interfaces.ErrNotFound)
+	require.NoError(t, db.AddServed("code", "a"))
+	require.NoError(t, db.AddServed("code", "a"))
+	require.NoError(t, db.AddServed("code", "b"))
+	require.NoError(t, db.Close())
+
+	db, err = NewInFile(path)
+	require.NoError(t, err)
+	defer db.Close()
+	link, err := db.GetLink("code")
+	require.NoError(t, err)
+	assert.Equal(t, destinations, link.Destinations)
+	got, err := db.GetDestinations("user", "", "code")
+	require.NoError(t, err)
+	require.Len(t, got, 2)
+	assert.Equal(t, int64(2), got[0].Served)
+	assert.Equal(t, int64(1), got[1].Served)
+
+	// served counts stay with the ID
+	require.NoError(t, db.SetDestinations("user", "", "code", []interfaces.Destination{
+		{ID: "b", URL: "https://b.example", Weight: 50},
+		{ID: "c", URL: "https://c.example", Weight: 50},
+	}))
+	got, err = db.GetDestinations("user", "", "code")
+	require.NoError(t, err)
+	require.Len(t, got, 2)
+	assert.Equal(t, int64(1), got[0].Served)
+	assert.Equal(t, int64(0), got[1].Served)
+}
+
+func TestInFile_Access(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "storage.json")
+	db, err := NewInFile(path)
+	require.NoError(t, err)
+	require.NoError(t, db.SetPrivateURL("user", "", "code", "https://a.example"))
+	limits := interfaces.Access{PasswordHash: []byte("hash"), MaxVisits: 2}
+	require.NoError(t, db.SetAccess("user", "", "code", limits))
+	assert.ErrorIs(t, db.SetAccess("other", "", "code", limits), interfaces.ErrNotFound)
+	visits, err := db.ConsumeVisit("code")
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), visits)
+	require.NoError(t, db.Close())
+
+	db, err = NewInFile(path)
+	require.NoError(t, err)
+	defer db.Close()
+	link, err := db.GetLink("code")
+	require.NoError(t, err)
+	assert.Equal(t, limits, link.Access)
+	visits, err = db.ConsumeVisit("code")
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), visits)
+	_, err = db.ConsumeVisit("code")
+	assert.ErrorIs(t, err, interfaces.ErrWasDeleted)
+	_, err = db.GetLink("code")
+	assert.ErrorIs(t, err, interfaces.ErrWasDeleted)
+
+	// a new limit counts visits from scratch
+	require.NoError(t, db.SetAccess("user", "", "code", interfaces.Access{MaxVisits: 1}))
+	got, err := db.GetAccess("user", "", "code")
+	require.NoError(t, err)
+	assert.Equal(t, interfaces.Access{MaxVisits: 1}, got)
+	_, err = db.GetLink("code")
+	assert.NoError(t, err)
+}
+
+func TestInFile_CountFailedWrite(t *testing.T) {
+	s, err := NewInFile(filepath.Join(t.TempDir(), "storage.json"))
+	require.NoError(t, err)
+	db := s.(*InFile)
+	require.NoError(t, db.SetShortURL("user", "code", "https://a.example"))
+	require.NoError(t, db.SetAccess("user", "", "code", interfaces.Access{MaxVisits: 1}))
+	require.NoError(t, db.file.Close())
+
+	// counts the file missed aren't kept in memory either
+	_, err = db.ConsumeVisit("code")
+	assert.Error(t, err)
+	_, err = db.AddClick("code")
+	assert.Error(t, err)
+	assert.Zero(t, db.DB.visits["code"])
+	assert.Zero(t, db.DB.clicks["code"])
+	_, err = db.GetLink("code")
+	assert.NoError(t, err)
+}
+
+func TestInFile_PatchURL(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "storage.json")
+	db, err := NewInFile(path)
+	require.NoError(t, err)
+	require.NoError(t, db.SetPrivateURL("user", "", "code", "https://a.example"))
+	redirect := interfaces.Redirect{StatusCode: 301}
+	limits := interfaces.Access{MaxVisits: 5}
+	verdict := interfaces.Verdict{Flagged: true, Reason: "phishing"}
+	revision, err := db.PatchURL("user", "", "code", interfaces.Patch{NewURL: "https://b.example", ChangedAt: time.Now().UTC(),
+		Verdict: &verdict, Redirect: &redirect, Access: &limits})
+	require.NoError(t, err)
+	assert.Equal(t, "https://a.example", revision.OldURL)
+	assert.Equal(t, "https://b.example", revision.NewURL)
+	_, err = db.PatchURL("other", "", "code", interfaces.Patch{Redirect: &redirect})
+	assert.ErrorIs(t, err, interfaces.ErrNotFound)
+	require.NoError(t, db.Close())
+
+	db, err = NewInFile(path)
+	require.NoError(t, err)
+	defer db.Close()
+	link, err := db.GetLink("code")
+	require.NoError(t, err)
+	assert.Equal(t, "https://b.example", link.BaseURL)
+	assert.Equal(t, verdict, link.Verdict)
+	assert.Equal(t, redirect, link.Redirect)
+	assert.Equal(t, limits, link.Access)
+	revisions, err := db.GetURLHistory("user", "", "code")
+	require.NoError(t, err)
+	assert.Len(t, revisions, 1)
+
+	// fields left out are kept, the same URL makes no revision
+	revision, err = db.PatchURL("user", "", "code", interfaces.Patch{NewURL: "https://b.example", Redirect: &interfaces.Redirect{}})
+	require.NoError(t, err)
+	assert.Equal(t, revision.OldURL, revision.NewURL)
+	link, err = db.GetLink("code")
+	require.NoError(t, err)
+	assert.True(t, link.Redirect.IsZero())
+	assert.Equal(t, limits, link.Access)
+	revisions, err = db.GetURLHistory("user", "", "code")
+	require.NoError(t, err)
+	assert.Len(t, revisions, 1)
+
+	// a patch which can't be saved changes nothing
+	require.NoError(t, db.SetShortURL("user", "shared", "https://c.example"))
+	require.NoError(t, db.SetShortURL("other", "shared", "https://c.example"))
+	_, err = db.PatchURL("user", "", "shared", interfaces.Patch{NewURL: "https://d.example", Access: &limits})
+	assert.ErrorIs(t, err, interfaces.ErrShared)
+	link, err = db.GetLink("shared")
+	require.NoError(t, err)
+	assert.Equal(t, "https://c.example", link.BaseURL)
+	assert.True(t, link.Access.IsZero())
+}
+
+func TestInFile_CreateURL(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "storage.json")
+	db, err := NewInFile(path)
+	require.NoError(t, err)
+	options := interfaces.LinkOptions{Private: true, Access: interfaces.Access{PasswordHash: []byte("hash"), MaxVisits: 2},
+		Redirect: interfaces.Redirect{StatusCode: 301}, Destinations: []interfaces.Destination{{ID: "1", URL: "https://b.example", Weight: 1}},
+		Annotation: interfaces.Annotation{Title: "Launch", Tags: []string{"release"}}}
+	require.NoError(t, db.CreateURL("user", "ws", "code", "https://a.example", options))
+	assert.ErrorIs(t, db.CreateURL("other", "", "code", "https://a.example", interfaces.LinkOptions{}), interfaces.ErrCodeTaken)
+	require.NoError(t, db.Close())
+
+	db, err = NewInFile(path)
+	require.NoError(t, err)
+	defer db.Close()
+	link, err := db.GetLink("code")
+	require.NoError(t, err)
+	assert.Equal(t, options.Access, link.Access)
+	assert.Equal(t, options.Redirect, link.Redirect)
+	destinations, err := db.GetDestinations("user", "ws", "code")
+	require.NoError(t, err)
+	assert.Equal(t, options.Destinations, destinations)
+	urls, err := db.GetWorkspaceURLs("ws")
+	require.NoError(t, err)
+	require.Len(t, urls, 1)
+	assert.True(t, urls[0].Private)
+	assert.Equal(t, "Launch", urls[0].Title)
+}
+
+func TestInFile_CreatedAt(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "storage.json")
+	db, err := NewInFile(path)
+	require.NoError(t, err)
+	require.NoError(t, db.SetShortURL("user", "code", "https://a.example"))
+	link, err := db.GetLink("code")
+	require.NoError(t, err)
+	createdAt := link.CreatedAt
+	require.False(t, createdAt.IsZero())
+	require.NoError(t, db.SetShortURL("other", "code", "https://a.example"))
+	require.NoError(t, db.Close())
+
+	db, err = NewInFile(path)
+	require.NoError(t, err)
+	defer db.Close()
+	link, err = db.GetLink("code")
+	require.NoError(t, err)
+	assert.True(t, createdAt.Equal(link.CreatedAt))
+}
+
+func TestInFile_SetPageMeta(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "storage.json")
+	db, err := NewInFile(path)
+	require.NoError(t, err)
+	require.NoError(t, db.SetShortURL("user", "code", "https://a.example"))
+	meta := interfaces.PageMeta{Title: "A", FaviconURL: "https://a.example/favicon.ico", FetchedAt: time.Now().UTC().Truncate(time.Second)}
+	require.NoError(t, db.SetPageMeta("https://a.example", meta))
+	require.NoError(t, db.Close())
+
+	db, err = NewInFile(path)
+	require.NoError(t, err)
+	defer db.Close()
+	link, err := db.GetLink("code")
+	require.NoError(t, err)
+	require.NotNil(t, link.Meta)
+	assert.Equal(t, meta.Title, link.Meta.Title)
+	assert.True(t, meta.FetchedAt.Equal(link.Meta.FetchedAt))
+	urls, err := db.GetAllURLsByUserID("user")
+	require.NoError(t, err)
+	require.Len(t, urls, 1)
+	require.NotNil(t, urls[0].Meta)
+	assert.Equal(t, meta.FaviconURL, urls[0].Meta.FaviconURL)
+}
+
+func TestInFile_Domains(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "storage.json")
+	db, err := NewInFile(path)
+	require.NoError(t, err)
+	// the same code in two domains leads to different URLs
+	require.NoError(t, db.SetShortURL("user", "code", "https://a.example"))
+	require.NoError(t, db.SetPrivateURL("user", "", "go.example.com/code", "https://b.example"))
+	require.NoError(t, db.DelBatchShortURLs([]interfaces.Task{{ID: "user", ShortURL: "code"}}))
+	require.NoError(t, db.Close())
+
+	db, err = NewInFile(path)
+	require.NoError(t, err)
+	defer db.Close()
+	_, err = db.GetURL("code")
+	assert.ErrorIs(t, err, interfaces.ErrWasDeleted)
+	baseURL, err := db.GetURL("go.example.com/code")
+	require.NoError(t, err)
+	assert.Equal(t, "https://b.example", baseURL)
+	assert.ErrorIs(t, db.SetShortURL("other", "go.example.com/code", "https://a.example"), interfaces.ErrCodeTaken)
+}
+
+func TestInFile_Annotations(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "storage.json")
+	db, err := NewInFile(path)
+	require.NoError(t, err)
+	require.NoError(t, db.SetShortURL("user", "a", "https://a.example/launch"))
+	require.NoError(t, db.SetShortURL("user", "b", "https://b.example"))
+	require.NoError(t, db.SetShortURL("user", "c", "https://c.example"))
+	require.NoError(t, db.SetShortURL("other", "c", "https://c.example"))
+	require.NoError(t, db.SetAnnotation("user", "", "a", interfaces.Annotation{Title: "Post", Tags: []string{"blog", "news"}}))
+	require.NoError(t, db.SetAnnotation("user", "", "b", interfaces.Annotation{Title: "Launch video", Tags: []string{"news"}}))
+	require.NoError(t, db.SetAnnotation("user", "", "c", interfaces.Annotation{Notes: "shared", Tags: []string{"blog"}}))
+	// owners sharing a short URL annotate it each in their own way
+	require.NoError(t, db.SetAnnotation("other", "", "c", interfaces.Annotation{Tags: []string{"mine"}}))
+	assert.ErrorIs(t, db.SetAnnotation("nobody", "", "c", interfaces.Annotation{Title: "x"}), interfaces.ErrNotFound)
+	require.NoError(t, db.SetAnnotation("user", "", "b", interfaces.Annotation{Title: "Launch video", Tags: []string{"news", "video"}}))
+	require.NoError(t, db.DelBatchShortURLs([]interfaces.Task{{ID: "user", ShortURL: "c"}}))
+	require.NoError(t, db.Close())
+
+	db, err = NewInFile(path)
+	require.NoError(t, err)
+	defer db.Close()
+	codes := func(userID string, filter interfaces.URLFilter) []string {
+		models, err := db.FindURLs(userID, "", filter)
+		require.NoError(t, err)
+		codes := make([]string, 0, len(models))
+		for _, model := range models {
+			codes = append(codes, model.ShortURL)
+		}
+		return codes
+	}
+	assert.Equal(t, []string{"a", "b"}, codes("user", interfaces.URLFilter{}))
+	assert.Equal(t, []string{"a", "b"}, codes("user", interfaces.URLFilter{Tags: []string{"news"}}))
+	assert.Equal(t, []string{"b"}, codes("user", interfaces.URLFilter{Tags: []string{"news", "video"}}))
+	assert.Equal(t, []string{"a"}, codes("user", interfaces.URLFilter{Tags: []string{"blog"}}))
+	assert.Empty(t, codes("user", interfaces.URLFilter{Tags: []string{"mine"}}))
+	// the query is found in original URLs and titles
+	assert.Equal(t, []string{"a", "b"}, codes("user", interfaces.URLFilter{Query: "LAUNCH"}))
+	assert.Equal(t, []string{"b"}, codes("user", interfaces.URLFilter{Tags: []string{"news"}, Query: "video"}))
+	assert.Equal(t, []string{"c"}, codes("other", interfaces.URLFilter{Tags: []string{"mine"}}))
+	_, err = db.FindURLs("nobody", "", interfaces.URLFilter{Tags: []string{"blog"}})
+	assert.ErrorIs(t, err, interfaces.ErrNotFound)
+
+	models, err := db.FindURLs("user", "", interfaces.URLFilter{Tags: []string{"video"}})
+	require.NoError(t, err)
+	require.Len(t, models, 1)
+	assert.Equal(t, interfaces.Annotation{Title: "Launch video", Tags: []string{"news", "video"}}, models[0].Annotation)
+
+	// annotations move with claimed URLs
+	require.NoError(t, db.ClaimURLs("user", "claimer"))
+	assert.Equal(t, []string{"b"}, codes("claimer", interfaces.URLFilter{Tags: []string{"video"}}))
+	_, err = db.FindURLs("user", "", interfaces.URLFilter{Tags: []string{"video"}})
+	assert.ErrorIs(t, err, interfaces.ErrNotFound)
+}
+
+func TestInFile_Search(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "storage.json")
+	db, err := NewInFile(path)
+	require.NoError(t, err)
+	require.NoError(t, db.SetShortURL("user", "a", "https://blog.example/posts/launch"))
+	require.NoError(t, db.SetShortURL("user", "b", "https://video.example/watch"))
+	require.NoError(t, db.SetShortURL("user", "c", "https://docs.example/guide"))
+	require.NoError(t, db.SetShortURL("other", "c", "https://docs.example/guide"))
+	require.NoError(t, db.SetAnnotation("user", "", "b", interfaces.Annotation{Title: "Launch video"}))
+	require.NoError(t, db.SetAnnotation("other", "", "c", interfaces.Annotation{Notes: "launch checklist"}))
+	_, err = db.RetargetURL("", interfaces.Revision{ShortURL: "a", UserID: "user", NewURL: "https://blog.example/posts/release"})
+	require.NoError(t, err)
+	require.NoError(t, db.SetShortURL("user", "d", "https://old.example"))
+	require.NoError(t, db.DelBatchShortURLs([]interfaces.Task{{ID: "user", ShortURL: "d"}}))
+	require.NoError(t, db.Close())
+
+	db, err = NewInFile(path)
+	require.NoError(t, err)
+	defer db.Close()
+	codes := func(userID, text string, offset, limit int) ([]string, int) {
+		result, err := db.SearchURLs(userID, "", interfaces.SearchQuery{Text: text, Offset: offset, Limit: limit})
+		require.NoError(t, err)
+		codes := make([]string, 0, len(result.Hits))
+		for _, hit := range result.Hits {
+			codes = append(codes, hit.ShortURL)
+		}
+		return codes, result.Total
+	}
+	found, total := codes("user", "launch", 0, 0)
+	assert.Equal(t, []string{"b"}, found, "the old URL of a retargeted short URL is not searched")
+	assert.Equal(t, 1, total)
+	found, _ = codes("user", "release", 0, 0)
+	assert.Equal(t, []string{"a"}, found)
+	found, _ = codes("user", "old", 0, 0)
+	assert.Empty(t, found)
+	found, _ = codes("other", "launch", 0, 0)
+	assert.Equal(t, []string{"c"}, found)
+	// pages of hits, the best first
+	found, total = codes("user", "example", 1, 1)
+	assert.Equal(t, []string{"b"}, found)
+	assert.Equal(t, 3, total)
+	found, total = codes("user", "example", 5, 1)
+	assert.Empty(t, found)
+	assert.Equal(t, 3, total)
+
+	result, err := db.SearchURLs("user", "", interfaces.SearchQuery{Text: "vidoe"})
+	require.NoError(t, err)
+	require.Len(t, result.Hits, 1)
+	assert.Equal(t, "Launch video", result.Hits[0].Title)
+	assert.Greater(t, result.Hits[0].Score, 0.0)
+
+	// the index moves with claimed URLs
+	require.NoError(t, db.ClaimURLs("user", "claimer"))
+	found, _ = codes("claimer", "release", 0, 0)
+	assert.Equal(t, []string{"a"}, found)
+	found, total = codes("user", "release", 0, 0)
+	assert.Empty(t, found)
+	assert.Zero(t, total)
+}
+
+func TestInFile_Webhooks(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "storage.json")
+	db, err := NewInFile(path)
+	require.NoError(t, err)
+	now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	webhook := interfaces.Webhook{ID: "hook", UserID: "user", URL: "https://hooks.example", Secret: "secret",
+		Events: []string{interfaces.EventLinkClicks}, Thresholds: []int64{10, 100}, CreatedAt: now}
+	require.NoError(t, db.CreateWebhook(webhook))
+	assert.ErrorIs(t, db.CreateWebhook(webhook), interfaces.ErrAlreadyExists)
+	require.NoError(t, db.CreateWebhook(interfaces.Webhook{ID: "gone", UserID: "user", URL: "https://gone.example",
+		Events: []string{interfaces.EventLinkCreated}, CreatedAt: now}))
+	require.NoError(t, db.CreateWebhook(interfaces.Webhook{ID: "team", UserID: "user", WorkspaceID: "ws", URL: "https://team.example",
+		Events: []string{interfaces.EventLinkClicks}, Thresholds: []int64{100, 1000}, CreatedAt: now}))
+	delivery := func(id, webhookID string, age time.Duration) interfaces.WebhookDelivery {
+		return interfaces.WebhookDelivery{ID: id, WebhookID: webhookID, Event: interfaces.EventLinkClicks, Payload: []byte(`{"id":"` + id + `"}`),
+			Status: interfaces.DeliveryPending, NextAttemptAt: now.Add(-age), CreatedAt: now.Add(-age), UpdatedAt: now.Add(-age)}
+	}
+	require.NoError(t, db.AddDeliveries([]interfaces.WebhookDelivery{delivery("d1", "hook", 2*time.Minute), delivery("d2", "hook", time.Minute),
+		delivery("d3", "gone", time.Minute), delivery("old", "hook", 48*time.Hour)}))
+	require.NoError(t, db.DelWebhook("user", "", "gone"))
+	assert.ErrorIs(t, db.DelWebhook("other", "", "hook"), interfaces.ErrNotFound)
+	assert.ErrorIs(t, db.DelWebhook("user", "", "team"), interfaces.ErrNotFound)
+
+	old := delivery("old", "hook", 48*time.Hour)
+	old.Status = interfaces.DeliveryDelivered
+	old.Attempts = 1
+	require.NoError(t, db.SetDelivery(old))
+	failed := delivery("d1", "hook", 2*time.Minute)
+	failed.Attempts = 1
+	failed.Error = "status 500"
+	failed.ResponseStatus = 500
+	failed.NextAttemptAt = now.Add(time.Minute)
+	require.NoError(t, db.SetDelivery(failed))
+	// adding a delivery again keeps the one there is
+	require.NoError(t, db.AddDeliveries([]interfaces.WebhookDelivery{delivery("d1", "hook", 2*time.Minute)}))
+	assert.ErrorIs(t, db.SetDelivery(delivery("d3", "gone", 0)), interfaces.ErrNotFound)
+	require.NoError(t, db.PruneDeliveries(now.Add(-24*time.Hour)))
+
+	require.NoError(t, db.SetShortURL("user", "code", "https://a.example"))
+	require.NoError(t, db.SetWorkspaceURL("ws", "user", "code", "https://a.example"))
+	clicks, err := db.AddClick("code")
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), clicks)
+	require.NoError(t, db.Close())
+
+	db, err = NewInFile(path)
+	require.NoError(t, err)
+	defer db.Close()
+	webhooks, err := db.GetWebhooks("user", "")
+	require.NoError(t, err)
+	assert.Equal(t, []interfaces.Webhook{webhook}, webhooks)
+	webhooks, err = db.GetWebhooks("other", "ws")
+	require.NoError(t, err)
+	require.Len(t, webhooks, 1)
+	assert.Equal(t, "team", webhooks[0].ID)
+	got, err := db.GetWebhook("hook")
+	require.NoError(t, err)
+	assert.Equal(t, webhook, got)
+	_, err = db.GetWebhook("gone")
+	assert.ErrorIs(t, err, interfaces.ErrNotFound)
+	thresholds, err := db.GetClickThresholds()
+	require.NoError(t, err)
+	assert.Equal(t, []int64{10, 100, 1000}, thresholds)
+
+	clicks, err = db.AddClick("code")
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), clicks)
+	owners, err := db.GetOwners("code")
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []interfaces.Owner{{UserID: "user"}, {WorkspaceID: "ws"}}, owners)
+
+	// the delivered one was pruned, the deliveries of the deleted webhook are gone
+	deliveries, err := db.GetDeliveries("user", "", "hook", 10)
+	require.NoError(t, err)
+	require.Len(t, deliveries, 2)
+	assert.Equal(t, "d2", deliveries[0].ID)
+	assert.Equal(t, failed, deliveries[1])
+	deliveries, err = db.GetDeliveries("user", "", "hook", 1)
+	require.NoError(t, err)
+	assert.Len(t, deliveries, 1)
+	_, err = db.GetDeliveries("other", "", "hook", 10)
+	assert.ErrorIs(t, err, interfaces.ErrNotFound)
+
+	// only due deliveries are claimed, and not again until the lease runs out
+	claimed, err := db.ClaimDeliveries(now, time.Minute, 10)
+	require.NoError(t, err)
+	require.Len(t, claimed, 1)
+	assert.Equal(t, "d2", claimed[0].ID)
+	claimed, err = db.ClaimDeliveries(now.Add(30*time.Second), time.Minute, 10)
+	require.NoError(t, err)
+	assert.Empty(t, claimed)
+	claimed, err = db.ClaimDeliveries(now.Add(2*time.Minute), time.Minute, 10)
+	require.NoError(t, err)
+	assert.Len(t, claimed, 2)
+}
+
+func TestInFile_Outbox(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "storage.json")
+	now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	delivery := interfaces.WebhookDelivery{ID: "d1", WebhookID: "hook", Event: interfaces.EventLinkClicks, Payload: []byte(`{"id":"d1"}`),
+		Status: interfaces.DeliveryPending, NextAttemptAt: now, CreatedAt: now, UpdatedAt: now}
+
+	// deliveries kept in the file before the outbox had one of its own are moved to it
+	legacy, err := json.Marshal(ModelFile{Kind: kindDelivery, Delivery: &delivery})
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(path, append(legacy, '\n'), 0644))
+	db, err := NewInFile(path)
+	require.NoError(t, err)
+	require.NoError(t, db.CreateWebhook(interfaces.Webhook{ID: "hook", UserID: "user", URL: "https://hooks.example",
+		Events: []string{interfaces.EventLinkClicks}, CreatedAt: now}))
+	deliveries, err := db.GetDeliveries("user", "", "hook", 10)
+	require.NoError(t, err)
+	assert.Equal(t, []interfaces.WebhookDelivery{delivery}, deliveries)
+
+	// attempts grow the outbox until finished deliveries are pruned
+	for i := 1; i <= 20; i++ {
+		delivery.Attempts = i
+		delivery.Status = interfaces.DeliveryFailed
+		require.NoError(t, db.SetDelivery(delivery))
+	}
+	grown, err := os.Stat(path + outboxSuffix)
+	require.NoError(t, err)
+	require.NoError(t, db.PruneDeliveries(now.Add(time.Hour)))
+	pruned, err := os.Stat(path + outboxSuffix)
+	require.NoError(t, err)
+	assert.Less(t, pruned.Size(), grown.Size())
+	require.NoError(t, db.Close())
+
+	// the pruned delivery doesn't come back from the record left in the file
+	db, err = NewInFile(path)
+	require.NoError(t, err)
+	defer db.Close()
+	deliveries, err = db.GetDeliveries("user", "", "hook", 10)
+	require.NoError(t, err)
+	assert.Empty(t, deliveries)
+}
+
+func TestInFile_Changes(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "storage.json")
+	db, err := NewInFile(path)
+	require.NoError(t, err)
+	require.NoError(t, db.SetShortURL("user", "a", "https://a.example"))
+	assert.ErrorIs(t, db.SetShortURL("user", "a", "https://a.example"), interfaces.ErrAlreadyExists)
+	require.NoError(t, db.SetShortURL("other", "a", "https://a.example"))
+	require.NoError(t, db.SetWorkspaceURL("ws", "other", "b", "https://b.example"))
+	require.NoError(t, db.SetPrivateURL("user", "", "c", "https://c.example"))
+	// deleting a link nobody has changes nothing
+	require.NoError(t, db.DelBatchShortURLs([]interfaces.Task{{ID: "user", ShortURL: "a"}, {ID: "user", ShortURL: "b"}}))
+	require.NoError(t, db.SetChangeCursor("file", 2))
+	before, err := db.GetChanges("", "", 0, 0)
+	require.NoError(t, err)
+	require.Len(t, before, 5)
+	require.NoError(t, db.Close())
+
+	db, err = NewInFile(path)
+	require.NoError(t, err)
+	defer db.Close()
+	all, err := db.GetChanges("", "", 0, 0)
+	require.NoError(t, err)
+	assert.Equal(t, before, all, "offsets and times are read back")
+	for i, change := range all {
+		assert.Equal(t, int64(i+1), change.Offset)
+	}
+	assert.Equal(t, interfaces.Change{Offset: 5, Type: interfaces.EventLinkDeleted, ShortURL: "a", BaseURL: "https://a.example",
+		UserID: "user", CreatedAt: all[4].CreatedAt}, all[4])
+	cursor, err := db.GetChangeCursor("file")
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), cursor)
+	cursor, err = db.GetChangeCursor("http")
+	require.NoError(t, err)
+	assert.Zero(t, cursor)
+
+	changes, err := db.GetChanges("user", "", 1, 0)
+	require.NoError(t, err)
+	require.Len(t, changes, 2)
+	assert.Equal(t, "c", changes[0].ShortURL)
+	assert.Equal(t, interfaces.EventLinkDeleted, changes[1].Type)
+	changes, err = db.GetChanges("nobody", "ws", 0, 0)
+	require.NoError(t, err)
+	require.Len(t, changes, 1)
+	assert.Equal(t, "b", changes[0].ShortURL)
+	assert.Equal(t, "other", changes[0].UserID)
+	changes, err = db.GetChanges("", "", 1, 2)
+	require.NoError(t, err)
+	require.Len(t, changes, 2)
+	assert.Equal(t, int64(2), changes[0].Offset)
+
+	// new changes go on from the last offset
+	require.NoError(t, db.SetShortURL("user", "d", "https://d.example"))
+	changes, err = db.GetChanges("", "", 5, 0)
+	require.NoError(t, err)
+	require.Len(t, changes, 1)
+	assert.Equal(t, int64(6), changes[0].Offset)
+	assert.Equal(t, interfaces.EventLinkCreated, changes[0].Type)
+}
@@ -3,18 +3,27 @@ package storage
 import (
 	"context"
 	"database/sql"
-	"errors"
+	"encoding/json"
+	"fmt"
 	"log"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/jackc/pgerrcode"
 	"github.com/lib/pq"
 
 	"github.com/ivanmyagkov/shortener.git/internal/interfaces"
+	"github.com/ivanmyagkov/shortener.git/internal/search"
 )
 
 type Storage struct {
 	db *sql.DB
+	// pg_trgm is available for fuzzy search
+	trigram bool
+
+	mu        sync.Mutex
+	rateSwept time.Time
 }
 
 //	NewDB is function to create DB connection.
@@ -32,155 +41,1555 @@ func NewDB(psqlConn string) (*Storage, error) {
 		return nil, interfaces.ErrCreateTable
 	}
 	return &Storage{
-		db: db,
+		db:      db,
+		trigram: createSearchIndexes(db),
 	}, nil
 }
 
 //	GetURL Get original URL from DB.
 func (D *Storage) GetURL(shortURL string) (string, error) {
+	link, err := D.GetLink(shortURL)
+	if err != nil {
+		return "", err
+	}
+	return link.BaseURL, nil
+}
+
+//	GetLink Get short URL with its original URL and reputation from DB.
+func (D *Storage) GetLink(shortURL string) (interfaces.Link, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
-	var baseURL string
+	link := interfaces.Link{ShortURL: shortURL}
 	var isDeleted bool
-	query := `SELECT base_url, is_deleted from urls right join users_url uu on urls.id = uu.url_id where short_url=$1`
-	rows, err := D.db.QueryContext(ctx, query, shortURL)
-	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			return "", interfaces.ErrNotFound
+	var flagged sql.NullBool
+	var flagReason sql.NullString
+	var redirect, rules, destinations, meta []byte
+	var visits int64
+	var createdAt sql.NullTime
+	// the short URL is deleted only when all its owners deleted it
+	query := `SELECT u.base_url, coalesce(bool_and(uu.is_deleted), false), u.flagged, u.flag_reason, u.redirect, u.rules, u.destinations,
+	u.password_hash, u.max_visits, u.visits, u.created_at, m.meta FROM urls u
+	LEFT JOIN users_url uu ON uu.url_id = u.id LEFT JOIN url_meta m ON m.base_url = u.base_url WHERE u.short_url = $1 GROUP BY u.id, m.meta;`
+	err := D.db.QueryRowContext(ctx, query, shortURL).Scan(&link.BaseURL, &isDeleted, &flagged, &flagReason, &redirect, &rules, &destinations,
+		&link.Access.PasswordHash, &link.Access.MaxVisits, &visits, &createdAt, &meta)
+	if err == sql.ErrNoRows {
+		return interfaces.Link{}, interfaces.ErrNotFound
+	} else if err != nil {
+		return interfaces.Link{}, err
+	}
+	if isDeleted {
+		return interfaces.Link{}, interfaces.ErrWasDeleted
+	}
+	if link.Access.MaxVisits > 0 && visits >= link.Access.MaxVisits {
+		return interfaces.Link{}, interfaces.ErrExpired
+	}
+	link.CreatedAt = createdAt.Time
+	link.Flagged = flagged.Bool
+	link.Reason = flagReason.String
+	if redirect != nil {
+		if err = json.Unmarshal(redirect, &link.Redirect); err != nil {
+			return interfaces.Link{}, err
 		}
-		return "", err
 	}
-	defer rows.Close()
-
-	if err = rows.Err(); err != nil {
-		return "", err
+	if rules != nil {
+		if err = json.Unmarshal(rules, &link.Rules); err != nil {
+			return interfaces.Link{}, err
+		}
 	}
-	for rows.Next() {
-		if err = rows.Scan(&baseURL, &isDeleted); err != nil {
-			return "", err
+	if destinations != nil {
+		if err = json.Unmarshal(destinations, &link.Destinations); err != nil {
+			return interfaces.Link{}, err
 		}
-		if !isDeleted {
-			break
+	}
+	if meta != nil {
+		link.Meta = &interfaces.PageMeta{}
+		if err = json.Unmarshal(meta, link.Meta); err != nil {
+			return interfaces.Link{}, err
 		}
 	}
+	return link, nil
+}
 
-	if isDeleted {
-		return "", interfaces.ErrWasDeleted
-	}
+//	GetAllURLsByUserID Get all user URLs from DB, workspace URLs are not included.
+func (D *Storage) GetAllURLsByUserID(userID string) ([]interfaces.ModelURL, error) {
+	return D.getURLs(`user_id=$1 and workspace_id=''`, userID, interfaces.URLFilter{})
+}
 
-	return baseURL, nil
+//	GetWorkspaceURLs Get all workspace URLs from DB.
+func (D *Storage) GetWorkspaceURLs(workspaceID string) ([]interfaces.ModelURL, error) {
+	return D.getURLs(`workspace_id=$1 and workspace_id<>''`, workspaceID, interfaces.URLFilter{})
 }
 
-//	GetAllURLsByUserID Get all user URLs from DB.
-func (D *Storage) GetAllURLsByUserID(userID string) ([]interfaces.ModelURL, error) {
-	modelURL := make([]interfaces.ModelURL, 1000)
-	var model interfaces.ModelURL
-	rows, err := D.db.Query("SELECT short_url, base_url FROM users_url RIGHT JOIN urls u on users_url.url_id=u.id WHERE user_id=$1 and is_deleted=$2;", userID, false)
+//	FindURLs Get URLs of the user or the workspace passing the filter from DB.
+//	Tags are found by the GIN index of users_url.tags, the text by trigram indexes if pg_trgm is available.
+func (D *Storage) FindURLs(userID, workspaceID string, filter interfaces.URLFilter) ([]interfaces.ModelURL, error) {
+	if workspaceID != "" {
+		return D.getURLs(`workspace_id=$1 and workspace_id<>''`, workspaceID, filter)
+	}
+	return D.getURLs(`user_id=$1 and workspace_id=''`, userID, filter)
+}
+
+//	likeEscaper escapes wildcards of LIKE patterns.
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+func (D *Storage) getURLs(owner string, arg string, filter interfaces.URLFilter) ([]interfaces.ModelURL, error) {
+	modelURL := make([]interfaces.ModelURL, 0, 1000)
+	query := `SELECT short_url, u.base_url, private, redirect, password_hash IS NOT NULL, max_visits, visits, m.meta, status_code, check_error, checked_at,
+	users_url.title, users_url.notes, users_url.tags
+	FROM users_url RIGHT JOIN urls u on users_url.url_id=u.id LEFT JOIN url_meta m ON m.base_url = u.base_url WHERE ` + owner + ` and is_deleted=$2`
+	args := []interface{}{arg, false}
+	if len(filter.Tags) > 0 {
+		args = append(args, pq.Array(filter.Tags))
+		query += fmt.Sprintf(` and users_url.tags @> $%d`, len(args))
+	}
+	if filter.Query != "" {
+		args = append(args, "%"+likeEscaper.Replace(strings.ToLower(filter.Query))+"%")
+		query += fmt.Sprintf(` and (lower(u.base_url) LIKE $%d OR lower(users_url.title) LIKE $%d)`, len(args), len(args))
+	}
+	rows, err := D.db.Query(query, args...)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	if err = rows.Err(); err != nil {
-		return nil, err
-	}
 	for rows.Next() {
-		if err = rows.Scan(&model.ShortURL, &model.BaseURL); err != nil {
+		model, err := scanURL(rows)
+		if err != nil {
 			return nil, err
 		}
 		modelURL = append(modelURL, model)
 	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
 	return modelURL, nil
 }
 
+//	scanURL Reading a short URL of a listing from the row, columns selected after the listed ones are read into extra.
+func scanURL(rows *sql.Rows, extra ...interface{}) (interfaces.ModelURL, error) {
+	var model interfaces.ModelURL
+	var statusCode sql.NullInt64
+	var checkError sql.NullString
+	var checkedAt sql.NullTime
+	var redirect, meta []byte
+	dest := append([]interface{}{&model.ShortURL, &model.BaseURL, &model.Private, &redirect, &model.PasswordProtected, &model.MaxVisits, &model.Visits,
+		&meta, &statusCode, &checkError, &checkedAt, &model.Title, &model.Notes, pq.Array(&model.Tags)}, extra...)
+	if err := rows.Scan(dest...); err != nil {
+		return model, err
+	}
+	if meta != nil {
+		model.Meta = &interfaces.PageMeta{}
+		if err := json.Unmarshal(meta, model.Meta); err != nil {
+			return model, err
+		}
+	}
+	if redirect != nil {
+		model.Redirect = &interfaces.Redirect{}
+		if err := json.Unmarshal(redirect, model.Redirect); err != nil {
+			return model, err
+		}
+	}
+	if checkedAt.Valid {
+		model.URLHealth = &interfaces.URLHealth{
+			StatusCode: int(statusCode.Int64),
+			Error:      checkError.String,
+			CheckedAt:  checkedAt.Time,
+		}
+	}
+	return model, nil
+}
+
+//	SearchURLs Get a page of URLs of the user or the workspace best matching the text from DB.
+//	Words are matched by prefix against users_url.search, a weighted tsvector of the title, the domain, the URL and the notes
+//	kept by triggers. If pg_trgm is available words with typos are matched by trigram similarity as well.
+func (D *Storage) SearchURLs(userID, workspaceID string, query interfaces.SearchQuery) (interfaces.SearchResult, error) {
+	result := interfaces.SearchResult{Hits: make([]interfaces.SearchHit, 0)}
+	words := search.Words(query.Text)
+	if len(words) == 0 {
+		return result, nil
+	}
+	prefixes := make([]string, 0, len(words))
+	for _, word := range words {
+		prefixes = append(prefixes, word+":*")
+	}
+	args := []interface{}{userID, workspaceID, strings.Join(prefixes, " | ")}
+	score := `ts_rank(uu.search, q)`
+	match := `uu.search @@ q`
+	if D.trigram {
+		args = append(args, strings.Join(words, " "))
+		score += ` + greatest(word_similarity($4, lower(uu.title)), 0.4 * word_similarity($4, lower(u.base_url)), 0.1 * word_similarity($4, lower(uu.notes)))`
+		match = `(` + match + ` OR $4 <% lower(uu.title) OR $4 <% lower(u.base_url) OR $4 <% lower(uu.notes))`
+	}
+	limit := "ALL"
+	if query.Limit > 0 {
+		limit = fmt.Sprint(query.Limit)
+	}
+	rows, err := D.db.Query(`SELECT u.short_url, u.base_url, u.private, u.redirect, u.password_hash IS NOT NULL, u.max_visits, u.visits, m.meta,
+	u.status_code, u.check_error, u.checked_at, uu.title, uu.notes, uu.tags, `+score+` AS score, count(*) OVER () AS total
+	FROM users_url uu JOIN urls u ON uu.url_id = u.id LEFT JOIN url_meta m ON m.base_url = u.base_url, to_tsquery('simple', $3) q
+	WHERE uu.workspace_id = $2 AND ($2 <> '' OR uu.user_id = $1) AND NOT uu.is_deleted AND `+match+`
+	ORDER BY score DESC, u.short_url LIMIT `+limit+fmt.Sprintf(` OFFSET $%d`, len(args)+1), append(args, query.Offset)...)
+	if err != nil {
+		return result, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var hit interfaces.SearchHit
+		if hit.ModelURL, err = scanURL(rows, &hit.Score, &result.Total); err != nil {
+			return result, err
+		}
+		result.Hits = append(result.Hits, hit)
+	}
+	if err = rows.Err(); err != nil {
+		return result, err
+	}
+	if len(result.Hits) == 0 && query.Offset > 0 {
+		// the page is past the last match, the matches are counted without it
+		err = D.db.QueryRow(`SELECT count(*) FROM users_url uu JOIN urls u ON uu.url_id = u.id, to_tsquery('simple', $3) q
+		WHERE uu.workspace_id = $2 AND ($2 <> '' OR uu.user_id = $1) AND NOT uu.is_deleted AND `+match, args...).Scan(&result.Total)
+	}
+	return result, err
+}
+
 //	DelBatchShortURLs Delete user URLs from DB.
+//	URLs of a workspace are deleted whoever added them, the role is checked before.
+//	Deletions are added to the change log in the same transaction.
 func (D *Storage) DelBatchShortURLs(tasks []interfaces.Task) error {
-
-	query := `UPDATE users_url SET is_deleted = true from (SELECT id FROM urls RIGHT JOIN users_url uu on urls.id = uu.url_id WHERE user_id=$1 and short_url=$2) as urls`
+	tx, err := D.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err = lockChanges(tx); err != nil {
+		return err
+	}
+	query := `WITH deleted AS (UPDATE users_url SET is_deleted = true FROM urls
+	WHERE urls.id = users_url.url_id AND urls.short_url = $2 AND users_url.workspace_id = $3 AND ($3 <> '' OR users_url.user_id = $1)
+	AND NOT users_url.is_deleted RETURNING urls.short_url, urls.base_url)
+	INSERT INTO link_changes (type, short_url, base_url, user_id, workspace_id, created_at)
+	SELECT $4, short_url, base_url, $1, $3, $5 FROM deleted`
 	for _, task := range tasks {
-		_, err := D.db.Exec(query, task.ID, task.ShortURL)
+		_, err = tx.Exec(query, task.ID, task.ShortURL, task.WorkspaceID, interfaces.EventLinkDeleted, time.Now().UTC())
 		if err != nil {
 			return err
 		}
 	}
-	return nil
+	return tx.Commit()
+}
+
+//	lockChanges Taking the lock of the change log until the end of the transaction.
+//	Offsets are taken under the lock, so changes are committed in the order of their offsets
+//	and a reader never skips a change committed after a later one.
+func lockChanges(tx *sql.Tx) error {
+	_, err := tx.Exec(`SELECT pg_advisory_xact_lock(hashtext('link_changes'));`)
+	return err
+}
+
+//	addChange Adding a change of the link to the change log in the transaction, lockChanges is called before.
+func addChange(tx *sql.Tx, kind, userID, workspaceID, shortURL, baseURL string) error {
+	_, err := tx.Exec(`INSERT INTO link_changes (type, short_url, base_url, user_id, workspace_id, created_at)
+	VALUES ($1, $2, $3, $4, $5, $6);`, kind, shortURL, baseURL, userID, workspaceID, time.Now().UTC())
+	return err
 }
 
 //	SetShortURL Add new URL in DB.
 func (D *Storage) SetShortURL(userID, shortURL, baseURL string) error {
-	var urlID int
-	query := `INSERT INTO urls (base_url, short_url) VALUES ($1, $2) RETURNING id `
-	err := D.db.QueryRow(query, baseURL, shortURL).Scan(&urlID)
+	return D.CreateURL(userID, "", shortURL, baseURL, interfaces.LinkOptions{})
+}
+
+//	SetWorkspaceURL Add new workspace URL in DB, the user who added it is kept.
+func (D *Storage) SetWorkspaceURL(workspaceID, userID, shortURL, baseURL string) error {
+	return D.CreateURL(userID, workspaceID, shortURL, baseURL, interfaces.LinkOptions{})
+}
+
+//	SetPrivateURL Add new URL of the user or the workspace in DB, nobody else may add the short URL.
+func (D *Storage) SetPrivateURL(userID, workspaceID, shortURL, baseURL string) error {
+	return D.CreateURL(userID, workspaceID, shortURL, baseURL, interfaces.LinkOptions{Private: true})
+}
+
+//	CreateURL Add new URL of the user or, if workspaceID is set, of the workspace with its options.
+//	Returns interfaces.ErrCodeTaken if the short URL leads to another URL or belongs to someone else.
+//	The URL, its options and the change log record are saved in one transaction.
+func (D *Storage) CreateURL(userID, workspaceID, shortURL, baseURL string, options interfaces.LinkOptions) error {
+	tx, err := D.db.Begin()
 	if err != nil {
-		querySelect := `SELECT id FROM urls WHERE base_url = $1;`
-		err = D.db.QueryRow(querySelect, baseURL).Scan(&urlID)
+		return err
+	}
+	defer tx.Rollback()
+	private := options.Private
+	var urlID int
+	query := `INSERT INTO urls (base_url, short_url, private) VALUES ($1, $2, $3) ON CONFLICT (short_url) DO NOTHING RETURNING id `
+	err = tx.QueryRow(query, baseURL, shortURL, private).Scan(&urlID)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	if err == sql.ErrNoRows {
+		var existing string
+		var existingPrivate bool
+		querySelect := `SELECT id, base_url, private FROM urls WHERE short_url = $1;`
+		err = tx.QueryRow(querySelect, shortURL).Scan(&urlID, &existing, &existingPrivate)
 		if err != nil {
 			return err
 		}
+		if existing != baseURL {
+			return interfaces.ErrCodeTaken
+		}
+		if private || existingPrivate {
+			var owned bool
+			queryOwned := `SELECT EXISTS (SELECT 1 FROM users_url WHERE url_id = $1 AND workspace_id = $3 AND ($3 <> '' OR user_id = $2));`
+			if err = tx.QueryRow(queryOwned, urlID, userID, workspaceID).Scan(&owned); err != nil {
+				return err
+			}
+			if !owned {
+				return interfaces.ErrCodeTaken
+			}
+		}
+	}
 
-		query = `INSERT INTO users_url (user_id, url_id) VALUES ($1, $2) ;`
-		_, err = D.db.Exec(query, userID, urlID)
+	// a failed statement would abort the transaction, so conflicts are skipped and checked
+	query = `INSERT INTO users_url (user_id, url_id, workspace_id) VALUES ($1, $2, $3) ON CONFLICT DO NOTHING;`
+	res, err := tx.Exec(query, userID, urlID, workspaceID)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		updateQuery := `UPDATE users_url SET is_deleted = false WHERE url_id = $2 AND workspace_id = $3 AND ($3 <> '' OR user_id = $1) AND is_deleted`
+		res, err = tx.Exec(updateQuery, userID, urlID, workspaceID)
 		if err != nil {
-			errCode := err.(*pq.Error).Code
-			if pgerrcode.IsIntegrityConstraintViolation(string(errCode)) {
-				var isDel bool
-				query = `SELECT is_deleted from users_url where user_id=$1 and url_id=$2`
-				err = D.db.QueryRow(query, userID, urlID).Scan(&isDel)
-				if err != nil {
-					return err
-				}
-				if !isDel {
-					return interfaces.ErrAlreadyExists
-				}
-				updateQuery := `UPDATE users_url SET is_deleted = false WHERE user_id = $1 AND url_id = $2`
-				_, err = D.db.Exec(updateQuery, userID, urlID)
-				if err != nil {
-					return err
-				}
-				return nil
-			}
 			return err
 		}
+		if n, err = res.RowsAffected(); err != nil {
+			return err
+		} else if n == 0 {
+			return interfaces.ErrAlreadyExists
+		}
+	}
+	if err = lockChanges(tx); err != nil {
+		return err
+	}
+	if err = addChange(tx, interfaces.EventLinkCreated, userID, workspaceID, shortURL, baseURL); err != nil {
+		return err
+	}
+	if !options.Access.IsZero() {
+		if err = setAccess(tx, urlID, options.Access); err != nil {
+			return err
+		}
+	}
+	if !options.Redirect.IsZero() {
+		if err = setRedirect(tx, urlID, options.Redirect); err != nil {
+			return err
+		}
+	}
+	if len(options.Destinations) > 0 {
+		if err = setDestinations(tx, urlID, options.Destinations); err != nil {
+			return err
+		}
+	}
+	if !options.Annotation.IsZero() {
+		query = `UPDATE users_url SET title = $4, notes = $5, tags = $6 WHERE url_id = $1 AND workspace_id = $3 AND ($3 <> '' OR user_id = $2);`
+		tags := append(make([]string, 0, len(options.Annotation.Tags)), options.Annotation.Tags...)
+		if _, err = tx.Exec(query, urlID, userID, workspaceID, options.Annotation.Title, options.Annotation.Notes, pq.Array(tags)); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+//	GetBaseURLs Get all original URLs from DB.
+func (D *Storage) GetBaseURLs() ([]string, error) {
+	baseURLs := make([]string, 0)
+	rows, err := D.db.Query(`SELECT DISTINCT base_url FROM urls;`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var baseURL string
+		if err = rows.Scan(&baseURL); err != nil {
+			return nil, err
+		}
+		baseURLs = append(baseURLs, baseURL)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return baseURLs, nil
+}
+
+//	SetURLHealth Save the last check result of the original URL in DB.
+func (D *Storage) SetURLHealth(baseURL string, health interfaces.URLHealth) error {
+	query := `UPDATE urls SET status_code = $2, check_error = $3, checked_at = $4 WHERE base_url = $1;`
+	_, err := D.db.Exec(query, baseURL, health.StatusCode, health.Error, health.CheckedAt)
+	return err
+}
+
+//	SetPageMeta Save metadata of the page of the original URL in DB.
+func (D *Storage) SetPageMeta(baseURL string, meta interfaces.PageMeta) error {
+	b, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+	query := `INSERT INTO url_meta (base_url, meta) VALUES ($1, $2)
+	ON CONFLICT (base_url) DO UPDATE SET meta = excluded.meta;`
+	_, err = D.db.Exec(query, baseURL, string(b))
+	return err
+}
+
+//	SetURLVerdict Save the reputation of the original URL in DB. URLs of rules and destinations
+//	have no short URLs of their own, so verdicts are kept apart from short URLs too.
+func (D *Storage) SetURLVerdict(baseURL string, verdict interfaces.Verdict) error {
+	_, err := D.db.Exec(verdictQuery, baseURL, verdict.Flagged, verdict.Reason)
+	return err
+}
+
+//	verdictQuery saves the verdict of the original URL $1 for links of it and for links that get it later.
+const verdictQuery = `WITH updated AS (UPDATE urls SET flagged = $2, flag_reason = $3 WHERE base_url = $1)
+	INSERT INTO url_verdicts (base_url, flagged, flag_reason) VALUES ($1, $2, $3)
+	ON CONFLICT (base_url) DO UPDATE SET flagged = excluded.flagged, flag_reason = excluded.flag_reason;`
+
+//	GetURLVerdict Get the reputation of the URL from DB, the zero verdict if it wasn't checked.
+func (D *Storage) GetURLVerdict(baseURL string) (interfaces.Verdict, error) {
+	var verdict interfaces.Verdict
+	query := `SELECT flagged, flag_reason FROM url_verdicts WHERE base_url = $1;`
+	err := D.db.QueryRow(query, baseURL).Scan(&verdict.Flagged, &verdict.Reason)
+	if err == sql.ErrNoRows {
+		return interfaces.Verdict{}, nil
+	}
+	return verdict, err
+}
+
+//	ClaimURLs Move URLs of one user to another in DB, URLs the other user has are dropped.
+func (D *Storage) ClaimURLs(fromUserID, toUserID string) error {
+	if fromUserID == toUserID {
 		return nil
 	}
+	tx, err := D.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	query := `UPDATE users_url SET user_id = $2 WHERE user_id = $1 AND workspace_id = '' AND url_id NOT IN (SELECT url_id FROM users_url WHERE user_id = $2 AND workspace_id = '');`
+	if _, err = tx.Exec(query, fromUserID, toUserID); err != nil {
+		return err
+	}
+	if _, err = tx.Exec(`DELETE FROM users_url WHERE user_id = $1 AND workspace_id = '';`, fromUserID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+//	CreateAccount Add new account in DB.
+func (D *Storage) CreateAccount(account interfaces.Account) error {
+	query := `INSERT INTO accounts (user_id, login, password_hash, created_at) VALUES ($1, $2, $3, $4);`
+	_, err := D.db.Exec(query, account.UserID, account.Login, account.PasswordHash, account.CreatedAt)
+	if pqErr, ok := err.(*pq.Error); ok && pgerrcode.IsIntegrityConstraintViolation(string(pqErr.Code)) {
+		return interfaces.ErrAlreadyExists
+	}
+	return err
+}
+
+//	GetAccount Get account by login from DB.
+func (D *Storage) GetAccount(login string) (interfaces.Account, error) {
+	return D.getAccount(`SELECT user_id, login, password_hash, created_at FROM accounts WHERE login = $1;`, login)
+}
+
+//	GetAccountByUserID Get account by user ID from DB.
+func (D *Storage) GetAccountByUserID(userID string) (interfaces.Account, error) {
+	return D.getAccount(`SELECT user_id, login, password_hash, created_at FROM accounts WHERE user_id = $1;`, userID)
+}
+
+func (D *Storage) getAccount(query string, arg string) (interfaces.Account, error) {
+	var account interfaces.Account
+	err := D.db.QueryRow(query, arg).Scan(&account.UserID, &account.Login, &account.PasswordHash, &account.CreatedAt)
+	if err == sql.ErrNoRows {
+		return account, interfaces.ErrNotFound
+	}
+	return account, err
+}
+
+//	SetAPIKey Add new API key in DB.
+func (D *Storage) SetAPIKey(key interfaces.APIKey) error {
+	query := `INSERT INTO api_keys (id, user_id, name, hash, created_at) VALUES ($1, $2, $3, $4, $5);`
+	_, err := D.db.Exec(query, key.ID, key.UserID, key.Name, key.Hash, key.CreatedAt)
+	if pqErr, ok := err.(*pq.Error); ok && pgerrcode.IsIntegrityConstraintViolation(string(pqErr.Code)) {
+		return interfaces.ErrAlreadyExists
+	}
+	return err
+}
 
-	query = `INSERT INTO users_url (user_id, url_id) VALUES ($1, $2);`
-	_, err = D.db.Exec(query, userID, urlID)
+//	GetAPIKeys Get API keys of the user from DB, the oldest first.
+func (D *Storage) GetAPIKeys(userID string) ([]interfaces.APIKey, error) {
+	keys := make([]interfaces.APIKey, 0)
+	rows, err := D.db.Query(`SELECT id, user_id, name, hash, created_at FROM api_keys WHERE user_id = $1 ORDER BY created_at;`, userID)
 	if err != nil {
-		errCode := err.(*pq.Error).Code
-		if pgerrcode.IsIntegrityConstraintViolation(string(errCode)) {
-			return interfaces.ErrAlreadyExists
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var key interfaces.APIKey
+		if err = rows.Scan(&key.ID, &key.UserID, &key.Name, &key.Hash, &key.CreatedAt); err != nil {
+			return nil, err
 		}
+		keys = append(keys, key)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+//	GetAPIKeyByHash Get API key by its hash from DB.
+func (D *Storage) GetAPIKeyByHash(hash string) (interfaces.APIKey, error) {
+	var key interfaces.APIKey
+	query := `SELECT id, user_id, name, hash, created_at FROM api_keys WHERE hash = $1;`
+	err := D.db.QueryRow(query, hash).Scan(&key.ID, &key.UserID, &key.Name, &key.Hash, &key.CreatedAt)
+	if err == sql.ErrNoRows {
+		return key, interfaces.ErrNotFound
+	}
+	return key, err
+}
+
+//	DelAPIKey Delete API key of the user from DB.
+func (D *Storage) DelAPIKey(userID, id string) error {
+	res, err := D.db.Exec(`DELETE FROM api_keys WHERE user_id = $1 AND id = $2;`, userID, id)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
 		return err
+	} else if n == 0 {
+		return interfaces.ErrNotFound
 	}
 	return nil
 }
 
-//	Ping is function to Ping DB connection.
-func (D *Storage) Ping() error {
-	return D.db.Ping()
+//	CreateWorkspace Add new workspace with its owner in DB.
+func (D *Storage) CreateWorkspace(workspace interfaces.Workspace, ownerID string) error {
+	tx, err := D.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	query := `INSERT INTO workspaces (id, name, created_at) VALUES ($1, $2, $3);`
+	_, err = tx.Exec(query, workspace.ID, workspace.Name, workspace.CreatedAt)
+	if pqErr, ok := err.(*pq.Error); ok && pgerrcode.IsIntegrityConstraintViolation(string(pqErr.Code)) {
+		return interfaces.ErrAlreadyExists
+	} else if err != nil {
+		return err
+	}
+	query = `INSERT INTO workspace_members (workspace_id, user_id, role, added_at) VALUES ($1, $2, $3, $4);`
+	if _, err = tx.Exec(query, workspace.ID, ownerID, interfaces.RoleOwner, workspace.CreatedAt); err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
-func createTable(db *sql.DB) error {
-	query := `CREATE TABLE IF NOT EXISTS urls (
-		id serial primary key,
-		base_url text not null unique,
-		short_url text not null 
-	);
-	CREATE TABLE IF NOT EXISTS users_url(
-	  user_id text not null ,
-	  url_id int not null  references urls(id),
-	  is_deleted boolean default false,
-	  CONSTRAINT unique_url UNIQUE (user_id, url_id)
-	);
-	`
-	_, err := db.Exec(query)
+//	GetWorkspaces Get workspaces of the user with its roles from DB, the oldest first.
+func (D *Storage) GetWorkspaces(userID string) ([]interfaces.Workspace, error) {
+	workspaces := make([]interfaces.Workspace, 0)
+	query := `SELECT w.id, w.name, w.created_at, m.role FROM workspaces w JOIN workspace_members m ON m.workspace_id = w.id WHERE m.user_id = $1 ORDER BY w.created_at;`
+	rows, err := D.db.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var workspace interfaces.Workspace
+		if err = rows.Scan(&workspace.ID, &workspace.Name, &workspace.CreatedAt, &workspace.Role); err != nil {
+			return nil, err
+		}
+		workspaces = append(workspaces, workspace)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return workspaces, nil
+}
+
+//	SetMember Add a member to the workspace or change its role in DB.
+func (D *Storage) SetMember(member interfaces.Member) error {
+	query := `INSERT INTO workspace_members (workspace_id, user_id, role, added_at) VALUES ($1, $2, $3, $4)
+	ON CONFLICT (workspace_id, user_id) DO UPDATE SET role = $3;`
+	_, err := D.db.Exec(query, member.WorkspaceID, member.UserID, member.Role, member.AddedAt)
+	if pqErr, ok := err.(*pq.Error); ok && pgerrcode.IsIntegrityConstraintViolation(string(pqErr.Code)) {
+		return interfaces.ErrNotFound
+	}
+	return err
+}
+
+//	GetMember Get a member of the workspace from DB.
+func (D *Storage) GetMember(workspaceID, userID string) (interfaces.Member, error) {
+	member := interfaces.Member{WorkspaceID: workspaceID, UserID: userID}
+	query := `SELECT role, added_at FROM workspace_members WHERE workspace_id = $1 AND user_id = $2;`
+	err := D.db.QueryRow(query, workspaceID, userID).Scan(&member.Role, &member.AddedAt)
+	if err == sql.ErrNoRows {
+		return member, interfaces.ErrNotFound
+	}
+	return member, err
+}
+
+//	GetMembers Get members of the workspace with their logins from DB, the oldest first.
+func (D *Storage) GetMembers(workspaceID string) ([]interfaces.Member, error) {
+	members := make([]interfaces.Member, 0)
+	query := `SELECT m.user_id, coalesce(a.login, ''), m.role, m.added_at FROM workspace_members m LEFT JOIN accounts a ON a.user_id = m.user_id
+	WHERE m.workspace_id = $1 ORDER BY m.added_at;`
+	rows, err := D.db.Query(query, workspaceID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		member := interfaces.Member{WorkspaceID: workspaceID}
+		if err = rows.Scan(&member.UserID, &member.Login, &member.Role, &member.AddedAt); err != nil {
+			return nil, err
+		}
+		members = append(members, member)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return members, nil
+}
+
+//	DelMember Delete a member of the workspace from DB.
+func (D *Storage) DelMember(workspaceID, userID string) error {
+	res, err := D.db.Exec(`DELETE FROM workspace_members WHERE workspace_id = $1 AND user_id = $2;`, workspaceID, userID)
 	if err != nil {
 		return err
 	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return interfaces.ErrNotFound
+	}
 	return nil
 }
 
+//	RetargetURL Change the original URL of the short URL in DB.
+//	Health and reputation of the old URL are dropped, the ones known for the new URL are taken.
+func (D *Storage) RetargetURL(workspaceID string, revision interfaces.Revision) (interfaces.Revision, error) {
+	tx, err := D.db.Begin()
+	if err != nil {
+		return interfaces.Revision{}, err
+	}
+	defer tx.Rollback()
+	var urlID int
+	query := `SELECT u.id, u.base_url FROM urls u JOIN users_url uu ON uu.url_id = u.id
+	WHERE u.short_url = $1 AND NOT uu.is_deleted AND uu.workspace_id = $3 AND ($3 <> '' OR uu.user_id = $2) FOR UPDATE OF u;`
+	err = tx.QueryRow(query, revision.ShortURL, revision.UserID, workspaceID).Scan(&urlID, &revision.OldURL)
+	if err == sql.ErrNoRows {
+		return interfaces.Revision{}, interfaces.ErrNotFound
+	} else if err != nil {
+		return interfaces.Revision{}, err
+	}
+	var owners int
+	if err = tx.QueryRow(`SELECT count(*) FROM users_url WHERE url_id = $1 AND NOT is_deleted;`, urlID).Scan(&owners); err != nil {
+		return interfaces.Revision{}, err
+	}
+	if owners > 1 {
+		return interfaces.Revision{}, interfaces.ErrShared
+	}
+	if revision.OldURL == revision.NewURL {
+		return revision, nil
+	}
+	if err = retarget(tx, urlID, revision); err != nil {
+		return interfaces.Revision{}, err
+	}
+	return revision, tx.Commit()
+}
+
+//	retarget Saving the new original URL of the revision for the URL by its ID in the transaction.
+func retarget(tx *sql.Tx, urlID int, revision interfaces.Revision) error {
+	query := `UPDATE urls SET base_url = $2, status_code = NULL, check_error = NULL, checked_at = NULL, flagged = false, flag_reason = NULL WHERE id = $1;`
+	if _, err := tx.Exec(query, urlID, revision.NewURL); err != nil {
+		return err
+	}
+	query = `UPDATE urls SET (status_code, check_error, checked_at, flagged, flag_reason) =
+		(SELECT status_code, check_error, checked_at, flagged, flag_reason FROM urls WHERE base_url = $2 AND id <> $1 LIMIT 1)
+	WHERE id = $1 AND EXISTS (SELECT 1 FROM urls WHERE base_url = $2 AND id <> $1);`
+	if _, err := tx.Exec(query, urlID, revision.NewURL); err != nil {
+		return err
+	}
+	query = `INSERT INTO url_revisions (url_id, short_url, old_url, new_url, user_id, changed_at) VALUES ($1, $2, $3, $4, $5, $6);`
+	_, err := tx.Exec(query, urlID, revision.ShortURL, revision.OldURL, revision.NewURL, revision.UserID, revision.ChangedAt)
+	return err
+}
+
+//	SetRedirect Change how the short URL of the user or the workspace redirects in DB.
+//	Returns interfaces.ErrShared if someone else has the short URL as well.
+func (D *Storage) SetRedirect(userID, workspaceID, shortURL string, redirect interfaces.Redirect) error {
+	tx, err := D.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	urlID, err := lockExclusive(tx, userID, workspaceID, shortURL)
+	if err != nil {
+		return err
+	}
+	if err = setRedirect(tx, urlID, redirect); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+//	setRedirect Saving the redirect options of the URL by its ID in the transaction.
+func setRedirect(tx *sql.Tx, urlID int, redirect interfaces.Redirect) error {
+	// no options are kept as NULL
+	var value interface{}
+	if !redirect.IsZero() {
+		b, err := json.Marshal(redirect)
+		if err != nil {
+			return err
+		}
+		value = string(b)
+	}
+	_, err := tx.Exec(`UPDATE urls SET redirect = $2 WHERE id = $1;`, urlID, value)
+	return err
+}
+
+//	GetRules Get routing rules of the short URL of the user or the workspace from DB.
+func (D *Storage) GetRules(userID, workspaceID, shortURL string) ([]interfaces.Rule, error) {
+	var value []byte
+	query := `SELECT u.rules FROM urls u JOIN users_url uu ON uu.url_id = u.id
+	WHERE u.short_url = $1 AND NOT uu.is_deleted AND uu.workspace_id = $3 AND ($3 <> '' OR uu.user_id = $2);`
+	err := D.db.QueryRow(query, shortURL, userID, workspaceID).Scan(&value)
+	if err == sql.ErrNoRows {
+		return nil, interfaces.ErrNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	rules := make([]interfaces.Rule, 0)
+	if value != nil {
+		if err = json.Unmarshal(value, &rules); err != nil {
+			return nil, err
+		}
+	}
+	return rules, nil
+}
+
+//	SetRules Replace routing rules of the short URL of the user or the workspace in DB.
+//	Returns interfaces.ErrShared if someone else has the short URL as well.
+func (D *Storage) SetRules(userID, workspaceID, shortURL string, rules []interfaces.Rule) error {
+	tx, err := D.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	urlID, err := lockExclusive(tx, userID, workspaceID, shortURL)
+	if err != nil {
+		return err
+	}
+	// no rules are kept as NULL
+	var value interface{}
+	if len(rules) > 0 {
+		b, err := json.Marshal(rules)
+		if err != nil {
+			return err
+		}
+		value = string(b)
+	}
+	if _, err = tx.Exec(`UPDATE urls SET rules = $2 WHERE id = $1;`, urlID, value); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+//	GetDestinations Get destinations of the short URL of the user or the workspace with the times they were served from DB.
+func (D *Storage) GetDestinations(userID, workspaceID, shortURL string) ([]interfaces.Destination, error) {
+	var value []byte
+	query := `SELECT u.destinations FROM urls u JOIN users_url uu ON uu.url_id = u.id
+	WHERE u.short_url = $1 AND NOT uu.is_deleted AND uu.workspace_id = $3 AND ($3 <> '' OR uu.user_id = $2);`
+	err := D.db.QueryRow(query, shortURL, userID, workspaceID).Scan(&value)
+	if err == sql.ErrNoRows {
+		return nil, interfaces.ErrNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	destinations := make([]interfaces.Destination, 0)
+	if value == nil {
+		return destinations, nil
+	}
+	if err = json.Unmarshal(value, &destinations); err != nil {
+		return nil, err
+	}
+	served := make(map[string]int64)
+	rows, err := D.db.Query(`SELECT destination_id, served FROM url_served WHERE short_url = $1;`, shortURL)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id string
+		var count int64
+		if err = rows.Scan(&id, &count); err != nil {
+			return nil, err
+		}
+		served[id] = count
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	for i := range destinations {
+		destinations[i].Served = served[destinations[i].ID]
+	}
+	return destinations, nil
+}
+
+//	SetDestinations Replace destinations of the short URL of the user or the workspace in DB.
+//	Destinations keep the times they were served by ID.
+//	Returns interfaces.ErrShared if someone else has the short URL as well.
+func (D *Storage) SetDestinations(userID, workspaceID, shortURL string, destinations []interfaces.Destination) error {
+	tx, err := D.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	urlID, err := lockExclusive(tx, userID, workspaceID, shortURL)
+	if err != nil {
+		return err
+	}
+	if err = setDestinations(tx, urlID, destinations); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+//	setDestinations Saving the destinations of the URL by its ID in the transaction.
+func setDestinations(tx *sql.Tx, urlID int, destinations []interfaces.Destination) error {
+	// no destinations are kept as NULL, counters are kept apart
+	var value interface{}
+	if len(destinations) > 0 {
+		stored := make([]interfaces.Destination, 0, len(destinations))
+		for _, destination := range destinations {
+			destination.Served = 0
+			stored = append(stored, destination)
+		}
+		b, err := json.Marshal(stored)
+		if err != nil {
+			return err
+		}
+		value = string(b)
+	}
+	_, err := tx.Exec(`UPDATE urls SET destinations = $2 WHERE id = $1;`, urlID, value)
+	return err
+}
+
+//	AddServed Count a visitor sent to the destination of the short URL in DB.
+func (D *Storage) AddServed(shortURL, destinationID string) error {
+	query := `INSERT INTO url_served (short_url, destination_id, served) VALUES ($1, $2, 1)
+	ON CONFLICT (short_url, destination_id) DO UPDATE SET served = url_served.served + 1;`
+	_, err := D.db.Exec(query, shortURL, destinationID)
+	return err
+}
+
+//	GetAccess Get access limits of the short URL of the user or the workspace from DB.
+func (D *Storage) GetAccess(userID, workspaceID, shortURL string) (interfaces.Access, error) {
+	var access interfaces.Access
+	query := `SELECT u.password_hash, u.max_visits FROM urls u JOIN users_url uu ON uu.url_id = u.id
+	WHERE u.short_url = $1 AND NOT uu.is_deleted AND uu.workspace_id = $3 AND ($3 <> '' OR uu.user_id = $2);`
+	err := D.db.QueryRow(query, shortURL, userID, workspaceID).Scan(&access.PasswordHash, &access.MaxVisits)
+	if err == sql.ErrNoRows {
+		return interfaces.Access{}, interfaces.ErrNotFound
+	}
+	return access, err
+}
+
+//	SetAccess Change access limits of the short URL of the user or the workspace in DB.
+//	Visits are counted against the new limit from scratch.
+//	Returns interfaces.ErrShared if someone else has the short URL as well.
+func (D *Storage) SetAccess(userID, workspaceID, shortURL string, access interfaces.Access) error {
+	tx, err := D.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	urlID, err := lockExclusive(tx, userID, workspaceID, shortURL)
+	if err != nil {
+		return err
+	}
+	if err = setAccess(tx, urlID, access); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+//	setAccess Saving the limits of the URL by its ID in the transaction, visits are counted anew.
+func setAccess(tx *sql.Tx, urlID int, access interfaces.Access) error {
+	var passwordHash interface{}
+	if len(access.PasswordHash) > 0 {
+		passwordHash = access.PasswordHash
+	}
+	query := `UPDATE urls SET password_hash = $2, max_visits = $3, visits = 0 WHERE id = $1;`
+	_, err := tx.Exec(query, urlID, passwordHash, access.MaxVisits)
+	return err
+}
+
+//	PatchURL Apply the patch to the short URL of the user or the workspace in DB in one transaction.
+func (D *Storage) PatchURL(userID, workspaceID, shortURL string, patch interfaces.Patch) (interfaces.Revision, error) {
+	tx, err := D.db.Begin()
+	if err != nil {
+		return interfaces.Revision{}, err
+	}
+	defer tx.Rollback()
+	urlID, err := lockExclusive(tx, userID, workspaceID, shortURL)
+	if err != nil {
+		return interfaces.Revision{}, err
+	}
+	revision := interfaces.Revision{ShortURL: shortURL, NewURL: patch.NewURL, UserID: userID, ChangedAt: patch.ChangedAt}
+	if err = tx.QueryRow(`SELECT base_url FROM urls WHERE id = $1;`, urlID).Scan(&revision.OldURL); err != nil {
+		return interfaces.Revision{}, err
+	}
+	if revision.NewURL == "" {
+		revision.NewURL = revision.OldURL
+	}
+	if revision.NewURL != revision.OldURL {
+		if err = retarget(tx, urlID, revision); err != nil {
+			return interfaces.Revision{}, err
+		}
+	}
+	if patch.Verdict != nil {
+		if _, err = tx.Exec(verdictQuery, revision.NewURL, patch.Verdict.Flagged, patch.Verdict.Reason); err != nil {
+			return interfaces.Revision{}, err
+		}
+	}
+	if patch.Redirect != nil {
+		if err = setRedirect(tx, urlID, *patch.Redirect); err != nil {
+			return interfaces.Revision{}, err
+		}
+	}
+	if patch.Access != nil {
+		if err = setAccess(tx, urlID, *patch.Access); err != nil {
+			return interfaces.Revision{}, err
+		}
+	}
+	return revision, tx.Commit()
+}
+
+//	SetAnnotation Change the title, the notes and the tags of the short URL of the user or the workspace in DB.
+//	Owners sharing the short URL keep annotations of their own.
+func (D *Storage) SetAnnotation(userID, workspaceID, shortURL string, annotation interfaces.Annotation) error {
+	query := `UPDATE users_url uu SET title = $4, notes = $5, tags = $6 FROM urls u
+	WHERE u.id = uu.url_id AND u.short_url = $1 AND NOT uu.is_deleted AND uu.workspace_id = $3 AND ($3 <> '' OR uu.user_id = $2);`
+	// the column is not null, an empty array stands for no tags
+	tags := append(make([]string, 0, len(annotation.Tags)), annotation.Tags...)
+	res, err := D.db.Exec(query, shortURL, userID, workspaceID, annotation.Title, annotation.Notes, pq.Array(tags))
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return interfaces.ErrNotFound
+	}
+	return nil
+}
+
+//	ConsumeVisit Count a visit of the short URL in DB.
+//	The limit is checked by the update itself, so concurrent visits can't exceed it.
+//	Returns interfaces.ErrExpired if the short URL has no visits left.
+func (D *Storage) ConsumeVisit(shortURL string) (int64, error) {
+	query := `UPDATE urls SET visits = visits + 1 WHERE short_url = $1 AND (max_visits = 0 OR visits < max_visits) RETURNING visits;`
+	var visits int64
+	err := D.db.QueryRow(query, shortURL).Scan(&visits)
+	if err == sql.ErrNoRows {
+		var exists bool
+		if err = D.db.QueryRow(`SELECT EXISTS (SELECT 1 FROM urls WHERE short_url = $1);`, shortURL).Scan(&exists); err != nil {
+			return 0, err
+		}
+		if !exists {
+			return 0, interfaces.ErrNotFound
+		}
+		return 0, interfaces.ErrExpired
+	}
+	return visits, err
+}
+
+//	AddClick Count a click of the short URL in DB, returning the number of clicks counted.
+func (D *Storage) AddClick(shortURL string) (int64, error) {
+	var clicks int64
+	err := D.db.QueryRow(`UPDATE urls SET clicks = clicks + 1 WHERE short_url = $1 RETURNING clicks;`, shortURL).Scan(&clicks)
+	if err == sql.ErrNoRows {
+		return 0, interfaces.ErrNotFound
+	}
+	return clicks, err
+}
+
+//	GetOwners Get users and workspaces having the short URL from DB.
+func (D *Storage) GetOwners(shortURL string) ([]interfaces.Owner, error) {
+	rows, err := D.db.Query(`SELECT uu.user_id, uu.workspace_id FROM users_url uu JOIN urls u ON uu.url_id = u.id
+	WHERE u.short_url = $1 AND NOT uu.is_deleted;`, shortURL)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	owners := make([]interfaces.Owner, 0, 1)
+	for rows.Next() {
+		var owner interfaces.Owner
+		if err = rows.Scan(&owner.UserID, &owner.WorkspaceID); err != nil {
+			return nil, err
+		}
+		owners = append(owners, owner)
+	}
+	return owners, rows.Err()
+}
+
+//	CreateWebhook Add new webhook in DB.
+func (D *Storage) CreateWebhook(webhook interfaces.Webhook) error {
+	query := `INSERT INTO webhooks (id, user_id, workspace_id, url, secret, events, click_thresholds, created_at)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8);`
+	_, err := D.db.Exec(query, webhook.ID, webhook.UserID, webhook.WorkspaceID, webhook.URL, webhook.Secret,
+		pq.Array(webhook.Events), pq.Array(thresholds(webhook.Thresholds)), webhook.CreatedAt)
+	if pqErr, ok := err.(*pq.Error); ok && pgerrcode.IsIntegrityConstraintViolation(string(pqErr.Code)) {
+		return interfaces.ErrAlreadyExists
+	}
+	return err
+}
+
+//	thresholds Returning click thresholds to save, never nil as the column is not null.
+func thresholds(values []int64) []int64 {
+	if values == nil {
+		return []int64{}
+	}
+	return values
+}
+
+//	webhookColumns are the columns scanned by scanWebhook.
+const webhookColumns = `id, user_id, workspace_id, url, secret, events, click_thresholds, created_at`
+
+//	scanner is a row of a query, *sql.Row or *sql.Rows.
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+//	scanWebhook Reading a webhook from the row.
+func scanWebhook(row scanner) (interfaces.Webhook, error) {
+	var webhook interfaces.Webhook
+	err := row.Scan(&webhook.ID, &webhook.UserID, &webhook.WorkspaceID, &webhook.URL, &webhook.Secret,
+		pq.Array(&webhook.Events), pq.Array(&webhook.Thresholds), &webhook.CreatedAt)
+	if len(webhook.Thresholds) == 0 {
+		webhook.Thresholds = nil
+	}
+	return webhook, err
+}
+
+//	GetWebhooks Get webhooks of the user or the workspace from DB, the oldest first.
+func (D *Storage) GetWebhooks(userID, workspaceID string) ([]interfaces.Webhook, error) {
+	rows, err := D.db.Query(`SELECT `+webhookColumns+` FROM webhooks
+	WHERE workspace_id = $2 AND ($2 <> '' OR user_id = $1) ORDER BY created_at, id;`, userID, workspaceID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	webhooks := make([]interfaces.Webhook, 0)
+	for rows.Next() {
+		webhook, err := scanWebhook(rows)
+		if err != nil {
+			return nil, err
+		}
+		webhooks = append(webhooks, webhook)
+	}
+	return webhooks, rows.Err()
+}
+
+//	GetWebhook Get webhook by its ID from DB.
+func (D *Storage) GetWebhook(id string) (interfaces.Webhook, error) {
+	webhook, err := scanWebhook(D.db.QueryRow(`SELECT `+webhookColumns+` FROM webhooks WHERE id = $1;`, id))
+	if err == sql.ErrNoRows {
+		return interfaces.Webhook{}, interfaces.ErrNotFound
+	}
+	return webhook, err
+}
+
+//	DelWebhook Delete webhook of the user or the workspace from DB, its deliveries are deleted in cascade.
+func (D *Storage) DelWebhook(userID, workspaceID, id string) error {
+	res, err := D.db.Exec(`DELETE FROM webhooks WHERE id = $3 AND workspace_id = $2 AND ($2 <> '' OR user_id = $1);`, userID, workspaceID, id)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return interfaces.ErrNotFound
+	}
+	return nil
+}
+
+//	GetClickThresholds Get distinct click thresholds of all webhooks from DB, the smallest first.
+func (D *Storage) GetClickThresholds() ([]int64, error) {
+	rows, err := D.db.Query(`SELECT DISTINCT unnest(click_thresholds) AS threshold FROM webhooks ORDER BY threshold;`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	values := make([]int64, 0)
+	for rows.Next() {
+		var threshold int64
+		if err = rows.Scan(&threshold); err != nil {
+			return nil, err
+		}
+		values = append(values, threshold)
+	}
+	return values, rows.Err()
+}
+
+//	AddDeliveries Add new webhook deliveries to the outbox in DB, deliveries it has are kept as they are.
+func (D *Storage) AddDeliveries(deliveries []interfaces.WebhookDelivery) error {
+	tx, err := D.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	query := `INSERT INTO webhook_deliveries (id, webhook_id, event, payload, status, attempts, next_attempt_at, response_status, error, created_at, updated_at)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11) ON CONFLICT (id) DO NOTHING;`
+	for _, d := range deliveries {
+		if _, err = tx.Exec(query, d.ID, d.WebhookID, d.Event, []byte(d.Payload), d.Status, d.Attempts, d.NextAttemptAt,
+			d.ResponseStatus, d.Error, d.CreatedAt, d.UpdatedAt); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+//	deliveryColumns are the columns scanned by scanDeliveries.
+const deliveryColumns = `id, webhook_id, event, payload, status, attempts, next_attempt_at, response_status, error, created_at, updated_at`
+
+//	scanDeliveries Reading webhook deliveries from the rows.
+func scanDeliveries(rows *sql.Rows) ([]interfaces.WebhookDelivery, error) {
+	defer rows.Close()
+	deliveries := make([]interfaces.WebhookDelivery, 0)
+	for rows.Next() {
+		var d interfaces.WebhookDelivery
+		var payload []byte
+		if err := rows.Scan(&d.ID, &d.WebhookID, &d.Event, &payload, &d.Status, &d.Attempts, &d.NextAttemptAt,
+			&d.ResponseStatus, &d.Error, &d.CreatedAt, &d.UpdatedAt); err != nil {
+			return nil, err
+		}
+		d.Payload = payload
+		deliveries = append(deliveries, d)
+	}
+	return deliveries, rows.Err()
+}
+
+//	ClaimDeliveries Get pending webhook deliveries due at now from DB, the longest waiting first.
+//	Claimed deliveries are put off by the lease, so they are attempted again only if their results are lost.
+//	Locked rows are skipped, so servers sharing the database never claim the same delivery.
+func (D *Storage) ClaimDeliveries(now time.Time, lease time.Duration, limit int) ([]interfaces.WebhookDelivery, error) {
+	rows, err := D.db.Query(`UPDATE webhook_deliveries SET next_attempt_at = $2 WHERE id IN (
+	  SELECT id FROM webhook_deliveries WHERE status = 'pending' AND next_attempt_at <= $1
+	  ORDER BY next_attempt_at LIMIT $3 FOR UPDATE SKIP LOCKED)
+	RETURNING `+deliveryColumns+`;`, now, now.Add(lease), limit)
+	if err != nil {
+		return nil, err
+	}
+	return scanDeliveries(rows)
+}
+
+//	SetDelivery Change the webhook delivery after an attempt in DB.
+//	Returns interfaces.ErrNotFound if the delivery was deleted with its webhook.
+func (D *Storage) SetDelivery(d interfaces.WebhookDelivery) error {
+	res, err := D.db.Exec(`UPDATE webhook_deliveries SET status = $2, attempts = $3, next_attempt_at = $4, response_status = $5, error = $6, updated_at = $7
+	WHERE id = $1;`, d.ID, d.Status, d.Attempts, d.NextAttemptAt, d.ResponseStatus, d.Error, d.UpdatedAt)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return interfaces.ErrNotFound
+	}
+	return nil
+}
+
+//	GetDeliveries Get the latest deliveries of the webhook of the user or the workspace from DB, the newest first.
+func (D *Storage) GetDeliveries(userID, workspaceID, webhookID string, limit int) ([]interfaces.WebhookDelivery, error) {
+	var exists bool
+	err := D.db.QueryRow(`SELECT EXISTS (SELECT 1 FROM webhooks WHERE id = $3 AND workspace_id = $2 AND ($2 <> '' OR user_id = $1));`,
+		userID, workspaceID, webhookID).Scan(&exists)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, interfaces.ErrNotFound
+	}
+	query := `SELECT ` + deliveryColumns + ` FROM webhook_deliveries WHERE webhook_id = $1 ORDER BY created_at DESC, id DESC`
+	if limit > 0 {
+		query += fmt.Sprintf(` LIMIT %d`, limit)
+	}
+	rows, err := D.db.Query(query, webhookID)
+	if err != nil {
+		return nil, err
+	}
+	return scanDeliveries(rows)
+}
+
+//	PruneDeliveries Delete delivered and failed webhook deliveries last changed before the time from DB.
+func (D *Storage) PruneDeliveries(before time.Time) error {
+	_, err := D.db.Exec(`DELETE FROM webhook_deliveries WHERE status <> 'pending' AND updated_at < $1;`, before)
+	return err
+}
+
+//	GetChanges Get changes of links of the user or the workspace past the offset from DB, the oldest first.
+//	Without the user and the workspace changes of all links are returned. A limit of 0 returns all of them.
+func (D *Storage) GetChanges(userID, workspaceID string, after int64, limit int) ([]interfaces.Change, error) {
+	query := `SELECT id, type, short_url, base_url, user_id, workspace_id, created_at FROM link_changes
+	WHERE id > $1 AND (($2 = '' AND $3 = '') OR (workspace_id = $3 AND ($3 <> '' OR user_id = $2))) ORDER BY id`
+	if limit > 0 {
+		query += fmt.Sprintf(` LIMIT %d`, limit)
+	}
+	rows, err := D.db.Query(query, after, userID, workspaceID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	changes := make([]interfaces.Change, 0)
+	for rows.Next() {
+		var change interfaces.Change
+		if err = rows.Scan(&change.Offset, &change.Type, &change.ShortURL, &change.BaseURL, &change.UserID,
+			&change.WorkspaceID, &change.CreatedAt); err != nil {
+			return nil, err
+		}
+		changes = append(changes, change)
+	}
+	return changes, rows.Err()
+}
+
+//	GetChangeCursor Get the offset of the last change the consumer has taken from DB, 0 if none.
+func (D *Storage) GetChangeCursor(consumer string) (int64, error) {
+	var offset int64
+	err := D.db.QueryRow(`SELECT "offset" FROM change_cursors WHERE consumer = $1;`, consumer).Scan(&offset)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return offset, err
+}
+
+//	SetChangeCursor Save the offset of the last change the consumer has taken in DB.
+func (D *Storage) SetChangeCursor(consumer string, offset int64) error {
+	_, err := D.db.Exec(`INSERT INTO change_cursors (consumer, "offset") VALUES ($1, $2)
+	ON CONFLICT (consumer) DO UPDATE SET "offset" = excluded."offset";`, consumer, offset)
+	return err
+}
+
+//	lockExclusive Locking the short URL of the user or the workspace and returning its id.
+//	Returns interfaces.ErrNotFound if the owner has no such short URL
+//	and interfaces.ErrShared if someone else has it as well.
+func lockExclusive(tx *sql.Tx, userID, workspaceID, shortURL string) (int, error) {
+	var urlID int
+	query := `SELECT u.id FROM urls u JOIN users_url uu ON uu.url_id = u.id
+	WHERE u.short_url = $1 AND NOT uu.is_deleted AND uu.workspace_id = $3 AND ($3 <> '' OR uu.user_id = $2) FOR UPDATE OF u;`
+	err := tx.QueryRow(query, shortURL, userID, workspaceID).Scan(&urlID)
+	if err == sql.ErrNoRows {
+		return 0, interfaces.ErrNotFound
+	} else if err != nil {
+		return 0, err
+	}
+	var owners int
+	if err = tx.QueryRow(`SELECT count(*) FROM users_url WHERE url_id = $1 AND NOT is_deleted;`, urlID).Scan(&owners); err != nil {
+		return 0, err
+	}
+	if owners > 1 {
+		return 0, interfaces.ErrShared
+	}
+	return urlID, nil
+}
+
+//	GetURLHistory Get changes of the short URL of the user or the workspace from DB, the oldest first.
+func (D *Storage) GetURLHistory(userID, workspaceID, shortURL string) ([]interfaces.Revision, error) {
+	var owned bool
+	query := `SELECT EXISTS (SELECT 1 FROM urls u JOIN users_url uu ON uu.url_id = u.id
+	WHERE u.short_url = $1 AND NOT uu.is_deleted AND uu.workspace_id = $3 AND ($3 <> '' OR uu.user_id = $2));`
+	if err := D.db.QueryRow(query, shortURL, userID, workspaceID).Scan(&owned); err != nil {
+		return nil, err
+	}
+	if !owned {
+		return nil, interfaces.ErrNotFound
+	}
+	revisions := make([]interfaces.Revision, 0)
+	query = `SELECT short_url, old_url, new_url, user_id, changed_at FROM url_revisions WHERE short_url = $1 ORDER BY changed_at, id;`
+	rows, err := D.db.Query(query, shortURL)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var revision interfaces.Revision
+		if err = rows.Scan(&revision.ShortURL, &revision.OldURL, &revision.NewURL, &revision.UserID, &revision.ChangedAt); err != nil {
+			return nil, err
+		}
+		revisions = append(revisions, revision)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return revisions, nil
+}
+
+//	Take Taking cost tokens from the bucket of the key in DB, so that all instances share the limit.
+func (D *Storage) Take(key string, cost int, limit interfaces.RateLimit) (bool, time.Duration, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	D.sweepRateLimits(ctx)
+	var allowed bool
+	var tokens float64
+	query := `INSERT INTO rate_limits (key, tokens, allowed, rate, burst, updated_at) VALUES ($1, $3::float8 - $2, $3 >= $2, $4, $3, now())
+	ON CONFLICT (key) DO UPDATE SET
+		tokens = CASE WHEN LEAST($3, rate_limits.tokens + EXTRACT(EPOCH FROM now() - rate_limits.updated_at) * $4) >= $2
+			THEN LEAST($3, rate_limits.tokens + EXTRACT(EPOCH FROM now() - rate_limits.updated_at) * $4) - $2
+			ELSE LEAST($3, rate_limits.tokens + EXTRACT(EPOCH FROM now() - rate_limits.updated_at) * $4) END,
+		allowed = LEAST($3, rate_limits.tokens + EXTRACT(EPOCH FROM now() - rate_limits.updated_at) * $4) >= $2,
+		rate = $4,
+		burst = $3,
+		updated_at = now()
+	RETURNING allowed, tokens;`
+	err := D.db.QueryRowContext(ctx, query, key, float64(cost), float64(limit.Burst), limit.Rate).Scan(&allowed, &tokens)
+	if err != nil {
+		return false, 0, err
+	}
+	if allowed || limit.Rate <= 0 {
+		return allowed, 0, nil
+	}
+	return false, time.Duration((float64(cost) - tokens) / limit.Rate * float64(time.Second)), nil
+}
+
+//	Refund Returning cost tokens to the bucket of the key in DB.
+func (D *Storage) Refund(key string, cost int, limit interfaces.RateLimit) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	query := `UPDATE rate_limits SET
+		tokens = LEAST($3, tokens + EXTRACT(EPOCH FROM now() - updated_at) * $4 + $2),
+		updated_at = now()
+	WHERE key = $1;`
+	_, err := D.db.ExecContext(ctx, query, key, float64(cost), float64(limit.Burst), limit.Rate)
+	return err
+}
+
+//	sweepRateLimits Removing buckets which are full again, at most once a minute.
+func (D *Storage) sweepRateLimits(ctx context.Context) {
+	D.mu.Lock()
+	if time.Since(D.rateSwept) < time.Minute {
+		D.mu.Unlock()
+		return
+	}
+	D.rateSwept = time.Now()
+	D.mu.Unlock()
+	query := `DELETE FROM rate_limits WHERE tokens + EXTRACT(EPOCH FROM now() - updated_at) * rate >= burst;`
+	if _, err := D.db.ExecContext(ctx, query); err != nil {
+		log.Println(err)
+	}
+}
+
+//	Ping is function to Ping DB connection.
+func (D *Storage) Ping() error {
+	return D.db.Ping()
+}
+
+func createTable(db *sql.DB) error {
+	query := `CREATE TABLE IF NOT EXISTS urls (
+		id serial primary key,
+		base_url text not null,
+		short_url text not null 
+	);
+	CREATE TABLE IF NOT EXISTS users_url(
+	  user_id text not null ,
+	  url_id int not null  references urls(id),
+	  is_deleted boolean default false,
+	  CONSTRAINT unique_url UNIQUE (user_id, url_id)
+	);
+	ALTER TABLE urls ADD COLUMN IF NOT EXISTS status_code int;
+	ALTER TABLE urls ADD COLUMN IF NOT EXISTS check_error text;
+	ALTER TABLE urls ADD COLUMN IF NOT EXISTS checked_at timestamptz;
+	ALTER TABLE urls ADD COLUMN IF NOT EXISTS flagged boolean default false;
+	ALTER TABLE urls ADD COLUMN IF NOT EXISTS flag_reason text;
+	CREATE UNLOGGED TABLE IF NOT EXISTS rate_limits(
+	  key text primary key,
+	  tokens double precision not null,
+	  allowed boolean not null,
+	  rate double precision not null,
+	  burst double precision not null,
+	  updated_at timestamptz not null
+	);
+	CREATE TABLE IF NOT EXISTS accounts(
+	  user_id text primary key,
+	  login text not null unique,
+	  password_hash bytea not null,
+	  created_at timestamptz not null default now()
+	);
+	CREATE TABLE IF NOT EXISTS api_keys(
+	  id text primary key,
+	  user_id text not null references accounts(user_id),
+	  name text not null default '',
+	  hash text not null unique,
+	  created_at timestamptz not null default now()
+	);
+	CREATE INDEX IF NOT EXISTS api_keys_user_id ON api_keys(user_id);
+	CREATE TABLE IF NOT EXISTS workspaces(
+	  id text primary key,
+	  name text not null,
+	  created_at timestamptz not null default now()
+	);
+	CREATE TABLE IF NOT EXISTS workspace_members(
+	  workspace_id text not null references workspaces(id),
+	  user_id text not null,
+	  role text not null,
+	  added_at timestamptz not null default now(),
+	  primary key (workspace_id, user_id)
+	);
+	CREATE INDEX IF NOT EXISTS workspace_members_user_id ON workspace_members(user_id);
+	ALTER TABLE users_url ADD COLUMN IF NOT EXISTS workspace_id text not null default '';
+	ALTER TABLE users_url DROP CONSTRAINT IF EXISTS unique_url;
+	CREATE UNIQUE INDEX IF NOT EXISTS users_url_user ON users_url(user_id, url_id) WHERE workspace_id = '';
+	CREATE UNIQUE INDEX IF NOT EXISTS users_url_workspace ON users_url(workspace_id, url_id) WHERE workspace_id <> '';
+	ALTER TABLE urls DROP CONSTRAINT IF EXISTS urls_base_url_key;
+	CREATE UNIQUE INDEX IF NOT EXISTS urls_short_url ON urls(short_url);
+	CREATE INDEX IF NOT EXISTS urls_base_url ON urls(base_url);
+	CREATE TABLE IF NOT EXISTS url_revisions(
+	  id serial primary key,
+	  url_id int not null references urls(id),
+	  short_url text not null,
+	  old_url text not null,
+	  new_url text not null,
+	  user_id text not null,
+	  changed_at timestamptz not null default now()
+	);
+	CREATE INDEX IF NOT EXISTS url_revisions_short_url ON url_revisions(short_url);
+	-- short URLs created before private ones were introduced stay shared
+	ALTER TABLE urls ADD COLUMN IF NOT EXISTS private boolean not null default false;
+	ALTER TABLE urls ADD COLUMN IF NOT EXISTS redirect jsonb;
+	ALTER TABLE urls ADD COLUMN IF NOT EXISTS rules jsonb;
+	ALTER TABLE urls ADD COLUMN IF NOT EXISTS destinations jsonb;
+	ALTER TABLE urls ADD COLUMN IF NOT EXISTS password_hash bytea;
+	ALTER TABLE urls ADD COLUMN IF NOT EXISTS max_visits bigint not null default 0;
+	ALTER TABLE urls ADD COLUMN IF NOT EXISTS visits bigint not null default 0;
+	-- short URLs created before creation times were kept have none
+	ALTER TABLE urls ADD COLUMN IF NOT EXISTS created_at timestamptz;
+	ALTER TABLE urls ALTER COLUMN created_at SET DEFAULT now();
+	CREATE TABLE IF NOT EXISTS url_served(
+	  short_url text not null,
+	  destination_id text not null,
+	  served bigint not null default 0,
+	  primary key (short_url, destination_id)
+	);
+	CREATE TABLE IF NOT EXISTS url_meta(
+	  base_url text primary key,
+	  meta jsonb not null
+	);
+	ALTER TABLE users_url ADD COLUMN IF NOT EXISTS title text not null default '';
+	ALTER TABLE users_url ADD COLUMN IF NOT EXISTS notes text not null default '';
+	ALTER TABLE users_url ADD COLUMN IF NOT EXISTS tags text[] not null default '{}';
+	CREATE INDEX IF NOT EXISTS users_url_tags ON users_url USING gin (tags);
+	-- words of the title, the domain, the URL and the notes weighted in this order, kept by triggers
+	ALTER TABLE users_url ADD COLUMN IF NOT EXISTS search tsvector;
+	CREATE INDEX IF NOT EXISTS users_url_search ON users_url USING gin (search);
+	CREATE OR REPLACE FUNCTION url_search_document(base_url text, title text, notes text) RETURNS tsvector AS $$
+	  SELECT setweight(to_tsvector('simple', coalesce(title, '')), 'A') ||
+	    setweight(to_tsvector('simple', regexp_replace(coalesce(substring(base_url from '^[[:alpha:]][[:alnum:]+.-]*://(?:[^/?#@]*@)?(?:www\.)?([^/?#:]+)'), ''),
+	      '[^[:alnum:]]+', ' ', 'g')), 'B') ||
+	    setweight(to_tsvector('simple', regexp_replace(coalesce(base_url, ''), '[^[:alnum:]]+', ' ', 'g')), 'C') ||
+	    setweight(to_tsvector('simple', coalesce(notes, '')), 'D')
+	$$ LANGUAGE sql IMMUTABLE;
+	CREATE OR REPLACE FUNCTION users_url_search() RETURNS trigger AS $$
+	BEGIN
+	  NEW.search := url_search_document((SELECT base_url FROM urls WHERE id = NEW.url_id), NEW.title, NEW.notes);
+	  RETURN NEW;
+	END
+	$$ LANGUAGE plpgsql;
+	DROP TRIGGER IF EXISTS users_url_search ON users_url;
+	CREATE TRIGGER users_url_search BEFORE INSERT OR UPDATE OF url_id, title, notes ON users_url
+	  FOR EACH ROW EXECUTE PROCEDURE users_url_search();
+	CREATE OR REPLACE FUNCTION urls_search() RETURNS trigger AS $$
+	BEGIN
+	  UPDATE users_url SET search = url_search_document(NEW.base_url, title, notes) WHERE url_id = NEW.id;
+	  RETURN NULL;
+	END
+	$$ LANGUAGE plpgsql;
+	DROP TRIGGER IF EXISTS urls_search ON urls;
+	CREATE TRIGGER urls_search AFTER UPDATE OF base_url ON urls
+	  FOR EACH ROW EXECUTE PROCEDURE urls_search();
+	-- short URLs added before search was introduced
+	UPDATE users_url uu SET search = url_search_document(u.base_url, uu.title, uu.notes) FROM urls u
+	  WHERE u.id = uu.url_id AND uu.search IS NULL;
+	ALTER TABLE urls ADD COLUMN IF NOT EXISTS clicks bigint not null default 0;
+	CREATE TABLE IF NOT EXISTS webhooks(
+	  id text primary key,
+	  user_id text not null,
+	  workspace_id text not null default '',
+	  url text not null,
+	  secret text not null,
+	  events text[] not null,
+	  click_thresholds bigint[] not null default '{}',
+	  created_at timestamptz not null default now()
+	);
+	CREATE INDEX IF NOT EXISTS webhooks_owner ON webhooks(workspace_id, user_id);
+	-- the outbox: pending deliveries wait for an attempt, finished ones are the delivery log
+	CREATE TABLE IF NOT EXISTS webhook_deliveries(
+	  id text primary key,
+	  webhook_id text not null references webhooks(id) ON DELETE CASCADE,
+	  event text not null,
+	  payload jsonb not null,
+	  status text not null,
+	  attempts int not null default 0,
+	  next_attempt_at timestamptz not null,
+	  response_status int not null default 0,
+	  error text not null default '',
+	  created_at timestamptz not null,
+	  updated_at timestamptz not null
+	);
+	CREATE INDEX IF NOT EXISTS webhook_deliveries_due ON webhook_deliveries(next_attempt_at) WHERE status = 'pending';
+	CREATE INDEX IF NOT EXISTS webhook_deliveries_webhook ON webhook_deliveries(webhook_id, created_at);
+	CREATE TABLE IF NOT EXISTS link_changes(
+	  id bigserial primary key,
+	  type text not null,
+	  short_url text not null,
+	  base_url text not null,
+	  user_id text not null,
+	  workspace_id text not null default '',
+	  created_at timestamptz not null
+	);
+	CREATE INDEX IF NOT EXISTS link_changes_owner ON link_changes(workspace_id, user_id, id);
+	CREATE TABLE IF NOT EXISTS change_cursors(
+	  consumer text primary key,
+	  "offset" bigint not null
+	);
+	CREATE TABLE IF NOT EXISTS url_verdicts(
+	  base_url text primary key,
+	  flagged boolean not null,
+	  flag_reason text not null default ''
+	);
+	`
+	_, err := db.Exec(query)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+//	createSearchIndexes Creating trigram indexes for searching URLs, titles and notes, returning whether pg_trgm is available.
+//	Creating the extension may need privileges the server doesn't have, search works without the indexes, only slower
+//	and without matching words with typos.
+func createSearchIndexes(db *sql.DB) bool {
+	query := `CREATE EXTENSION IF NOT EXISTS pg_trgm;
+	CREATE INDEX IF NOT EXISTS urls_base_url_trgm ON urls USING gin (lower(base_url) gin_trgm_ops);
+	CREATE INDEX IF NOT EXISTS users_url_title_trgm ON users_url USING gin (lower(title) gin_trgm_ops);
+	CREATE INDEX IF NOT EXISTS users_url_notes_trgm ON users_url USING gin (lower(notes) gin_trgm_ops);
+	`
+	if _, err := db.Exec(query); err != nil {
+		log.Printf("search indexes are not created: %v", err)
+	}
+	var trigram bool
+	if err := db.QueryRow(`SELECT EXISTS (SELECT 1 FROM pg_extension WHERE extname = 'pg_trgm')`).Scan(&trigram); err != nil {
+		log.Println(err)
+	}
+	return trigram
+}
+
 func (D *Storage) Close() error {
 	err := D.db.Close()
 	return err
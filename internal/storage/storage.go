@@ -1,67 +1,1305 @@
 package storage
 
 import (
+	"sort"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/ivanmyagkov/shortener.git/internal/interfaces"
+	"github.com/ivanmyagkov/shortener.git/internal/search"
 )
 
 type DB struct {
 	sync.Mutex
 	Storage  map[string]string
 	ShortURL map[string][]interfaces.ModelURL
+	health   map[string]interfaces.URLHealth
+	meta     map[string]interfaces.PageMeta
+	verdicts map[string]interfaces.Verdict
+	accounts map[string]interfaces.Account
+	logins   map[string]string
+	apiKeys  map[string]interfaces.APIKey
+	// workspaces by ID and their members by workspace and user ID
+	workspaces map[string]interfaces.Workspace
+	members    map[string]map[string]interfaces.Member
+	// revisions by short URL, the oldest first
+	revisions map[string][]interfaces.Revision
+	// owners of private short URLs and short URLs deleted by all their owners
+	private map[string]string
+	deleted map[string]struct{}
+	// redirect options and routing rules by short URL
+	redirects map[string]interfaces.Redirect
+	rules     map[string][]interfaces.Rule
+	// destinations of split short URLs and how many times each of them was served
+	destinations map[string][]interfaces.Destination
+	served       map[string]map[string]int64
+	// access limits of short URLs and their visits counted against the limits
+	access map[string]interfaces.Access
+	visits map[string]int64
+	// times short URLs were first created
+	created map[string]time.Time
+	// annotations of short URLs by owner and short URLs of owners by tag
+	annotations map[string]map[string]interfaces.Annotation
+	tagged      map[string]map[string]map[string]struct{}
+	// short URLs of owners by words of their original URLs and annotations
+	search map[string]*search.Index
+	// clicks of short URLs, counted for webhooks
+	clicks map[string]int64
+	// webhooks and their deliveries by ID, pending deliveries are the outbox
+	webhooks   map[string]interfaces.Webhook
+	deliveries map[string]interfaces.WebhookDelivery
+	pending    map[string]struct{}
+	// the change log of links, the oldest first, and positions of its consumers;
+	// changes aren't recorded while a file is replayed, the file has them
+	changes []interfaces.Change
+	cursors map[string]int64
+	replay  bool
 }
 
 //	NewDBConn is function to create string map storage.
 func NewDBConn() *DB {
 	return &DB{
-		Storage:  make(map[string]string),
-		ShortURL: make(map[string][]interfaces.ModelURL),
+		Storage:      make(map[string]string),
+		ShortURL:     make(map[string][]interfaces.ModelURL),
+		health:       make(map[string]interfaces.URLHealth),
+		meta:         make(map[string]interfaces.PageMeta),
+		verdicts:     make(map[string]interfaces.Verdict),
+		accounts:     make(map[string]interfaces.Account),
+		logins:       make(map[string]string),
+		apiKeys:      make(map[string]interfaces.APIKey),
+		workspaces:   make(map[string]interfaces.Workspace),
+		members:      make(map[string]map[string]interfaces.Member),
+		revisions:    make(map[string][]interfaces.Revision),
+		private:      make(map[string]string),
+		deleted:      make(map[string]struct{}),
+		redirects:    make(map[string]interfaces.Redirect),
+		rules:        make(map[string][]interfaces.Rule),
+		destinations: make(map[string][]interfaces.Destination),
+		served:       make(map[string]map[string]int64),
+		access:       make(map[string]interfaces.Access),
+		visits:       make(map[string]int64),
+		created:      make(map[string]time.Time),
+		annotations:  make(map[string]map[string]interfaces.Annotation),
+		tagged:       make(map[string]map[string]map[string]struct{}),
+		search:       make(map[string]*search.Index),
+		clicks:       make(map[string]int64),
+		webhooks:     make(map[string]interfaces.Webhook),
+		deliveries:   make(map[string]interfaces.WebhookDelivery),
+		pending:      make(map[string]struct{}),
+		cursors:      make(map[string]int64),
 	}
 }
 
 //	GetURL Get original URL from map.
 func (db *DB) GetURL(shortURL string) (string, error) {
+	link, err := db.GetLink(shortURL)
+	if err != nil {
+		return "", err
+	}
+	return link.BaseURL, nil
+}
+
+//	GetLink Get short URL with its original URL and reputation from map.
+func (db *DB) GetLink(shortURL string) (interfaces.Link, error) {
 	db.Lock()
 	defer db.Unlock()
-	if _, ok := db.Storage[shortURL]; ok {
-		return db.Storage[shortURL], nil
+	baseURL, ok := db.Storage[shortURL]
+	if !ok {
+		return interfaces.Link{}, interfaces.ErrNotFound
+	}
+	if _, ok := db.deleted[shortURL]; ok {
+		return interfaces.Link{}, interfaces.ErrWasDeleted
 	}
-	return "", interfaces.ErrNotFound
+	if db.exhausted(shortURL) {
+		return interfaces.Link{}, interfaces.ErrExpired
+	}
+	return interfaces.Link{
+		ShortURL:     shortURL,
+		BaseURL:      baseURL,
+		Verdict:      db.verdicts[baseURL],
+		Redirect:     db.redirects[shortURL],
+		Rules:        db.rules[shortURL],
+		Destinations: db.destinations[shortURL],
+		Access:       db.access[shortURL],
+		CreatedAt:    db.created[shortURL],
+		Meta:         db.pageMeta(baseURL),
+	}, nil
+}
+
+//	workspaceOwner is the key of workspace URLs in the ShortURL map, user IDs are hex so they never clash.
+func workspaceOwner(workspaceID string) string {
+	return "workspace/" + workspaceID
+}
+
+//	owner is the key of URLs of the user or, if workspaceID is set, of the workspace.
+func owner(userID, workspaceID string) string {
+	if workspaceID != "" {
+		return workspaceOwner(workspaceID)
+	}
+	return userID
 }
 
 //	GetAllURLsByUserID Get all user URLs from map.
 func (db *DB) GetAllURLsByUserID(userID string) ([]interfaces.ModelURL, error) {
-	var ok bool
-	if _, ok = db.ShortURL[userID]; ok {
-		return db.ShortURL[userID], nil
+	return db.getURLs(userID)
+}
+
+//	GetWorkspaceURLs Get all workspace URLs from map.
+func (db *DB) GetWorkspaceURLs(workspaceID string) ([]interfaces.ModelURL, error) {
+	return db.getURLs(workspaceOwner(workspaceID))
+}
+
+func (db *DB) getURLs(owner string) ([]interfaces.ModelURL, error) {
+	db.Lock()
+	defer db.Unlock()
+	if _, ok := db.ShortURL[owner]; !ok {
+		return nil, interfaces.ErrNotFound
+	}
+	modelURL := make([]interfaces.ModelURL, 0, len(db.ShortURL[owner]))
+	for _, model := range db.ShortURL[owner] {
+		modelURL = append(modelURL, db.listed(owner, model))
 	}
-	return nil, interfaces.ErrNotFound
+	return modelURL, nil
 }
-func (db *DB) DelBatchShortURLs(tasks []interfaces.Task) error {
 
+//	FindURLs Get URLs of the user or the workspace passing the filter from map.
+//	With tags only short URLs having the rarest of them are looked at, they are listed the oldest first.
+func (db *DB) FindURLs(userID, workspaceID string, filter interfaces.URLFilter) ([]interfaces.ModelURL, error) {
+	key := owner(userID, workspaceID)
+	if len(filter.Tags) == 0 {
+		models, err := db.getURLs(key)
+		if err != nil || filter.Query == "" {
+			return models, err
+		}
+		found := make([]interfaces.ModelURL, 0)
+		for _, model := range models {
+			if filter.Match(model) {
+				found = append(found, model)
+			}
+		}
+		return found, nil
+	}
+	db.Lock()
+	defer db.Unlock()
+	if _, ok := db.ShortURL[key]; !ok {
+		return nil, interfaces.ErrNotFound
+	}
+	var rarest map[string]struct{}
+	for i, tag := range filter.Tags {
+		if candidates := db.tagged[key][tag]; i == 0 || len(candidates) < len(rarest) {
+			rarest = candidates
+		}
+	}
+	found := make([]interfaces.ModelURL, 0)
+	for shortURL := range rarest {
+		model := db.listed(key, interfaces.ModelURL{ShortURL: shortURL, BaseURL: db.Storage[shortURL]})
+		if filter.Match(model) {
+			found = append(found, model)
+		}
+	}
+	sort.Slice(found, func(i, j int) bool {
+		ci, cj := db.created[found[i].ShortURL], db.created[found[j].ShortURL]
+		if !ci.Equal(cj) {
+			return ci.Before(cj)
+		}
+		return found[i].ShortURL < found[j].ShortURL
+	})
+	return found, nil
+}
+
+//	listed Returning the short URL of the owner with everything shown in listings.
+func (db *DB) listed(owner string, model interfaces.ModelURL) interfaces.ModelURL {
+	model.Annotation = db.annotations[owner][model.ShortURL]
+	model.Meta = db.pageMeta(model.BaseURL)
+	if health, ok := db.health[model.BaseURL]; ok {
+		model.URLHealth = &health
+	}
+	_, model.Private = db.private[model.ShortURL]
+	if redirect, ok := db.redirects[model.ShortURL]; ok {
+		model.Redirect = &redirect
+	}
+	access := db.access[model.ShortURL]
+	model.PasswordProtected = len(access.PasswordHash) > 0
+	model.MaxVisits = access.MaxVisits
+	model.Visits = db.visits[model.ShortURL]
+	return model
+}
+
+//	DelBatchShortURLs Delete user URLs from map.
+//	A short URL shared by several users is deleted when the last of them deletes it.
+func (db *DB) DelBatchShortURLs(tasks []interfaces.Task) error {
+	db.Lock()
+	defer db.Unlock()
+	for _, task := range tasks {
+		key := owner(task.ID, task.WorkspaceID)
+		i := indexURL(db.ShortURL[key], task.ShortURL)
+		if i < 0 {
+			continue
+		}
+		db.change(interfaces.EventLinkDeleted, task.ID, task.WorkspaceID, task.ShortURL, db.ShortURL[key][i].BaseURL)
+		db.untag(key, task.ShortURL)
+		delete(db.annotations[key], task.ShortURL)
+		db.unindex(key, task.ShortURL)
+		models := db.ShortURL[key]
+		if len(models) == 1 {
+			delete(db.ShortURL, key)
+		} else {
+			db.ShortURL[key] = append(models[:i:i], models[i+1:]...)
+		}
+		if !db.owned(task.ShortURL) {
+			db.deleted[task.ShortURL] = struct{}{}
+		}
+	}
 	return nil
 }
 
+//	owned Checking someone still has the short URL.
+func (db *DB) owned(shortURL string) bool {
+	for _, models := range db.ShortURL {
+		if indexURL(models, shortURL) >= 0 {
+			return true
+		}
+	}
+	return false
+}
+
 //	SetShortURL Add new URL in map.
 func (db *DB) SetShortURL(userID, shortURL, URL string) error {
+	return db.CreateURL(userID, "", shortURL, URL, interfaces.LinkOptions{})
+}
+
+//	SetWorkspaceURL Add new workspace URL in map, the user who added it is not kept.
+func (db *DB) SetWorkspaceURL(workspaceID, userID, shortURL, URL string) error {
+	return db.CreateURL(userID, workspaceID, shortURL, URL, interfaces.LinkOptions{})
+}
+
+//	SetPrivateURL Add new URL of the user or the workspace in map, nobody else may add the short URL.
+func (db *DB) SetPrivateURL(userID, workspaceID, shortURL, URL string) error {
+	return db.CreateURL(userID, workspaceID, shortURL, URL, interfaces.LinkOptions{Private: true})
+}
+
+//	CreateURL Add new URL of the user or, if workspaceID is set, of the workspace with its options in map.
+func (db *DB) CreateURL(userID, workspaceID, shortURL, URL string, options interfaces.LinkOptions) error {
 	db.Lock()
 	defer db.Unlock()
+	if err := db.setShortURL(userID, workspaceID, shortURL, URL, options.Private); err != nil {
+		return err
+	}
+	if !options.Access.IsZero() {
+		db.setAccess(shortURL, options.Access)
+	}
+	if !options.Redirect.IsZero() {
+		db.setRedirect(shortURL, options.Redirect)
+	}
+	if len(options.Destinations) > 0 {
+		db.setDestinations(shortURL, options.Destinations)
+	}
+	if !options.Annotation.IsZero() {
+		key := owner(userID, workspaceID)
+		db.untag(key, shortURL)
+		db.annotate(key, shortURL, options.Annotation)
+		db.reindex(key, shortURL)
+	}
+	return nil
+}
+
+//	setShortURL Add new URL of the user or, if workspaceID is set, of the workspace in map.
+//	Returns interfaces.ErrCodeTaken if the short URL leads to another URL or belongs to someone else.
+func (db *DB) setShortURL(userID, workspaceID, shortURL, URL string, private bool) error {
+	owner := owner(userID, workspaceID)
+	if baseURL, ok := db.Storage[shortURL]; ok && baseURL != URL {
+		return interfaces.ErrCodeTaken
+	}
+	if privateOwner, ok := db.private[shortURL]; ok && privateOwner != owner {
+		return interfaces.ErrCodeTaken
+	}
+	if _, ok := db.Storage[shortURL]; ok && private && db.private[shortURL] != owner {
+		return interfaces.ErrCodeTaken
+	}
 	modelURL := interfaces.ModelURL{
 		ShortURL: shortURL,
 		BaseURL:  URL,
 	}
-	if _, ok := db.ShortURL[userID]; ok {
-		for _, val := range db.ShortURL[userID] {
+	if _, ok := db.ShortURL[owner]; ok {
+		for _, val := range db.ShortURL[owner] {
 			if val.ShortURL == shortURL {
 				return interfaces.ErrAlreadyExists
 			}
 		}
 	}
-	db.ShortURL[userID] = append(db.ShortURL[userID], modelURL)
+	db.ShortURL[owner] = append(db.ShortURL[owner], modelURL)
+	if _, ok := db.Storage[shortURL]; !ok {
+		db.created[shortURL] = time.Now().UTC()
+	}
 	db.Storage[modelURL.ShortURL] = modelURL.BaseURL
+	db.reindex(owner, shortURL)
+	delete(db.deleted, shortURL)
+	if private {
+		db.private[shortURL] = owner
+	}
+	db.change(interfaces.EventLinkCreated, userID, workspaceID, shortURL, URL)
+	return nil
+}
+
+//	GetBaseURLs Get all distinct original URLs from map.
+func (db *DB) GetBaseURLs() ([]string, error) {
+	db.Lock()
+	defer db.Unlock()
+	seen := make(map[string]struct{}, len(db.Storage))
+	baseURLs := make([]string, 0, len(db.Storage))
+	for _, baseURL := range db.Storage {
+		if _, ok := seen[baseURL]; ok {
+			continue
+		}
+		seen[baseURL] = struct{}{}
+		baseURLs = append(baseURLs, baseURL)
+	}
+	return baseURLs, nil
+}
+
+//	SetURLHealth Save the last check result of the original URL in map.
+func (db *DB) SetURLHealth(baseURL string, health interfaces.URLHealth) error {
+	db.Lock()
+	defer db.Unlock()
+	db.health[baseURL] = health
+	return nil
+}
+
+//	SetURLVerdict Save the reputation of the original URL in map.
+func (db *DB) SetURLVerdict(baseURL string, verdict interfaces.Verdict) error {
+	db.Lock()
+	defer db.Unlock()
+	db.setVerdict(baseURL, verdict)
+	return nil
+}
+
+//	setVerdict Saving the verdict of the original URL, only flagged ones are kept.
+func (db *DB) setVerdict(baseURL string, verdict interfaces.Verdict) {
+	if verdict.Flagged {
+		db.verdicts[baseURL] = verdict
+	} else {
+		delete(db.verdicts, baseURL)
+	}
+}
+
+//	GetURLVerdict Get the reputation of the URL from map, the zero verdict if it isn't flagged.
+func (db *DB) GetURLVerdict(baseURL string) (interfaces.Verdict, error) {
+	db.Lock()
+	defer db.Unlock()
+	return db.verdicts[baseURL], nil
+}
+
+//	SetPageMeta Save metadata of the page of the original URL in map.
+func (db *DB) SetPageMeta(baseURL string, meta interfaces.PageMeta) error {
+	db.Lock()
+	defer db.Unlock()
+	db.meta[baseURL] = meta
+	return nil
+}
+
+//	pageMeta Returning metadata of the page of the original URL, nil if it wasn't fetched.
+func (db *DB) pageMeta(baseURL string) *interfaces.PageMeta {
+	meta, ok := db.meta[baseURL]
+	if !ok {
+		return nil
+	}
+	return &meta
+}
+
+//	ClaimURLs Move URLs of one user to another in map, URLs the other user has are dropped.
+func (db *DB) ClaimURLs(fromUserID, toUserID string) error {
+	db.Lock()
+	defer db.Unlock()
+	if fromUserID == toUserID {
+		return nil
+	}
+	have := make(map[string]struct{}, len(db.ShortURL[toUserID]))
+	for _, model := range db.ShortURL[toUserID] {
+		have[model.ShortURL] = struct{}{}
+	}
+	for _, model := range db.ShortURL[fromUserID] {
+		if _, ok := have[model.ShortURL]; !ok {
+			db.ShortURL[toUserID] = append(db.ShortURL[toUserID], model)
+			if annotation, ok := db.annotations[fromUserID][model.ShortURL]; ok {
+				db.annotate(toUserID, model.ShortURL, annotation)
+			}
+			db.reindex(toUserID, model.ShortURL)
+		}
+		db.untag(fromUserID, model.ShortURL)
+		if db.private[model.ShortURL] == fromUserID {
+			db.private[model.ShortURL] = toUserID
+		}
+	}
+	delete(db.ShortURL, fromUserID)
+	delete(db.annotations, fromUserID)
+	delete(db.search, fromUserID)
+	return nil
+}
+
+//	SetAnnotation Change the title, the notes and the tags of the short URL of the user or the workspace in map.
+//	Owners sharing the short URL keep annotations of their own.
+func (db *DB) SetAnnotation(userID, workspaceID, shortURL string, annotation interfaces.Annotation) error {
+	db.Lock()
+	defer db.Unlock()
+	key := owner(userID, workspaceID)
+	if indexURL(db.ShortURL[key], shortURL) < 0 {
+		return interfaces.ErrNotFound
+	}
+	db.untag(key, shortURL)
+	db.annotate(key, shortURL, annotation)
+	db.reindex(key, shortURL)
+	return nil
+}
+
+//	annotate Save the annotation of the short URL of the owner and index its tags.
+func (db *DB) annotate(owner, shortURL string, annotation interfaces.Annotation) {
+	if annotation.IsZero() {
+		delete(db.annotations[owner], shortURL)
+		return
+	}
+	if db.annotations[owner] == nil {
+		db.annotations[owner] = make(map[string]interfaces.Annotation)
+	}
+	db.annotations[owner][shortURL] = annotation
+	if len(annotation.Tags) > 0 && db.tagged[owner] == nil {
+		db.tagged[owner] = make(map[string]map[string]struct{})
+	}
+	for _, tag := range annotation.Tags {
+		if db.tagged[owner][tag] == nil {
+			db.tagged[owner][tag] = make(map[string]struct{})
+		}
+		db.tagged[owner][tag][shortURL] = struct{}{}
+	}
+}
+
+//	untag Remove the short URL of the owner from the index of tags.
+func (db *DB) untag(owner, shortURL string) {
+	for _, tag := range db.annotations[owner][shortURL].Tags {
+		delete(db.tagged[owner][tag], shortURL)
+		if len(db.tagged[owner][tag]) == 0 {
+			delete(db.tagged[owner], tag)
+		}
+	}
+	if len(db.tagged[owner]) == 0 {
+		delete(db.tagged, owner)
+	}
+}
+
+//	SearchURLs Get a page of URLs of the user or the workspace best matching the text from the index of the owner.
+func (db *DB) SearchURLs(userID, workspaceID string, query interfaces.SearchQuery) (interfaces.SearchResult, error) {
+	db.Lock()
+	defer db.Unlock()
+	key := owner(userID, workspaceID)
+	result := interfaces.SearchResult{Hits: make([]interfaces.SearchHit, 0)}
+	index, ok := db.search[key]
+	if !ok {
+		return result, nil
+	}
+	hits := index.Search(query.Text)
+	result.Total = len(hits)
+	if query.Offset >= len(hits) {
+		return result, nil
+	}
+	hits = hits[query.Offset:]
+	if query.Limit > 0 && query.Limit < len(hits) {
+		hits = hits[:query.Limit]
+	}
+	for _, hit := range hits {
+		model := db.listed(key, interfaces.ModelURL{ShortURL: hit.ID, BaseURL: db.Storage[hit.ID]})
+		result.Hits = append(result.Hits, interfaces.SearchHit{ModelURL: model, Score: hit.Score})
+	}
+	return result, nil
+}
+
+//	reindex Index words of the original URL and the annotation of the short URL of the owner.
+func (db *DB) reindex(owner, shortURL string) {
+	if db.search[owner] == nil {
+		db.search[owner] = search.NewIndex()
+	}
+	annotation := db.annotations[owner][shortURL]
+	db.search[owner].Add(shortURL, search.Document{
+		URL:   db.Storage[shortURL],
+		Title: annotation.Title,
+		Notes: annotation.Notes,
+	})
+}
+
+//	unindex Remove the short URL of the owner from the index.
+func (db *DB) unindex(owner, shortURL string) {
+	index, ok := db.search[owner]
+	if !ok {
+		return
+	}
+	index.Remove(shortURL)
+	if index.Len() == 0 {
+		delete(db.search, owner)
+	}
+}
+
+//	CreateAccount Add new account in map.
+func (db *DB) CreateAccount(account interfaces.Account) error {
+	db.Lock()
+	defer db.Unlock()
+	if _, ok := db.accounts[account.UserID]; ok {
+		return interfaces.ErrAlreadyExists
+	}
+	if _, ok := db.logins[account.Login]; ok {
+		return interfaces.ErrAlreadyExists
+	}
+	db.accounts[account.UserID] = account
+	db.logins[account.Login] = account.UserID
+	return nil
+}
+
+//	GetAccount Get account by login from map.
+func (db *DB) GetAccount(login string) (interfaces.Account, error) {
+	db.Lock()
+	defer db.Unlock()
+	userID, ok := db.logins[login]
+	if !ok {
+		return interfaces.Account{}, interfaces.ErrNotFound
+	}
+	return db.accounts[userID], nil
+}
+
+//	GetAccountByUserID Get account by user ID from map.
+func (db *DB) GetAccountByUserID(userID string) (interfaces.Account, error) {
+	db.Lock()
+	defer db.Unlock()
+	account, ok := db.accounts[userID]
+	if !ok {
+		return interfaces.Account{}, interfaces.ErrNotFound
+	}
+	return account, nil
+}
+
+//	SetAPIKey Add new API key in map.
+func (db *DB) SetAPIKey(key interfaces.APIKey) error {
+	db.Lock()
+	defer db.Unlock()
+	if _, ok := db.apiKeys[key.Hash]; ok {
+		return interfaces.ErrAlreadyExists
+	}
+	db.apiKeys[key.Hash] = key
+	return nil
+}
+
+//	GetAPIKeys Get API keys of the user from map, the oldest first.
+func (db *DB) GetAPIKeys(userID string) ([]interfaces.APIKey, error) {
+	db.Lock()
+	defer db.Unlock()
+	keys := make([]interfaces.APIKey, 0)
+	for _, key := range db.apiKeys {
+		if key.UserID == userID {
+			keys = append(keys, key)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].CreatedAt.Before(keys[j].CreatedAt)
+	})
+	return keys, nil
+}
+
+//	GetAPIKeyByHash Get API key by its hash from map.
+func (db *DB) GetAPIKeyByHash(hash string) (interfaces.APIKey, error) {
+	db.Lock()
+	defer db.Unlock()
+	key, ok := db.apiKeys[hash]
+	if !ok {
+		return interfaces.APIKey{}, interfaces.ErrNotFound
+	}
+	return key, nil
+}
+
+//	DelAPIKey Delete API key of the user from map.
+func (db *DB) DelAPIKey(userID, id string) error {
+	db.Lock()
+	defer db.Unlock()
+	for hash, key := range db.apiKeys {
+		if key.UserID == userID && key.ID == id {
+			delete(db.apiKeys, hash)
+			return nil
+		}
+	}
+	return interfaces.ErrNotFound
+}
+
+//	CreateWorkspace Add new workspace with its owner in map.
+func (db *DB) CreateWorkspace(workspace interfaces.Workspace, ownerID string) error {
+	db.Lock()
+	defer db.Unlock()
+	if _, ok := db.workspaces[workspace.ID]; ok {
+		return interfaces.ErrAlreadyExists
+	}
+	workspace.Role = ""
+	db.workspaces[workspace.ID] = workspace
+	db.members[workspace.ID] = map[string]interfaces.Member{
+		ownerID: {
+			WorkspaceID: workspace.ID,
+			UserID:      ownerID,
+			Role:        interfaces.RoleOwner,
+			AddedAt:     workspace.CreatedAt,
+		},
+	}
+	return nil
+}
+
+//	GetWorkspaces Get workspaces of the user with its roles from map, the oldest first.
+func (db *DB) GetWorkspaces(userID string) ([]interfaces.Workspace, error) {
+	db.Lock()
+	defer db.Unlock()
+	workspaces := make([]interfaces.Workspace, 0)
+	for id, members := range db.members {
+		if member, ok := members[userID]; ok {
+			workspace := db.workspaces[id]
+			workspace.Role = member.Role
+			workspaces = append(workspaces, workspace)
+		}
+	}
+	sort.Slice(workspaces, func(i, j int) bool {
+		return workspaces[i].CreatedAt.Before(workspaces[j].CreatedAt)
+	})
+	return workspaces, nil
+}
+
+//	SetMember Add a member to the workspace or change its role in map.
+func (db *DB) SetMember(member interfaces.Member) error {
+	db.Lock()
+	defer db.Unlock()
+	members, ok := db.members[member.WorkspaceID]
+	if !ok {
+		return interfaces.ErrNotFound
+	}
+	if prev, ok := members[member.UserID]; ok {
+		member.AddedAt = prev.AddedAt
+	}
+	member.Login = ""
+	members[member.UserID] = member
+	return nil
+}
+
+//	GetMember Get a member of the workspace from map.
+func (db *DB) GetMember(workspaceID, userID string) (interfaces.Member, error) {
+	db.Lock()
+	defer db.Unlock()
+	member, ok := db.members[workspaceID][userID]
+	if !ok {
+		return interfaces.Member{}, interfaces.ErrNotFound
+	}
+	return member, nil
+}
+
+//	GetMembers Get members of the workspace with their logins from map, the oldest first.
+func (db *DB) GetMembers(workspaceID string) ([]interfaces.Member, error) {
+	db.Lock()
+	defer db.Unlock()
+	members := make([]interfaces.Member, 0, len(db.members[workspaceID]))
+	for _, member := range db.members[workspaceID] {
+		member.Login = db.accounts[member.UserID].Login
+		members = append(members, member)
+	}
+	sort.Slice(members, func(i, j int) bool {
+		return members[i].AddedAt.Before(members[j].AddedAt)
+	})
+	return members, nil
+}
+
+//	DelMember Delete a member of the workspace from map.
+func (db *DB) DelMember(workspaceID, userID string) error {
+	db.Lock()
+	defer db.Unlock()
+	if _, ok := db.members[workspaceID][userID]; !ok {
+		return interfaces.ErrNotFound
+	}
+	delete(db.members[workspaceID], userID)
+	return nil
+}
+
+//	RetargetURL Change the original URL of the short URL in map.
+//	Health and reputation are kept by original URL, so the ones of the old URL no longer apply.
+func (db *DB) RetargetURL(workspaceID string, revision interfaces.Revision) (interfaces.Revision, error) {
+	db.Lock()
+	defer db.Unlock()
+	key := owner(revision.UserID, workspaceID)
+	i, err := db.exclusive(key, revision.ShortURL)
+	if err != nil {
+		return interfaces.Revision{}, err
+	}
+	revision.OldURL = db.ShortURL[key][i].BaseURL
+	db.retarget(key, i, revision)
+	return revision, nil
+}
+
+//	retarget Saving the new original URL of the revision for the i-th URL of the owner, if it differs.
+func (db *DB) retarget(key string, i int, revision interfaces.Revision) {
+	if revision.OldURL == revision.NewURL {
+		return
+	}
+	db.ShortURL[key][i].BaseURL = revision.NewURL
+	db.Storage[revision.ShortURL] = revision.NewURL
+	db.reindex(key, revision.ShortURL)
+	db.revisions[revision.ShortURL] = append(db.revisions[revision.ShortURL], revision)
+}
+
+//	SetRedirect Change how the short URL of the user or the workspace redirects in map.
+//	Returns interfaces.ErrShared if someone else has the short URL as well.
+func (db *DB) SetRedirect(userID, workspaceID, shortURL string, redirect interfaces.Redirect) error {
+	db.Lock()
+	defer db.Unlock()
+	if _, err := db.exclusive(owner(userID, workspaceID), shortURL); err != nil {
+		return err
+	}
+	db.setRedirect(shortURL, redirect)
+	return nil
+}
+
+//	setRedirect Saving the redirect options of the short URL, no options are not kept.
+func (db *DB) setRedirect(shortURL string, redirect interfaces.Redirect) {
+	if redirect.IsZero() {
+		delete(db.redirects, shortURL)
+	} else {
+		db.redirects[shortURL] = redirect
+	}
+}
+
+//	GetRules Get routing rules of the short URL of the user or the workspace from map.
+func (db *DB) GetRules(userID, workspaceID, shortURL string) ([]interfaces.Rule, error) {
+	db.Lock()
+	defer db.Unlock()
+	if indexURL(db.ShortURL[owner(userID, workspaceID)], shortURL) < 0 {
+		return nil, interfaces.ErrNotFound
+	}
+	return append(make([]interfaces.Rule, 0), db.rules[shortURL]...), nil
+}
+
+//	SetRules Replace routing rules of the short URL of the user or the workspace in map.
+//	Returns interfaces.ErrShared if someone else has the short URL as well.
+func (db *DB) SetRules(userID, workspaceID, shortURL string, rules []interfaces.Rule) error {
+	db.Lock()
+	defer db.Unlock()
+	if _, err := db.exclusive(owner(userID, workspaceID), shortURL); err != nil {
+		return err
+	}
+	if len(rules) == 0 {
+		delete(db.rules, shortURL)
+	} else {
+		db.rules[shortURL] = append(make([]interfaces.Rule, 0, len(rules)), rules...)
+	}
+	return nil
+}
+
+//	GetDestinations Get destinations of the short URL of the user or the workspace with the times they were served from map.
+func (db *DB) GetDestinations(userID, workspaceID, shortURL string) ([]interfaces.Destination, error) {
+	db.Lock()
+	defer db.Unlock()
+	if indexURL(db.ShortURL[owner(userID, workspaceID)], shortURL) < 0 {
+		return nil, interfaces.ErrNotFound
+	}
+	destinations := make([]interfaces.Destination, 0, len(db.destinations[shortURL]))
+	for _, destination := range db.destinations[shortURL] {
+		destination.Served = db.served[shortURL][destination.ID]
+		destinations = append(destinations, destination)
+	}
+	return destinations, nil
+}
+
+//	SetDestinations Replace destinations of the short URL of the user or the workspace in map.
+//	Destinations keep the times they were served by ID.
+//	Returns interfaces.ErrShared if someone else has the short URL as well.
+func (db *DB) SetDestinations(userID, workspaceID, shortURL string, destinations []interfaces.Destination) error {
+	db.Lock()
+	defer db.Unlock()
+	if _, err := db.exclusive(owner(userID, workspaceID), shortURL); err != nil {
+		return err
+	}
+	db.setDestinations(shortURL, destinations)
+	return nil
+}
+
+//	setDestinations Saving the destinations of the short URL, their counters start from zero.
+func (db *DB) setDestinations(shortURL string, destinations []interfaces.Destination) {
+	if len(destinations) == 0 {
+		delete(db.destinations, shortURL)
+		return
+	}
+	stored := make([]interfaces.Destination, 0, len(destinations))
+	for _, destination := range destinations {
+		destination.Served = 0
+		stored = append(stored, destination)
+	}
+	db.destinations[shortURL] = stored
+}
+
+//	AddServed Count a visitor sent to the destination of the short URL in map.
+func (db *DB) AddServed(shortURL, destinationID string) error {
+	db.Lock()
+	defer db.Unlock()
+	if db.served[shortURL] == nil {
+		db.served[shortURL] = make(map[string]int64)
+	}
+	db.served[shortURL][destinationID]++
+	return nil
+}
+
+//	GetAccess Get access limits of the short URL of the user or the workspace from map.
+func (db *DB) GetAccess(userID, workspaceID, shortURL string) (interfaces.Access, error) {
+	db.Lock()
+	defer db.Unlock()
+	if indexURL(db.ShortURL[owner(userID, workspaceID)], shortURL) < 0 {
+		return interfaces.Access{}, interfaces.ErrNotFound
+	}
+	return db.access[shortURL], nil
+}
+
+//	SetAccess Change access limits of the short URL of the user or the workspace in map.
+//	Visits are counted against the new limit from scratch.
+//	Returns interfaces.ErrShared if someone else has the short URL as well.
+func (db *DB) SetAccess(userID, workspaceID, shortURL string, access interfaces.Access) error {
+	db.Lock()
+	defer db.Unlock()
+	if _, err := db.exclusive(owner(userID, workspaceID), shortURL); err != nil {
+		return err
+	}
+	db.setAccess(shortURL, access)
+	return nil
+}
+
+//	setAccess Saving the limits of the short URL, visits are counted anew.
+func (db *DB) setAccess(shortURL string, access interfaces.Access) {
+	if access.IsZero() {
+		delete(db.access, shortURL)
+	} else {
+		db.access[shortURL] = access
+	}
+	delete(db.visits, shortURL)
+}
+
+//	PatchURL Apply the patch to the short URL of the user or the workspace in map, nothing is saved if it can't be.
+func (db *DB) PatchURL(userID, workspaceID, shortURL string, patch interfaces.Patch) (interfaces.Revision, error) {
+	db.Lock()
+	defer db.Unlock()
+	key := owner(userID, workspaceID)
+	i, err := db.exclusive(key, shortURL)
+	if err != nil {
+		return interfaces.Revision{}, err
+	}
+	revision := interfaces.Revision{
+		ShortURL:  shortURL,
+		OldURL:    db.ShortURL[key][i].BaseURL,
+		NewURL:    patch.NewURL,
+		UserID:    userID,
+		ChangedAt: patch.ChangedAt,
+	}
+	if revision.NewURL == "" {
+		revision.NewURL = revision.OldURL
+	}
+	db.retarget(key, i, revision)
+	if patch.Verdict != nil {
+		db.setVerdict(revision.NewURL, *patch.Verdict)
+	}
+	if patch.Redirect != nil {
+		db.setRedirect(shortURL, *patch.Redirect)
+	}
+	if patch.Access != nil {
+		db.setAccess(shortURL, *patch.Access)
+	}
+	return revision, nil
+}
+
+//	ConsumeVisit Count a visit of the short URL in map, returning the number of visits counted.
+//	Returns interfaces.ErrExpired if the short URL has no visits left.
+func (db *DB) ConsumeVisit(shortURL string) (int64, error) {
+	db.Lock()
+	defer db.Unlock()
+	if err := db.visitable(shortURL); err != nil {
+		return 0, err
+	}
+	db.visits[shortURL]++
+	return db.visits[shortURL], nil
+}
+
+//	AddClick Count a click of the short URL in map, returning the number of clicks counted.
+func (db *DB) AddClick(shortURL string) (int64, error) {
+	db.Lock()
+	defer db.Unlock()
+	if _, ok := db.Storage[shortURL]; !ok {
+		return 0, interfaces.ErrNotFound
+	}
+	db.clicks[shortURL]++
+	return db.clicks[shortURL], nil
+}
+
+//	GetOwners Get users and workspaces having the short URL from map.
+func (db *DB) GetOwners(shortURL string) ([]interfaces.Owner, error) {
+	db.Lock()
+	defer db.Unlock()
+	owners := make([]interfaces.Owner, 0, 1)
+	for key, models := range db.ShortURL {
+		if indexURL(models, shortURL) < 0 {
+			continue
+		}
+		if strings.HasPrefix(key, workspaceOwner("")) {
+			owners = append(owners, interfaces.Owner{WorkspaceID: strings.TrimPrefix(key, workspaceOwner(""))})
+		} else {
+			owners = append(owners, interfaces.Owner{UserID: key})
+		}
+	}
+	return owners, nil
+}
+
+//	CreateWebhook Add new webhook in map.
+func (db *DB) CreateWebhook(webhook interfaces.Webhook) error {
+	db.Lock()
+	defer db.Unlock()
+	if _, ok := db.webhooks[webhook.ID]; ok {
+		return interfaces.ErrAlreadyExists
+	}
+	db.webhooks[webhook.ID] = webhook
+	return nil
+}
+
+//	GetWebhooks Get webhooks of the user or the workspace from map, the oldest first.
+func (db *DB) GetWebhooks(userID, workspaceID string) ([]interfaces.Webhook, error) {
+	db.Lock()
+	defer db.Unlock()
+	webhooks := make([]interfaces.Webhook, 0)
+	for _, webhook := range db.webhooks {
+		if ownsWebhook(webhook, userID, workspaceID) {
+			webhooks = append(webhooks, webhook)
+		}
+	}
+	sort.Slice(webhooks, func(i, j int) bool {
+		if !webhooks[i].CreatedAt.Equal(webhooks[j].CreatedAt) {
+			return webhooks[i].CreatedAt.Before(webhooks[j].CreatedAt)
+		}
+		return webhooks[i].ID < webhooks[j].ID
+	})
+	return webhooks, nil
+}
+
+//	ownsWebhook Checking the webhook belongs to the user or, if workspaceID is set, to the workspace.
+func ownsWebhook(webhook interfaces.Webhook, userID, workspaceID string) bool {
+	if workspaceID != "" {
+		return webhook.WorkspaceID == workspaceID
+	}
+	return webhook.WorkspaceID == "" && webhook.UserID == userID
+}
+
+//	GetWebhook Get webhook by its ID from map.
+func (db *DB) GetWebhook(id string) (interfaces.Webhook, error) {
+	db.Lock()
+	defer db.Unlock()
+	webhook, ok := db.webhooks[id]
+	if !ok {
+		return interfaces.Webhook{}, interfaces.ErrNotFound
+	}
+	return webhook, nil
+}
+
+//	DelWebhook Delete webhook of the user or the workspace with its deliveries from map.
+func (db *DB) DelWebhook(userID, workspaceID, id string) error {
+	db.Lock()
+	defer db.Unlock()
+	webhook, ok := db.webhooks[id]
+	if !ok || !ownsWebhook(webhook, userID, workspaceID) {
+		return interfaces.ErrNotFound
+	}
+	delete(db.webhooks, id)
+	for deliveryID, delivery := range db.deliveries {
+		if delivery.WebhookID == id {
+			delete(db.deliveries, deliveryID)
+			delete(db.pending, deliveryID)
+		}
+	}
+	return nil
+}
+
+//	GetClickThresholds Get distinct click thresholds of all webhooks from map, the smallest first.
+func (db *DB) GetClickThresholds() ([]int64, error) {
+	db.Lock()
+	defer db.Unlock()
+	seen := make(map[int64]struct{})
+	thresholds := make([]int64, 0)
+	for _, webhook := range db.webhooks {
+		for _, threshold := range webhook.Thresholds {
+			if _, ok := seen[threshold]; !ok {
+				seen[threshold] = struct{}{}
+				thresholds = append(thresholds, threshold)
+			}
+		}
+	}
+	sort.Slice(thresholds, func(i, j int) bool { return thresholds[i] < thresholds[j] })
+	return thresholds, nil
+}
+
+//	AddDeliveries Add new webhook deliveries to the outbox in map, deliveries it has are kept as they are.
+func (db *DB) AddDeliveries(deliveries []interfaces.WebhookDelivery) error {
+	db.Lock()
+	defer db.Unlock()
+	db.addDeliveries(deliveries)
+	return nil
+}
+
+//	addDeliveries Save the webhook deliveries which are new, returning them.
+func (db *DB) addDeliveries(deliveries []interfaces.WebhookDelivery) []interfaces.WebhookDelivery {
+	added := make([]interfaces.WebhookDelivery, 0, len(deliveries))
+	for _, delivery := range deliveries {
+		if _, ok := db.deliveries[delivery.ID]; ok {
+			continue
+		}
+		db.putDelivery(delivery)
+		added = append(added, delivery)
+	}
+	return added
+}
+
+//	putDelivery Save the webhook delivery, keeping track of pending ones.
+func (db *DB) putDelivery(delivery interfaces.WebhookDelivery) {
+	db.deliveries[delivery.ID] = delivery
+	if delivery.Status == interfaces.DeliveryPending {
+		db.pending[delivery.ID] = struct{}{}
+	} else {
+		delete(db.pending, delivery.ID)
+	}
+}
+
+//	ClaimDeliveries Get pending webhook deliveries due at now from map, the longest waiting first.
+//	Claimed deliveries are put off by the lease, so they are attempted again only if their results are lost.
+func (db *DB) ClaimDeliveries(now time.Time, lease time.Duration, limit int) ([]interfaces.WebhookDelivery, error) {
+	db.Lock()
+	defer db.Unlock()
+	due := make([]interfaces.WebhookDelivery, 0)
+	for id := range db.pending {
+		if delivery := db.deliveries[id]; !delivery.NextAttemptAt.After(now) {
+			due = append(due, delivery)
+		}
+	}
+	sort.Slice(due, func(i, j int) bool {
+		if !due[i].NextAttemptAt.Equal(due[j].NextAttemptAt) {
+			return due[i].NextAttemptAt.Before(due[j].NextAttemptAt)
+		}
+		return due[i].ID < due[j].ID
+	})
+	if len(due) > limit {
+		due = due[:limit]
+	}
+	for i := range due {
+		due[i].NextAttemptAt = now.Add(lease)
+		db.deliveries[due[i].ID] = due[i]
+	}
+	return due, nil
+}
+
+//	SetDelivery Change the webhook delivery after an attempt in map.
+//	Returns interfaces.ErrNotFound if the delivery was deleted with its webhook.
+func (db *DB) SetDelivery(delivery interfaces.WebhookDelivery) error {
+	db.Lock()
+	defer db.Unlock()
+	if _, ok := db.deliveries[delivery.ID]; !ok {
+		return interfaces.ErrNotFound
+	}
+	db.putDelivery(delivery)
+	return nil
+}
+
+//	GetDeliveries Get the latest deliveries of the webhook of the user or the workspace from map, the newest first.
+func (db *DB) GetDeliveries(userID, workspaceID, webhookID string, limit int) ([]interfaces.WebhookDelivery, error) {
+	db.Lock()
+	defer db.Unlock()
+	webhook, ok := db.webhooks[webhookID]
+	if !ok || !ownsWebhook(webhook, userID, workspaceID) {
+		return nil, interfaces.ErrNotFound
+	}
+	deliveries := make([]interfaces.WebhookDelivery, 0)
+	for _, delivery := range db.deliveries {
+		if delivery.WebhookID == webhookID {
+			deliveries = append(deliveries, delivery)
+		}
+	}
+	sort.Slice(deliveries, func(i, j int) bool {
+		if !deliveries[i].CreatedAt.Equal(deliveries[j].CreatedAt) {
+			return deliveries[i].CreatedAt.After(deliveries[j].CreatedAt)
+		}
+		return deliveries[i].ID > deliveries[j].ID
+	})
+	if limit > 0 && len(deliveries) > limit {
+		deliveries = deliveries[:limit]
+	}
+	return deliveries, nil
+}
+
+//	PruneDeliveries Delete delivered and failed webhook deliveries last changed before the time from map.
+func (db *DB) PruneDeliveries(before time.Time) error {
+	db.Lock()
+	defer db.Unlock()
+	for id, delivery := range db.deliveries {
+		if delivery.Status != interfaces.DeliveryPending && delivery.UpdatedAt.Before(before) {
+			delete(db.deliveries, id)
+		}
+	}
+	return nil
+}
+
+//	change Appending a change of the link to the change log, the lock is held by the caller.
+func (db *DB) change(kind, userID, workspaceID, shortURL, baseURL string) {
+	if db.replay {
+		return
+	}
+	db.changes = append(db.changes, interfaces.Change{
+		Offset:      int64(len(db.changes)) + 1,
+		Type:        kind,
+		ShortURL:    shortURL,
+		BaseURL:     baseURL,
+		UserID:      userID,
+		WorkspaceID: workspaceID,
+		CreatedAt:   time.Now().UTC(),
+	})
+}
+
+//	changesAfter Returning changes of all links past the offset.
+func (db *DB) changesAfter(after int64) []interfaces.Change {
+	db.Lock()
+	defer db.Unlock()
+	if after >= int64(len(db.changes)) {
+		return nil
+	}
+	if after < 0 {
+		after = 0
+	}
+	return append([]interfaces.Change(nil), db.changes[after:]...)
+}
+
+//	lastChange Returning the offset of the last change.
+func (db *DB) lastChange() int64 {
+	db.Lock()
+	defer db.Unlock()
+	return int64(len(db.changes))
+}
+
+//	addChange Appending a change read back from a file, keeping its offset and time.
+func (db *DB) addChange(change interfaces.Change) {
+	db.Lock()
+	defer db.Unlock()
+	if change.Offset == int64(len(db.changes))+1 {
+		db.changes = append(db.changes, change)
+	}
+}
+
+//	GetChanges Get changes of links of the user or the workspace past the offset from map, the oldest first.
+//	Without the user and the workspace changes of all links are returned. A limit of 0 returns all of them.
+func (db *DB) GetChanges(userID, workspaceID string, after int64, limit int) ([]interfaces.Change, error) {
+	db.Lock()
+	defer db.Unlock()
+	changes := make([]interfaces.Change, 0)
+	if after < 0 {
+		after = 0
+	}
+	// offsets are positions in the log
+	for i := after; i < int64(len(db.changes)); i++ {
+		change := db.changes[i]
+		if userID != "" || workspaceID != "" {
+			by := interfaces.Owner{UserID: change.UserID, WorkspaceID: change.WorkspaceID}
+			if !by.Is(userID, workspaceID) {
+				continue
+			}
+		}
+		changes = append(changes, change)
+		if limit > 0 && len(changes) == limit {
+			break
+		}
+	}
+	return changes, nil
+}
+
+//	GetChangeCursor Get the offset of the last change the consumer has taken from map, 0 if none.
+func (db *DB) GetChangeCursor(consumer string) (int64, error) {
+	db.Lock()
+	defer db.Unlock()
+	return db.cursors[consumer], nil
+}
+
+//	SetChangeCursor Save the offset of the last change the consumer has taken in map.
+func (db *DB) SetChangeCursor(consumer string, offset int64) error {
+	db.Lock()
+	defer db.Unlock()
+	db.cursors[consumer] = offset
 	return nil
 }
+
+//	createdAt Returning the time the short URL was first created, zero if it isn't known.
+func (db *DB) createdAt(shortURL string) time.Time {
+	db.Lock()
+	defer db.Unlock()
+	return db.created[shortURL]
+}
+
+//	setCreatedAt Change the time the short URL was first created, zero if it isn't known.
+func (db *DB) setCreatedAt(shortURL string, at time.Time) {
+	db.Lock()
+	defer db.Unlock()
+	if at.IsZero() {
+		delete(db.created, shortURL)
+	} else {
+		db.created[shortURL] = at
+	}
+}
+
+//	visitable Checking the short URL can be visited once more.
+func (db *DB) visitable(shortURL string) error {
+	if _, ok := db.Storage[shortURL]; !ok {
+		return interfaces.ErrNotFound
+	}
+	if _, ok := db.deleted[shortURL]; ok {
+		return interfaces.ErrWasDeleted
+	}
+	if db.exhausted(shortURL) {
+		return interfaces.ErrExpired
+	}
+	return nil
+}
+
+//	exhausted Checking the short URL has no visits left.
+func (db *DB) exhausted(shortURL string) bool {
+	maxVisits := db.access[shortURL].MaxVisits
+	return maxVisits > 0 && db.visits[shortURL] >= maxVisits
+}
+
+//	exclusive Returning the index of the short URL among URLs of the owner.
+//	Returns interfaces.ErrNotFound if the owner has no such short URL
+//	and interfaces.ErrShared if someone else has it as well.
+func (db *DB) exclusive(key, shortURL string) (int, error) {
+	i := indexURL(db.ShortURL[key], shortURL)
+	if i < 0 {
+		return -1, interfaces.ErrNotFound
+	}
+	for other, models := range db.ShortURL {
+		if other != key && indexURL(models, shortURL) >= 0 {
+			return -1, interfaces.ErrShared
+		}
+	}
+	return i, nil
+}
+
+//	GetURLHistory Get changes of the short URL of the user or the workspace from map, the oldest first.
+func (db *DB) GetURLHistory(userID, workspaceID, shortURL string) ([]interfaces.Revision, error) {
+	db.Lock()
+	defer db.Unlock()
+	if indexURL(db.ShortURL[owner(userID, workspaceID)], shortURL) < 0 {
+		return nil, interfaces.ErrNotFound
+	}
+	return append(make([]interfaces.Revision, 0), db.revisions[shortURL]...), nil
+}
+
+func indexURL(models []interfaces.ModelURL, shortURL string) int {
+	for i, model := range models {
+		if model.ShortURL == shortURL {
+			return i
+		}
+	}
+	return -1
+}
+
 func (db *DB) Ping() error {
 	return nil
 }
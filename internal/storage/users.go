@@ -2,67 +2,101 @@ package storage
 
 import (
 	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
 	"encoding/hex"
 
-	"github.com/ivanmyagkov/shortener.git/internal/config"
+	"github.com/ivanmyagkov/shortener.git/internal/interfaces"
+	"github.com/ivanmyagkov/shortener.git/internal/keys"
 )
 
+//	macSize is the length of the session signature in bytes.
+const macSize = 16
+
 type DBUsers struct {
-	//storageUsers map[string]ModelUser
-	randNum    string
 	CookieWord string
+	keys       []sessionKey
+	legacy     cipher.Block
 }
 
-//type ModelUser struct {
-//	userID string
-//	cookie string
-//}
+//	sessionKey is a session key split into the encryption and signing parts.
+type sessionKey struct {
+	block cipher.Block
+	mac   []byte
+}
 
-func New() *DBUsers {
-	return &DBUsers{
-		//	storageUsers: map[string]ModelUser{},
-		randNum:    "",
+//	New is function to create sessions encrypted with the key ring.
+func New(ring *keys.Ring) *DBUsers {
+	u := &DBUsers{
 		CookieWord: "cookie",
 	}
+	for _, key := range ring.Keys() {
+		block, err := aes.NewCipher(derive(key.Secret, "session encryption"))
+		if err != nil {
+			// keys of the ring always have a valid length
+			panic(err)
+		}
+		u.keys = append(u.keys, sessionKey{block: block, mac: derive(key.Secret, "session signature")})
+	}
+	if legacy := ring.Legacy(); legacy != nil {
+		u.legacy, _ = aes.NewCipher(legacy)
+	}
+	return u
+}
+
+//	derive Deriving a separate key for every purpose from a secret.
+func derive(secret []byte, purpose string) []byte {
+	h := hmac.New(sha256.New, secret)
+	h.Write([]byte(purpose))
+	return h.Sum(nil)
+}
+
+func (k sessionKey) sign(data []byte) []byte {
+	h := hmac.New(sha256.New, k.mac)
+	h.Write(data)
+	return h.Sum(nil)[:macSize]
 }
 
 //	CreateSissionID Creating a session id for cookies.
+//	The user ID is encrypted and signed with the primary key.
 func (MU *DBUsers) CreateSissionID(uid string) (string, error) {
-	// Generate SessionID
-	MU.randNum = uid
-	src, err := hex.DecodeString(MU.randNum)
+	src, err := hex.DecodeString(uid)
 	if err != nil {
 		return "", err
 	}
-	// Read secret key
-	key := []byte(config.Secret)
-
-	// Sign the session with a secret key
-	aesblock, err := aes.NewCipher(key)
-	if err != nil {
-		return "", err
+	if len(src) != aes.BlockSize {
+		return "", interfaces.ErrBadSession
 	}
-	dst := make([]byte, 16)
-
-	aesblock.Encrypt(dst, src)
-	cookie := hex.EncodeToString(dst)
-	return cookie, nil
-
+	key := MU.keys[0]
+	dst := make([]byte, aes.BlockSize, aes.BlockSize+macSize)
+	key.block.Encrypt(dst, src)
+	dst = append(dst, key.sign(dst)...)
+	return hex.EncodeToString(dst), nil
 }
 
 //	ReadSessionID Reading the user ID.
+//	Sessions signed with any active key are accepted,
+//	unsigned sessions of the old format only with the legacy key.
 func (MU *DBUsers) ReadSessionID(id string) (string, error) {
-	key := []byte(config.Secret)
 	dst, err := hex.DecodeString(id)
 	if err != nil {
 		return "", err
 	}
-	aesblock, err := aes.NewCipher(key)
-	if err != nil {
-		return "", err
+	src := make([]byte, aes.BlockSize)
+	switch len(dst) {
+	case aes.BlockSize + macSize:
+		for _, key := range MU.keys {
+			if hmac.Equal(key.sign(dst[:aes.BlockSize]), dst[aes.BlockSize:]) {
+				key.block.Decrypt(src, dst[:aes.BlockSize])
+				return hex.EncodeToString(src), nil
+			}
+		}
+	case aes.BlockSize:
+		if MU.legacy != nil {
+			MU.legacy.Decrypt(src, dst)
+			return hex.EncodeToString(src), nil
+		}
 	}
-	// Decryption session id by secret key
-	src := make([]byte, 16)
-	aesblock.Decrypt(src, dst)
-	return hex.EncodeToString(src), nil
+	return "", interfaces.ErrBadSession
 }
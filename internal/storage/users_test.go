@@ -0,0 +1,71 @@
+package storage
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ivanmyagkov/shortener.git/internal/keys"
+)
+
+func TestDBUsers_Rotation(t *testing.T) {
+	oldKey, err := keys.Generate()
+	require.NoError(t, err)
+	oldKey.ID = "old"
+	newKey, err := keys.Generate()
+	require.NoError(t, err)
+
+	uid := "0123456789abcdef0123456789abcdef"
+	oldRing, err := keys.NewRing([]keys.Key{oldKey}, nil)
+	require.NoError(t, err)
+	session, err := New(oldRing).CreateSissionID(uid)
+	require.NoError(t, err)
+
+	// the old key is still active, new sessions use the new one
+	ring, err := keys.NewRing([]keys.Key{newKey, oldKey}, nil)
+	require.NoError(t, err)
+	users := New(ring)
+	got, err := users.ReadSessionID(session)
+	require.NoError(t, err)
+	assert.Equal(t, uid, got)
+	fresh, err := users.CreateSissionID(uid)
+	require.NoError(t, err)
+	assert.NotEqual(t, session, fresh)
+
+	// the old key is retired
+	ring, err = keys.NewRing([]keys.Key{newKey}, nil)
+	require.NoError(t, err)
+	users = New(ring)
+	_, err = users.ReadSessionID(session)
+	assert.Error(t, err)
+	got, err = users.ReadSessionID(fresh)
+	require.NoError(t, err)
+	assert.Equal(t, uid, got)
+
+	// a tampered session is rejected
+	tampered := "ff" + fresh[2:]
+	if strings.HasPrefix(fresh, "ff") {
+		tampered = "00" + fresh[2:]
+	}
+	_, err = users.ReadSessionID(tampered)
+	assert.Error(t, err)
+}
+
+func TestDBUsers_Legacy(t *testing.T) {
+	key, err := keys.Generate()
+	require.NoError(t, err)
+	legacy := "a07a35a622236b60753719fbc9a9ff0c"
+
+	ring, err := keys.NewRing([]keys.Key{key}, nil)
+	require.NoError(t, err)
+	_, err = New(ring).ReadSessionID(legacy)
+	assert.Error(t, err)
+
+	ring, err = keys.NewRing([]keys.Key{key}, []byte("vfktymrjqtkjxrt[jkjlyjpb"))
+	require.NoError(t, err)
+	uid, err := New(ring).ReadSessionID(legacy)
+	require.NoError(t, err)
+	assert.Len(t, uid, 32)
+}
@@ -0,0 +1,145 @@
+//	Package tlsconfig for building the TLS settings of the server:
+//	certificates from files, from Let's Encrypt or self-signed ones.
+package tlsconfig
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+//	Settings of TLS.
+type Settings struct {
+	// certificate and key files, they are re-read when rotated
+	CertFile string
+	KeyFile  string
+	// generate a self-signed certificate for development
+	SelfSigned bool
+	// host names to get Let's Encrypt certificates for
+	AutocertHosts []string
+	// directory to keep Let's Encrypt certificates
+	AutocertCacheDir string
+	// minimal TLS version: 1.0, 1.1, 1.2 or 1.3
+	MinVersion string
+	// allowed cipher suites for TLS 1.2 and older, default suites if empty
+	CipherSuites []string
+}
+
+//	Server is TLS settings with the handler for the plain HTTP listener.
+type Server struct {
+	Config *tls.Config
+	// Redirect sends plain HTTP requests to HTTPS,
+	// with Let's Encrypt it also answers HTTP-01 challenges.
+	Redirect http.Handler
+}
+
+var versions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+//	New is function to build TLS settings.
+//	Certificate files take precedence over a self-signed certificate, which takes precedence over Let's Encrypt.
+//	httpsAddr is the address of the HTTPS listener used in redirects.
+func New(settings Settings, httpsAddr string) (*Server, error) {
+	cfg := &tls.Config{}
+	if settings.MinVersion != "" {
+		version, ok := versions[settings.MinVersion]
+		if !ok {
+			return nil, fmt.Errorf("unknown TLS version %q", settings.MinVersion)
+		}
+		cfg.MinVersion = version
+	} else {
+		cfg.MinVersion = tls.VersionTLS12
+	}
+	suites, err := cipherSuites(settings.CipherSuites)
+	if err != nil {
+		return nil, err
+	}
+	cfg.CipherSuites = suites
+
+	_, port, err := net.SplitHostPort(httpsAddr)
+	if err != nil {
+		return nil, err
+	}
+	srv := &Server{Config: cfg, Redirect: redirectHandler(port)}
+
+	switch {
+	case settings.CertFile != "" || settings.KeyFile != "":
+		if settings.CertFile == "" || settings.KeyFile == "" {
+			return nil, errors.New("both TLS certificate and key files are required")
+		}
+		reloader, err := NewCertReloader(settings.CertFile, settings.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.GetCertificate = reloader.GetCertificate
+	case settings.SelfSigned:
+		cert, err := SelfSigned(settings.AutocertHosts)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	default:
+		if len(settings.AutocertHosts) == 0 {
+			return nil, errors.New("no TLS certificate: set certificate files, self-signed mode or autocert hosts")
+		}
+		m := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			Cache:      autocert.DirCache(settings.AutocertCacheDir),
+			HostPolicy: autocert.HostWhitelist(settings.AutocertHosts...),
+		}
+		cfg.GetCertificate = m.GetCertificate
+		cfg.NextProtos = []string{"h2", "http/1.1", "acme-tls/1"}
+		srv.Redirect = m.HTTPHandler(srv.Redirect)
+	}
+	return srv, nil
+}
+
+//	cipherSuites Finding cipher suites by names, insecure suites are not allowed.
+func cipherSuites(names []string) ([]uint16, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+	known := make(map[string]uint16)
+	for _, suite := range tls.CipherSuites() {
+		known[suite.Name] = suite.ID
+	}
+	ids := make([]uint16, 0, len(names))
+	for _, name := range names {
+		id, ok := known[strings.TrimSpace(name)]
+		if !ok {
+			return nil, fmt.Errorf("unknown or insecure cipher suite %q", name)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+//	redirectHandler Redirecting requests to the same host and path on HTTPS.
+func redirectHandler(httpsPort string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			http.Error(w, "use HTTPS", http.StatusBadRequest)
+			return
+		}
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if strings.Contains(host, ":") {
+			host = "[" + host + "]"
+		}
+		if httpsPort != "" && httpsPort != "443" {
+			host += ":" + httpsPort
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusMovedPermanently)
+	})
+}
@@ -0,0 +1,76 @@
+package tlsconfig
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeCert(t *testing.T, dir string, host string) (string, string) {
+	cert, err := SelfSigned([]string{host})
+	require.NoError(t, err)
+	key, err := x509.MarshalPKCS8PrivateKey(cert.PrivateKey)
+	require.NoError(t, err)
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	require.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]}), 0600))
+	require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: key}), 0600))
+	return certFile, keyFile
+}
+
+func leafHost(t *testing.T, cert *tls.Certificate) []string {
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	require.NoError(t, err)
+	return leaf.DNSNames
+}
+
+func TestCertReloader_GetCertificate(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := writeCert(t, dir, "old.example.com")
+	r, err := NewCertReloader(certFile, keyFile)
+	require.NoError(t, err)
+
+	cert, err := r.GetCertificate(nil)
+	require.NoError(t, err)
+	assert.Contains(t, leafHost(t, cert), "old.example.com")
+
+	writeCert(t, dir, "new.example.com")
+	modTime := time.Now().Add(time.Minute)
+	require.NoError(t, os.Chtimes(certFile, modTime, modTime))
+	r.checked = time.Now().Add(-reloadInterval)
+
+	cert, err = r.GetCertificate(nil)
+	require.NoError(t, err)
+	assert.Contains(t, leafHost(t, cert), "new.example.com")
+}
+
+func TestNew(t *testing.T) {
+	srv, err := New(Settings{SelfSigned: true, MinVersion: "1.3", AutocertHosts: []string{"short.ru"}}, ":8443")
+	require.NoError(t, err)
+	assert.Equal(t, uint16(tls.VersionTLS13), srv.Config.MinVersion)
+	require.Len(t, srv.Config.Certificates, 1)
+
+	req := httptest.NewRequest(http.MethodGet, "http://short.ru/f845599b09851789?a=b", nil)
+	rec := httptest.NewRecorder()
+	srv.Redirect.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusMovedPermanently, rec.Code)
+	assert.Equal(t, "https://short.ru:8443/f845599b09851789?a=b", rec.Header().Get("Location"))
+
+	_, err = New(Settings{}, ":443")
+	assert.Error(t, err)
+	_, err = New(Settings{SelfSigned: true, MinVersion: "2.0"}, ":443")
+	assert.Error(t, err)
+	_, err = New(Settings{SelfSigned: true, CipherSuites: []string{"TLS_RSA_WITH_RC4_128_SHA"}}, ":443")
+	assert.Error(t, err)
+	_, err = New(Settings{SelfSigned: true, CipherSuites: []string{"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256"}}, ":443")
+	assert.NoError(t, err)
+}
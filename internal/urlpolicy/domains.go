@@ -0,0 +1,119 @@
+package urlpolicy
+
+import (
+	"bufio"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+//	reloadInterval is how often a domain list file is checked for changes.
+const reloadInterval = 5 * time.Second
+
+//	DomainList is a set of domains read from a file, one domain per line,
+//	"#" starts a comment. A domain matches itself and all its subdomains.
+//	The file is re-read when it changes.
+type DomainList struct {
+	mu      sync.RWMutex
+	path    string
+	modTime time.Time
+	checked time.Time
+	domains map[string]struct{}
+}
+
+//	LoadDomainList is function to read a domain list from file.
+func LoadDomainList(path string) (*DomainList, error) {
+	l := &DomainList{path: path}
+	if err := l.Reload(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+//	Reload Reading the file again.
+func (l *DomainList) Reload() error {
+	file, err := os.Open(l.path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	stat, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	domains := make(map[string]struct{})
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		line = strings.Trim(strings.ToLower(strings.TrimSpace(line)), ".")
+		if line != "" {
+			domains[line] = struct{}{}
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.domains = domains
+	l.modTime = stat.ModTime()
+	l.checked = time.Now()
+	return nil
+}
+
+//	Len Number of domains in the list.
+func (l *DomainList) Len() int {
+	l.reloadIfChanged()
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return len(l.domains)
+}
+
+//	Match Checking if the host or one of its parent domains is in the list.
+func (l *DomainList) Match(host string) bool {
+	l.reloadIfChanged()
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	host = strings.Trim(strings.ToLower(host), ".")
+	for host != "" {
+		if _, ok := l.domains[host]; ok {
+			return true
+		}
+		i := strings.IndexByte(host, '.')
+		if i < 0 {
+			break
+		}
+		host = host[i+1:]
+	}
+	return false
+}
+
+func (l *DomainList) reloadIfChanged() {
+	l.mu.Lock()
+	if time.Since(l.checked) < reloadInterval {
+		l.mu.Unlock()
+		return
+	}
+	l.checked = time.Now()
+	modTime := l.modTime
+	l.mu.Unlock()
+
+	stat, err := os.Stat(l.path)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	if stat.ModTime().Equal(modTime) {
+		return
+	}
+	if err = l.Reload(); err != nil {
+		log.Println(err)
+	}
+}
@@ -0,0 +1,254 @@
+//	Package urlpolicy for deciding which URLs may be shortened.
+package urlpolicy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/ivanmyagkov/shortener.git/internal/interfaces"
+)
+
+//	Codes of validation errors.
+const (
+	CodeInvalidURL       = "invalid_url"
+	CodeTooLong          = "url_too_long"
+	CodeSchemeNotAllowed = "scheme_not_allowed"
+	CodePrivateAddress   = "private_address"
+	CodeSelfReference    = "self_reference"
+	CodeDomainBlocked    = "domain_blocked"
+	CodeDomainNotAllowed = "domain_not_allowed"
+)
+
+//	resolveTimeout limits host name resolution when ResolveHosts is set.
+const resolveTimeout = 2 * time.Second
+
+//	Settings of the policy.
+type Settings struct {
+	// allowed URL schemes
+	Schemes []string
+	// deny private, loopback, link-local and unspecified addresses
+	DenyPrivate bool
+	// resolve host names and check their addresses too
+	ResolveHosts bool
+	// maximal URL length, 0 is unlimited
+	MaxLength int
+	// file of denied domains
+	BlocklistFile string
+	// file of allowed domains, if set other domains are denied
+	AllowlistFile string
+	// base URLs of the shortener itself, links to them are denied
+	SelfURLs []string
+}
+
+type Policy struct {
+	schemes      map[string]struct{}
+	denyPrivate  bool
+	resolveHosts bool
+	maxLength    int
+	blocklist    *DomainList
+	allowlist    *DomainList
+	self         map[string]struct{}
+	lookupIP     func(ctx context.Context, host string) ([]net.IPAddr, error)
+}
+
+//	New is function to create a validation policy.
+func New(settings Settings) (*Policy, error) {
+	p := &Policy{
+		schemes:      make(map[string]struct{}),
+		denyPrivate:  settings.DenyPrivate,
+		resolveHosts: settings.ResolveHosts,
+		maxLength:    settings.MaxLength,
+		self:         make(map[string]struct{}),
+		lookupIP:     net.DefaultResolver.LookupIPAddr,
+	}
+	for _, scheme := range settings.Schemes {
+		p.schemes[strings.ToLower(scheme)] = struct{}{}
+	}
+	for _, selfURL := range settings.SelfURLs {
+		u, err := url.Parse(selfURL)
+		if err != nil {
+			return nil, err
+		}
+		p.self[hostPort(u)] = struct{}{}
+	}
+	var err error
+	if settings.BlocklistFile != "" {
+		if p.blocklist, err = LoadDomainList(settings.BlocklistFile); err != nil {
+			return nil, err
+		}
+	}
+	if settings.AllowlistFile != "" {
+		if p.allowlist, err = LoadDomainList(settings.AllowlistFile); err != nil {
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+//	Reload Reading the domain lists again.
+func (p *Policy) Reload() error {
+	if p.blocklist != nil {
+		if err := p.blocklist.Reload(); err != nil {
+			return err
+		}
+	}
+	if p.allowlist != nil {
+		if err := p.allowlist.Reload(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//	Validate Checking the URL against the policy.
+//	Returns *interfaces.ValidationError if the URL is denied.
+func (p *Policy) Validate(rawURL string) error {
+	if p.maxLength > 0 && len(rawURL) > p.maxLength {
+		return deny(CodeTooLong, "URL is longer than %d characters", p.maxLength)
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Scheme == "" {
+		return deny(CodeInvalidURL, "URL is not valid")
+	}
+	scheme := strings.ToLower(u.Scheme)
+	if _, ok := p.schemes[scheme]; !ok {
+		return deny(CodeSchemeNotAllowed, "scheme %q is not allowed", scheme)
+	}
+	host := strings.TrimSuffix(strings.ToLower(u.Hostname()), ".")
+	if host == "" {
+		return deny(CodeInvalidURL, "URL has no host")
+	}
+	if _, ok := p.self[hostPort(u)]; ok {
+		return deny(CodeSelfReference, "URL points to the shortener itself")
+	}
+	if p.allowlist != nil && !p.allowlist.Match(host) {
+		return deny(CodeDomainNotAllowed, "domain %q is not in the allowlist", host)
+	}
+	if p.blocklist != nil && p.blocklist.Match(host) {
+		return deny(CodeDomainBlocked, "domain %q is blocked", host)
+	}
+	if p.denyPrivate {
+		if err = p.checkAddress(host); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//	checkAddress Denying hosts which are or resolve to non-public addresses.
+func (p *Policy) checkAddress(host string) error {
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return deny(CodePrivateAddress, "host %q is not public", host)
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		ip = parseLooseIPv4(host)
+	}
+	if ip != nil {
+		if !IsPublic(ip) {
+			return deny(CodePrivateAddress, "address %s is not public", ip)
+		}
+		return nil
+	}
+	if !p.resolveHosts {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), resolveTimeout)
+	defer cancel()
+	addrs, err := p.lookupIP(ctx, host)
+	if err != nil {
+		return deny(CodeInvalidURL, "host %q can't be resolved", host)
+	}
+	for _, addr := range addrs {
+		if !IsPublic(addr.IP) {
+			return deny(CodePrivateAddress, "host %q resolves to an address that is not public", host)
+		}
+	}
+	return nil
+}
+
+//	IsPublic Checking the address may be reached from the internet.
+func IsPublic(ip net.IP) bool {
+	return !(ip.IsPrivate() || ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsUnspecified())
+}
+
+//	ErrPrivateAddress is the error of connections the dialer of Dialer refuses.
+var ErrPrivateAddress = errors.New("address is not public")
+
+//	Dialer is function to create a dialer for fetching URLs users gave. With denyPrivate it refuses
+//	to connect to addresses that are not public, checked on connect, so neither redirects nor DNS
+//	can lead to a private address.
+func Dialer(timeout time.Duration, denyPrivate bool) *net.Dialer {
+	dialer := &net.Dialer{Timeout: timeout}
+	if denyPrivate {
+		dialer.Control = func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || !IsPublic(ip) {
+				return ErrPrivateAddress
+			}
+			return nil
+		}
+	}
+	return dialer
+}
+
+//	parseLooseIPv4 Parsing IPv4 forms that browsers accept but net.ParseIP doesn't,
+//	such as "2130706433", "0x7f.1" or "0177.0.0.1".
+func parseLooseIPv4(host string) net.IP {
+	parts := strings.Split(host, ".")
+	if len(parts) > 4 {
+		return nil
+	}
+	nums := make([]uint64, 0, len(parts))
+	for _, part := range parts {
+		n, err := strconv.ParseUint(part, 0, 32)
+		if err != nil {
+			return nil
+		}
+		nums = append(nums, n)
+	}
+	var addr uint64
+	for i, n := range nums[:len(nums)-1] {
+		if n > 0xff {
+			return nil
+		}
+		addr |= n << (24 - 8*uint(i))
+	}
+	last := nums[len(nums)-1]
+	if last >= 1<<(8*uint(5-len(nums))) {
+		return nil
+	}
+	addr |= last
+	return net.IPv4(byte(addr>>24), byte(addr>>16), byte(addr>>8), byte(addr))
+}
+
+func hostPort(u *url.URL) string {
+	port := u.Port()
+	if port == "" {
+		switch strings.ToLower(u.Scheme) {
+		case "http":
+			port = "80"
+		case "https":
+			port = "443"
+		}
+	}
+	return strings.ToLower(u.Hostname()) + ":" + port
+}
+
+func deny(code, format string, args ...interface{}) error {
+	return &interfaces.ValidationError{
+		Code:    code,
+		Message: fmt.Sprintf(format, args...),
+	}
+}
@@ -0,0 +1,104 @@
+package urlpolicy
+
+import (
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ivanmyagkov/shortener.git/internal/interfaces"
+)
+
+func TestPolicy_Validate(t *testing.T) {
+	dir := t.TempDir()
+	blocklist := filepath.Join(dir, "blocklist.txt")
+	require.NoError(t, os.WriteFile(blocklist, []byte("# spam\nevil.com\n"), 0600))
+
+	p, err := New(Settings{
+		Schemes:       []string{"http", "https"},
+		DenyPrivate:   true,
+		MaxLength:     64,
+		BlocklistFile: blocklist,
+		SelfURLs:      []string{"https://short.ru"},
+	})
+	require.NoError(t, err)
+
+	tests := []struct {
+		name  string
+		value string
+		want  string
+	}{
+		{name: "allowed", value: "https://www.yandex.ru/path", want: ""},
+		{name: "javascript", value: "javascript:alert(1)", want: CodeSchemeNotAllowed},
+		{name: "file", value: "file:///etc/passwd", want: CodeSchemeNotAllowed},
+		{name: "data", value: "data:text/html,hi", want: CodeSchemeNotAllowed},
+		{name: "loopback", value: "http://127.0.0.1/admin", want: CodePrivateAddress},
+		{name: "loopback decimal", value: "http://2130706433/", want: CodePrivateAddress},
+		{name: "private", value: "http://10.1.2.3/", want: CodePrivateAddress},
+		{name: "link-local", value: "http://169.254.169.254/latest", want: CodePrivateAddress},
+		{name: "ipv6 loopback", value: "http://[::1]:8080/", want: CodePrivateAddress},
+		{name: "localhost", value: "http://localhost:8080/", want: CodePrivateAddress},
+		{name: "self", value: "https://short.ru/f845599b09851789", want: CodeSelfReference},
+		{name: "blocked", value: "http://evil.com/", want: CodeDomainBlocked},
+		{name: "blocked subdomain", value: "http://www.Evil.com/", want: CodeDomainBlocked},
+		{name: "too long", value: "https://www.yandex.ru/" + string(make([]byte, 64)), want: CodeTooLong},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := p.Validate(tt.value)
+			if tt.want == "" {
+				assert.NoError(t, err)
+				return
+			}
+			var validationErr *interfaces.ValidationError
+			require.True(t, errors.As(err, &validationErr))
+			assert.Equal(t, tt.want, validationErr.Code)
+		})
+	}
+}
+
+func TestPolicy_Allowlist(t *testing.T) {
+	allowlist := filepath.Join(t.TempDir(), "allowlist.txt")
+	require.NoError(t, os.WriteFile(allowlist, []byte("yandex.ru\n"), 0600))
+
+	p, err := New(Settings{Schemes: []string{"https"}, AllowlistFile: allowlist})
+	require.NoError(t, err)
+
+	assert.NoError(t, p.Validate("https://market.yandex.ru/"))
+	assert.Error(t, p.Validate("https://google.com/"))
+}
+
+func TestDomainList_ReloadIfChanged(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "blocklist.txt")
+	require.NoError(t, os.WriteFile(path, []byte("evil.com\n"), 0600))
+
+	l, err := LoadDomainList(path)
+	require.NoError(t, err)
+	require.True(t, l.Match("evil.com"))
+	require.False(t, l.Match("bad.org"))
+
+	require.NoError(t, os.WriteFile(path, []byte("bad.org\n"), 0600))
+	modTime := time.Now().Add(time.Minute)
+	require.NoError(t, os.Chtimes(path, modTime, modTime))
+	l.checked = time.Now().Add(-reloadInterval)
+
+	assert.True(t, l.Match("bad.org"))
+	assert.False(t, l.Match("evil.com"))
+}
+
+func TestDialer(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+
+	_, err = Dialer(time.Second, true).Dial("tcp", listener.Addr().String())
+	assert.ErrorIs(t, err, ErrPrivateAddress)
+	conn, err := Dialer(time.Second, false).Dial("tcp", listener.Addr().String())
+	require.NoError(t, err)
+	conn.Close()
+}
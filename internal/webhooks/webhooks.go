@@ -0,0 +1,637 @@
+//	Package webhooks for telling users about events of their links by HTTP requests.
+//
+//	Events are saved as deliveries in an outbox of the storage and sent by a loop,
+//	so they survive restarts. Created and deleted links are read from the change log,
+//	which the storage writes with the links themselves, so these events are not lost
+//	between saving a link and saving its deliveries. A failed delivery is retried with exponential backoff
+//	until it runs out of attempts. Payloads are signed with HMAC-SHA256 by the secret
+//	of the webhook: the X-Webhook-Signature header is "sha256=" and the hex signature
+//	of the X-Webhook-Timestamp header, a dot and the body.
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/ivanmyagkov/shortener.git/internal/domains"
+	"github.com/ivanmyagkov/shortener.git/internal/interfaces"
+	"github.com/ivanmyagkov/shortener.git/internal/urlpolicy"
+)
+
+//	Codes of rejected subscriptions.
+const (
+	CodeInvalidURL        = "invalid_url"
+	CodeInvalidEvents     = "invalid_events"
+	CodeInvalidThresholds = "invalid_thresholds"
+	CodeTooManyWebhooks   = "too_many_webhooks"
+)
+
+//	Limits of subscriptions.
+const (
+	MaxWebhooks   = 10
+	MaxThresholds = 20
+	maxURL        = 2048
+)
+
+//	Headers of deliveries.
+const (
+	HeaderID        = "X-Webhook-ID"
+	HeaderEvent     = "X-Webhook-Event"
+	HeaderTimestamp = "X-Webhook-Timestamp"
+	HeaderSignature = "X-Webhook-Signature"
+)
+
+const (
+	// deliveries a worker takes from the outbox at once
+	perWorker = 4
+	// how much of a response is read before the connection is dropped
+	maxResponse = 64 << 10
+	// how often click thresholds are read again, other instances may have changed them
+	thresholdsInterval = time.Minute
+	// how often old deliveries are removed
+	pruneInterval = time.Hour
+	// clicks waiting to be counted, more are dropped
+	clickQueue = 1024
+)
+
+var events = map[string]bool{
+	interfaces.EventLinkCreated: true,
+	interfaces.EventLinkDeleted: true,
+	interfaces.EventLinkExpired: true,
+	interfaces.EventLinkClicks:  true,
+}
+
+//	Settings of the service.
+type Settings struct {
+	// number of concurrent deliveries
+	Workers int
+	// timeout of a single delivery
+	Timeout time.Duration
+	// attempts of a delivery before it fails
+	MaxAttempts int
+	// delay before the second attempt, doubled after each next one up to MaxBackoff
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	// how long delivered and failed deliveries are kept, 0 keeps them forever
+	Retention time.Duration
+	// how often the outbox is checked for due deliveries, new events are sent at once
+	PollInterval time.Duration
+	// refuse to connect to private, loopback and link-local addresses
+	DenyPrivate bool
+	// base URLs of domains, to tell full short URLs, they are read for every event so reloads apply
+	Config interfaces.Config
+}
+
+//	Data is what an event tells about the link.
+type Data struct {
+	ShortURL    string `json:"short_url"`
+	BaseURL     string `json:"original_url,omitempty"`
+	WorkspaceID string `json:"workspace_id,omitempty"`
+	Clicks      int64  `json:"clicks,omitempty"`
+}
+
+//	Payload is the body of a delivery.
+type Payload struct {
+	ID        string    `json:"id"`
+	Event     string    `json:"event"`
+	CreatedAt time.Time `json:"created_at"`
+	Data      Data      `json:"data"`
+}
+
+//	click is a visit of a link waiting to be counted.
+type click struct {
+	shortURL string
+	baseURL  string
+}
+
+type Service struct {
+	storage  interfaces.Storage
+	client   *http.Client
+	settings Settings
+	wake     chan struct{}
+	clicks   chan click
+	ctx      context.Context
+	now      func() time.Time
+
+	mu         sync.RWMutex
+	thresholds map[int64]struct{}
+}
+
+//	New is function to create the webhooks service.
+func New(ctx context.Context, storage interfaces.Storage, settings Settings) *Service {
+	if settings.Workers < 1 {
+		settings.Workers = 1
+	}
+	if settings.MaxAttempts < 1 {
+		settings.MaxAttempts = 1
+	}
+	if settings.PollInterval <= 0 {
+		settings.PollInterval = time.Second
+	}
+	dialer := urlpolicy.Dialer(settings.Timeout, settings.DenyPrivate)
+	transport := &http.Transport{
+		DialContext:           dialer.DialContext,
+		TLSHandshakeTimeout:   settings.Timeout,
+		ResponseHeaderTimeout: settings.Timeout,
+	}
+	client := &http.Client{
+		Timeout:   settings.Timeout,
+		Transport: transport,
+		// a redirect is an answer of the receiver, it is not followed
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	return &Service{
+		storage:    storage,
+		client:     client,
+		settings:   settings,
+		wake:       make(chan struct{}, 1),
+		clicks:     make(chan click, clickQueue),
+		ctx:        ctx,
+		now:        func() time.Time { return time.Now().UTC() },
+		thresholds: make(map[int64]struct{}),
+	}
+}
+
+//	Subscribe Adding a webhook of the user or the workspace, the secret of the returned webhook signs its payloads.
+func (s *Service) Subscribe(userID, workspaceID string, webhook interfaces.Webhook) (interfaces.Webhook, error) {
+	if err := validate(&webhook); err != nil {
+		return interfaces.Webhook{}, err
+	}
+	existing, err := s.storage.GetWebhooks(userID, workspaceID)
+	if err != nil {
+		return interfaces.Webhook{}, err
+	}
+	if len(existing) >= MaxWebhooks {
+		return interfaces.Webhook{}, &interfaces.ValidationError{Code: CodeTooManyWebhooks, Message: fmt.Sprintf("at most %d webhooks are allowed", MaxWebhooks)}
+	}
+	if webhook.ID, err = random(8); err != nil {
+		return interfaces.Webhook{}, err
+	}
+	secret, err := random(24)
+	if err != nil {
+		return interfaces.Webhook{}, err
+	}
+	webhook.Secret = "whsec_" + secret
+	webhook.UserID = userID
+	webhook.WorkspaceID = workspaceID
+	webhook.CreatedAt = s.now()
+	if err = s.storage.CreateWebhook(webhook); err != nil {
+		return interfaces.Webhook{}, err
+	}
+	s.refresh()
+	return webhook, nil
+}
+
+//	validate Checking the URL, the events and the thresholds of a new webhook, bringing them to order.
+func validate(webhook *interfaces.Webhook) error {
+	u, err := url.Parse(webhook.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || len(webhook.URL) > maxURL {
+		return &interfaces.ValidationError{Code: CodeInvalidURL, Message: fmt.Sprintf("url must be an http or https URL of at most %d characters", maxURL)}
+	}
+	if u.User != nil {
+		return &interfaces.ValidationError{Code: CodeInvalidURL, Message: "url must not have credentials"}
+	}
+	if len(webhook.Events) == 0 {
+		return &interfaces.ValidationError{Code: CodeInvalidEvents, Message: "events must not be empty"}
+	}
+	seen := make(map[string]bool)
+	list := make([]string, 0, len(webhook.Events))
+	for _, event := range webhook.Events {
+		if !events[event] {
+			return &interfaces.ValidationError{Code: CodeInvalidEvents, Message: fmt.Sprintf("unknown event %q", event)}
+		}
+		if !seen[event] {
+			seen[event] = true
+			list = append(list, event)
+		}
+	}
+	sort.Strings(list)
+	webhook.Events = list
+	if seen[interfaces.EventLinkClicks] != (len(webhook.Thresholds) > 0) {
+		return &interfaces.ValidationError{Code: CodeInvalidThresholds, Message: "click_thresholds are required by link.clicks and only by it"}
+	}
+	if len(webhook.Thresholds) > MaxThresholds {
+		return &interfaces.ValidationError{Code: CodeInvalidThresholds, Message: fmt.Sprintf("at most %d click_thresholds are allowed", MaxThresholds)}
+	}
+	thresholds := make([]int64, 0, len(webhook.Thresholds))
+	for _, threshold := range webhook.Thresholds {
+		if threshold < 1 {
+			return &interfaces.ValidationError{Code: CodeInvalidThresholds, Message: "click_thresholds must be positive"}
+		}
+		if !containsInt(thresholds, threshold) {
+			thresholds = append(thresholds, threshold)
+		}
+	}
+	sort.Slice(thresholds, func(i, j int) bool { return thresholds[i] < thresholds[j] })
+	webhook.Thresholds = thresholds
+	if len(thresholds) == 0 {
+		webhook.Thresholds = nil
+	}
+	return nil
+}
+
+//	List Listing webhooks of the user or the workspace, secrets are only told on subscription.
+func (s *Service) List(userID, workspaceID string) ([]interfaces.Webhook, error) {
+	webhooks, err := s.storage.GetWebhooks(userID, workspaceID)
+	if err != nil {
+		return nil, err
+	}
+	for i := range webhooks {
+		webhooks[i].Secret = ""
+	}
+	return webhooks, nil
+}
+
+//	Unsubscribe Deleting the webhook with its deliveries.
+//	Returns interfaces.ErrNotFound if the user or the workspace has no such webhook.
+func (s *Service) Unsubscribe(userID, workspaceID, id string) error {
+	if err := s.storage.DelWebhook(userID, workspaceID, id); err != nil {
+		return err
+	}
+	s.refresh()
+	return nil
+}
+
+//	Deliveries Listing the latest deliveries of the webhook, the newest first.
+//	Returns interfaces.ErrNotFound if the user or the workspace has no such webhook.
+func (s *Service) Deliveries(userID, workspaceID, id string, limit int) ([]interfaces.WebhookDelivery, error) {
+	return s.storage.GetDeliveries(userID, workspaceID, id, limit)
+}
+
+//	Publish Adding deliveries of the created and deleted links of the changes to the outbox.
+//	Webhooks are only told about changes made after they subscribed. Deliveries are named
+//	by their changes, so changes published again add nothing.
+func (s *Service) Publish(_ context.Context, changes []interfaces.Change) error {
+	now := s.now()
+	owners := make(map[interfaces.Owner][]interfaces.Webhook)
+	var deliveries []interfaces.WebhookDelivery
+	for _, change := range changes {
+		owner := interfaces.Owner{UserID: change.UserID, WorkspaceID: change.WorkspaceID}
+		webhooks, ok := owners[owner]
+		if !ok {
+			var err error
+			if webhooks, err = s.storage.GetWebhooks(owner.UserID, owner.WorkspaceID); err != nil {
+				return err
+			}
+			owners[owner] = webhooks
+		}
+		data := Data{ShortURL: change.ShortURL, BaseURL: change.BaseURL, WorkspaceID: change.WorkspaceID}
+		for _, webhook := range webhooks {
+			if !containsString(webhook.Events, change.Type) || change.CreatedAt.Before(webhook.CreatedAt) {
+				continue
+			}
+			id := strconv.FormatInt(change.Offset, 10) + "-" + webhook.ID
+			delivery, err := newDelivery(id, webhook.ID, change.Type, change.CreatedAt, now, data)
+			if err != nil {
+				return err
+			}
+			deliveries = append(deliveries, delivery)
+		}
+	}
+	if len(deliveries) == 0 {
+		return nil
+	}
+	if err := s.storage.AddDeliveries(deliveries); err != nil {
+		return err
+	}
+	s.notify()
+	return nil
+}
+
+//	Expired Telling webhooks of the owners the link has no visits left.
+func (s *Service) Expired(shortURL, baseURL string) {
+	owners, err := s.storage.GetOwners(shortURL)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	s.publish(owners, interfaces.EventLinkExpired, Data{ShortURL: shortURL, BaseURL: baseURL}, nil)
+}
+
+//	Clicked Queueing the click of the link to be counted by CountLoop, so the visitor doesn't wait for it.
+//	Clicks are only counted while some webhook has thresholds.
+func (s *Service) Clicked(shortURL, baseURL string) {
+	s.mu.RLock()
+	counting := len(s.thresholds) > 0
+	s.mu.RUnlock()
+	if !counting {
+		return
+	}
+	select {
+	case s.clicks <- click{shortURL: shortURL, baseURL: baseURL}:
+	default:
+		log.Printf("click of %s is not counted, the queue is full", shortURL)
+	}
+}
+
+//	CountLoop Counting queued clicks until the context is done.
+func (s *Service) CountLoop() error {
+	for {
+		select {
+		case <-s.ctx.Done():
+			return nil
+		case c := <-s.clicks:
+			s.count(c)
+		}
+	}
+}
+
+//	count Counting the click and telling webhooks of the owners when the count reaches one of their thresholds.
+func (s *Service) count(c click) {
+	clicks, err := s.storage.AddClick(c.shortURL)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	s.mu.RLock()
+	_, ok := s.thresholds[clicks]
+	s.mu.RUnlock()
+	if !ok {
+		return
+	}
+	owners, err := s.storage.GetOwners(c.shortURL)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	s.publish(owners, interfaces.EventLinkClicks, Data{ShortURL: c.shortURL, BaseURL: c.baseURL, Clicks: clicks}, func(webhook interfaces.Webhook) bool {
+		return containsInt(webhook.Thresholds, clicks)
+	})
+}
+
+//	publish Adding deliveries of the event to the outbox for webhooks of the owners subscribed to it
+//	and accepted by the filter, if any.
+func (s *Service) publish(owners []interfaces.Owner, event string, data Data, filter func(interfaces.Webhook) bool) {
+	now := s.now()
+	key := data.ShortURL
+	data.ShortURL = domains.New(s.settings.Config.BaseURLs()).URL(key)
+	var deliveries []interfaces.WebhookDelivery
+	for _, owner := range owners {
+		webhooks, err := s.storage.GetWebhooks(owner.UserID, owner.WorkspaceID)
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+		data.WorkspaceID = owner.WorkspaceID
+		for _, webhook := range webhooks {
+			if !containsString(webhook.Events, event) || (filter != nil && !filter(webhook)) {
+				continue
+			}
+			id, err := random(12)
+			if err != nil {
+				log.Println(err)
+				return
+			}
+			delivery, err := newDelivery(id, webhook.ID, event, now, now, data)
+			if err != nil {
+				log.Println(err)
+				return
+			}
+			deliveries = append(deliveries, delivery)
+		}
+	}
+	if len(deliveries) == 0 {
+		return
+	}
+	if err := s.storage.AddDeliveries(deliveries); err != nil {
+		log.Printf("webhook deliveries of %s of %s are lost: %v", event, key, err)
+		return
+	}
+	s.notify()
+}
+
+//	newDelivery Returning a pending delivery of the event that happened at the time, due now.
+func newDelivery(id, webhookID, event string, happened, now time.Time, data Data) (interfaces.WebhookDelivery, error) {
+	payload, err := json.Marshal(Payload{ID: id, Event: event, CreatedAt: happened, Data: data})
+	if err != nil {
+		return interfaces.WebhookDelivery{}, err
+	}
+	return interfaces.WebhookDelivery{
+		ID:            id,
+		WebhookID:     webhookID,
+		Event:         event,
+		Payload:       payload,
+		Status:        interfaces.DeliveryPending,
+		NextAttemptAt: now,
+		CreatedAt:     now,
+		UpdatedAt:     now,
+	}, nil
+}
+
+//	notify Waking the loop to send new deliveries at once.
+func (s *Service) notify() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+//	Loop Sending due deliveries of the outbox until the context is done.
+func (s *Service) Loop() error {
+	ticker := time.NewTicker(s.settings.PollInterval)
+	defer ticker.Stop()
+	var refreshed, pruned time.Time
+	for {
+		if now := s.now(); now.Sub(refreshed) >= thresholdsInterval {
+			s.refresh()
+			refreshed = now
+		}
+		if now := s.now(); s.settings.Retention > 0 && now.Sub(pruned) >= pruneInterval {
+			if err := s.storage.PruneDeliveries(now.Add(-s.settings.Retention)); err != nil {
+				log.Println(err)
+			}
+			pruned = now
+		}
+		s.Dispatch()
+		select {
+		case <-s.ctx.Done():
+			return nil
+		case <-ticker.C:
+		case <-s.wake:
+		}
+	}
+}
+
+//	Dispatch Sending due deliveries of the outbox until none are left.
+//	Taken deliveries are leased, so other instances don't send them at the same time.
+func (s *Service) Dispatch() {
+	batch := s.settings.Workers * perWorker
+	lease := perWorker*s.settings.Timeout + time.Minute
+	for s.ctx.Err() == nil {
+		deliveries, err := s.storage.ClaimDeliveries(s.now(), lease, batch)
+		if err != nil {
+			log.Println(err)
+			return
+		}
+		queue := make(chan interfaces.WebhookDelivery, len(deliveries))
+		for _, delivery := range deliveries {
+			queue <- delivery
+		}
+		close(queue)
+		var wg sync.WaitGroup
+		for i := 0; i < s.settings.Workers; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for delivery := range queue {
+					s.attempt(delivery)
+				}
+			}()
+		}
+		wg.Wait()
+		if len(deliveries) < batch {
+			return
+		}
+	}
+}
+
+//	attempt Sending the delivery once and saving the outcome, scheduling the next attempt if it failed.
+func (s *Service) attempt(delivery interfaces.WebhookDelivery) {
+	webhook, err := s.storage.GetWebhook(delivery.WebhookID)
+	if errors.Is(err, interfaces.ErrNotFound) {
+		// unsubscribed, the deliveries are gone
+		return
+	} else if err != nil {
+		log.Println(err)
+		return
+	}
+	status, err := s.send(webhook, delivery)
+	if s.ctx.Err() != nil {
+		// stopped, the attempt doesn't count and the lease runs out
+		return
+	}
+	now := s.now()
+	delivery.Attempts++
+	delivery.UpdatedAt = now
+	delivery.ResponseStatus = status
+	delivery.Error = ""
+	switch {
+	case err == nil:
+		delivery.Status = interfaces.DeliveryDelivered
+	case delivery.Attempts >= s.settings.MaxAttempts:
+		delivery.Status = interfaces.DeliveryFailed
+		delivery.Error = err.Error()
+	default:
+		delivery.Error = err.Error()
+		delivery.NextAttemptAt = now.Add(s.backoff(delivery.Attempts))
+	}
+	if err = s.storage.SetDelivery(delivery); err != nil && !errors.Is(err, interfaces.ErrNotFound) {
+		log.Println(err)
+	}
+}
+
+//	send Posting the payload of the delivery to the webhook, returning the status of the response if any.
+//	Statuses other than 2xx are errors.
+func (s *Service) send(webhook interfaces.Webhook, delivery interfaces.WebhookDelivery) (int, error) {
+	req, err := http.NewRequestWithContext(s.ctx, http.MethodPost, webhook.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return 0, err
+	}
+	timestamp := strconv.FormatInt(s.now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "shortener-webhooks")
+	req.Header.Set(HeaderID, delivery.ID)
+	req.Header.Set(HeaderEvent, delivery.Event)
+	req.Header.Set(HeaderTimestamp, timestamp)
+	req.Header.Set(HeaderSignature, Sign(webhook.Secret, timestamp, delivery.Payload))
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	// reading the rest lets the connection be reused
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, maxResponse))
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("status %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+//	backoff Returning the delay after the attempt: Backoff doubled for each attempt before, at most MaxBackoff.
+func (s *Service) backoff(attempts int) time.Duration {
+	delay := s.settings.Backoff
+	for i := 1; i < attempts; i++ {
+		if s.settings.MaxBackoff > 0 && delay >= s.settings.MaxBackoff {
+			break
+		}
+		delay *= 2
+	}
+	if s.settings.MaxBackoff > 0 && delay > s.settings.MaxBackoff {
+		delay = s.settings.MaxBackoff
+	}
+	return delay
+}
+
+//	refresh Reading click thresholds of all webhooks.
+func (s *Service) refresh() {
+	values, err := s.storage.GetClickThresholds()
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	thresholds := make(map[int64]struct{}, len(values))
+	for _, value := range values {
+		thresholds[value] = struct{}{}
+	}
+	s.mu.Lock()
+	s.thresholds = thresholds
+	s.mu.Unlock()
+}
+
+//	Sign Returning the signature of the body sent at the timestamp, in the form of the X-Webhook-Signature header.
+func Sign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+//	Verify Checking the signature of the body sent at the timestamp, for receivers.
+func Verify(secret, timestamp, signature string, body []byte) bool {
+	return hmac.Equal([]byte(signature), []byte(Sign(secret, timestamp, body)))
+}
+
+func containsString(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
+func containsInt(list []int64, value int64) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
+//	random Returning a random hex string of n bytes.
+func random(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,171 @@
+//	Package workspaces for groups of users sharing links.
+//
+//	Members have one of the roles: owners manage members, editors add and
+//	delete links, viewers only list them. Members are added by the login
+//	of their account.
+package workspaces
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ivanmyagkov/shortener.git/internal/interfaces"
+)
+
+//	Codes of rejected requests.
+const (
+	CodeInvalidName  = "invalid_name"
+	CodeInvalidRole  = "invalid_role"
+	CodeUnknownLogin = "unknown_login"
+)
+
+const maxName = 100
+
+//	ranks of roles, a role can do everything the lower ones can
+var ranks = map[string]int{
+	interfaces.RoleViewer: 1,
+	interfaces.RoleEditor: 2,
+	interfaces.RoleOwner:  3,
+}
+
+type Service struct {
+	storage interfaces.Storage
+}
+
+//	New is function to create the workspaces service.
+func New(storage interfaces.Storage) *Service {
+	return &Service{storage: storage}
+}
+
+//	Create Creating a workspace owned by the user.
+//	Returns interfaces.ErrNotFound if the user has no account.
+func (s *Service) Create(userID, name string) (interfaces.Workspace, error) {
+	if _, err := s.storage.GetAccountByUserID(userID); err != nil {
+		return interfaces.Workspace{}, err
+	}
+	name = strings.TrimSpace(name)
+	if name == "" || len(name) > maxName {
+		return interfaces.Workspace{}, &interfaces.ValidationError{Code: CodeInvalidName, Message: fmt.Sprintf("name must have 1 to %d characters", maxName)}
+	}
+	id, err := random(8)
+	if err != nil {
+		return interfaces.Workspace{}, err
+	}
+	workspace := interfaces.Workspace{
+		ID:        id,
+		Name:      name,
+		CreatedAt: time.Now().UTC(),
+	}
+	if err = s.storage.CreateWorkspace(workspace, userID); err != nil {
+		return interfaces.Workspace{}, err
+	}
+	workspace.Role = interfaces.RoleOwner
+	return workspace, nil
+}
+
+//	List Listing workspaces of the user with its roles.
+func (s *Service) List(userID string) ([]interfaces.Workspace, error) {
+	return s.storage.GetWorkspaces(userID)
+}
+
+//	Members Listing members of the workspace, any member may do it.
+func (s *Service) Members(userID, workspaceID string) ([]interfaces.Member, error) {
+	if err := s.Authorize(userID, workspaceID, interfaces.RoleViewer); err != nil {
+		return nil, err
+	}
+	return s.storage.GetMembers(workspaceID)
+}
+
+//	SetMember Adding the account with the login to the workspace or changing its role, only owners may do it.
+//	Returns interfaces.ErrLastOwner if the only owner would be demoted.
+func (s *Service) SetMember(userID, workspaceID, login, role string) (interfaces.Member, error) {
+	if err := s.Authorize(userID, workspaceID, interfaces.RoleOwner); err != nil {
+		return interfaces.Member{}, err
+	}
+	if _, ok := ranks[role]; !ok {
+		return interfaces.Member{}, &interfaces.ValidationError{Code: CodeInvalidRole, Message: "role must be owner, editor or viewer"}
+	}
+	account, err := s.storage.GetAccount(strings.TrimSpace(login))
+	if errors.Is(err, interfaces.ErrNotFound) {
+		return interfaces.Member{}, &interfaces.ValidationError{Code: CodeUnknownLogin, Message: "no account with this login"}
+	} else if err != nil {
+		return interfaces.Member{}, err
+	}
+	if role != interfaces.RoleOwner {
+		if err = s.keepOwner(workspaceID, account.UserID); err != nil {
+			return interfaces.Member{}, err
+		}
+	}
+	member := interfaces.Member{
+		WorkspaceID: workspaceID,
+		UserID:      account.UserID,
+		Login:       account.Login,
+		Role:        role,
+		AddedAt:     time.Now().UTC(),
+	}
+	if err = s.storage.SetMember(member); err != nil {
+		return interfaces.Member{}, err
+	}
+	return member, nil
+}
+
+//	RemoveMember Removing a member from the workspace, owners may remove anyone and members themselves.
+//	Returns interfaces.ErrLastOwner if the only owner would be removed.
+func (s *Service) RemoveMember(userID, workspaceID, memberUserID string) error {
+	role := interfaces.RoleOwner
+	if userID == memberUserID {
+		role = interfaces.RoleViewer
+	}
+	if err := s.Authorize(userID, workspaceID, role); err != nil {
+		return err
+	}
+	if err := s.keepOwner(workspaceID, memberUserID); err != nil {
+		return err
+	}
+	return s.storage.DelMember(workspaceID, memberUserID)
+}
+
+//	Authorize Checking the user has at least the role in the workspace.
+//	Returns interfaces.ErrNotFound if the user is not a member, so workspaces of others stay hidden,
+//	and interfaces.ErrForbidden if the role is lower.
+func (s *Service) Authorize(userID, workspaceID, role string) error {
+	member, err := s.storage.GetMember(workspaceID, userID)
+	if err != nil {
+		return err
+	}
+	if ranks[member.Role] < ranks[role] {
+		return interfaces.ErrForbidden
+	}
+	return nil
+}
+
+//	keepOwner Checking the workspace has an owner besides the user.
+func (s *Service) keepOwner(workspaceID, userID string) error {
+	members, err := s.storage.GetMembers(workspaceID)
+	if err != nil {
+		return err
+	}
+	for _, member := range members {
+		if member.Role == interfaces.RoleOwner && member.UserID != userID {
+			return nil
+		}
+	}
+	for _, member := range members {
+		if member.UserID == userID && member.Role == interfaces.RoleOwner {
+			return interfaces.ErrLastOwner
+		}
+	}
+	return nil
+}
+
+func random(size int) (string, error) {
+	b := make([]byte, size)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,138 @@
+package workspaces
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ivanmyagkov/shortener.git/internal/interfaces"
+	"github.com/ivanmyagkov/shortener.git/internal/storage"
+)
+
+func newStorage(t *testing.T) interfaces.Storage {
+	db := storage.NewDBConn()
+	for _, account := range []interfaces.Account{
+		{UserID: "owner", Login: "alice"},
+		{UserID: "editor", Login: "bob"},
+		{UserID: "viewer", Login: "carol"},
+	} {
+		require.NoError(t, db.CreateAccount(account))
+	}
+	return db
+}
+
+func TestCreate(t *testing.T) {
+	s := New(newStorage(t))
+	_, err := s.Create("anonymous", "team")
+	assert.ErrorIs(t, err, interfaces.ErrNotFound)
+
+	var validationErr *interfaces.ValidationError
+	_, err = s.Create("owner", " ")
+	require.True(t, errors.As(err, &validationErr))
+	assert.Equal(t, CodeInvalidName, validationErr.Code)
+
+	workspace, err := s.Create("owner", "team")
+	require.NoError(t, err)
+	assert.Equal(t, interfaces.RoleOwner, workspace.Role)
+	list, err := s.List("owner")
+	require.NoError(t, err)
+	require.Len(t, list, 1)
+	assert.Equal(t, "team", list[0].Name)
+	assert.Equal(t, interfaces.RoleOwner, list[0].Role)
+}
+
+func TestAuthorize(t *testing.T) {
+	s := New(newStorage(t))
+	workspace, err := s.Create("owner", "team")
+	require.NoError(t, err)
+	_, err = s.SetMember("owner", workspace.ID, "bob", interfaces.RoleEditor)
+	require.NoError(t, err)
+	_, err = s.SetMember("owner", workspace.ID, "carol", interfaces.RoleViewer)
+	require.NoError(t, err)
+
+	tests := []struct {
+		userID string
+		role   string
+		err    error
+	}{
+		{userID: "owner", role: interfaces.RoleOwner},
+		{userID: "editor", role: interfaces.RoleEditor},
+		{userID: "editor", role: interfaces.RoleOwner, err: interfaces.ErrForbidden},
+		{userID: "viewer", role: interfaces.RoleViewer},
+		{userID: "viewer", role: interfaces.RoleEditor, err: interfaces.ErrForbidden},
+		{userID: "stranger", role: interfaces.RoleViewer, err: interfaces.ErrNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.userID+" as "+tt.role, func(t *testing.T) {
+			err := s.Authorize(tt.userID, workspace.ID, tt.role)
+			if tt.err != nil {
+				assert.ErrorIs(t, err, tt.err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestMembers(t *testing.T) {
+	s := New(newStorage(t))
+	workspace, err := s.Create("owner", "team")
+	require.NoError(t, err)
+
+	_, err = s.SetMember("owner", workspace.ID, "bob", "admin")
+	var validationErr *interfaces.ValidationError
+	require.True(t, errors.As(err, &validationErr))
+	assert.Equal(t, CodeInvalidRole, validationErr.Code)
+	_, err = s.SetMember("owner", workspace.ID, "nobody", interfaces.RoleViewer)
+	require.True(t, errors.As(err, &validationErr))
+	assert.Equal(t, CodeUnknownLogin, validationErr.Code)
+
+	_, err = s.SetMember("owner", workspace.ID, "bob", interfaces.RoleEditor)
+	require.NoError(t, err)
+	_, err = s.SetMember("editor", workspace.ID, "carol", interfaces.RoleViewer)
+	assert.ErrorIs(t, err, interfaces.ErrForbidden)
+	_, err = s.SetMember("owner", workspace.ID, "alice", interfaces.RoleEditor)
+	assert.ErrorIs(t, err, interfaces.ErrLastOwner)
+	assert.ErrorIs(t, s.RemoveMember("owner", workspace.ID, "owner"), interfaces.ErrLastOwner)
+
+	members, err := s.Members("editor", workspace.ID)
+	require.NoError(t, err)
+	require.Len(t, members, 2)
+	assert.Equal(t, "alice", members[0].Login)
+	assert.Equal(t, interfaces.RoleEditor, members[1].Role)
+
+	assert.ErrorIs(t, s.RemoveMember("editor", workspace.ID, "owner"), interfaces.ErrForbidden)
+	require.NoError(t, s.RemoveMember("editor", workspace.ID, "editor"))
+	_, err = s.Members("editor", workspace.ID)
+	assert.ErrorIs(t, err, interfaces.ErrNotFound)
+}
+
+func TestInFileReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "storage.json")
+	db, err := storage.NewInFile(path)
+	require.NoError(t, err)
+	require.NoError(t, db.CreateAccount(interfaces.Account{UserID: "owner", Login: "alice"}))
+	require.NoError(t, db.CreateAccount(interfaces.Account{UserID: "editor", Login: "bob"}))
+	s := New(db)
+	workspace, err := s.Create("owner", "team")
+	require.NoError(t, err)
+	_, err = s.SetMember("owner", workspace.ID, "bob", interfaces.RoleEditor)
+	require.NoError(t, err)
+	require.NoError(t, db.SetWorkspaceURL(workspace.ID, "editor", "a", "http://a.example"))
+	require.NoError(t, db.Close())
+
+	db, err = storage.NewInFile(path)
+	require.NoError(t, err)
+	defer db.Close()
+	s = New(db)
+	require.NoError(t, s.Authorize("editor", workspace.ID, interfaces.RoleEditor))
+	urls, err := db.GetWorkspaceURLs(workspace.ID)
+	require.NoError(t, err)
+	require.Len(t, urls, 1)
+	assert.Equal(t, "http://a.example", urls[0].BaseURL)
+	_, err = db.GetAllURLsByUserID("editor")
+	assert.ErrorIs(t, err, interfaces.ErrNotFound)
+}